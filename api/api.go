@@ -19,15 +19,17 @@ type APIServer struct {
 	listenAddr string
 	env        config.EnvConfig
 	registry   *store.ProviderRegistry
+	manager    *store.DownloadManager
 	db         *sql.DB
 	build      buildFunc
 }
 
-func NewAPIServer(listenAddr string, env config.EnvConfig, registry *store.ProviderRegistry, db *sql.DB, build buildFunc) *APIServer {
+func NewAPIServer(listenAddr string, env config.EnvConfig, registry *store.ProviderRegistry, manager *store.DownloadManager, db *sql.DB, build buildFunc) *APIServer {
 	return &APIServer{
 		listenAddr: listenAddr,
 		env:        env,
 		registry:   registry,
+		manager:    manager,
 		db:         db,
 		build:      build,
 	}
@@ -47,13 +49,13 @@ func (s *APIServer) Start() error {
 	app.Use(cors.New(cors.Config{
 		AllowCredentials: true,
 		AllowOrigins:     fmt.Sprintf("http://localhost:5173,%s", s.env.AppURL),
-		AllowMethods:     "GET,POST",
+		AllowMethods:     "GET,POST,DELETE",
 	}))
 
 	// API Routes will be prefixed with `/api/v1`.
 	v1 := app.Group("/api/v1")
 
-	r := NewRouter(s.registry, s.env, s.db)
+	r := NewRouter(s.registry, s.manager, s.env, s.db)
 	r.RegisterRoutes(v1)
 
 	// Static build folder for production usage.
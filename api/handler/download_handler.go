@@ -1,39 +1,75 @@
 package handler
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/session"
 	"github.com/nilotpaul/go-downloader/config"
+	"github.com/nilotpaul/go-downloader/service"
 	"github.com/nilotpaul/go-downloader/setting"
 	"github.com/nilotpaul/go-downloader/store"
+	"github.com/nilotpaul/go-downloader/types"
 	"github.com/nilotpaul/go-downloader/util"
 )
 
 type DownloadHandler struct {
-	registry   *store.ProviderRegistry
-	downloader *store.Downloader
-	sessStore  *session.Store
-	env        config.EnvConfig
+	registry  *store.ProviderRegistry
+	manager   *store.DownloadManager
+	sessStore *session.Store
+	db        *sql.DB
+	env       config.EnvConfig
 }
 
-func NewDownloadHandler(registry *store.ProviderRegistry, sessStore *session.Store, env config.EnvConfig) *DownloadHandler {
+func NewDownloadHandler(registry *store.ProviderRegistry, manager *store.DownloadManager, sessStore *session.Store, db *sql.DB, env config.EnvConfig) *DownloadHandler {
 	return &DownloadHandler{
-		registry:   registry,
-		sessStore:  sessStore,
-		env:        env,
-		downloader: store.NewDownloader(make([]string, 0), ""),
+		registry:  registry,
+		sessStore: sessStore,
+		db:        db,
+		env:       env,
+		manager:   manager,
 	}
 }
 
+// `downloadGroup` holds the files which are downloaded from the same provider with the same account.
+type downloadGroup struct {
+	provider setting.Provider
+	opts     types.SourceOptions
+	files    []types.SourceFile
+}
+
+// getDownloader returns the downloader of the logged in user, every user can only access their
+// own downloads. Requests with an API token and no session share the downloader of the API principal.
+func (h *DownloadHandler) getDownloader(userID any, apiPrincipal any) (*store.Downloader, error) {
+	if id, ok := userID.(string); ok && len(id) != 0 {
+		return h.manager.GetDownloader(id), nil
+	}
+	if ok, _ := apiPrincipal.(bool); ok {
+		return h.manager.GetDownloader(setting.APIPrincipalID), nil
+	}
+
+	return nil, util.NewAppError(
+		http.StatusUnauthorized,
+		"invalid session, please login",
+	)
+}
+
 func (h *DownloadHandler) DownloadHandler(c *fiber.Ctx) error {
+	downloader, err := h.getDownloader(c.Locals(setting.LocalSessionKey), c.Locals(setting.LocalAPIPrincipalKey))
+	if err != nil {
+		return err
+	}
+
 	// Validating the JSON body data
 	b, err := util.ValidateDownloadHRBody(c)
 	if err != nil {
@@ -43,83 +79,292 @@ func (h *DownloadHandler) DownloadHandler(c *fiber.Ctx) error {
 	if len(b.DestinationPath) == 0 {
 		b.DestinationPath = h.env.DefaultDownloadPath
 	}
+	// Same for the export format of Google Docs, Sheets and Slides.
+	if len(b.ExportFormat) == 0 {
+		b.ExportFormat = setting.ExportFormat(h.env.DefaultExportFormat)
+	}
+
+	// Uploaded `.torrent` files are saved and downloaded by their magnet link.
+	links := strings.Split(b.Links, ",")
+	for _, fh := range util.TorrentUploads(c) {
+		magnet, err := saveTorrentUpload(h.env.TorrentDataDir, fh)
+		if err != nil {
+			return util.NewAppError(
+				http.StatusBadRequest,
+				fmt.Sprintf("invalid torrent file %s", fh.Filename),
+				err,
+			)
+		}
+		links = append(links, magnet)
+	}
+
+	// Every link is matched with its source, the files are grouped by their provider
+	// and the account they're downloaded with.
+	candidates := make(map[setting.Provider][]types.SourceOptions)
+	groups := make(map[string]*downloadGroup)
+	order := make([]string, 0)
+	fileIDs := make([]string, 0)
+	for _, link := range links {
+		link = strings.TrimSpace(link)
+		if len(link) == 0 {
+			continue
+		}
+
+		provider, src, err := h.registry.FindSource(link)
+		if err != nil {
+			return util.NewAppError(
+				http.StatusBadRequest,
+				"invalid link(s)",
+			)
+		}
+		// The API principal has no accounts or connection profiles.
+		if downloader.UserID() == setting.APIPrincipalID && !isAnonymousSource(src) {
+			return util.NewAppError(
+				http.StatusForbidden,
+				"only public links can be downloaded with an API token",
+			)
+		}
+		if _, ok := candidates[provider]; !ok {
+			o, err := h.sourceOptions(src, downloader.UserID(), b)
+			if err != nil {
+				return err
+			}
+			candidates[provider] = o
+		}
+
+		sourceFiles, opts, err := resolveLink(c, src, link, candidates[provider])
+		if err != nil {
+			return err
+		}
+		sourceFiles = util.FilterTorrentFiles(sourceFiles, b.TorrentFiles)
+		for _, f := range sourceFiles {
+			fileIDs = append(fileIDs, f.ID)
+		}
+
+		key := string(provider) + "/" + opts.AccountID
+		g, ok := groups[key]
+		if !ok {
+			g = &downloadGroup{provider: provider, opts: opts}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.files = append(g.files, sourceFiles...)
+	}
 
-	// From the given links, we take out the folder and file IDs.
-	// `IDs["file"]` contains all given fileIDs and same for the `IDs["folder"]`.
-	IDs := util.ParseGDriveIDs(b.Links)
-	if len(IDs) == 0 {
+	if len(fileIDs) == 0 {
 		return util.NewAppError(
 			http.StatusBadRequest,
 			"invalid link(s)",
 		)
 	}
-
-	gp, err := h.registry.GetProvider(setting.GoogleProvider)
-	if err != nil {
+	// Check to see if any duplicate fileIDs are present.
+	if util.HasDuplicates(fileIDs) {
 		return util.NewAppError(
-			http.StatusNotFound,
-			"no provider found",
+			http.StatusBadRequest,
+			"duplicate links found",
 		)
 	}
 
-	t := gp.GetAccessToken()
-	srv, err := util.MakeGDriveService(c.Context(), t)
+	slog.Info("downloading", "fileIDs: ", fileIDs)
+
+	// Every file gets its own job, the job IDs are used to track or cancel the downloads.
+	jobIDs := make([]string, 0, len(fileIDs))
+	for _, key := range order {
+		g := groups[key]
+		ids, err := downloader.StartDownload(c.Context(), g.provider, b.DestinationPath, b.SpeedLimit, g.opts, g.files)
+		if err != nil {
+			return util.NewAppError(
+				http.StatusInternalServerError,
+				"failed to start the download",
+				err,
+			)
+		}
+		jobIDs = append(jobIDs, ids...)
+	}
+
+	return c.JSON(fiber.Map{
+		"status":   http.StatusOK,
+		"job_ids":  jobIDs,
+		"file_ids": fileIDs,
+	})
+}
+
+// sourceOptions builds the options for the downloads from `src`, the access
+// token of `userID` is taken from the source's auth provider if it needs one.
+// Providers which link several accounts give the options of every account, in
+// the order they're tried. Sources which can download public files go on without a token.
+func (h *DownloadHandler) sourceOptions(src types.Source, userID string, b *types.DownloadHRBody) ([]types.SourceOptions, error) {
+	opts := types.SourceOptions{
+		UserID:       userID,
+		Headers:      util.MergeCookies(b.Headers, b.Cookies),
+		ExportFormat: b.ExportFormat,
+	}
+	allowsAnonymous := isAnonymousSource(src)
+
+	authProvider := src.AuthProvider()
+	if len(authProvider) == 0 || (len(userID) == 0 && allowsAnonymous) {
+		return []types.SourceOptions{opts}, nil
+	}
+
+	p, err := h.registry.GetProvider(authProvider)
 	if err != nil {
-		return util.NewAppError(
-			http.StatusInternalServerError,
-			"failed to initialize the GDrive service",
-			err,
+		if allowsAnonymous {
+			return []types.SourceOptions{opts}, nil
+		}
+		return nil, util.NewAppError(
+			http.StatusNotFound,
+			"no provider found",
 		)
 	}
 
-	// Range over all the folder IDs.
-	for _, folderID := range IDs["folder"] {
-		// Use the each `folderID` to extract its fileIds.
-		fileIDs, err := util.GetFileIDsFromFolder(srv, folderID)
+	multi, ok := p.(types.MultiAccountProvider)
+	if !ok {
+		ts, err := p.TokenSource(userID)
 		if err != nil {
-			return util.NewAppError(
-				http.StatusInternalServerError,
-				fmt.Sprintf("failed to get contents from folder %s. %s\n", folderID, err.Error()),
+			if allowsAnonymous {
+				return []types.SourceOptions{opts}, nil
+			}
+			return nil, util.NewAppError(
+				http.StatusUnauthorized,
+				fmt.Sprintf("no %s account connected", authProvider),
 				err,
 			)
 		}
-		// Append all the extracted fileIDs in `IDs["file"]`.
-		IDs["file"] = append(IDs["file"], fileIDs...)
+		opts.TokenSource = ts
+		return []types.SourceOptions{opts}, nil
 	}
 
-	if len(IDs["file"]) == 0 {
-		return util.NewAppError(
+	accountIDs, err := multi.AccountIDs(userID, b.GoogleAccount)
+	if err != nil {
+		return nil, util.NewAppError(
 			http.StatusBadRequest,
-			"invalid link(s)",
+			err.Error(),
 		)
 	}
-	// Check to see if any duplicate fileIDs are present.
-	if util.HasDuplicates(IDs["file"]) {
-		return util.NewAppError(
-			http.StatusBadRequest,
-			"duplicate links found",
+	options := make([]types.SourceOptions, 0, len(accountIDs))
+	for _, id := range accountIDs {
+		ts, err := multi.AccountTokenSource(userID, id)
+		if err != nil {
+			// The other accounts might still work.
+			slog.Warn("skipping account", "accountID", id, "error", err)
+			continue
+		}
+		o := opts
+		o.TokenSource = ts
+		o.AccountID = id
+		options = append(options, o)
+	}
+	if len(options) == 0 {
+		if allowsAnonymous {
+			return []types.SourceOptions{opts}, nil
+		}
+		return nil, util.NewAppError(
+			http.StatusUnauthorized,
+			fmt.Sprintf("no %s account connected", authProvider),
 		)
 	}
 
-	// Setting the FileIDs in the `Downloader` struct.
-	h.downloader.FileIDs = IDs["file"]
-	h.downloader.DestinationPath = b.DestinationPath
+	return options, nil
+}
 
-	slog.Info("downloading", "GDrive fileIDs: ", IDs)
+func isAnonymousSource(src types.Source) bool {
+	anon, ok := src.(types.AnonymousSource)
+	return ok && anon.AllowsAnonymous()
+}
 
-	if err := h.downloader.StartDownload(c.Context(), t, ""); err != nil {
-		return err
+func saveTorrentUpload(dataDir string, fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
 	}
+	defer f.Close()
 
-	return c.JSON(fiber.Map{
-		"status":   http.StatusOK,
-		"file_ids": IDs["file"],
-	})
+	return util.SaveTorrentFile(dataDir, f)
 }
 
-// Sends the ongoing downloads.
+// resolveLink returns the file of the link, or every file inside it if it's a folder, along with
+// the options it was resolved with. The options are tried in order, the next ones are only used
+// if the account of the previous ones can't access the link.
+func resolveLink(c *fiber.Ctx, src types.Source, link string, candidates []types.SourceOptions) ([]types.SourceFile, types.SourceOptions, error) {
+	var (
+		files []types.SourceFile
+		err   error
+	)
+	for i, opts := range candidates {
+		files, err = resolveFiles(c, src, link, opts)
+		if err == nil {
+			return files, opts, nil
+		}
+		if i < len(candidates)-1 && util.IsAccessDenied(err) {
+			slog.Info("trying the next account", "link", link, "accountID", opts.AccountID, "error", err)
+			continue
+		}
+		break
+	}
+
+	// The source already tells what's wrong, eg. a missing connection profile.
+	var appErr *util.AppError
+	if errors.As(err, &appErr) {
+		return nil, types.SourceOptions{}, appErr
+	}
+	var folderErr *folderListError
+	if errors.As(err, &folderErr) {
+		return nil, types.SourceOptions{}, util.NewAppError(
+			http.StatusInternalServerError,
+			fmt.Sprintf("failed to get contents from folder %s. %s\n", folderErr.folderID, folderErr.err.Error()),
+			folderErr.err,
+		)
+	}
+
+	return nil, types.SourceOptions{}, util.NewAppError(
+		http.StatusBadRequest,
+		"invalid link(s)",
+		err,
+	)
+}
+
+// `folderListError` is returned when a folder was resolved, but its files couldn't be listed.
+type folderListError struct {
+	folderID string
+	err      error
+}
+
+func (e *folderListError) Error() string {
+	return e.err.Error()
+}
+
+func (e *folderListError) Unwrap() error {
+	return e.err
+}
+
+// resolveFiles returns the file of the link, or every file inside it if it's a folder.
+func resolveFiles(c *fiber.Ctx, src types.Source, link string, opts types.SourceOptions) ([]types.SourceFile, error) {
+	file, err := src.Resolve(c.Context(), link, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !file.IsFolder {
+		return []types.SourceFile{*file}, nil
+	}
+
+	// Walks the folder recursively, every file keeps its path
+	// relative to the top-level folder.
+	files, err := src.ListFolder(c.Context(), file, opts)
+	if err != nil {
+		return nil, &folderListError{folderID: file.ID, err: err}
+	}
+
+	return files, nil
+}
+
+// Sends the ongoing downloads of the user.
 func (h *DownloadHandler) ProgressHTTPHandler(c *fiber.Ctx) error {
-	pendings, _ := h.downloader.GetPendingDownloads()
+	downloader, err := h.getDownloader(c.Locals(setting.LocalSessionKey), c.Locals(setting.LocalAPIPrincipalKey))
+	if err != nil {
+		return err
+	}
+
+	pendings, _ := downloader.GetPendingDownloads()
 	if len(pendings) == 0 {
 		return util.NewAppError(
 			http.StatusNotFound,
@@ -130,36 +375,161 @@ func (h *DownloadHandler) ProgressHTTPHandler(c *fiber.Ctx) error {
 	return c.JSON(pendings)
 }
 
-// Cancels the ongoing download by fileID.
+// Cancels the user's ongoing download by jobID.
 func (h *DownloadHandler) CancelDownloadHandler(c *fiber.Ctx) error {
-	fileID, err := util.ValidateCancelDownloadHRBody(c)
+	downloader, err := h.getDownloader(c.Locals(setting.LocalSessionKey), c.Locals(setting.LocalAPIPrincipalKey))
 	if err != nil {
 		return err
 	}
 
-	if _, err := h.downloader.GetProgress(fileID); err != nil {
+	jobID, err := util.ValidateCancelDownloadHRBody(c)
+	if err != nil {
+		return err
+	}
+
+	if _, err := downloader.GetProgress(jobID); err != nil {
 		return util.NewAppError(
 			http.StatusNotFound,
 			"no ongoing downloads",
 		)
 	}
 
-	if err := h.downloader.CancelDownload(fileID); err != nil {
+	if err := downloader.CancelDownload(jobID); err != nil {
 		return util.NewAppError(
 			http.StatusInternalServerError,
-			fmt.Sprintf("failed to cancel the download for file %s", fileID),
+			fmt.Sprintf("failed to cancel the download for job %s", jobID),
 		)
 	}
 
 	return c.JSON("OK")
 }
 
-// Cancels all ongoing downloads.
+// Pauses one or a batch of the user's downloads by jobID, the partial data is kept.
+func (h *DownloadHandler) PauseDownloadHandler(c *fiber.Ctx) error {
+	return h.pauseOrResume(c, "pause", func(d *store.Downloader, jobID string) error {
+		return d.PauseDownload(jobID)
+	})
+}
+
+// Resumes one or a batch of the user's paused downloads by jobID.
+func (h *DownloadHandler) ResumeDownloadHandler(c *fiber.Ctx) error {
+	return h.pauseOrResume(c, "resume", func(d *store.Downloader, jobID string) error {
+		return d.ResumeDownload(jobID)
+	})
+}
+
+// pauseOrResume applies `fn` to every job in the request body,
+// nothing is changed if any of the jobs isn't found.
+func (h *DownloadHandler) pauseOrResume(c *fiber.Ctx, action string, fn func(d *store.Downloader, jobID string) error) error {
+	downloader, err := h.getDownloader(c.Locals(setting.LocalSessionKey), c.Locals(setting.LocalAPIPrincipalKey))
+	if err != nil {
+		return err
+	}
+
+	jobIDs, err := util.ValidatePauseResumeDownloadHRBody(c)
+	if err != nil {
+		return err
+	}
+
+	for _, jobID := range jobIDs {
+		if _, err := downloader.GetProgress(jobID); err != nil {
+			return util.NewAppError(
+				http.StatusNotFound,
+				fmt.Sprintf("no ongoing download for job %s", jobID),
+			)
+		}
+	}
+
+	for _, jobID := range jobIDs {
+		if err := fn(downloader, jobID); err != nil {
+			return util.NewAppError(
+				http.StatusInternalServerError,
+				fmt.Sprintf("failed to %s the download for job %s", action, jobID),
+				err,
+			)
+		}
+	}
+
+	return c.JSON("OK")
+}
+
+// Cancels all ongoing downloads of the user.
 func (h *DownloadHandler) CancelAllDownloadsHandler(c *fiber.Ctx) error {
-	h.downloader.CancelAllDownloads()
+	downloader, err := h.getDownloader(c.Locals(setting.LocalSessionKey), c.Locals(setting.LocalAPIPrincipalKey))
+	if err != nil {
+		return err
+	}
+
+	downloader.CancelAllDownloads()
 	return c.JSON("OK")
 }
 
+// Sends the latest download jobs of the user, including the finished ones.
+func (h *DownloadHandler) JobHistoryHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals(setting.LocalSessionKey).(string)
+	if !ok || len(userID) == 0 {
+		return util.NewAppError(
+			http.StatusUnauthorized,
+			"invalid session, please login",
+		)
+	}
+
+	jobs, err := service.GetDownloadJobsByUserID(h.db, userID, setting.JobHistoryLimit)
+	if err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to retrieve the download history",
+			err,
+		)
+	}
+
+	return c.JSON(jobs)
+}
+
+// Sends the ongoing downloads of every user, keyed by their userID.
+func (h *DownloadHandler) AdminProgressHandler(c *fiber.Ctx) error {
+	return c.JSON(h.manager.GetAllPendingDownloads())
+}
+
+// Sends the current bandwidth limits.
+func (h *DownloadHandler) GetBandwidthHandler(c *fiber.Ctx) error {
+	return c.JSON(h.manager.GetBandwidth())
+}
+
+// Changes the bandwidth limits, they apply to the ongoing downloads as well.
+func (h *DownloadHandler) SetBandwidthHandler(c *fiber.Ctx) error {
+	b, err := util.ValidateBandwidthHRBody(c)
+	if err != nil {
+		return err
+	}
+
+	h.manager.SetBandwidth(*b)
+
+	return c.JSON(h.manager.GetBandwidth())
+}
+
+// Changes the speed limit of a single ongoing download, 0 goes back to the default per-job limit.
+func (h *DownloadHandler) SetJobBandwidthHandler(c *fiber.Ctx) error {
+	jobID, limit, err := util.ValidateJobBandwidthHRBody(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.manager.SetJobSpeedLimit(jobID, limit); err != nil {
+		return util.NewAppError(
+			http.StatusNotFound,
+			"no ongoing download found",
+			err,
+		)
+	}
+
+	return c.JSON(fiber.Map{
+		"status": http.StatusOK,
+		"job_id": jobID,
+		"limit":  limit,
+	})
+}
+
 func (h *DownloadHandler) ProgressWebsocketHandler(c *websocket.Conn) error {
 	defer func() {
 		if err := c.Close(); err != nil {
@@ -174,11 +544,19 @@ func (h *DownloadHandler) ProgressWebsocketHandler(c *websocket.Conn) error {
 			"invalid session",
 		)
 	}
+	// The userID is set by the `SessionMiddleware` before upgrading the connection.
+	downloader, err := h.getDownloader(c.Locals(setting.LocalSessionKey), nil)
+	if err != nil {
+		return util.NewAppError(
+			websocket.TextMessage,
+			"invalid session",
+		)
+	}
 
 	// Starting an infinite Loop.
 	for {
 		// Gets the ongoing downloads.
-		pendings, _ := h.downloader.GetPendingDownloads()
+		pendings, _ := downloader.GetPendingDownloads()
 		progressJSON, err := json.Marshal(pendings)
 		if err != nil {
 			return util.NewAppError(
@@ -194,26 +572,18 @@ func (h *DownloadHandler) ProgressWebsocketHandler(c *websocket.Conn) error {
 			}
 		}
 
-		// Ranging over the error channels.
-		// If any error occurs for any download it sends the error back.
+		// If any error occurred for any download since the last update it sends the error back.
 		// Client tries to reconnect after a conn lost, so returning after error is fine.
-		// TODO: Needs improvement
-		for fileID, errChan := range h.downloader.ErrChans {
-			select {
-			case err := <-errChan:
-				errJSON, err := json.Marshal(fiber.Map{
-					"file_id": fileID,
-					"errMsg":  err.Error(),
-				})
-				if err != nil {
-					if writeErr := c.WriteMessage(websocket.TextMessage, []byte("error marshalling failed")); writeErr != nil {
-						return err
-					}
+		for _, downloadErr := range downloader.TakeErrors() {
+			errJSON, err := json.Marshal(downloadErr)
+			if err != nil {
+				if writeErr := c.WriteMessage(websocket.TextMessage, []byte("error marshalling failed")); writeErr != nil {
+					return writeErr
 				}
-				if writeErr := c.WriteMessage(websocket.TextMessage, errJSON); writeErr != nil {
-					return err
-				}
-			default:
+				continue
+			}
+			if err := c.WriteMessage(websocket.TextMessage, errJSON); err != nil {
+				return err
 			}
 		}
 
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nilotpaul/go-downloader/api/middleware"
+	"github.com/nilotpaul/go-downloader/config"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/store"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAPIToken = "api-token"
+
+// `publicSource` serves the files of `files` by their link, like public Drive files which
+// need the Google provider only for private files.
+type publicSource struct {
+	prefix    string
+	anonymous bool
+	files     map[string]string
+}
+
+func (s *publicSource) AuthProvider() setting.Provider {
+	return setting.GoogleProvider
+}
+
+func (s *publicSource) AllowsAnonymous() bool {
+	return s.anonymous
+}
+
+func (s *publicSource) Match(link string) bool {
+	return strings.HasPrefix(link, s.prefix)
+}
+
+func (s *publicSource) Resolve(ctx context.Context, link string, opts types.SourceOptions) (*types.SourceFile, error) {
+	return &types.SourceFile{ID: link}, nil
+}
+
+func (s *publicSource) ListFolder(ctx context.Context, folder *types.SourceFile, opts types.SourceOptions) ([]types.SourceFile, error) {
+	return nil, fmt.Errorf("not a folder")
+}
+
+func (s *publicSource) Stat(ctx context.Context, fileID string, opts types.SourceOptions) (*types.SourceFile, error) {
+	content, ok := s.files[fileID]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", fileID)
+	}
+
+	return &types.SourceFile{ID: fileID, Name: filepath.Base(fileID), Size: int64(len(content))}, nil
+}
+
+func (s *publicSource) Open(ctx context.Context, file *types.SourceFile, offset int64, opts types.SourceOptions) (io.ReadCloser, int64, error) {
+	if opts.TokenSource != nil {
+		return nil, 0, fmt.Errorf("public files are downloaded without a token")
+	}
+
+	return io.NopCloser(strings.NewReader(s.files[file.ID][offset:])), offset, nil
+}
+
+func (s *publicSource) Checksum(file *types.SourceFile) types.Checksum {
+	return types.Checksum{}
+}
+
+func newTestDownloadApp(registry *store.ProviderRegistry) (*fiber.App, *store.DownloadManager) {
+	env := config.EnvConfig{DownloadAPITokens: []string{testAPIToken}}
+	manager := store.NewDownloadManager(nil, registry, store.QueueConfig{}, store.RetryConfig{MaxAttempts: 1}, types.BandwidthConfig{})
+	sessionMW := middleware.NewSessionMiddleware(env, nil, nil)
+	downloadHR := NewDownloadHandler(registry, manager, nil, nil, env)
+
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+	app.Post("/download", sessionMW.SessionMiddleware, downloadHR.DownloadHandler)
+
+	return app, manager
+}
+
+func postDownload(t *testing.T, app *fiber.App, token string, body fiber.Map) (*http.Response, fiber.Map) {
+	t.Helper()
+
+	b, err := json.Marshal(body)
+	assert.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/download", strings.NewReader(string(b)))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if len(token) != 0 {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+
+	res, err := app.Test(req)
+	assert.NoError(t, err)
+
+	var decoded fiber.Map
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&decoded))
+	return res, decoded
+}
+
+func TestDownloadHandler_APIToken(t *testing.T) {
+	const (
+		publicLink  = "https://public.example.com/file.txt"
+		privateLink = "https://private.example.com/file.txt"
+		content     = "public content"
+	)
+
+	// No Google provider is registered, so there's no Google account either.
+	registry := store.NewProviderRegistry()
+	registry.RegisterSource(setting.GoogleProvider, &publicSource{
+		prefix:    "https://public.example.com/",
+		anonymous: true,
+		files:     map[string]string{publicLink: content},
+	})
+	registry.RegisterSource(setting.DropboxProvider, &publicSource{prefix: "https://private.example.com/"})
+	app, manager := newTestDownloadApp(registry)
+	dir := t.TempDir()
+
+	res, _ := postDownload(t, app, "", fiber.Map{"links": publicLink, "path": dir})
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	res, _ = postDownload(t, app, "wrong-token", fiber.Map{"links": publicLink, "path": dir})
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	// Links which need an account can't be downloaded with an API token.
+	res, body := postDownload(t, app, testAPIToken, fiber.Map{"links": privateLink, "path": dir})
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.Equal(t, "only public links can be downloaded with an API token", body["errMsg"])
+
+	res, body = postDownload(t, app, testAPIToken, fiber.Map{"links": publicLink, "path": dir})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, body["job_ids"], 1)
+
+	d := manager.GetDownloader(setting.APIPrincipalID)
+	assert.Eventually(t, func() bool {
+		pendings, _ := d.GetPendingDownloads()
+		return len(pendings) == 0
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.Empty(t, d.TakeErrors())
+
+	b, err := os.ReadFile(filepath.Join(dir, "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(b))
+}
@@ -6,10 +6,12 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/google/uuid"
 	"github.com/nilotpaul/go-downloader/config"
 	"github.com/nilotpaul/go-downloader/service"
 	"github.com/nilotpaul/go-downloader/setting"
 	"github.com/nilotpaul/go-downloader/store"
+	"github.com/nilotpaul/go-downloader/types"
 	"github.com/nilotpaul/go-downloader/util"
 )
 
@@ -39,13 +41,14 @@ func (h *GoogleHandler) GoogleSignInHandler(c *fiber.Ctx) error {
 		)
 	}
 
-	state, err := util.GenerateRandomState(32)
+	// The state and PKCE verifier are kept in the session of this browser until the callback.
+	flow, err := startOAuthFlow(c, h.sessStore, setting.OAuthFlowSignIn)
 	if err != nil {
 		return err
 	}
 
 	// GetAuthURL returns a URL to Google's consent page.
-	authURL := gp.GetAuthURL(state)
+	authURL := gp.GetAuthURL(flow.State, flow.Verifier)
 	if len(authURL) == 0 {
 		return util.NewAppError(
 			http.StatusInternalServerError,
@@ -78,14 +81,20 @@ func (h *GoogleHandler) GoogleCallbackHandler(c *fiber.Ctx) error {
 		)
 	}
 
-	// Sets the access & refresh tokens in the GoogleProvider struct.
-	if err := gp.Authenticate(authCode); err != nil {
+	// The callback has to belong to the sign in started from this browser.
+	flow, err := finishOAuthFlow(c, h.sessStore, setting.OAuthFlowSignIn)
+	if err != nil {
+		return err
+	}
+
+	// Exchanges the code for the access & refresh tokens of the account.
+	token, err := gp.Authenticate(c.Context(), authCode, flow.Verifier)
+	if err != nil {
 		return err
 	}
 
-	// Creates or Updates the user account in database from the new tokens
-	// that were inserted via Authenticate func above.
-	userID, err := gp.CreateOrUpdateAccount()
+	// Creates or Updates the user account in database from the new tokens.
+	userID, err := gp.CreateOrUpdateAccount(c.Context(), token)
 	if err != nil {
 		return err
 	}
@@ -128,23 +137,23 @@ func (h *GoogleHandler) GetSessionHandler(c *fiber.Ctx) error {
 	return c.JSON(u.Email)
 }
 
-// LogoutHandler clear the user session.
+// LogoutHandler revokes the session of the request and clears it.
 func (h *GoogleHandler) LogoutHandler(c *fiber.Ctx) error {
-	gp, err := h.registry.GetProvider(setting.GoogleProvider)
-	if err != nil {
-		util.NewAppError(
-			http.StatusNotFound,
-			"no provider found",
-		)
+	// Only this session is revoked, the user's tokens stay in the
+	// database for their other sessions and downloads.
+	userID, _ := c.Locals(setting.LocalSessionKey).(string)
+	sessionID, _ := c.Locals(setting.LocalSessionIDKey).(string)
+	if len(sessionID) != 0 {
+		if _, err := service.RevokeUserSession(h.db, userID, sessionID); err != nil {
+			return util.NewAppError(
+				http.StatusInternalServerError,
+				"failed to revoke the session",
+				err,
+			)
+		}
 	}
-
-	// Resets and clears the session state and cookie.
-	err = util.ResetSession(c, gp, h.env.Domain)
-	if err != nil {
-		util.NewAppError(
-			http.StatusNotFound,
-			"failed to clear the session",
-		)
+	if err := clearSession(c, h.sessStore, h.env.Domain); err != nil {
+		return err
 	}
 
 	return c.JSON("OK")
@@ -171,24 +180,15 @@ func (h *GoogleHandler) RefreshTokenHandler(c *fiber.Ctx) error {
 	}
 
 	// Here, we forcefully refresh the session which might still be valid.
-	t, err := gp.RefreshToken(c, sess.UserID, true)
+	t, err := gp.RefreshToken(sess.UserID, true)
 	if err != nil {
 		return err
 	}
 
-	sess.AccessToken = t.AccessToken
-	sess.RefreshToken = t.RefreshToken
+	// The tokens are stored with the account, the session only keeps their expiry.
 	sess.TokenType = t.TokenType
 	sess.ExpiresAt = t.Expiry
 
-	// Updating the token state.
-	if err := gp.UpdateTokens(sess); err != nil {
-		return util.NewAppError(
-			http.StatusInternalServerError,
-			"failed to update the tokens",
-			err,
-		)
-	}
 	// Updating the memory store.
 	if err := util.SetSessionInStore(c, h.sessStore, sess); err != nil {
 		return util.NewAppError(
@@ -202,3 +202,203 @@ func (h *GoogleHandler) RefreshTokenHandler(c *fiber.Ctx) error {
 
 	return c.JSON("OK")
 }
+
+// ListAccountsHandler sends back the Google accounts linked to the user, without their tokens.
+func (h *GoogleHandler) ListAccountsHandler(c *fiber.Ctx) error {
+	userID, err := sessionUserID(c)
+	if err != nil {
+		return err
+	}
+
+	accounts, err := service.GetGoogleAccounts(h.db, userID)
+	if err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to retrieve the google accounts",
+			err,
+		)
+	}
+
+	linked := make([]types.LinkedGoogleAccount, 0, len(accounts))
+	for _, acc := range accounts {
+		linked = append(linked, types.LinkedGoogleAccount{
+			ID:        acc.ID,
+			Email:     acc.Email,
+			Name:      acc.Name,
+			IsPrimary: acc.IsPrimary,
+			CreatedAt: acc.CreatedAt,
+		})
+	}
+
+	return c.JSON(linked)
+}
+
+// LinkAccountHandler sends back an URL for the Google's consent page, the chosen
+// account is linked to the signed in user.
+func (h *GoogleHandler) LinkAccountHandler(c *fiber.Ctx) error {
+	if _, err := sessionUserID(c); err != nil {
+		return err
+	}
+	linker, err := h.getLinker()
+	if err != nil {
+		return err
+	}
+
+	flow, err := startOAuthFlow(c, h.sessStore, setting.OAuthFlowLink)
+	if err != nil {
+		return err
+	}
+
+	authURL := linker.GetLinkURL(flow.State, flow.Verifier)
+	if len(authURL) == 0 {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"no authentication URL was generated",
+		)
+	}
+
+	return c.JSON(fiber.Map{
+		"url": authURL,
+	})
+}
+
+// LinkAccountCallbackHandler uses `code` in URL and links the account to the signed in user.
+func (h *GoogleHandler) LinkAccountCallbackHandler(c *fiber.Ctx) error {
+	userID, err := sessionUserID(c)
+	if err != nil {
+		return err
+	}
+	linker, err := h.getLinker()
+	if err != nil {
+		return err
+	}
+
+	// Getting the `code` from the url.
+	authCode := c.Query("code")
+	if len(authCode) == 0 {
+		return util.NewAppError(
+			http.StatusBadRequest,
+			"no authorization code found in URL",
+		)
+	}
+
+	flow, err := finishOAuthFlow(c, h.sessStore, setting.OAuthFlowLink)
+	if err != nil {
+		return err
+	}
+
+	if err := linker.LinkAccount(c.Context(), userID, authCode, flow.Verifier); err != nil {
+		return err
+	}
+
+	// Redirecting the user to our App.
+	return c.Redirect(util.GetEnv("REDIRECT_AFTER_LOGIN", "/"), http.StatusTemporaryRedirect)
+}
+
+// RenameAccountHandler changes the name of one of the user's Google accounts,
+// downloads can pick the account by its name.
+func (h *GoogleHandler) RenameAccountHandler(c *fiber.Ctx) error {
+	userID, err := sessionUserID(c)
+	if err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	if err := uuid.Validate(id); err != nil {
+		return util.NewAppError(
+			http.StatusBadRequest,
+			"invalid account id",
+		)
+	}
+
+	var b types.RenameGoogleAccountHRBody
+	if err := c.BodyParser(&b); err != nil {
+		return util.NewAppError(
+			http.StatusUnprocessableEntity,
+			"failed to parse the response body",
+			err,
+		)
+	}
+	name, err := util.ValidateGoogleAccountName(b.Name)
+	if err != nil {
+		return util.NewAppError(
+			http.StatusBadRequest,
+			err.Error(),
+		)
+	}
+
+	renamed, err := service.RenameGoogleAccount(h.db, userID, id, name)
+	if err != nil {
+		// Names are unique per user.
+		return util.NewAppError(
+			http.StatusConflict,
+			"failed to rename the google account, the name might already be taken",
+			err,
+		)
+	}
+	if !renamed {
+		return util.NewAppError(
+			http.StatusNotFound,
+			"no google account found",
+		)
+	}
+
+	return c.JSON(fiber.Map{
+		"status": http.StatusOK,
+	})
+}
+
+// UnlinkAccountHandler removes one of the user's Google accounts, except the one they sign in with.
+func (h *GoogleHandler) UnlinkAccountHandler(c *fiber.Ctx) error {
+	userID, err := sessionUserID(c)
+	if err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	if err := uuid.Validate(id); err != nil {
+		return util.NewAppError(
+			http.StatusBadRequest,
+			"invalid account id",
+		)
+	}
+
+	unlinked, err := service.UnlinkGoogleAccount(h.db, userID, id)
+	if err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to unlink the google account",
+			err,
+		)
+	}
+	if !unlinked {
+		return util.NewAppError(
+			http.StatusNotFound,
+			"no linked google account found, the primary account can't be unlinked",
+		)
+	}
+
+	return c.JSON(fiber.Map{
+		"status": http.StatusOK,
+	})
+}
+
+// getLinker returns the Google provider, which can link several accounts to a user.
+func (h *GoogleHandler) getLinker() (types.MultiAccountProvider, error) {
+	gp, err := h.registry.GetProvider(setting.GoogleProvider)
+	if err != nil {
+		return nil, util.NewAppError(
+			http.StatusNotFound,
+			"provider not found",
+		)
+	}
+	linker, ok := gp.(types.MultiAccountProvider)
+	if !ok {
+		return nil, util.NewAppError(
+			http.StatusBadRequest,
+			"provider can't link several accounts",
+		)
+	}
+
+	return linker, nil
+}
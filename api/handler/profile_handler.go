@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/nilotpaul/go-downloader/service"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+)
+
+// `ProfileHandler` manages the connection profiles of the signed in user,
+// which hold the credentials for SFTP and FTP links.
+type ProfileHandler struct {
+	db *sql.DB
+}
+
+func NewProfileHandler(db *sql.DB) *ProfileHandler {
+	return &ProfileHandler{
+		db: db,
+	}
+}
+
+// Sends the user's profiles without their secrets.
+func (h *ProfileHandler) ListProfilesHandler(c *fiber.Ctx) error {
+	userID, err := sessionUserID(c)
+	if err != nil {
+		return err
+	}
+
+	profiles, err := service.GetConnectionProfiles(h.db, userID)
+	if err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to retrieve the connection profiles",
+			err,
+		)
+	}
+
+	return c.JSON(profiles)
+}
+
+// Saves a profile, an existing profile for the same server is replaced.
+func (h *ProfileHandler) SaveProfileHandler(c *fiber.Ctx) error {
+	userID, err := sessionUserID(c)
+	if err != nil {
+		return err
+	}
+
+	b, err := util.ValidateConnectionProfileHRBody(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := service.CreateOrUpdateConnectionProfile(h.db, &types.ConnectionProfile{
+		UserID:      userID,
+		Name:        b.Name,
+		Protocol:    b.Protocol,
+		Host:        b.Host,
+		Port:        b.Port,
+		Username:    b.Username,
+		Password:    b.Password,
+		PrivateKey:  b.PrivateKey,
+		HostKey:     b.HostKey,
+		ExplicitTLS: b.ExplicitTLS,
+	})
+	if err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to save the connection profile",
+			err,
+		)
+	}
+
+	return c.JSON(fiber.Map{
+		"status": http.StatusOK,
+		"id":     id,
+	})
+}
+
+// Deletes one of the user's profiles by its ID.
+func (h *ProfileHandler) DeleteProfileHandler(c *fiber.Ctx) error {
+	userID, err := sessionUserID(c)
+	if err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	if err := uuid.Validate(id); err != nil {
+		return util.NewAppError(
+			http.StatusBadRequest,
+			"invalid profile id",
+		)
+	}
+
+	deleted, err := service.DeleteConnectionProfile(h.db, userID, id)
+	if err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to delete the connection profile",
+			err,
+		)
+	}
+	if !deleted {
+		return util.NewAppError(
+			http.StatusNotFound,
+			"no connection profile found",
+		)
+	}
+
+	return c.JSON(fiber.Map{
+		"status": http.StatusOK,
+	})
+}
+
+// sessionUserID returns the ID of the signed in user.
+func sessionUserID(c *fiber.Ctx) (string, error) {
+	userID, ok := c.Locals(setting.LocalSessionKey).(string)
+	if !ok || len(userID) == 0 {
+		return "", util.NewAppError(
+			http.StatusUnauthorized,
+			"invalid session, please login",
+		)
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/store"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+)
+
+// `ProviderHandler` connects the accounts of the providers which can't be used to sign in,
+// like Dropbox, to the signed in user.
+type ProviderHandler struct {
+	registry  *store.ProviderRegistry
+	sessStore *session.Store
+}
+
+func NewProviderHandler(registry *store.ProviderRegistry, sessStore *session.Store) *ProviderHandler {
+	return &ProviderHandler{
+		registry:  registry,
+		sessStore: sessStore,
+	}
+}
+
+// ConnectHandler sends back an URL for the consent page of the provider.
+func (h *ProviderHandler) ConnectHandler(c *fiber.Ctx) error {
+	providerName := c.Params("provider")
+	p, _, err := h.getConnector(providerName)
+	if err != nil {
+		return err
+	}
+
+	flow, err := startOAuthFlow(c, h.sessStore, setting.OAuthFlowConnect+providerName)
+	if err != nil {
+		return err
+	}
+
+	authURL := p.GetAuthURL(flow.State, flow.Verifier)
+	if len(authURL) == 0 {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"no authentication URL was generated",
+		)
+	}
+
+	return c.JSON(fiber.Map{
+		"url": authURL,
+	})
+}
+
+// ConnectCallbackHandler uses `code` in URL and connects the account to the signed in user.
+func (h *ProviderHandler) ConnectCallbackHandler(c *fiber.Ctx) error {
+	providerName := c.Params("provider")
+	_, connector, err := h.getConnector(providerName)
+	if err != nil {
+		return err
+	}
+
+	userID, ok := c.Locals(setting.LocalSessionKey).(string)
+	if !ok || len(userID) == 0 {
+		return util.NewAppError(
+			http.StatusUnauthorized,
+			"invalid session, please login",
+		)
+	}
+
+	// Getting the `code` from the url.
+	authCode := c.Query("code")
+	if len(authCode) == 0 {
+		return util.NewAppError(
+			http.StatusBadRequest,
+			"no authorization code found in URL",
+		)
+	}
+
+	flow, err := finishOAuthFlow(c, h.sessStore, setting.OAuthFlowConnect+providerName)
+	if err != nil {
+		return err
+	}
+
+	if err := connector.ConnectAccount(c.Context(), userID, authCode, flow.Verifier); err != nil {
+		return err
+	}
+
+	// Redirecting the user to our App.
+	return c.Redirect(util.GetEnv("REDIRECT_AFTER_LOGIN", "/"), http.StatusTemporaryRedirect)
+}
+
+// getConnector returns the provider if its accounts can be connected.
+func (h *ProviderHandler) getConnector(providerName string) (types.OAuthProvider, types.AccountConnector, error) {
+	p, err := h.registry.GetProvider(setting.Provider(providerName))
+	if err != nil {
+		return nil, nil, util.NewAppError(
+			http.StatusNotFound,
+			"provider not found",
+		)
+	}
+	connector, ok := p.(types.AccountConnector)
+	if !ok {
+		return nil, nil, util.NewAppError(
+			http.StatusBadRequest,
+			"provider can't be connected, sign in with it instead",
+		)
+	}
+
+	return p, connector, nil
+}
+
+// startOAuthFlow keeps the state and PKCE verifier of a new OAuth flow in the session until its callback.
+func startOAuthFlow(c *fiber.Ctx, store *session.Store, name string) (*types.OAuthFlow, error) {
+	flow, err := util.StartOAuthFlow(c, store, name)
+	if err != nil {
+		return nil, util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to start the oauth flow",
+			err,
+		)
+	}
+
+	return flow, nil
+}
+
+// finishOAuthFlow checks the `state` of the callback against the flow started from the same browser.
+func finishOAuthFlow(c *fiber.Ctx, store *session.Store, name string) (*types.OAuthFlow, error) {
+	flow, err := util.FinishOAuthFlow(c, store, name, c.Query("state"))
+	if util.IsOAuthFlowError(err) {
+		return nil, util.NewAppError(
+			http.StatusBadRequest,
+			err.Error(),
+		)
+	}
+	if err != nil {
+		return nil, util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to finish the oauth flow",
+			err,
+		)
+	}
+
+	return flow, nil
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/google/uuid"
+	"github.com/nilotpaul/go-downloader/config"
+	"github.com/nilotpaul/go-downloader/service"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/util"
+)
+
+// `SessionHandler` lists the sessions of the signed in user on their devices and revokes them.
+type SessionHandler struct {
+	db        *sql.DB
+	sessStore *session.Store
+	env       config.EnvConfig
+}
+
+func NewSessionHandler(db *sql.DB, sessStore *session.Store, env config.EnvConfig) *SessionHandler {
+	return &SessionHandler{
+		db:        db,
+		sessStore: sessStore,
+		env:       env,
+	}
+}
+
+// Sends the user's active sessions, the session of the request is marked as `current`.
+func (h *SessionHandler) ListSessionsHandler(c *fiber.Ctx) error {
+	userID, err := sessionUserID(c)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := service.GetActiveUserSessions(h.db, userID)
+	if err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to retrieve the sessions",
+			err,
+		)
+	}
+	current, _ := c.Locals(setting.LocalSessionIDKey).(string)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	return c.JSON(sessions)
+}
+
+// Revokes one of the user's sessions by its ID, revoking the session of the request signs the user out.
+func (h *SessionHandler) RevokeSessionHandler(c *fiber.Ctx) error {
+	userID, err := sessionUserID(c)
+	if err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	if err := uuid.Validate(id); err != nil {
+		return util.NewAppError(
+			http.StatusBadRequest,
+			"invalid session id",
+		)
+	}
+
+	revoked, err := service.RevokeUserSession(h.db, userID, id)
+	if err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to revoke the session",
+			err,
+		)
+	}
+	if !revoked {
+		return util.NewAppError(
+			http.StatusNotFound,
+			"no active session found",
+		)
+	}
+	if current, _ := c.Locals(setting.LocalSessionIDKey).(string); current == id {
+		if err := clearSession(c, h.sessStore, h.env.Domain); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"status": http.StatusOK,
+	})
+}
+
+// Revokes every session of the user, including the session of the request.
+func (h *SessionHandler) RevokeAllSessionsHandler(c *fiber.Ctx) error {
+	userID, err := sessionUserID(c)
+	if err != nil {
+		return err
+	}
+
+	n, err := service.RevokeUserSessions(h.db, userID)
+	if err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to revoke the sessions",
+			err,
+		)
+	}
+	if err := clearSession(c, h.sessStore, h.env.Domain); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  http.StatusOK,
+		"revoked": n,
+	})
+}
+
+// clearSession clears the session of this browser and its cookies.
+func clearSession(c *fiber.Ctx, store *session.Store, domain string) error {
+	if err := util.SetSessionInStore(c, store, nil); err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to clear the session",
+			err,
+		)
+	}
+	util.ResetSession(c, domain)
+
+	return nil
+}
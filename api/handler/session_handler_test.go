@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/nilotpaul/go-downloader/api/middleware"
+	"github.com/nilotpaul/go-downloader/config"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testUserID         = "user-a"
+	testCurrentSession = "6f1c1a3e-0d5b-4a52-9a8e-3b1f2c4d5e6f"
+)
+
+// newTestSessionApp serves the session routes for the session `testCurrentSession` of `testUserID`.
+func newTestSessionApp(t *testing.T) (*fiber.App, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	sessionHR := NewSessionHandler(db, session.New(), config.EnvConfig{})
+	signedIn := func(c *fiber.Ctx) error {
+		c.Locals(setting.LocalSessionKey, testUserID)
+		c.Locals(setting.LocalSessionIDKey, testCurrentSession)
+		return c.Next()
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.ErrorHandler})
+	app.Delete("/sessions", signedIn, sessionHR.RevokeAllSessionsHandler)
+	app.Delete("/sessions/:id", signedIn, sessionHR.RevokeSessionHandler)
+
+	return app, mock
+}
+
+func deleteSessions(t *testing.T, app *fiber.App, path string) (*http.Response, fiber.Map) {
+	t.Helper()
+
+	res, err := app.Test(httptest.NewRequest(http.MethodDelete, path, nil))
+	assert.NoError(t, err)
+
+	var body fiber.Map
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	return res, body
+}
+
+// sessionCleared reports whether the response removes the session cookie of the browser.
+func sessionCleared(res *http.Response) bool {
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == setting.SessionKey {
+			return len(cookie.Value) == 0 && cookie.Expires.Before(time.Now())
+		}
+	}
+	return false
+}
+
+func TestRevokeSessionHandler(t *testing.T) {
+	const otherDevice = "0b9d2e4f-7a6c-4e1b-8f3d-2c5a6b7d8e9f"
+	app, mock := newTestSessionApp(t)
+
+	res, body := deleteSessions(t, app, "/sessions/not-a-uuid")
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "invalid session id", body["errMsg"])
+
+	// Another device of the user, this browser stays signed in.
+	mock.ExpectExec("UPDATE user_sessions").
+		WithArgs(sqlmock.AnyArg(), otherDevice, testUserID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	res, _ = deleteSessions(t, app, "/sessions/"+otherDevice)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.False(t, sessionCleared(res))
+
+	// The session of this browser signs the user out.
+	mock.ExpectExec("UPDATE user_sessions").
+		WithArgs(sqlmock.AnyArg(), testCurrentSession, testUserID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	res, _ = deleteSessions(t, app, "/sessions/"+testCurrentSession)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, sessionCleared(res))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeSessionHandler_OtherUser(t *testing.T) {
+	const otherUsersSession = "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"
+	app, mock := newTestSessionApp(t)
+
+	// The session is only revoked if it belongs to the signed in user, so nothing is updated.
+	mock.ExpectExec("WHERE\\s+id = \\$2 AND user_id = \\$3 AND revoked_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), otherUsersSession, testUserID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	res, body := deleteSessions(t, app, "/sessions/"+otherUsersSession)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, "no active session found", body["errMsg"])
+	assert.False(t, sessionCleared(res))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeAllSessionsHandler(t *testing.T) {
+	app, mock := newTestSessionApp(t)
+
+	mock.ExpectExec("WHERE\\s+user_id = \\$2 AND revoked_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), testUserID).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	res, body := deleteSessions(t, app, "/sessions")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, float64(3), body["revoked"])
+	assert.True(t, sessionCleared(res))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/gob"
 	"log/slog"
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
@@ -12,7 +14,6 @@ import (
 	"github.com/nilotpaul/go-downloader/config"
 	"github.com/nilotpaul/go-downloader/service"
 	"github.com/nilotpaul/go-downloader/setting"
-	"github.com/nilotpaul/go-downloader/store"
 	"github.com/nilotpaul/go-downloader/types"
 	"github.com/nilotpaul/go-downloader/util"
 )
@@ -21,40 +22,32 @@ type SessionMiddleware struct {
 	env       config.EnvConfig
 	sessStore *session.Store
 	db        *sql.DB
-	registry  *store.ProviderRegistry
 }
 
-func NewSessionMiddleware(env config.EnvConfig, sessStore *session.Store, db *sql.DB, registry *store.ProviderRegistry) *SessionMiddleware {
+func NewSessionMiddleware(env config.EnvConfig, sessStore *session.Store, db *sql.DB) *SessionMiddleware {
 	return &SessionMiddleware{
 		env:       env,
 		sessStore: sessStore,
 		db:        db,
-		registry:  registry,
 	}
 }
 
 func init() {
 	gob.Register(types.GoogleAccountWrapper{})
+	gob.Register(types.OAuthFlow{})
 }
 
-// SessionMiddleware will check the validity of AccessToken token,
-// if it's invalid it'll refresh the token and return the new credentials.
-// values are created and updated in order to maintain the synchronicity.
+// SessionMiddleware checks the session of the request and sets the user's ID in the request.
+// The tokens aren't touched here, the token source of every download refreshes them when needed.
 func (m *SessionMiddleware) SessionMiddleware(c *fiber.Ctx) error {
-	gp, err := m.registry.GetProvider(setting.GoogleProvider)
-	if err != nil {
-		return util.NewAppError(
-			http.StatusInternalServerError,
-			"no provider found",
-			"SessionMiddleware error: ",
-			err,
-		)
-	}
-
 	// GetSessionToken gets the jwt token from the cookie
 	// which contains the UserID and JWT Expiry.
 	token := util.GetSessionToken(c)
 	if len(token) == 0 {
+		// Scripts download public links with an API token instead.
+		if util.IsValidAPIToken(util.GetAPIToken(c), m.env.DownloadAPITokens) {
+			c.Locals(setting.LocalAPIPrincipalKey, true)
+		}
 		return c.Next()
 	}
 
@@ -62,57 +55,45 @@ func (m *SessionMiddleware) SessionMiddleware(c *fiber.Ctx) error {
 	decoded, err := util.VerifyAndDecodeSessionToken(token, m.env.SessionSecret)
 	if err != nil {
 		slog.Error("invalid session", "SessionMiddleware error", err)
-		m.resetPersistingSession(c, gp)
+		m.resetPersistingSession(c)
 		return c.Next()
 	}
 
-	session, err := service.GetAccountByUserID(m.db, decoded.UserID)
+	// The session might have been revoked on another device.
+	userSession, err := service.GetActiveUserSession(m.db, decoded.UserID, decoded.SessionID)
 	if err != nil {
-		slog.Error("invalid session", "SessionMiddleware error", err)
-		m.resetPersistingSession(c, gp)
-		return c.Next()
-	}
-	if session == nil {
-		slog.Error("no account found", "SessionMiddleware error", err)
-		m.resetPersistingSession(c, gp)
+		slog.Error("failed to retrieve the session", "SessionMiddleware error", err)
+		m.resetPersistingSession(c)
 		return c.Next()
 	}
-
-	// Injecting provider with the old and potentially (expired/invalid) tokens,
-	// so that it can be used to refresh the token later.
-	if err := gp.UpdateTokens(session); err != nil {
-		slog.Error("failed to update with old tokens", "SessionMiddleware error", err)
-		m.resetPersistingSession(c, gp)
+	if len(userSession.ID) == 0 {
+		slog.Info("session was revoked or expired", "sessionID", decoded.SessionID)
+		m.resetPersistingSession(c)
+		util.ResetSession(c, m.env.Domain)
 		return c.Next()
 	}
-
-	// If token has expired or is invalid, RefreshToken will generate a new
-	// AccessToken and update the user account in database.
-	if !gp.IsTokenValid() {
-		t, err := gp.RefreshToken(c, session.UserID, false)
-		if err != nil {
-			slog.Error("failed to refresh the token", "SessionMiddleware error", err)
-			m.resetPersistingSession(c, gp)
-			return c.Next()
+	if time.Since(userSession.LastSeenAt) > setting.SessionTouchInterval {
+		if err := service.TouchUserSession(m.db, userSession.ID, c.Get(fiber.HeaderUserAgent), c.IP()); err != nil {
+			slog.Error("failed to update the session", "SessionMiddleware error", err)
 		}
-
-		session.AccessToken = t.AccessToken
-		session.RefreshToken = t.RefreshToken
-		session.TokenType = t.TokenType
-		session.ExpiresAt = t.Expiry
 	}
 
-	// Injecting provider with the new tokens.
-	if err := gp.UpdateTokens(session); err != nil {
-		slog.Error("failed to update with new tokens", "SessionMiddleware error", err)
-		m.resetPersistingSession(c, gp)
+	session, err := service.GetAccountByUserID(m.db, decoded.UserID)
+	if err != nil {
+		slog.Error("invalid session", "SessionMiddleware error", err)
+		m.resetPersistingSession(c)
+		return c.Next()
+	}
+	if session == nil {
+		slog.Error("no account found", "SessionMiddleware error", err)
+		m.resetPersistingSession(c)
 		return c.Next()
 	}
 
 	// Setting the session in the in memory session store.
 	if err := util.SetSessionInStore(c, m.sessStore, session); err != nil {
 		slog.Error("failed to set the session in memory store", "SessionMiddleware error", err)
-		m.resetPersistingSession(c, gp)
+		m.resetPersistingSession(c)
 		return c.Next()
 	}
 
@@ -121,41 +102,54 @@ func (m *SessionMiddleware) SessionMiddleware(c *fiber.Ctx) error {
 	// `fiber.Ctx `and `websocket.Conn` doesn't match, hence retrieving
 	// sessions from storage is not possible.
 	c.Locals(setting.LocalSessionKey, session.UserID)
+	c.Locals(setting.LocalSessionIDKey, userSession.ID)
 
 	return c.Next()
 }
 
-// WithGoogleOAuth will block access if the Token is invalid.
+// WithGoogleOAuth will block access if `SessionMiddleware` didn't find a valid session.
 func (m *SessionMiddleware) WithGoogleOAuth(c *fiber.Ctx) error {
-	gp, err := m.registry.GetProvider(setting.GoogleProvider)
-	if err != nil {
+	if userID, ok := c.Locals(setting.LocalSessionKey).(string); !ok || len(userID) == 0 {
 		return util.NewAppError(
 			http.StatusUnauthorized,
-			"no provider found",
+			"invalid session, please login",
 		)
 	}
 
-	if !gp.IsTokenValid() {
+	return c.Next()
+}
+
+// WithAdmin will block access if the logged in user isn't listed in `ADMIN_EMAILS`.
+func (m *SessionMiddleware) WithAdmin(c *fiber.Ctx) error {
+	userID, ok := c.Locals(setting.LocalSessionKey).(string)
+	if !ok || len(userID) == 0 {
 		return util.NewAppError(
 			http.StatusUnauthorized,
 			"invalid session, please login",
 		)
 	}
 
-	return c.Next()
-}
-
-// WithGoogleOAuth will block access if the Token is valid.
-func (m *SessionMiddleware) WithoutGoogleOAuth(c *fiber.Ctx) error {
-	gp, err := m.registry.GetProvider(setting.GoogleProvider)
+	u, err := service.GetUserByID(m.db, userID)
 	if err != nil {
 		return util.NewAppError(
-			http.StatusNotFound,
-			"no provider found",
+			http.StatusInternalServerError,
+			"failed to retrieve the user",
+			err,
+		)
+	}
+	if len(u.Email) == 0 || !slices.Contains(m.env.AdminEmails, u.Email) {
+		return util.NewAppError(
+			http.StatusForbidden,
+			"admin access required",
 		)
 	}
 
-	if gp.IsTokenValid() {
+	return c.Next()
+}
+
+// WithoutGoogleOAuth will block access if `SessionMiddleware` found a valid session.
+func (m *SessionMiddleware) WithoutGoogleOAuth(c *fiber.Ctx) error {
+	if userID, ok := c.Locals(setting.LocalSessionKey).(string); ok && len(userID) != 0 {
 		return util.NewAppError(
 			http.StatusUnauthorized,
 			"your session is valid",
@@ -165,15 +159,13 @@ func (m *SessionMiddleware) WithoutGoogleOAuth(c *fiber.Ctx) error {
 	return c.Next()
 }
 
-// resetPersistingSession will update the session and token state with empty or nil values.
+// resetPersistingSession will update the session state with empty or nil values.
 // It'll reset the persisting session when an error occurs in `SessionMiddleware`.
-func (m *SessionMiddleware) resetPersistingSession(c *fiber.Ctx, gp types.OAuthProvider) {
-	if err := gp.UpdateTokens(nil); err != nil {
-		log.Error("failed reseting session(UpdateTokens): ", err)
-	}
+func (m *SessionMiddleware) resetPersistingSession(c *fiber.Ctx) {
 	if err := util.SetSessionInStore(c, m.sessStore, nil); err != nil {
 		log.Error("failed reseting session(SetSessionInStore): ", err)
 	}
 
 	c.Locals(setting.LocalSessionKey, "")
+	c.Locals(setting.LocalSessionIDKey, "")
 }
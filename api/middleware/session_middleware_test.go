@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/nilotpaul/go-downloader/config"
+	"github.com/nilotpaul/go-downloader/service"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/util"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSessionSecret = "session-secret"
+
+// newTestSessionApp serves `/me` with the user ID of the session, for signed in users only.
+func newTestSessionApp(t *testing.T) (*fiber.App, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	mw := NewSessionMiddleware(config.EnvConfig{SessionSecret: testSessionSecret}, session.New(), db)
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Get("/me", mw.SessionMiddleware, mw.WithGoogleOAuth, func(c *fiber.Ctx) error {
+		return c.SendString(c.Locals(setting.LocalSessionKey).(string))
+	})
+
+	return app, mock
+}
+
+// getMe requests `/me` with the token of the session `sessionID` of `userID`.
+func getMe(t *testing.T, app *fiber.App, userID string, sessionID string) (*http.Response, string) {
+	token, err := util.GenerateSessionToken(userID, sessionID, time.Now().Add(time.Hour), testSessionSecret)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.AddCookie(&http.Cookie{Name: setting.SessionKey, Value: token})
+	res, err := app.Test(req)
+	assert.NoError(t, err)
+
+	b, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	return res, string(b)
+}
+
+var userSessionColumns = []string{"id", "user_id", "user_agent", "ip_address", "created_at", "last_seen_at", "expires_at"}
+
+func TestSessionMiddleware_ActiveSession(t *testing.T) {
+	keys, err := util.ParseTokenKeys("test:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	assert.NoError(t, err)
+	prev, _ := service.TokenKeyring()
+	service.SetTokenKeyring(keys)
+	t.Cleanup(func() { service.SetTokenKeyring(prev) })
+	accessToken, err := keys.Encrypt("access")
+	assert.NoError(t, err)
+	refreshToken, err := keys.Encrypt("refresh")
+	assert.NoError(t, err)
+
+	app, mock := newTestSessionApp(t)
+	now := time.Now()
+	// Seen just now, so the session isn't touched.
+	mock.ExpectQuery("FROM\\s+user_sessions").
+		WithArgs("s1", "user", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(userSessionColumns).AddRow("s1", "user", "curl", "127.0.0.1", now, now, now.Add(time.Hour)))
+	mock.ExpectQuery("FROM google_accounts").
+		WithArgs("user").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "email", "name", "is_primary", "access_token", "refresh_token", "token_type", "expires_at", "created_at", "updated_at"}).
+			AddRow("1", "user", "a@x.com", "a@x.com", true, accessToken, refreshToken, "Bearer", now.Add(time.Hour), now, now))
+
+	res, body := getMe(t, app, "user", "s1")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "user", body)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSessionMiddleware_RevokedSession(t *testing.T) {
+	app, mock := newTestSessionApp(t)
+	// Revoked and expired sessions aren't found.
+	mock.ExpectQuery("revoked_at IS NULL AND expires_at > \\$3").
+		WithArgs("s1", "user", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(userSessionColumns))
+
+	res, _ := getMe(t, app, "user", "s1")
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// The cookie of the revoked session is removed.
+	var cleared bool
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == setting.SessionKey {
+			cleared = len(cookie.Value) == 0 && cookie.Expires.Before(time.Now())
+		}
+	}
+	assert.True(t, cleared)
+}
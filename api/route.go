@@ -14,23 +14,26 @@ import (
 
 type Router struct {
 	registry  *store.ProviderRegistry
+	manager   *store.DownloadManager
 	env       config.EnvConfig
 	db        *sql.DB
 	sessStore *session.Store
 }
 
-func NewRouter(registry *store.ProviderRegistry, env config.EnvConfig, db *sql.DB) *Router {
+func NewRouter(registry *store.ProviderRegistry, manager *store.DownloadManager, env config.EnvConfig, db *sql.DB) *Router {
 	return &Router{
 		registry: registry,
+		manager:  manager,
 		env:      env,
 		db:       db,
 	}
 }
 
 func (h *Router) RegisterRoutes(r fiber.Router) {
-	// Creates an in memory storage for session.
+	// The session storage is kept in the database, so a restart doesn't sign everyone out.
 	store := session.New(session.Config{
 		CookieDomain: h.env.Domain,
+		Storage:      store.NewSessionStorage(h.db),
 	})
 	h.sessStore = store
 
@@ -39,25 +42,60 @@ func (h *Router) RegisterRoutes(r fiber.Router) {
 	})
 
 	// Middlewares
-	sessionMW := MW.NewSessionMiddleware(h.env, store, h.db, h.registry)
+	sessionMW := MW.NewSessionMiddleware(h.env, store, h.db)
 
 	// Handlers
 	googleHR := handler.NewGoogleHandler(h.registry, store, h.db, h.env)
-	downloadHR := handler.NewDownloadHandler(h.registry, h.sessStore, h.env)
+	downloadHR := handler.NewDownloadHandler(h.registry, h.manager, h.sessStore, h.db, h.env)
+	providerHR := handler.NewProviderHandler(h.registry, store)
+	profileHR := handler.NewProfileHandler(h.db)
+	sessionHR := handler.NewSessionHandler(h.db, store, h.env)
 
 	// OAuth Routes for google.
 	r.Post("/signin/google", sessionMW.SessionMiddleware, sessionMW.WithoutGoogleOAuth, googleHR.GoogleSignInHandler)
 	r.Post("/refresh", sessionMW.SessionMiddleware, sessionMW.WithGoogleOAuth, googleHR.RefreshTokenHandler)
-	r.Post("/logout", sessionMW.WithGoogleOAuth, googleHR.LogoutHandler)
+	r.Post("/logout", sessionMW.SessionMiddleware, sessionMW.WithGoogleOAuth, googleHR.LogoutHandler)
 	r.Get("/callback/google", sessionMW.SessionMiddleware, sessionMW.WithoutGoogleOAuth, googleHR.GoogleCallbackHandler)
 
+	// Sessions of the signed in user on their devices.
+	r.Get("/sessions", sessionMW.SessionMiddleware, sessionMW.WithGoogleOAuth, sessionHR.ListSessionsHandler)
+	r.Delete("/sessions", sessionMW.SessionMiddleware, sessionMW.WithGoogleOAuth, sessionHR.RevokeAllSessionsHandler)
+	r.Delete("/sessions/:id", sessionMW.SessionMiddleware, sessionMW.WithGoogleOAuth, sessionHR.RevokeSessionHandler)
+
+	// Google accounts linked to the signed in user, besides the one they signed in with.
+	r.Get("/accounts/google", sessionMW.SessionMiddleware, googleHR.ListAccountsHandler)
+	r.Post("/accounts/google/link", sessionMW.SessionMiddleware, googleHR.LinkAccountHandler)
+	r.Get("/accounts/google/callback", sessionMW.SessionMiddleware, googleHR.LinkAccountCallbackHandler)
+	r.Patch("/accounts/google/:id", sessionMW.SessionMiddleware, googleHR.RenameAccountHandler)
+	r.Delete("/accounts/google/:id", sessionMW.SessionMiddleware, googleHR.UnlinkAccountHandler)
+
+	// OAuth Routes for the providers which are connected to the signed in user, eg. `/connect/dropbox`.
+	// Registered after the Google callback, so it isn't matched by `:provider`.
+	r.Post("/connect/:provider", sessionMW.SessionMiddleware, sessionMW.WithGoogleOAuth, providerHR.ConnectHandler)
+	r.Get("/callback/:provider", sessionMW.SessionMiddleware, sessionMW.WithGoogleOAuth, providerHR.ConnectCallbackHandler)
+
+	// Connection profiles, the credentials of SFTP and FTP links.
+	r.Get("/profiles", sessionMW.SessionMiddleware, profileHR.ListProfilesHandler)
+	r.Post("/profiles", sessionMW.SessionMiddleware, profileHR.SaveProfileHandler)
+	r.Delete("/profiles/:id", sessionMW.SessionMiddleware, profileHR.DeleteProfileHandler)
+
 	// Download Routes
-	// Note: For now this download route will only support GDrive, later multiple providers will be handled here.
-	r.Post("/download", sessionMW.SessionMiddleware, sessionMW.WithGoogleOAuth, downloadHR.DownloadHandler)
-	r.Post("/cancel", sessionMW.SessionMiddleware, sessionMW.WithGoogleOAuth, downloadHR.CancelDownloadHandler)
-	r.Post("/cancelAll", sessionMW.SessionMiddleware, sessionMW.WithGoogleOAuth, downloadHR.CancelAllDownloadsHandler)
+	// Note: The providers are checked per link, public links like Drive files shared with
+	// anyone don't need a connected account.
+	r.Post("/download", sessionMW.SessionMiddleware, downloadHR.DownloadHandler)
+	r.Post("/cancel", sessionMW.SessionMiddleware, downloadHR.CancelDownloadHandler)
+	r.Post("/cancelAll", sessionMW.SessionMiddleware, downloadHR.CancelAllDownloadsHandler)
+	r.Post("/pause", sessionMW.SessionMiddleware, downloadHR.PauseDownloadHandler)
+	r.Post("/resume", sessionMW.SessionMiddleware, downloadHR.ResumeDownloadHandler)
 	r.Get("/progress", sessionMW.SessionMiddleware, downloadHR.ProgressHTTPHandler)
-	r.Get("/ws/progress", util.MakeWebsocketHandler(downloadHR.ProgressWebsocketHandler, h.env.AppURL))
+	r.Get("/jobs", sessionMW.SessionMiddleware, downloadHR.JobHistoryHandler)
+	r.Get("/ws/progress", sessionMW.SessionMiddleware, util.MakeWebsocketHandler(downloadHR.ProgressWebsocketHandler, h.env.AppURL))
+
+	// Admin Routes
+	r.Get("/admin/progress", sessionMW.SessionMiddleware, sessionMW.WithAdmin, downloadHR.AdminProgressHandler)
+	r.Get("/admin/bandwidth", sessionMW.SessionMiddleware, sessionMW.WithAdmin, downloadHR.GetBandwidthHandler)
+	r.Post("/admin/bandwidth", sessionMW.SessionMiddleware, sessionMW.WithAdmin, downloadHR.SetBandwidthHandler)
+	r.Post("/admin/bandwidth/jobs/:id", sessionMW.SessionMiddleware, sessionMW.WithAdmin, downloadHR.SetJobBandwidthHandler)
 
 	// Folder Tree structure Route
 	r.Get("/folderTree", downloadHR.FolderTreeHandler)
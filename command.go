@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/nilotpaul/go-downloader/service"
+	"github.com/nilotpaul/go-downloader/util"
+	"github.com/pressly/goose/v3"
+)
+
+const migrationsDir = "./migrations"
+
+// runCommand runs a command of the binary instead of the server, eg. `go-downloader migrate up`.
+func runCommand(db *sql.DB, keys *util.TokenKeyring, args []string) error {
+	switch args[0] {
+	// Runs a goose command, the Go migrations are only known by the binary.
+	case "migrate":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: migrate <up|down|status|reset|...> [args]")
+		}
+		return goose.RunContext(context.Background(), args[1], db, migrationsDir, args[2:]...)
+
+	// Wraps the stored tokens with the first key after a new key was added,
+	// the old keys can be removed once it's done.
+	case "rotate-token-keys":
+		n, err := rotateTokenKeys(db, keys)
+		if err != nil {
+			return err
+		}
+		log.Printf("%d accounts and downloads were updated, their tokens are encrypted with key %s", n, keys.PrimaryKeyID())
+		return nil
+
+	default:
+		return fmt.Errorf("unknown command %q, expected migrate or rotate-token-keys", args[0])
+	}
+}
+
+func rotateTokenKeys(db *sql.DB, keys *util.TokenKeyring) (n int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer util.CommitOrRollback(tx, &err)
+
+	return service.RewrapTokens(context.Background(), tx, keys)
+}
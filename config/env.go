@@ -1,8 +1,14 @@
 package config
 
 import (
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
 	"github.com/nilotpaul/go-downloader/util"
 )
 
@@ -14,15 +20,69 @@ type EnvConfig struct {
 	AppURL              string `envconfig:"APP_URL"`
 	Domain              string `envconfig:"DOMAIN"`
 	DefaultDownloadPath string `envconfig:"DEFAULT_DOWNLOAD_PATH"`
+	// Format for Google Docs, Sheets and Slides when a download doesn't specify one.
+	DefaultExportFormat string `envconfig:"DEFAULT_EXPORT_FORMAT" default:"office"`
+
+	// Download queue limits, downloads beyond these will wait in the queue.
+	MaxConcurrentDownloads  int `envconfig:"MAX_CONCURRENT_DOWNLOADS" default:"5"`
+	MaxDownloadsPerProvider int `envconfig:"MAX_DOWNLOADS_PER_PROVIDER" default:"3"`
+	// Attempts of a download failing with transient errors, like a connection reset or a rate limit.
+	MaxDownloadAttempts int `envconfig:"MAX_DOWNLOAD_ATTEMPTS" default:"5"`
+
+	// Download speed limits per second as sizes like `2MB`, empty means unlimited.
+	BandwidthLimit       string `envconfig:"BANDWIDTH_LIMIT"`
+	BandwidthPerJobLimit string `envconfig:"BANDWIDTH_PER_JOB_LIMIT"`
+	// Global limit by the time of day, eg. `08:00-23:00=2MB,23:00-08:00=0`.
+	BandwidthSchedule string `envconfig:"BANDWIDTH_SCHEDULE"`
+
+	// Path of a JSON file with the S3-compatible endpoints by name, see `types.S3Endpoint`.
+	S3Config string `envconfig:"S3_CONFIG"`
 
 	SessionSecret string `envconfig:"SESSION_SECRET"`
+	// Keys which encrypt the stored OAuth tokens, see `util.ParseTokenKeys`. They're read from
+	// `TOKEN_ENCRYPTION_KEY_FILE` instead if it's set, the first key encrypts.
+	TokenEncryptionKeys    string `envconfig:"TOKEN_ENCRYPTION_KEYS"`
+	TokenEncryptionKeyFile string `envconfig:"TOKEN_ENCRYPTION_KEY_FILE"`
+	// Emails of the users who can see the downloads of everyone.
+	AdminEmails []string `envconfig:"ADMIN_EMAILS"`
+	// Tokens which download public links without a session, sent as `Authorization: Bearer <token>`.
+	DownloadAPITokens []string `envconfig:"DOWNLOAD_API_TOKENS"`
 	GoogleOAuthEnvConfig
+	DropboxOAuthEnvConfig
+	MicrosoftOAuthEnvConfig
+	TorrentEnvConfig
 }
 
 // Google OAuth specific configuration
 type GoogleOAuthEnvConfig struct {
 	GoogleClientID     string `envconfig:"GOOGLE_CLIENT_ID"`
 	GoogleClientSecret string `envconfig:"GOOGLE_CLIENT_SECRET"`
+	// Optional, lists public Drive folders and reads file metadata without a Google account.
+	GoogleAPIKey string `envconfig:"GOOGLE_API_KEY"`
+}
+
+// Dropbox OAuth specific configuration
+type DropboxOAuthEnvConfig struct {
+	DropboxClientID     string `envconfig:"DROPBOX_CLIENT_ID"`
+	DropboxClientSecret string `envconfig:"DROPBOX_CLIENT_SECRET"`
+}
+
+// Microsoft OAuth specific configuration
+type MicrosoftOAuthEnvConfig struct {
+	MicrosoftClientID     string `envconfig:"MICROSOFT_CLIENT_ID"`
+	MicrosoftClientSecret string `envconfig:"MICROSOFT_CLIENT_SECRET"`
+	// `common` allows personal as well as work or school accounts.
+	MicrosoftTenant string `envconfig:"MICROSOFT_TENANT" default:"common"`
+}
+
+// BitTorrent client configuration
+type TorrentEnvConfig struct {
+	// Torrents are downloaded here before they're copied to their destination, and seeded from here.
+	TorrentDataDir    string `envconfig:"TORRENT_DATA_DIR" default:"torrents"`
+	TorrentListenPort int    `envconfig:"TORRENT_LISTEN_PORT" default:"42069"`
+	// Seeding stops at whichever limit is reached first, with both at 0 there's no seeding.
+	TorrentSeedRatio float64       `envconfig:"TORRENT_SEED_RATIO" default:"1"`
+	TorrentSeedTime  time.Duration `envconfig:"TORRENT_SEED_TIME" default:"1h"`
 }
 
 func loadEnv() (*EnvConfig, error) {
@@ -38,10 +98,104 @@ func loadEnv() (*EnvConfig, error) {
 	if err := envconfig.Process("", &cfg); err != nil {
 		return nil, err
 	}
+	if !util.IsValidExportFormat(setting.ExportFormat(cfg.DefaultExportFormat)) {
+		return nil, fmt.Errorf("invalid DEFAULT_EXPORT_FORMAT: %s", cfg.DefaultExportFormat)
+	}
+	if _, err := cfg.BandwidthConfig(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.S3Endpoints(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.TokenKeyring(); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
 
+// BandwidthConfig parses the bandwidth limits and schedule.
+func (cfg EnvConfig) BandwidthConfig() (types.BandwidthConfig, error) {
+	globalLimit, err := util.ParseBytes(cfg.BandwidthLimit)
+	if err != nil {
+		return types.BandwidthConfig{}, fmt.Errorf("invalid BANDWIDTH_LIMIT: %v", err)
+	}
+	perJobLimit, err := util.ParseBytes(cfg.BandwidthPerJobLimit)
+	if err != nil {
+		return types.BandwidthConfig{}, fmt.Errorf("invalid BANDWIDTH_PER_JOB_LIMIT: %v", err)
+	}
+	schedule, err := util.ParseBandwidthSchedule(cfg.BandwidthSchedule)
+	if err != nil {
+		return types.BandwidthConfig{}, fmt.Errorf("invalid BANDWIDTH_SCHEDULE: %v", err)
+	}
+
+	return types.BandwidthConfig{
+		GlobalLimit: globalLimit,
+		PerJobLimit: perJobLimit,
+		Schedule:    schedule,
+	}, nil
+}
+
+// TorrentConfig returns the configuration of the BitTorrent client.
+func (cfg EnvConfig) TorrentConfig() types.TorrentConfig {
+	return types.TorrentConfig{
+		DataDir:    cfg.TorrentDataDir,
+		ListenPort: cfg.TorrentListenPort,
+		SeedRatio:  cfg.TorrentSeedRatio,
+		SeedTime:   cfg.TorrentSeedTime,
+	}
+}
+
+// S3Endpoints reads the S3-compatible endpoints from `S3_CONFIG`, nil if it's not set.
+func (cfg EnvConfig) S3Endpoints() (map[string]types.S3Endpoint, error) {
+	if len(cfg.S3Config) == 0 {
+		return nil, nil
+	}
+
+	f, err := os.Open(cfg.S3Config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3_CONFIG: %v", err)
+	}
+	defer f.Close()
+
+	var endpoints map[string]types.S3Endpoint
+	if err := util.DecodeJSON(f, &endpoints); err != nil {
+		return nil, fmt.Errorf("invalid S3_CONFIG: %v", err)
+	}
+	for name, endpoint := range endpoints {
+		if err := util.ValidateS3Endpoint(endpoint); err != nil {
+			return nil, fmt.Errorf("invalid S3_CONFIG endpoint %s: %v", name, err)
+		}
+	}
+
+	return endpoints, nil
+}
+
+// TokenKeyring reads the keys which encrypt the stored OAuth tokens.
+func (cfg EnvConfig) TokenKeyring() (*util.TokenKeyring, error) {
+	if len(cfg.TokenEncryptionKeyFile) != 0 {
+		b, err := os.ReadFile(cfg.TokenEncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TOKEN_ENCRYPTION_KEY_FILE: %v", err)
+		}
+		keys, err := util.ParseTokenKeys(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TOKEN_ENCRYPTION_KEY_FILE: %v", err)
+		}
+		return keys, nil
+	}
+
+	if len(cfg.TokenEncryptionKeys) == 0 {
+		return nil, fmt.Errorf("TOKEN_ENCRYPTION_KEYS or TOKEN_ENCRYPTION_KEY_FILE is required")
+	}
+	keys, err := util.ParseTokenKeys(cfg.TokenEncryptionKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOKEN_ENCRYPTION_KEYS: %v", err)
+	}
+
+	return keys, nil
+}
+
 func MustLoadEnv() *EnvConfig {
 	cfg, err := loadEnv()
 
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
 
 	"github.com/nilotpaul/go-downloader/api"
 	"github.com/nilotpaul/go-downloader/config"
+	_ "github.com/nilotpaul/go-downloader/migrations"
+	"github.com/nilotpaul/go-downloader/service"
+	"github.com/nilotpaul/go-downloader/setting"
 	"github.com/nilotpaul/go-downloader/store"
 	"github.com/nilotpaul/go-downloader/util"
 	"github.com/pressly/goose/v3"
@@ -22,9 +27,21 @@ func main() {
 		}
 	}()
 
+	// The stored OAuth tokens are encrypted with these keys, already validated while loading the env.
+	keys, _ := env.TokenKeyring()
+	service.SetTokenKeyring(keys)
+
+	// Commands like `migrate up` run instead of the server.
+	if len(os.Args) > 1 {
+		if err := runCommand(db, keys, os.Args[1:]); err != nil {
+			log.Fatalf("%s failed: %v", os.Args[1], err)
+		}
+		return
+	}
+
 	// Run auto migrations in production.
 	if util.IsProduction() {
-		if err := goose.Up(db, "./migrations"); err != nil {
+		if err := goose.Up(db, migrationsDir); err != nil {
 			log.Fatalf("failed to apply database migrations: %v", err)
 		}
 	}
@@ -33,8 +50,28 @@ func main() {
 	// auth providers are registered.
 	r := store.InitStore(*env, db)
 
+	// Already validated while loading the env.
+	bandwidth, _ := env.BandwidthConfig()
+
+	// Initializes the download manager which keeps the downloads of every user separately,
+	// every download is tracked as a job in the database. The files are downloaded
+	// from the sources of the registry.
+	m := store.NewDownloadManager(db, r, store.QueueConfig{
+		MaxConcurrent:  env.MaxConcurrentDownloads,
+		MaxPerProvider: env.MaxDownloadsPerProvider,
+	}, store.RetryConfig{
+		MaxAttempts: env.MaxDownloadAttempts,
+		BaseDelay:   setting.RetryBaseDelay,
+		MaxDelay:    setting.RetryMaxDelay,
+	}, bandwidth)
+
+	// Re-enqueue the downloads which were interrupted by the last shutdown or crash.
+	if err := m.RecoverDownloads(context.Background()); err != nil {
+		log.Printf("failed to recover the interrupted downloads: %v", err)
+	}
+
 	// All routes, handlers & middlewares are registered here.
-	server := api.NewAPIServer(env.Port, *env, r, db, build)
+	server := api.NewAPIServer(env.Port, *env, r, m, db, build)
 
 	log.Fatal(server.Start())
 }
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+
+ALTER TABLE "download_jobs" ADD COLUMN export_format VARCHAR(20) NOT NULL DEFAULT 'office';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+
+ALTER TABLE "download_jobs" DROP COLUMN export_format;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+
+ALTER TABLE "download_jobs" ADD COLUMN md5_checksum VARCHAR(32) NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+
+ALTER TABLE "download_jobs" DROP COLUMN md5_checksum;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+
+-- Direct downloads use the URL as the file ID.
+ALTER TABLE "download_jobs" ALTER COLUMN file_id TYPE TEXT;
+ALTER TABLE "download_jobs" ADD COLUMN headers JSONB NOT NULL DEFAULT '{}';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+
+ALTER TABLE "download_jobs" DROP COLUMN headers;
+ALTER TABLE "download_jobs" ALTER COLUMN file_id TYPE VARCHAR(255) USING LEFT(file_id, 255);
+-- +goose StatementEnd
@@ -0,0 +1,57 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+
+CREATE TABLE IF NOT EXISTS "provider_accounts" (
+    id UUID PRIMARY KEY NOT NULL DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    account_id TEXT NOT NULL,
+    access_token TEXT NOT NULL,
+    refresh_token TEXT NOT NULL,
+    token_type TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL,
+    UNIQUE (user_id, provider)
+);
+
+INSERT INTO "provider_accounts" (
+    user_id, provider, account_id, access_token, refresh_token, token_type, expires_at, created_at, updated_at
+)
+SELECT
+    user_id, 'dropbox', account_id, access_token, refresh_token, token_type, expires_at, created_at, updated_at
+FROM
+    "dropbox_accounts";
+
+DROP TABLE IF EXISTS "dropbox_accounts";
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+
+CREATE TABLE IF NOT EXISTS "dropbox_accounts" (
+    id UUID PRIMARY KEY NOT NULL DEFAULT gen_random_uuid(),
+    user_id UUID UNIQUE NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    account_id TEXT NOT NULL,
+    access_token TEXT NOT NULL,
+    refresh_token TEXT NOT NULL,
+    token_type TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO "dropbox_accounts" (
+    user_id, account_id, access_token, refresh_token, token_type, expires_at, created_at, updated_at
+)
+SELECT
+    user_id, account_id, access_token, refresh_token, token_type, expires_at, created_at, updated_at
+FROM
+    "provider_accounts"
+WHERE
+    provider = 'dropbox';
+
+DROP TABLE IF EXISTS "provider_accounts";
+-- +goose StatementEnd
@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+
+ALTER TABLE "google_accounts" ADD COLUMN email VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE "google_accounts" ADD COLUMN name VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE "google_accounts" ADD COLUMN is_primary BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Every user had a single account so far, the one they signed in with.
+UPDATE "google_accounts" a
+SET
+    email = u.email,
+    name = u.email,
+    is_primary = TRUE
+FROM
+    "users" u
+WHERE
+    a.user_id = u.id;
+
+CREATE UNIQUE INDEX google_accounts_user_email_idx ON "google_accounts" (user_id, email);
+CREATE UNIQUE INDEX google_accounts_user_name_idx ON "google_accounts" (user_id, name);
+CREATE UNIQUE INDEX google_accounts_user_primary_idx ON "google_accounts" (user_id) WHERE is_primary;
+
+-- The linked account a download uses, empty for the providers with a single account per user.
+ALTER TABLE "download_jobs" ADD COLUMN account_id TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+
+ALTER TABLE "download_jobs" DROP COLUMN account_id;
+
+DROP INDEX IF EXISTS google_accounts_user_primary_idx;
+DROP INDEX IF EXISTS google_accounts_user_name_idx;
+DROP INDEX IF EXISTS google_accounts_user_email_idx;
+
+DELETE FROM "google_accounts" WHERE NOT is_primary;
+
+ALTER TABLE "google_accounts" DROP COLUMN is_primary;
+ALTER TABLE "google_accounts" DROP COLUMN name;
+ALTER TABLE "google_accounts" DROP COLUMN email;
+-- +goose StatementEnd
@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/nilotpaul/go-downloader/service"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upEncryptAccountTokens, downEncryptAccountTokens)
+}
+
+// The tokens are encrypted with the keys of the app, so this migration is written in Go
+// and only runs through the app, see `go-downloader migrate`.
+func upEncryptAccountTokens(ctx context.Context, tx *sql.Tx) error {
+	keys, err := service.TokenKeyring()
+	if err != nil {
+		return err
+	}
+
+	// Encrypted tokens don't fit in 255 characters.
+	const query = `
+		ALTER TABLE "google_accounts"
+			ALTER COLUMN access_token TYPE TEXT,
+			ALTER COLUMN refresh_token TYPE TEXT
+	`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	_, err = service.RewrapTokens(ctx, tx, keys)
+	return err
+}
+
+func downEncryptAccountTokens(ctx context.Context, tx *sql.Tx) error {
+	keys, err := service.TokenKeyring()
+	if err != nil {
+		return err
+	}
+
+	if _, err := service.DecryptTokens(ctx, tx, keys); err != nil {
+		return err
+	}
+
+	const query = `
+		ALTER TABLE "google_accounts"
+			ALTER COLUMN access_token TYPE VARCHAR(255),
+			ALTER COLUMN refresh_token TYPE VARCHAR(255)
+	`
+	_, err = tx.ExecContext(ctx, query)
+	return err
+}
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+
+-- Every sign in, the session cookie refers to its row so it can be revoked.
+CREATE TABLE IF NOT EXISTS "user_sessions" (
+    id UUID PRIMARY KEY NOT NULL DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX user_sessions_user_id_idx ON "user_sessions" (user_id);
+
+-- Storage of fiber's session store, it keeps the OAuth flows and the session data across restarts.
+CREATE TABLE IF NOT EXISTS "session_store" (
+    key TEXT PRIMARY KEY NOT NULL,
+    data BYTEA NOT NULL,
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX session_store_expires_at_idx ON "session_store" (expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+
+DROP TABLE IF EXISTS "session_store";
+DROP TABLE IF EXISTS "user_sessions";
+-- +goose StatementEnd
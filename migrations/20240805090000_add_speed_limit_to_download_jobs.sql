@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+
+-- Bytes per second of a single download, 0 uses the default per-job limit.
+ALTER TABLE "download_jobs" ADD COLUMN speed_limit BIGINT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+
+ALTER TABLE "download_jobs" DROP COLUMN speed_limit;
+-- +goose StatementEnd
@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/nilotpaul/go-downloader/service"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upEncryptDownloadJobHeaders, downEncryptDownloadJobHeaders)
+}
+
+// The headers of direct downloads can hold cookies and authorization headers, so
+// they're encrypted like the tokens of the accounts.
+func upEncryptDownloadJobHeaders(ctx context.Context, tx *sql.Tx) error {
+	keys, err := service.TokenKeyring()
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		ALTER TABLE "download_jobs"
+			ALTER COLUMN headers DROP DEFAULT,
+			ALTER COLUMN headers TYPE TEXT USING headers::text
+	`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	_, err = service.RewrapTokens(ctx, tx, keys, "download_jobs")
+	return err
+}
+
+func downEncryptDownloadJobHeaders(ctx context.Context, tx *sql.Tx) error {
+	keys, err := service.TokenKeyring()
+	if err != nil {
+		return err
+	}
+
+	if _, err := service.DecryptTokens(ctx, tx, keys, "download_jobs"); err != nil {
+		return err
+	}
+
+	const query = `
+		ALTER TABLE "download_jobs"
+			ALTER COLUMN headers TYPE JSONB USING headers::jsonb,
+			ALTER COLUMN headers SET DEFAULT '{}'
+	`
+	_, err = tx.ExecContext(ctx, query)
+	return err
+}
@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/nilotpaul/go-downloader/service"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upEncryptConnectionProfileSecrets, downEncryptConnectionProfileSecrets)
+}
+
+// The passwords and private keys of the SFTP and FTP servers are encrypted like the tokens of the accounts.
+func upEncryptConnectionProfileSecrets(ctx context.Context, tx *sql.Tx) error {
+	keys, err := service.TokenKeyring()
+	if err != nil {
+		return err
+	}
+
+	_, err = service.RewrapTokens(ctx, tx, keys, "connection_profiles")
+	return err
+}
+
+func downEncryptConnectionProfileSecrets(ctx context.Context, tx *sql.Tx) error {
+	keys, err := service.TokenKeyring()
+	if err != nil {
+		return err
+	}
+
+	_, err = service.DecryptTokens(ctx, tx, keys, "connection_profiles")
+	return err
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+)
+
+// `profileFunc` returns the user's connection profile for a server, the `ID` is empty if there's none.
+type profileFunc func(userID string, protocol setting.ProfileProtocol, host string, port int) (*types.ConnectionProfile, error)
+
+// dbProfiles looks up the connection profiles in `db`.
+func dbProfiles(db *sql.DB) profileFunc {
+	return func(userID string, protocol setting.ProfileProtocol, host string, port int) (*types.ConnectionProfile, error) {
+		return GetConnectionProfile(db, userID, protocol, host, port)
+	}
+}
+
+// findProfile returns the profile for the server of `loc`, an `AppError` if the user has none.
+func findProfile(profiles profileFunc, userID string, loc util.RemoteLocation) (*types.ConnectionProfile, error) {
+	p, err := profiles(userID, loc.Protocol, loc.Host, loc.Port)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the connection profile: %v", err)
+	}
+	if len(p.ID) == 0 {
+		return nil, util.NewAppError(
+			http.StatusBadRequest,
+			fmt.Sprintf("no connection profile found for %s://%s:%d", loc.Protocol, loc.Host, loc.Port),
+		)
+	}
+
+	return p, nil
+}
+
+// CreateOrUpdateConnectionProfile saves the profile and returns its ID, an existing profile
+// for the same server is replaced. The password and private key are stored encrypted.
+func CreateOrUpdateConnectionProfile(db *sql.DB, p *types.ConnectionProfile) (string, error) {
+	const query = `
+		INSERT INTO connection_profiles (
+			user_id,
+			name,
+			protocol,
+			host,
+			port,
+			username,
+			password,
+			private_key,
+			host_key,
+			explicit_tls,
+			updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (user_id, protocol, host, port) DO UPDATE
+		SET
+			name = EXCLUDED.name,
+			username = EXCLUDED.username,
+			password = EXCLUDED.password,
+			private_key = EXCLUDED.private_key,
+			host_key = EXCLUDED.host_key,
+			explicit_tls = EXCLUDED.explicit_tls,
+			updated_at = EXCLUDED.updated_at
+		RETURNING id
+	`
+
+	password, privateKey, err := encryptProfileSecrets(p)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	err = db.QueryRow(
+		query,
+		p.UserID,
+		p.Name,
+		p.Protocol,
+		p.Host,
+		p.Port,
+		p.Username,
+		password,
+		privateKey,
+		p.HostKey,
+		p.ExplicitTLS,
+		time.Now(),
+	).Scan(&id)
+
+	return id, err
+}
+
+// GetConnectionProfiles gets all the profiles of the user, sorted by name.
+func GetConnectionProfiles(db *sql.DB, userID string) ([]types.ConnectionProfile, error) {
+	const query = `
+		SELECT
+			id, user_id, name, protocol, host, port, username, password, private_key, host_key, explicit_tls, created_at, updated_at
+		FROM
+			connection_profiles
+		WHERE
+			user_id = $1
+		ORDER BY
+			name, host
+	`
+
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := make([]types.ConnectionProfile, 0)
+	for rows.Next() {
+		var p types.ConnectionProfile
+		if err := scanConnectionProfile(rows, &p); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+
+	return profiles, rows.Err()
+}
+
+// GetConnectionProfile gets the user's profile for a server, the `ID` is empty if there's none.
+func GetConnectionProfile(db *sql.DB, userID string, protocol setting.ProfileProtocol, host string, port int) (*types.ConnectionProfile, error) {
+	const query = `
+		SELECT
+			id, user_id, name, protocol, host, port, username, password, private_key, host_key, explicit_tls, created_at, updated_at
+		FROM
+			connection_profiles
+		WHERE
+			user_id = $1 AND protocol = $2 AND host = $3 AND port = $4
+	`
+
+	var p types.ConnectionProfile
+	err := scanConnectionProfile(db.QueryRow(query, userID, protocol, host, port), &p)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// DeleteConnectionProfile deletes the user's profile, false if it doesn't exist.
+func DeleteConnectionProfile(db *sql.DB, userID string, id string) (bool, error) {
+	const query = `
+		DELETE FROM connection_profiles
+		WHERE
+			id = $1 AND user_id = $2
+	`
+
+	res, err := db.Exec(query, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// scanConnectionProfile scans a profile and decrypts its password and private key.
+func scanConnectionProfile(row interface{ Scan(...any) error }, p *types.ConnectionProfile) error {
+	err := row.Scan(
+		&p.ID,
+		&p.UserID,
+		&p.Name,
+		&p.Protocol,
+		&p.Host,
+		&p.Port,
+		&p.Username,
+		&p.Password,
+		&p.PrivateKey,
+		&p.HostKey,
+		&p.ExplicitTLS,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return decryptProfileSecrets(p)
+}
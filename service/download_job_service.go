@@ -0,0 +1,225 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+)
+
+// Columns of the `download_jobs` table in the order they're scanned.
+const downloadJobColumns = `
+	id, COALESCE(user_id::text, ''), file_id, provider, destination_path, file_name, export_format, account_id,
+	status, bytes_done, total_bytes, error, md5_checksum, speed_limit, headers, started_at, completed_at, created_at, updated_at
+`
+
+// CreateDownloadJob inserts a new job in `queued` state and returns its ID.
+// A new ID is generated if the job doesn't have one.
+func CreateDownloadJob(db *sql.DB, job *types.DownloadJob) (string, error) {
+	const query = `
+		INSERT INTO download_jobs (
+			id,
+			user_id,
+			file_id,
+			provider,
+			destination_path,
+			file_name,
+			export_format,
+			account_id,
+			speed_limit,
+			headers,
+			status,
+			updated_at
+		)
+		VALUES (COALESCE(NULLIF($1, '')::uuid, gen_random_uuid()), NULLIF($2, '')::uuid, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id
+	`
+
+	headers, err := json.Marshal(job.Headers)
+	if err != nil {
+		return "", err
+	}
+	if job.Headers == nil {
+		headers = []byte("{}")
+	}
+	encryptedHeaders, err := encryptHeaders(headers)
+	if err != nil {
+		return "", err
+	}
+
+	var jobID string
+	err = db.QueryRow(
+		query,
+		job.ID,
+		job.UserID,
+		job.FileID,
+		job.Provider,
+		job.DestinationPath,
+		job.FileName,
+		job.ExportFormat,
+		job.AccountID,
+		job.SpeedLimit,
+		encryptedHeaders,
+		setting.StatusQueued,
+		time.Now(),
+	).Scan(&jobID)
+	if err != nil {
+		return "", err
+	}
+
+	return jobID, nil
+}
+
+// UpdateDownloadJobStatus changes the status of a job. `errMsg` is stored as is,
+// so passing an empty string clears the previous error.
+func UpdateDownloadJobStatus(db *sql.DB, jobID string, status setting.DownloadStatus, errMsg string) error {
+	const query = `
+		UPDATE download_jobs
+		SET
+			status = $1::text,
+			error = $2,
+			started_at = CASE WHEN $1::text = 'running' THEN COALESCE(started_at, $3) ELSE started_at END,
+			completed_at = CASE WHEN $1::text IN ('completed', 'failed', 'cancelled') THEN $3 ELSE NULL END,
+			updated_at = $3
+		WHERE
+			id = $4
+	`
+	_, err := db.Exec(query, status, errMsg, time.Now(), jobID)
+
+	return err
+}
+
+// UpdateDownloadJobProgress saves the downloaded bytes of a job.
+func UpdateDownloadJobProgress(db *sql.DB, jobID string, bytesDone int64, totalBytes int64) error {
+	const query = `
+		UPDATE download_jobs
+		SET
+			bytes_done = $1,
+			total_bytes = $2,
+			updated_at = $3
+		WHERE
+			id = $4
+	`
+	_, err := db.Exec(query, bytesDone, totalBytes, time.Now(), jobID)
+
+	return err
+}
+
+// UpdateDownloadJobChecksum saves the verified md5 checksum of a completed job.
+func UpdateDownloadJobChecksum(db *sql.DB, jobID string, md5Checksum string) error {
+	const query = `
+		UPDATE download_jobs
+		SET
+			md5_checksum = $1,
+			updated_at = $2
+		WHERE
+			id = $3
+	`
+	_, err := db.Exec(query, md5Checksum, time.Now(), jobID)
+
+	return err
+}
+
+// UpdateDownloadJobSpeedLimit saves the limit of a single job, so it's kept after a restart.
+func UpdateDownloadJobSpeedLimit(db *sql.DB, jobID string, limit int64) error {
+	const query = `
+		UPDATE download_jobs
+		SET
+			speed_limit = $1,
+			updated_at = $2
+		WHERE
+			id = $3
+	`
+	_, err := db.Exec(query, limit, time.Now(), jobID)
+
+	return err
+}
+
+// GetDownloadJobsByUserID gets the latest jobs of a user, newest first.
+func GetDownloadJobsByUserID(db *sql.DB, userID string, limit int) ([]*types.DownloadJob, error) {
+	query := `
+		SELECT ` + downloadJobColumns + `
+		FROM download_jobs
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	return queryDownloadJobs(db, query, userID, limit)
+}
+
+// GetInterruptedDownloadJobs gets all the jobs which were queued, running, paused or
+// waiting for a retry after a checksum mismatch when the server stopped, oldest first.
+func GetInterruptedDownloadJobs(db *sql.DB) ([]*types.DownloadJob, error) {
+	query := `
+		SELECT ` + downloadJobColumns + `
+		FROM download_jobs
+		WHERE status IN ('queued', 'running', 'paused', 'corrupt')
+		ORDER BY created_at ASC
+	`
+
+	return queryDownloadJobs(db, query)
+}
+
+// queryDownloadJobs runs a query selecting `downloadJobColumns` and scans all the rows.
+func queryDownloadJobs(db *sql.DB, query string, args ...any) ([]*types.DownloadJob, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*types.DownloadJob
+	for rows.Next() {
+		job, err := scanDownloadJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// scanDownloadJob scans a row selected with `downloadJobColumns`.
+func scanDownloadJob(row interface{ Scan(...any) error }) (*types.DownloadJob, error) {
+	var (
+		job     types.DownloadJob
+		headers string
+	)
+	err := row.Scan(
+		&job.ID,
+		&job.UserID,
+		&job.FileID,
+		&job.Provider,
+		&job.DestinationPath,
+		&job.FileName,
+		&job.ExportFormat,
+		&job.AccountID,
+		&job.Status,
+		&job.BytesDone,
+		&job.TotalBytes,
+		&job.Error,
+		&job.MD5Checksum,
+		&job.SpeedLimit,
+		&headers,
+		&job.StartedAt,
+		&job.CompletedAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := decryptHeaders(headers)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(plaintext, &job.Headers); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
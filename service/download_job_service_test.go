@@ -0,0 +1,102 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// downloadJobRows returns the rows of `jobs` as selected with `downloadJobColumns`, the headers are encrypted.
+func downloadJobRows(t *testing.T, jobs ...types.DownloadJob) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{
+		"id", "user_id", "file_id", "provider", "destination_path", "file_name", "export_format", "account_id",
+		"status", "bytes_done", "total_bytes", "error", "md5_checksum", "speed_limit", "headers", "started_at", "completed_at", "created_at", "updated_at",
+	})
+	for _, job := range jobs {
+		b, err := json.Marshal(job.Headers)
+		assert.NoError(t, err)
+		headers, err := encryptHeaders(b)
+		assert.NoError(t, err)
+
+		rows.AddRow(
+			job.ID, job.UserID, job.FileID, job.Provider, job.DestinationPath, job.FileName, job.ExportFormat, job.AccountID,
+			job.Status, job.BytesDone, job.TotalBytes, job.Error, job.MD5Checksum, job.SpeedLimit, headers, job.StartedAt, job.CompletedAt, job.CreatedAt, job.UpdatedAt,
+		)
+	}
+
+	return rows
+}
+
+func TestGetInterruptedDownloadJobs(t *testing.T) {
+	useTestTokenKeys(t)
+	var query string
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherFunc(func(_, actual string) error {
+		query = actual
+		return nil
+	})))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	startedAt := time.Now().Add(-time.Hour)
+	running := types.DownloadJob{
+		ID:        "1",
+		UserID:    "user",
+		FileID:    "https://example.com/a.iso",
+		Provider:  setting.HTTPProvider,
+		Status:    setting.StatusRunning,
+		BytesDone: 4000,
+		Headers:   map[string]string{"Cookie": "session=1"},
+		StartedAt: &startedAt,
+	}
+	queued := types.DownloadJob{ID: "2", UserID: "user", FileID: "file", Provider: setting.GoogleProvider, Status: setting.StatusQueued}
+	mock.ExpectQuery("").WillReturnRows(downloadJobRows(t, running, queued))
+
+	jobs, err := GetInterruptedDownloadJobs(db)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	if !assert.Len(t, jobs, 2) {
+		return
+	}
+	assert.Equal(t, setting.StatusRunning, jobs[0].Status)
+	assert.Equal(t, int64(4000), jobs[0].BytesDone)
+	assert.Equal(t, map[string]string{"Cookie": "session=1"}, jobs[0].Headers)
+	assert.Equal(t, setting.StatusQueued, jobs[1].Status)
+
+	// Only jobs which didn't end are restarted, oldest first.
+	assert.Contains(t, query, "WHERE status IN ('queued', 'running', 'paused', 'corrupt')")
+	assert.Contains(t, query, "ORDER BY created_at ASC")
+	for _, status := range []setting.DownloadStatus{setting.StatusCompleted, setting.StatusFailed, setting.StatusCancelled} {
+		assert.False(t, strings.Contains(query, "'"+string(status)+"'"), status)
+	}
+}
+
+func TestCreateDownloadJob(t *testing.T) {
+	keys := useTestTokenKeys(t)
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	job := &types.DownloadJob{
+		UserID:          "user",
+		FileID:          "https://example.com/a.iso",
+		Provider:        setting.HTTPProvider,
+		DestinationPath: "/downloads",
+		SpeedLimit:      1024,
+		Headers:         map[string]string{"Cookie": "session=1"},
+	}
+	// New jobs are queued, the headers are stored encrypted.
+	mock.ExpectQuery("INSERT INTO download_jobs").
+		WithArgs("", "user", job.FileID, job.Provider, "/downloads", "", "", "", int64(1024), encryptedArg{keys, `{"Cookie":"session=1"}`}, setting.StatusQueued, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+
+	id, err := CreateDownloadJob(db, job)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -2,7 +2,10 @@ package service
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log/slog"
 	"math"
@@ -13,120 +16,249 @@ import (
 	"github.com/nilotpaul/go-downloader/util"
 )
 
+// ErrChecksumMismatch is returned when the downloaded file doesn't match the checksum of its source.
+// The partial data is removed, so the download can be retried from scratch.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
 type DownloaderConfig struct {
 	FileID          string
 	DestinationPath string
 	FileName        string
-	AccessToken     string
+	// Passed to every call of the source, eg. the access token or export format.
+	Options types.SourceOptions
+	// Throttles the download speed, nil means unlimited.
+	Limiter util.Limiter
 }
 
-// GDriveDownloader will fallback to the original filename in if the `filename` parameter is an empty string.
-func GDriveDownloader(cfg DownloaderConfig, progChan chan<- *types.Progress, ctx context.Context) error {
+// DownloadFromSource downloads a single file of `src`, it will fallback to the name from the source
+// if `FileName` is an empty string. The data is written to a `.part` file first, an interrupted download
+// continues from where it was left as long as the file didn't change. The checksum is verified if the
+// source knows it. The progress is sent by value, so the receiver can keep it without racing with the download.
+func DownloadFromSource(src types.Source, cfg DownloaderConfig, progChan chan<- types.Progress, ctx context.Context) error {
 	// Validates the downloader configuration.
 	if err := validateDownloaderConfig(cfg); err != nil {
 		return err
 	}
 
-	// Making a GDrive Service with the access token from OAuth.
-	srv, err := util.MakeGDriveService(ctx, cfg.AccessToken)
+	file, err := src.Stat(ctx, cfg.FileID, cfg.Options)
 	if err != nil {
-		return fmt.Errorf("failed to initialize GDrive service")
-	}
-
-	file, err := srv.Files.Get(cfg.FileID).Do()
-	if err != nil {
-		return fmt.Errorf("failed to get file info: %v", err)
-	}
-
-	// In case `fileID` is for a folder we return an error.
-	if file.MimeType == "application/vnd.google-apps.folder" {
-		return fmt.Errorf("expected file, received a folder")
+		return fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	// If no manual filename is provided, use the original filename from Google Drive
+	// If no manual filename is provided, use the name from the source.
 	if len(cfg.FileName) == 0 {
-		cfg.FileName = file.OriginalFilename
+		cfg.FileName = file.Name
 	}
 
 	// We take the destination path which is a folder location while the file will be downloaded.
 	// Sanitize the filename to remove any invalid characters for file paths.
 	destFileName := cfg.DestinationPath + "/" + util.SanitizeFileName(cfg.FileName)
-	// Create the destination file, including any necessary directories.
-	destFile, err := util.CreateFile(destFileName)
+
+	// Another job saving to the same file would corrupt its `.part` file, so it has to fail.
+	release, err := util.ClaimDestination(destFileName)
 	if err != nil {
-		return fmt.Errorf("failed to create destination file %s", destFileName)
+		return err
 	}
-	defer destFile.Close()
+	defer release()
 
-	res, err := srv.Files.Get(cfg.FileID).Download()
+	// If a matching `.part` file already exists from an earlier attempt, it's reused.
+	checksum := src.Checksum(file)
+	destFile, offset, err := util.OpenPartFile(destFileName, types.PartMeta{
+		FileID:   cfg.FileID,
+		Size:     file.Size,
+		Checksum: checksum.Value,
+		ETag:     file.ETag,
+	})
 	if err != nil {
-		return fmt.Errorf("failed with %d to download the file: %v", res.StatusCode, err)
+		return fmt.Errorf("failed to create destination file %s: %v", destFileName, err)
 	}
-	defer res.Body.Close()
+	defer destFile.Close()
 
-	// Creating a 32KB buffer which will hold a portion of the entire file for streaming.
-	// Downloading the file in 32KB chunks is fast and memory efficient.
-	buf := make([]byte, 32*1024) // 32KB buffer
-	var totalWritten int64
+	// Without a size, an ETag or a checksum there's no telling if the partial data belongs to the same file.
+	if offset > 0 && file.Size <= 0 && len(file.ETag) == 0 && len(checksum.Value) == 0 {
+		if err := util.ResetPartFile(destFile); err != nil {
+			return err
+		}
+		offset = 0
+	}
 
-	slog.Info("downloading", "filename", file.OriginalFilename)
+	// The checksum is computed while streaming, so the already downloaded
+	// part has to be hashed before continuing.
+	var hasher hash.Hash
+	if len(checksum.Value) != 0 {
+		if hasher, err = util.NewChecksumHash(checksum.Algorithm); err != nil {
+			return err
+		}
+	}
+	if hasher != nil && offset > 0 {
+		if _, err := destFile.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to read the partial file: %v", err)
+		}
+		if _, err := io.CopyN(hasher, destFile, offset); err != nil {
+			return fmt.Errorf("failed to read the partial file: %v", err)
+		}
+	}
+
+	slog.Info("downloading", "filename", cfg.FileName, "offset", offset)
 
 	prog := &types.Progress{
 		FileID:       cfg.FileID,
-		Total:        file.FileSize,
-		ReadableSize: util.FormatBytes(file.FileSize),
+		Downloaded:   offset,
+		ReadableSize: "unknown",
 		StartTime:    time.Now(),
 	}
+	setProgressTotal(prog, file.Size)
 
 	// Sending the initial progress
-	progChan <- prog
+	progChan <- *prog
+
+	// Nothing is left to download if the `.part` file is already complete.
+	if file.Size <= 0 || offset < file.Size {
+		body, start, err := src.Open(ctx, file, offset, cfg.Options)
+		if err != nil {
+			return fmt.Errorf("failed to download the file: %w", err)
+		}
+		defer body.Close()
+
+		// The source can't continue from the offset and sends the entire file,
+		// so the partial data has to be thrown away.
+		if start != offset {
+			if err := util.ResetPartFile(destFile); err != nil {
+				return err
+			}
+			if hasher != nil {
+				hasher.Reset()
+			}
+			prog.Downloaded = 0
+		}
+
+		var dst io.Writer = destFile
+		if hasher != nil {
+			dst = io.MultiWriter(destFile, hasher)
+		}
+		// Torrents report their peers with the progress.
+		peers, _ := body.(types.PeerCounter)
+		cancelled, err := copyWithProgress(ctx, dst, limitReader(ctx, body, cfg.Limiter), peers, prog, progChan)
+		if err != nil {
+			return fmt.Errorf("failed to download the file %s: %w", cfg.FileName, err)
+		}
+		// The `.part` file is kept for resuming later.
+		if cancelled {
+			log.Infof("download cancelled for %s", cfg.FileID)
+			return nil
+		}
+	}
+
+	// Only a complete and intact file gets its final name.
+	if file.Size > 0 && prog.Downloaded != file.Size {
+		// The stream ended early, it can be resumed from the offset.
+		return fmt.Errorf("%w: size mismatch for %s, expected %d bytes, got %d", io.ErrUnexpectedEOF, cfg.FileName, file.Size, prog.Downloaded)
+	}
+	var sum string
+	if hasher != nil {
+		sum = hex.EncodeToString(hasher.Sum(nil))
+		if sum != checksum.Value {
+			// The partial data is corrupt, resuming from it again would never succeed.
+			destFile.Close()
+			if err := util.RemovePartFile(destFileName); err != nil {
+				return err
+			}
+			return fmt.Errorf("%w for %s, expected %s, got %s", ErrChecksumMismatch, cfg.FileName, checksum.Value, sum)
+		}
+	}
+	if err := destFile.Close(); err != nil {
+		return fmt.Errorf("failed to close the destination file: %v", err)
+	}
+	if err := util.FinalizePartFile(destFileName); err != nil {
+		return err
+	}
+
+	// Mark download as complete with the verified checksum.
+	if checksum.Algorithm == "md5" {
+		prog.MD5Checksum = sum
+	}
+	// The size might only be known once the download is done.
+	setProgressTotal(prog, prog.Downloaded)
+	prog.Current = 100
+	prog.Complete = true
+	prog.EndTime = time.Now()
+	progChan <- *prog
+
+	return nil
+}
+
+// setProgressTotal sets the size of the download if it's known.
+func setProgressTotal(prog *types.Progress, size int64) {
+	if size <= 0 {
+		return
+	}
+
+	prog.Total = size
+	prog.ReadableSize = util.FormatBytes(size)
+	prog.Current = int(float64(prog.Downloaded) / float64(size) * 100)
+}
+
+// limitReader throttles `r` with `limiter` if there's one.
+func limitReader(ctx context.Context, r io.Reader, limiter util.Limiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+
+	return util.NewLimitedReader(ctx, r, limiter)
+}
+
+// copyWithProgress streams `src` into `dst` and sends the progress after every chunk.
+// `prog.Downloaded` is the offset `src` starts from, `peers` can be nil. It returns true if the context got cancelled.
+func copyWithProgress(ctx context.Context, dst io.Writer, src io.Reader, peers types.PeerCounter, prog *types.Progress, progChan chan<- types.Progress) (bool, error) {
+	// Creating a 32KB buffer which will hold a portion of the entire file for streaming.
+	// Downloading the file in 32KB chunks is fast and memory efficient.
+	buf := make([]byte, 32*1024) // 32KB buffer
+	sessionStart := prog.Downloaded
 
 	for {
 		// Read the response body in chunks(32KB) and write it to the destination file,
-		n, err := res.Body.Read(buf)
+		n, err := src.Read(buf)
 
 		// If `cancel` function is called from the download context, the loop will break
 		// stopping the ongoing download.
 		select {
 		case <-ctx.Done():
-			log.Infof("download cancelled for %s", cfg.FileID)
-			return nil
+			return true, nil
 		default:
 			if n > 0 {
-				written, writeErr := destFile.Write(buf[0:n])
+				written, writeErr := dst.Write(buf[0:n])
 				if writeErr != nil {
-					return fmt.Errorf("failed to write the file content")
+					return false, fmt.Errorf("failed to write the file content")
 				}
 
-				totalWritten += int64(written)
-				prog.Current = int(float64(totalWritten) / float64(file.FileSize) * 100)
+				prog.Downloaded += int64(written)
+				// Without a known size, only the downloaded bytes are reported.
+				if prog.Total > 0 {
+					prog.Current = int(float64(prog.Downloaded) / float64(prog.Total) * 100)
+				}
 				elapsedTime := time.Since(prog.StartTime).Seconds()
 				if elapsedTime > 0 {
-					speed := ((float64(totalWritten) / elapsedTime) / 1e6) // Speed in Mbps
-					prog.Speed = math.Round(speed*100) / 100               // Rounded to two decimal places
+					speed := ((float64(prog.Downloaded-sessionStart) / elapsedTime) / 1e6) // Speed in Mbps
+					prog.Speed = math.Round(speed*100) / 100                               // Rounded to two decimal places
+				}
+				if peers != nil {
+					prog.Peers, prog.Seeds = peers.PeerCount()
 				}
 
 				// Updating the downloading progress
-				progChan <- prog
+				progChan <- *prog
 			}
 		}
 
 		if err != nil {
 			// Break the loop if error is `EOF` -> End of Line which means the entire file has been downloaded.
 			if err == io.EOF {
-				break
+				return false, nil
 			}
 			// Otherwise break the loop and return with an error.
-			return fmt.Errorf("failed to read response body of the file %s", file.OriginalFilename)
+			return false, fmt.Errorf("failed to read the response body: %w", err)
 		}
 	}
-
-	// Mark download as complete
-	prog.Complete = true
-	prog.EndTime = time.Now()
-	progChan <- prog
-
-	return nil
 }
 
 func validateDownloaderConfig(cfg DownloaderConfig) error {
@@ -136,8 +268,5 @@ func validateDownloaderConfig(cfg DownloaderConfig) error {
 	if len(cfg.DestinationPath) == 0 {
 		return fmt.Errorf("invalid destination path")
 	}
-	if len(cfg.AccessToken) == 0 {
-		return fmt.Errorf("invalid access token")
-	}
 	return nil
 }
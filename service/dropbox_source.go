@@ -0,0 +1,298 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+)
+
+const (
+	dropboxAPIURL     = "https://api.dropboxapi.com/2"
+	dropboxContentURL = "https://content.dropboxapi.com/2"
+)
+
+// Path prefixes of Dropbox shared links, eg. `dropbox.com/s/...` or `dropbox.com/scl/fo/...`.
+var dropboxLinkPrefixes = []string{"/s/", "/sh/", "/scl/fi/", "/scl/fo/"}
+
+// `DropboxSource` downloads the files of Dropbox shared links with the access token of the Dropbox provider.
+// The ID of a file is its shared link, a file inside a shared folder has its path appended after a `#`,
+// eg. `https://www.dropbox.com/scl/fo/abc/xyz?rlkey=k#/photos/a.jpg`.
+type DropboxSource struct {
+	client     *http.Client
+	apiURL     string
+	contentURL string
+}
+
+func NewDropboxSource() *DropboxSource {
+	return &DropboxSource{
+		client:     util.NewHTTPClient(setting.MaxHTTPRedirects),
+		apiURL:     dropboxAPIURL,
+		contentURL: dropboxContentURL,
+	}
+}
+
+func (s *DropboxSource) AuthProvider() setting.Provider {
+	return setting.DropboxProvider
+}
+
+func (s *DropboxSource) Match(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || (u.Host != "dropbox.com" && u.Host != "www.dropbox.com") {
+		return false
+	}
+	for _, prefix := range dropboxLinkPrefixes {
+		if strings.HasPrefix(u.Path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resolve tells whether the shared link is a file or a folder.
+func (s *DropboxSource) Resolve(ctx context.Context, link string, opts types.SourceOptions) (*types.SourceFile, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link %s", link)
+	}
+	// The fragment is used for the paths inside shared folders.
+	u.Fragment = ""
+
+	meta, err := s.getMetadata(ctx, u.String(), "", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.SourceFile{
+		ID:       u.String(),
+		Name:     meta.Name,
+		Size:     meta.Size,
+		IsFolder: meta.Tag == "folder",
+		ETag:     meta.Rev,
+	}, nil
+}
+
+// ListFolder walks the shared folder recursively, shared links can't be listed recursively in a single call.
+func (s *DropboxSource) ListFolder(ctx context.Context, folder *types.SourceFile, opts types.SourceOptions) ([]types.SourceFile, error) {
+	link, _ := splitSharedID(folder.ID)
+	files := make([]types.SourceFile, 0)
+
+	var walk func(dir string, folderPath string) error
+	walk = func(dir string, folderPath string) error {
+		entries, err := s.listFolder(ctx, link, folderPath, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			entryPath := folderPath + "/" + entry.Name
+			switch entry.Tag {
+			case "folder":
+				if err := walk(path.Join(dir, util.SanitizeFileName(entry.Name)), entryPath); err != nil {
+					return err
+				}
+			case "file":
+				files = append(files, types.SourceFile{
+					ID:   link + "#" + entryPath,
+					Dir:  dir,
+					Name: entry.Name,
+					Size: entry.Size,
+					ETag: entry.Rev,
+				})
+			}
+		}
+
+		return nil
+	}
+
+	if err := walk(util.SanitizeFileName(folder.Name), ""); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (s *DropboxSource) Stat(ctx context.Context, fileID string, opts types.SourceOptions) (*types.SourceFile, error) {
+	link, filePath := splitSharedID(fileID)
+
+	meta, err := s.getMetadata(ctx, link, filePath, opts)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Tag != "file" {
+		return nil, fmt.Errorf("expected file, received a %s", meta.Tag)
+	}
+
+	return &types.SourceFile{
+		ID:   fileID,
+		Name: meta.Name,
+		Size: meta.Size,
+		ETag: meta.Rev,
+	}, nil
+}
+
+// Open streams the file from `offset` with a range request.
+func (s *DropboxSource) Open(ctx context.Context, file *types.SourceFile, offset int64, opts types.SourceOptions) (io.ReadCloser, int64, error) {
+	link, filePath := splitSharedID(file.ID)
+
+	args := map[string]string{"url": link}
+	if len(filePath) != 0 {
+		args["path"] = filePath
+	}
+	apiArg, err := dropboxAPIArg(args)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return util.OpenRange(s.client, offset, func(offset int64) (*http.Request, error) {
+		req, err := s.newRequest(ctx, s.contentURL+"/sharing/get_shared_link_file", nil, opts)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Dropbox-API-Arg", apiArg)
+		util.SetRangeHeader(req, offset, "")
+
+		return req, nil
+	}, dropboxError)
+}
+
+// Checksum isn't known for shared links, Dropbox only returns it for the files of the user.
+func (s *DropboxSource) Checksum(file *types.SourceFile) types.Checksum {
+	return types.Checksum{}
+}
+
+func (s *DropboxSource) getMetadata(ctx context.Context, link string, filePath string, opts types.SourceOptions) (*types.DropboxMetadata, error) {
+	args := map[string]string{"url": link}
+	if len(filePath) != 0 {
+		args["path"] = filePath
+	}
+
+	var meta types.DropboxMetadata
+	if err := s.call(ctx, "/sharing/get_shared_link_metadata", args, &meta, opts); err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+// listFolder returns all the entries directly inside `folderPath` of the shared folder.
+func (s *DropboxSource) listFolder(ctx context.Context, link string, folderPath string, opts types.SourceOptions) ([]types.DropboxMetadata, error) {
+	var page types.DropboxListFolderResponse
+	err := s.call(ctx, "/files/list_folder", map[string]any{
+		"path":        folderPath,
+		"shared_link": map[string]string{"url": link},
+	}, &page, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := page.Entries
+	for page.HasMore {
+		cursor := page.Cursor
+		page = types.DropboxListFolderResponse{}
+		if err := s.call(ctx, "/files/list_folder/continue", map[string]string{"cursor": cursor}, &page, opts); err != nil {
+			return nil, err
+		}
+		entries = append(entries, page.Entries...)
+	}
+
+	return entries, nil
+}
+
+// call makes an RPC call to the Dropbox API and decodes the result into `target`.
+func (s *DropboxSource) call(ctx context.Context, endpoint string, args any, target any, opts types.SourceOptions) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	req, err := s.newRequest(ctx, s.apiURL+endpoint, bytes.NewReader(body), opts)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return dropboxError(res)
+	}
+
+	return util.DecodeJSON(res.Body, target)
+}
+
+func (s *DropboxSource) newRequest(ctx context.Context, endpoint string, body io.Reader, opts types.SourceOptions) (*http.Request, error) {
+	if opts.TokenSource == nil {
+		return nil, fmt.Errorf("invalid access token")
+	}
+	token, err := opts.TokenSource.Token()
+	if err != nil {
+		return nil, fmt.Errorf("invalid access token: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	token.SetAuthHeader(req)
+
+	return req, nil
+}
+
+// dropboxError turns a failed response into an error. Rate limits and server errors are
+// `HTTPStatusError`s, so they're retried, the rest can't succeed by trying again.
+func dropboxError(res *http.Response) error {
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError {
+		return &util.HTTPStatusError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+
+	var errRes types.DropboxErrorResponse
+	if err := util.DecodeJSON(res.Body, &errRes); err != nil || len(errRes.ErrorSummary) == 0 {
+		return fmt.Errorf("dropbox request failed: %s", res.Status)
+	}
+
+	return fmt.Errorf("dropbox request failed: %s", errRes.ErrorSummary)
+}
+
+// splitSharedID splits a file ID into the shared link and the file inside it, see `DropboxSource`.
+func splitSharedID(fileID string) (string, string) {
+	link, file, _ := strings.Cut(fileID, "#")
+	return link, file
+}
+
+// dropboxAPIArg encodes the arguments of a content endpoint for the `Dropbox-API-Arg` header,
+// HTTP headers can only hold ASCII so every other character is escaped.
+func dropboxAPIArg(args any) (string, error) {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, r := range string(b) {
+		if r < 0x80 {
+			sb.WriteRune(r)
+			continue
+		}
+		for _, c := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&sb, "\\u%04x", c)
+		}
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+const testDropboxLink = "https://www.dropbox.com/scl/fo/abc/xyz?rlkey=k&dl=0"
+
+// newFakeDropbox serves a shared folder with `a.txt` and `sub/résumé.bin`,
+// the listing of the root folder is split into two pages.
+func newFakeDropbox(t *testing.T, content string, ranges *atomic.Int32) *DropboxSource {
+	files := map[string]types.DropboxMetadata{
+		"":                {Tag: "folder", Name: "Shared"},
+		"/a.txt":          {Tag: "file", Name: "a.txt", Size: 5, Rev: "r1"},
+		"/sub":            {Tag: "folder", Name: "sub"},
+		"/sub/résumé.bin": {Tag: "file", Name: "résumé.bin", Size: int64(len(content)), Rev: "r2"},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		var args struct {
+			URL        string `json:"url"`
+			Path       string `json:"path"`
+			Cursor     string `json:"cursor"`
+			SharedLink struct {
+				URL string `json:"url"`
+			} `json:"shared_link"`
+		}
+		if r.URL.Path == "/sharing/get_shared_link_file" {
+			assert.NoError(t, json.Unmarshal([]byte(r.Header.Get("Dropbox-API-Arg")), &args))
+		} else {
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&args))
+		}
+
+		switch r.URL.Path {
+		case "/sharing/get_shared_link_metadata":
+			assert.Equal(t, testDropboxLink, args.URL)
+			meta, ok := files[args.Path]
+			if !ok {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(`{"error_summary": "shared_link_not_found/"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(meta)
+		case "/files/list_folder":
+			assert.Equal(t, testDropboxLink, args.SharedLink.URL)
+			switch args.Path {
+			case "":
+				json.NewEncoder(w).Encode(types.DropboxListFolderResponse{Entries: []types.DropboxMetadata{files["/a.txt"]}, Cursor: "next", HasMore: true})
+			case "/sub":
+				json.NewEncoder(w).Encode(types.DropboxListFolderResponse{Entries: []types.DropboxMetadata{files["/sub/résumé.bin"]}})
+			}
+		case "/files/list_folder/continue":
+			assert.Equal(t, "next", args.Cursor)
+			json.NewEncoder(w).Encode(types.DropboxListFolderResponse{Entries: []types.DropboxMetadata{files["/sub"]}})
+		case "/sharing/get_shared_link_file":
+			assert.Equal(t, testDropboxLink, args.URL)
+			assert.Equal(t, "/sub/résumé.bin", args.Path)
+			serveRange(w, r, "", content, ranges)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return &DropboxSource{client: srv.Client(), apiURL: srv.URL, contentURL: srv.URL}
+}
+
+func TestDropboxSource_Match(t *testing.T) {
+	src := NewDropboxSource()
+	assert.True(t, src.Match("https://www.dropbox.com/s/abc123/file.zip?dl=0"))
+	assert.True(t, src.Match("https://dropbox.com/scl/fi/abc/file.zip?rlkey=k"))
+	assert.True(t, src.Match(testDropboxLink))
+	assert.False(t, src.Match("https://www.dropbox.com/home/file.zip"))
+	assert.False(t, src.Match("https://example.com/s/abc/file.zip"))
+}
+
+func TestDropboxSource_DownloadFolder(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var ranges atomic.Int32
+	src := newFakeDropbox(t, content, &ranges)
+	opts := types.SourceOptions{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})}
+
+	folder, err := src.Resolve(context.Background(), testDropboxLink+"#ignored", opts)
+	assert.NoError(t, err)
+	assert.True(t, folder.IsFolder)
+	assert.Equal(t, testDropboxLink, folder.ID)
+
+	files, err := src.ListFolder(context.Background(), folder, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.SourceFile{
+		{ID: testDropboxLink + "#/a.txt", Dir: "Shared", Name: "a.txt", Size: 5, ETag: "r1"},
+		{ID: testDropboxLink + "#/sub/résumé.bin", Dir: "Shared/sub", Name: "résumé.bin", Size: int64(len(content)), ETag: "r2"},
+	}, files)
+	assert.Zero(t, ranges.Load())
+}
+
+// resumeDropbox serves `content` as a file of a shared folder.
+func resumeDropbox(t *testing.T, content string) (types.Source, string, types.SourceOptions, func(t *testing.T)) {
+	var ranges atomic.Int32
+	opts := types.SourceOptions{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})}
+
+	return newFakeDropbox(t, content, &ranges), testDropboxLink + "#/sub/résumé.bin", opts, func(t *testing.T) {
+		assert.Equal(t, int32(1), ranges.Load())
+	}
+}
+
+func TestDropboxSource_Errors(t *testing.T) {
+	var ranges atomic.Int32
+	src := newFakeDropbox(t, "", &ranges)
+
+	_, err := src.Stat(context.Background(), testDropboxLink+"#/missing.txt", types.SourceOptions{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})})
+	assertPermanentError(t, err, "shared_link_not_found")
+
+	_, err = src.Stat(context.Background(), testDropboxLink, types.SourceOptions{})
+	assert.ErrorContains(t, err, "invalid access token")
+}
+
+func TestDropboxAPIArg(t *testing.T) {
+	arg, err := dropboxAPIArg(map[string]string{"path": "/résumé 😀.pdf"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"path":"/r\u00e9sum\u00e9 \ud83d\ude00.pdf"}`, arg)
+}
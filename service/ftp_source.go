@@ -0,0 +1,293 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+)
+
+// `FTPSource` downloads `ftp://` and `ftps://` links with the credentials of the user's connection profile
+// for the host. The ID of a file is its link, a link to a directory is downloaded like a folder.
+type FTPSource struct {
+	profiles profileFunc
+}
+
+func NewFTPSource(db *sql.DB) *FTPSource {
+	return &FTPSource{
+		profiles: dbProfiles(db),
+	}
+}
+
+// The credentials are taken from the connection profiles, no login needed.
+func (s *FTPSource) AuthProvider() setting.Provider {
+	return ""
+}
+
+func (s *FTPSource) Match(link string) bool {
+	link = strings.ToLower(link)
+	return strings.HasPrefix(link, "ftp://") || strings.HasPrefix(link, "ftps://")
+}
+
+func (s *FTPSource) Resolve(ctx context.Context, link string, opts types.SourceOptions) (*types.SourceFile, error) {
+	loc, err := util.ParseRemoteLink(link)
+	if err != nil {
+		return nil, util.NewAppError(http.StatusBadRequest, err.Error())
+	}
+
+	conn, err := s.connect(ctx, loc, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	file, err := conn.stat(loc)
+	if err == nil {
+		return file, nil
+	}
+	// `SIZE` fails for directories.
+	if dirErr := conn.ChangeDir(loc.Path); dirErr != nil {
+		return nil, err
+	}
+
+	return &types.SourceFile{ID: loc.String(), Name: remoteBase(loc), IsFolder: true}, nil
+}
+
+// ListFolder lists the directory recursively, symlinks are skipped so a link can't loop.
+func (s *FTPSource) ListFolder(ctx context.Context, folder *types.SourceFile, opts types.SourceOptions) ([]types.SourceFile, error) {
+	loc, err := util.ParseRemoteLink(folder.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := s.connect(ctx, loc, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	files := make([]types.SourceFile, 0)
+	base := remoteBase(loc)
+	var list func(dir string) error
+	list = func(dir string) error {
+		entries, err := conn.List(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.Name == "." || e.Name == ".." {
+				continue
+			}
+
+			fileLoc := loc
+			fileLoc.Path = path.Join(dir, e.Name)
+			switch e.Type {
+			case ftp.EntryTypeFolder:
+				if err := list(fileLoc.Path); err != nil {
+					return err
+				}
+			case ftp.EntryTypeFile:
+				files = append(files, types.SourceFile{
+					ID:   fileLoc.String(),
+					Dir:  util.SanitizeDir(base, strings.TrimPrefix(dir, loc.Path)),
+					Name: e.Name,
+					Size: int64(e.Size),
+				})
+			}
+		}
+		return nil
+	}
+	if err := list(loc.Path); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (s *FTPSource) Stat(ctx context.Context, fileID string, opts types.SourceOptions) (*types.SourceFile, error) {
+	loc, err := util.ParseRemoteLink(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := s.connect(ctx, loc, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.stat(loc)
+}
+
+// Open continues from `offset` with `REST`, the connection is closed along with the file.
+func (s *FTPSource) Open(ctx context.Context, file *types.SourceFile, offset int64, opts types.SourceOptions) (io.ReadCloser, int64, error) {
+	loc, err := util.ParseRemoteLink(file.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	conn, err := s.connect(ctx, loc, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	res, err := conn.RetrFrom(loc.Path, uint64(offset))
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+
+	return &ftpReader{res: res, conn: conn}, offset, nil
+}
+
+// FTP has no standard way to get a checksum.
+func (s *FTPSource) Checksum(file *types.SourceFile) types.Checksum {
+	return types.Checksum{}
+}
+
+// connect logs in with the user's connection profile for the host of `loc`.
+func (s *FTPSource) connect(ctx context.Context, loc util.RemoteLocation, opts types.SourceOptions) (*ftpConn, error) {
+	p, err := findProfile(s.profiles, opts.UserID, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &ftpDialer{ctx: ctx}
+	if loc.Protocol == setting.ProtocolFTPS {
+		d.tlsConfig = &tls.Config{
+			ServerName: loc.Host,
+			// Servers usually want the data connections to resume the session of the control connection.
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		}
+		d.explicitTLS = p.ExplicitTLS
+	}
+
+	// The dialer does the TLS handshake itself, the options just tell the client what to expect.
+	dialOpts := []ftp.DialOption{ftp.DialWithDialFunc(d.dial)}
+	if d.explicitTLS {
+		dialOpts = append(dialOpts, ftp.DialWithExplicitTLS(d.tlsConfig))
+	} else if d.tlsConfig != nil {
+		dialOpts = append(dialOpts, ftp.DialWithTLS(d.tlsConfig))
+	}
+
+	c, err := ftp.Dial(net.JoinHostPort(loc.Host, strconv.Itoa(loc.Port)), dialOpts...)
+	if err != nil {
+		d.closeAll()
+		return nil, err
+	}
+	// Closing the connections unblocks a pending read once the download is cancelled or paused.
+	stop := context.AfterFunc(ctx, d.closeAll)
+	if err := c.Login(p.Username, p.Password); err != nil {
+		stop()
+		d.closeAll()
+		return nil, err
+	}
+
+	return &ftpConn{ServerConn: c, dialer: d, stop: stop}, nil
+}
+
+// `ftpConn` is a logged in FTP session.
+type ftpConn struct {
+	*ftp.ServerConn
+	dialer *ftpDialer
+	stop   func() bool
+}
+
+func (c *ftpConn) Close() error {
+	c.stop()
+	c.Quit()
+	c.dialer.closeAll()
+	return nil
+}
+
+// stat gets the size of the file, and its modification time if the server supports `MDTM`.
+func (c *ftpConn) stat(loc util.RemoteLocation) (*types.SourceFile, error) {
+	size, err := c.FileSize(loc.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &types.SourceFile{
+		ID:   loc.String(),
+		Name: path.Base(loc.Path),
+		Size: size,
+		ETag: strconv.FormatInt(size, 10),
+	}
+	if c.IsGetTimeSupported() {
+		if t, err := c.GetTime(loc.Path); err == nil {
+			file.ETag = fmt.Sprintf("%d-%d", size, t.Unix())
+		}
+	}
+
+	return file, nil
+}
+
+// `ftpReader` is a download, closing it ends the session.
+type ftpReader struct {
+	res  *ftp.Response
+	conn *ftpConn
+}
+
+func (r *ftpReader) Read(p []byte) (int, error) {
+	return r.res.Read(p)
+}
+
+// Close ends the session first, so closing the transfer doesn't wait for the server to confirm it.
+func (r *ftpReader) Close() error {
+	r.conn.Close()
+	r.res.Close()
+	return nil
+}
+
+// `ftpDialer` opens the control and data connections of a session, and keeps them to close
+// them all at once. Data connections always use TLS with FTPS, the control connection only
+// with implicit TLS, as the client upgrades it itself after `AUTH TLS`.
+type ftpDialer struct {
+	ctx         context.Context
+	tlsConfig   *tls.Config
+	explicitTLS bool
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (d *ftpDialer) dial(network string, addr string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: setting.RemoteDialTimeout}
+	conn, err := dialer.DialContext(d.ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	control := len(d.conns) == 0
+	d.conns = append(d.conns, conn)
+	d.mu.Unlock()
+
+	if d.tlsConfig != nil && (!control || !d.explicitTLS) {
+		return tls.Client(conn, d.tlsConfig), nil
+	}
+
+	return conn, nil
+}
+
+func (d *ftpDialer) closeAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, conn := range d.conns {
+		conn.Close()
+	}
+	d.conns = nil
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// `ftpCommands` records the commands received by the test server.
+type ftpCommands struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (c *ftpCommands) add(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, line)
+}
+
+func (c *ftpCommands) list() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.lines...)
+}
+
+// newTestFTPServer serves `files` by their path over FTP for the user `me` with the password `secret`,
+// the directories are implied by the paths. The received commands are recorded in `cmds`.
+func newTestFTPServer(t *testing.T, files map[string]string, cmds *ftpCommands) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveFTP(conn, files, cmds)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func serveFTP(conn net.Conn, files map[string]string, cmds *ftpCommands) {
+	tp := textproto.NewConn(conn)
+	defer tp.Close()
+
+	isDir := func(dir string) bool {
+		for name := range files {
+			if strings.HasPrefix(name, strings.TrimSuffix(dir, "/")+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var (
+		data   net.Listener
+		offset int64
+	)
+	tp.PrintfLine("220 ready")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmds.add(line)
+		cmd, arg, _ := strings.Cut(line, " ")
+
+		switch cmd {
+		case "USER":
+			tp.PrintfLine("331 password required")
+		case "PASS":
+			if arg != "secret" {
+				tp.PrintfLine("530 login incorrect")
+				continue
+			}
+			tp.PrintfLine("230 logged in")
+		case "FEAT":
+			tp.PrintfLine("211-Features:\r\n SIZE\r\n MDTM\r\n211 End")
+		case "TYPE":
+			tp.PrintfLine("200 ok")
+		case "EPSV":
+			data, _ = net.Listen("tcp", "127.0.0.1:0")
+			tp.PrintfLine("229 Entering Extended Passive Mode (|||%d|)", data.Addr().(*net.TCPAddr).Port)
+		case "SIZE":
+			content, ok := files[arg]
+			if !ok {
+				tp.PrintfLine("550 not a file")
+				continue
+			}
+			tp.PrintfLine("213 %d", len(content))
+		case "MDTM":
+			tp.PrintfLine("213 20240701120000")
+		case "CWD":
+			if !isDir(arg) {
+				tp.PrintfLine("550 no such directory")
+				continue
+			}
+			tp.PrintfLine("250 ok")
+		case "REST":
+			offset, _ = strconv.ParseInt(arg, 10, 64)
+			tp.PrintfLine("350 restarting at %d", offset)
+		case "RETR", "LIST":
+			dataConn, err := data.Accept()
+			data.Close()
+			if err != nil {
+				return
+			}
+			tp.PrintfLine("150 opening data connection")
+			if cmd == "RETR" {
+				dataConn.Write([]byte(files[arg][offset:]))
+				offset = 0
+			} else {
+				dataConn.Write([]byte(ftpListing(files, arg)))
+			}
+			dataConn.Close()
+			tp.PrintfLine("226 transfer complete")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// ftpListing formats the entries of `dir` like `ls -l`.
+func ftpListing(files map[string]string, dir string) string {
+	dir = strings.TrimSuffix(dir, "/") + "/"
+	entries := make(map[string]string)
+	for name, content := range files {
+		rel, ok := strings.CutPrefix(name, dir)
+		if !ok {
+			continue
+		}
+		if first, _, isSub := strings.Cut(rel, "/"); isSub {
+			entries[first] = "drwxr-xr-x 1 ftp ftp 0 Jul 01 12:00 " + first
+		} else {
+			entries[rel] = fmt.Sprintf("-rw-r--r-- 1 ftp ftp %d Jul 01 12:00 %s", len(content), rel)
+		}
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, line := range entries {
+		lines = append(lines, line+"\r\n")
+	}
+	sort.Strings(lines)
+
+	return strings.Join(lines, "")
+}
+
+// newTestFTPSource logs in to the server at `addr` with the profile of the user `user`.
+func newTestFTPSource(addr string) *FTPSource {
+	host, port, _ := net.SplitHostPort(addr)
+
+	return &FTPSource{profiles: func(userID string, protocol setting.ProfileProtocol, h string, p int) (*types.ConnectionProfile, error) {
+		if userID != "user" || protocol != setting.ProtocolFTP || h != host || strconv.Itoa(p) != port {
+			return &types.ConnectionProfile{}, nil
+		}
+		return &types.ConnectionProfile{ID: "1", Username: "me", Password: "secret"}, nil
+	}}
+}
+
+func TestFTPSource_DownloadFolder(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	files := map[string]string{
+		"/archive/a.txt":     "hello",
+		"/archive/sub/b.bin": content,
+	}
+	var cmds ftpCommands
+	addr := newTestFTPServer(t, files, &cmds)
+	src := newTestFTPSource(addr)
+	opts := types.SourceOptions{UserID: "user"}
+	link := "ftp://" + addr + "/archive"
+
+	folder, err := src.Resolve(context.Background(), link, opts)
+	assert.NoError(t, err)
+	assert.True(t, folder.IsFolder)
+	assert.Equal(t, "archive", folder.Name)
+
+	list, err := src.ListFolder(context.Background(), folder, opts)
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, "archive", list[0].Dir)
+	assert.Equal(t, link+"/sub/b.bin", list[1].ID)
+	assert.Equal(t, "archive/sub", list[1].Dir)
+
+	file, err := src.Stat(context.Background(), list[1].ID, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), file.Size)
+	assert.Equal(t, path.Base(list[1].ID), file.Name)
+}
+
+// resumeFTP serves `content` over FTP.
+func resumeFTP(t *testing.T, content string) (types.Source, string, types.SourceOptions, func(t *testing.T)) {
+	var cmds ftpCommands
+	addr := newTestFTPServer(t, map[string]string{"/b.bin": content}, &cmds)
+
+	return newTestFTPSource(addr), "ftp://" + addr + "/b.bin", types.SourceOptions{UserID: "user"}, func(t *testing.T) {
+		assert.Contains(t, cmds.list(), "REST 4000")
+	}
+}
+
+func TestFTPSource_Rest(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var cmds ftpCommands
+	addr := newTestFTPServer(t, map[string]string{"/b.bin": content}, &cmds)
+	src := newTestFTPSource(addr)
+	file := &types.SourceFile{ID: "ftp://" + addr + "/b.bin"}
+
+	// retr opens the file at `offset` and returns the transfer commands sent for it.
+	retr := func(offset int64) []string {
+		sent := len(cmds.list())
+		body, start, err := src.Open(context.Background(), file, offset, types.SourceOptions{UserID: "user"})
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		b, err := io.ReadAll(body)
+		assert.NoError(t, err)
+		assert.NoError(t, body.Close())
+		assert.Equal(t, offset, start)
+		assert.Equal(t, content[offset:], string(b))
+
+		var transfer []string
+		for _, line := range cmds.list()[sent:] {
+			if strings.HasPrefix(line, "REST ") || strings.HasPrefix(line, "RETR ") {
+				transfer = append(transfer, line)
+			}
+		}
+		return transfer
+	}
+
+	// The server has to skip to the offset before the transfer starts.
+	assert.Equal(t, []string{"REST 4000", "RETR /b.bin"}, retr(4000))
+	assert.Equal(t, []string{"RETR /b.bin"}, retr(0))
+}
+
+func TestFTPSource_Errors(t *testing.T) {
+	var cmds ftpCommands
+	addr := newTestFTPServer(t, map[string]string{}, &cmds)
+
+	src := &FTPSource{profiles: func(string, setting.ProfileProtocol, string, int) (*types.ConnectionProfile, error) {
+		return &types.ConnectionProfile{ID: "1", Username: "me", Password: "wrong"}, nil
+	}}
+	_, err := src.Stat(context.Background(), "ftp://"+addr+"/missing.iso", types.SourceOptions{UserID: "user"})
+	assert.ErrorContains(t, err, "login incorrect")
+
+	_, err = src.Resolve(context.Background(), "ftp://me:secret@"+addr+"/missing.iso", types.SourceOptions{UserID: "user"})
+	assert.ErrorContains(t, err, "connection profile")
+}
@@ -0,0 +1,344 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/option"
+)
+
+// `GDriveSource` downloads Google Drive files with the access token of the Google provider.
+// Google Docs, Sheets and Slides have no binary content, they're exported instead.
+// Without a Google account, public files are downloaded with the API key if there's one,
+// or like a browser would, through the `uc?export=download` links.
+type GDriveSource struct {
+	apiKey string
+	// Base URL of the Drive API, the default of the client library if it's empty.
+	apiURL    string
+	client    *http.Client
+	publicURL string
+}
+
+func NewGDriveSource(apiKey string) *GDriveSource {
+	return &GDriveSource{
+		apiKey:    apiKey,
+		client:    util.NewHTTPClient(setting.MaxHTTPRedirects),
+		publicURL: setting.GDrivePublicDownloadURL,
+	}
+}
+
+func (s *GDriveSource) AuthProvider() setting.Provider {
+	return setting.GoogleProvider
+}
+
+// Public files don't need a Google account.
+func (s *GDriveSource) AllowsAnonymous() bool {
+	return true
+}
+
+func (s *GDriveSource) Match(link string) bool {
+	fileID, _ := util.GetGDriveFileID(link)
+	return len(fileID) != 0
+}
+
+// Resolve parses the link and checks that the account can access the file, so another account
+// of the user can be tried otherwise. The file itself is looked up once its download starts.
+func (s *GDriveSource) Resolve(ctx context.Context, link string, opts types.SourceOptions) (*types.SourceFile, error) {
+	fileID, isFile := util.GetGDriveFileID(link)
+	if len(fileID) == 0 {
+		return nil, fmt.Errorf("invalid link %s", link)
+	}
+
+	// Links shared before 2021 carry the resource key of the file.
+	ref := util.GDriveFileRef{ID: fileID, ResourceKey: util.GetGDriveResourceKey(link)}
+
+	srv, err := s.service(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Public files are checked when their download starts.
+	if srv == nil {
+		return &types.SourceFile{ID: ref.String(), IsFolder: !isFile}, nil
+	}
+
+	call := srv.Files.Get(ref.ID).Fields("id, mimeType").Context(ctx)
+	util.SetGDriveResourceKey(call.Header(), ref)
+	file, err := call.Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.SourceFile{ID: ref.String(), IsFolder: file.MimeType == setting.GDriveFolderMimeType}, nil
+}
+
+func (s *GDriveSource) ListFolder(ctx context.Context, folder *types.SourceFile, opts types.SourceOptions) ([]types.SourceFile, error) {
+	srv, err := s.service(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	// The public download links can't list a folder.
+	if srv == nil {
+		return nil, fmt.Errorf("public Drive folders need GOOGLE_API_KEY or a connected Google account")
+	}
+
+	// Walks the folder recursively, every file keeps its path relative to the top-level folder.
+	return util.GetFilesFromFolder(srv, util.ParseGDriveFileRef(folder.ID))
+}
+
+func (s *GDriveSource) Stat(ctx context.Context, fileID string, opts types.SourceOptions) (*types.SourceFile, error) {
+	srv, err := s.service(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	ref := util.ParseGDriveFileRef(fileID)
+	if srv == nil {
+		return s.publicStat(ctx, ref)
+	}
+
+	call := srv.Files.Get(ref.ID).Context(ctx)
+	util.SetGDriveResourceKey(call.Header(), ref)
+	file, err := call.Do()
+	if err != nil {
+		return nil, err
+	}
+
+	// In case `fileID` is for a folder we return an error.
+	if file.MimeType == setting.GDriveFolderMimeType {
+		return nil, fmt.Errorf("expected file, received a folder")
+	}
+
+	// The exported size isn't known before hand.
+	if strings.HasPrefix(file.MimeType, setting.GDriveNativeMimePrefix) {
+		_, ext, ok := util.GetExportType(file.MimeType, opts.ExportFormat)
+		if !ok {
+			return nil, fmt.Errorf("%s can't be exported", file.MimeType)
+		}
+
+		name := file.Title
+		if !strings.HasSuffix(strings.ToLower(name), ext) {
+			name += ext
+		}
+
+		return &types.SourceFile{
+			ID:       fileID,
+			Name:     name,
+			MimeType: file.MimeType,
+			Size:     -1,
+		}, nil
+	}
+
+	return &types.SourceFile{
+		ID:       fileID,
+		Name:     file.OriginalFilename,
+		MimeType: file.MimeType,
+		Size:     file.FileSize,
+		Hash:     file.Md5Checksum,
+	}, nil
+}
+
+// Open streams the file from `offset` with a range request. Exports can't be resumed,
+// so they always start from the beginning.
+func (s *GDriveSource) Open(ctx context.Context, file *types.SourceFile, offset int64, opts types.SourceOptions) (io.ReadCloser, int64, error) {
+	srv, err := s.service(ctx, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	ref := util.ParseGDriveFileRef(file.ID)
+	if srv == nil {
+		return s.publicOpen(ctx, ref, offset)
+	}
+
+	if strings.HasPrefix(file.MimeType, setting.GDriveNativeMimePrefix) {
+		mimeType, _, ok := util.GetExportType(file.MimeType, opts.ExportFormat)
+		if !ok {
+			return nil, 0, fmt.Errorf("%s can't be exported", file.MimeType)
+		}
+
+		call := srv.Files.Export(ref.ID, mimeType).Context(ctx)
+		util.SetGDriveResourceKey(call.Header(), ref)
+		res, err := call.Download()
+		if err != nil {
+			return nil, 0, err
+		}
+		return res.Body, 0, nil
+	}
+
+	call := srv.Files.Get(ref.ID).Context(ctx)
+	util.SetGDriveResourceKey(call.Header(), ref)
+	if offset > 0 {
+		call.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	res, err := call.Download()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The server ignored the range request and sent the entire file.
+	if offset > 0 && res.StatusCode != http.StatusPartialContent {
+		return res.Body, 0, nil
+	}
+
+	return res.Body, offset, nil
+}
+
+// Checksum returns Drive's `md5Checksum`, exported files don't have one.
+func (s *GDriveSource) Checksum(file *types.SourceFile) types.Checksum {
+	if strings.HasPrefix(file.MimeType, setting.GDriveNativeMimePrefix) {
+		return types.Checksum{}
+	}
+
+	return types.Checksum{Algorithm: "md5", Value: file.Hash}
+}
+
+// service returns the Drive API client of the user, or one with the API key if the user has no
+// Google account. It returns nil without either, the public download links are used then.
+func (s *GDriveSource) service(ctx context.Context, opts types.SourceOptions) (*drive.Service, error) {
+	var (
+		srv *drive.Service
+		err error
+	)
+	switch {
+	case opts.TokenSource != nil:
+		// Making a GDrive Service with the tokens from OAuth.
+		srv, err = util.MakeGDriveService(ctx, opts.TokenSource)
+	case len(s.apiKey) != 0:
+		srv, err = drive.NewService(ctx, option.WithAPIKey(s.apiKey))
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize GDrive service")
+	}
+
+	if len(s.apiURL) != 0 {
+		srv.BasePath = s.apiURL
+	}
+	return srv, nil
+}
+
+// publicStat requests the first byte of a public file to read its name and size from the response headers.
+func (s *GDriveSource) publicStat(ctx context.Context, ref util.GDriveFileRef) (*types.SourceFile, error) {
+	res, err := s.publicDownload(ctx, ref, "bytes=0-0")
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	// The whole file is sent if the range was ignored, its body isn't read though.
+	size := res.ContentLength
+	if res.StatusCode == http.StatusPartialContent || res.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		size = util.ContentRangeSize(res.Header.Get("Content-Range"))
+	}
+
+	mimeType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	return &types.SourceFile{
+		ID:       ref.String(),
+		Name:     util.GetHTTPFileName(res.Header, res.Request.URL),
+		MimeType: mimeType,
+		Size:     size,
+	}, nil
+}
+
+func (s *GDriveSource) publicOpen(ctx context.Context, ref util.GDriveFileRef, offset int64) (io.ReadCloser, int64, error) {
+	var rangeHeader string
+	if offset > 0 {
+		rangeHeader = fmt.Sprintf("bytes=%d-", offset)
+	}
+	res, err := s.publicDownload(ctx, ref, rangeHeader)
+	if err != nil {
+		return nil, 0, err
+	}
+	// The partial data is already bigger than the file, it has to start over.
+	if res.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		res.Body.Close()
+		return s.publicOpen(ctx, ref, 0)
+	}
+
+	// The server ignored the range request and sent the entire file.
+	if offset > 0 && res.StatusCode != http.StatusPartialContent {
+		return res.Body, 0, nil
+	}
+
+	return res.Body, offset, nil
+}
+
+// publicDownload requests a public file, or the part of it in `rangeHeader` if it's set. Files too large
+// to be scanned for viruses are behind a warning page, its form is submitted once to get to the file.
+func (s *GDriveSource) publicDownload(ctx context.Context, ref util.GDriveFileRef, rangeHeader string) (*http.Response, error) {
+	u, err := url.Parse(s.publicURL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	query.Set("export", "download")
+	query.Set("id", ref.ID)
+	if len(ref.ResourceKey) != 0 {
+		query.Set("resourcekey", ref.ResourceKey)
+	}
+	u.RawQuery = query.Encode()
+
+	link := u.String()
+	for confirmed := false; ; confirmed = true {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(rangeHeader) != 0 {
+			req.Header.Set("Range", rangeHeader)
+		}
+
+		res, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if isGDriveFileResponse(res) {
+			return res, nil
+		}
+		// The range starts after the end of the file, eg. the first byte of an empty file.
+		if res.StatusCode == http.StatusRequestedRangeNotSatisfiable && len(rangeHeader) != 0 {
+			return res, nil
+		}
+
+		// Anything else is an HTML page telling why the file wasn't sent.
+		page, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case util.IsGDriveQuotaPage(page):
+			return nil, util.ErrGDriveQuotaExceeded
+		case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError:
+			return nil, &util.HTTPStatusError{StatusCode: res.StatusCode, Status: res.Status}
+		case res.StatusCode >= http.StatusBadRequest:
+			return nil, util.ErrGDriveNotPublic
+		}
+
+		// Private files redirect to the sign in page, which has no confirmation form.
+		next, ok := util.ParseGDriveConfirmPage(page, res.Request.URL)
+		if !ok || confirmed {
+			return nil, util.ErrGDriveNotPublic
+		}
+		link = next
+	}
+}
+
+// isGDriveFileResponse reports whether Drive sent the file itself rather than a page.
+func isGDriveFileResponse(res *http.Response) bool {
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+		return false
+	}
+
+	return strings.HasPrefix(res.Header.Get("Content-Disposition"), "attachment") ||
+		!strings.HasPrefix(res.Header.Get("Content-Type"), "text/html")
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+// newFakePublicDrive serves the public file `big` as `content` behind the virus scan warning, the file
+// `popular` is over its download quota and every other file is private. Requests of the file without
+// a range are counted in `full`.
+func newFakePublicDrive(t *testing.T, content string, ranges *atomic.Int32, full *atomic.Int32) *GDriveSource {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case r.URL.Path == "/uc" && q.Get("id") == "big":
+			assert.Equal(t, "download", q.Get("export"))
+			assert.Equal(t, "rk", q.Get("resourcekey"))
+			// Too large to be scanned for viruses.
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<form id="download-form" action="/download" method="get">
+				<input type="hidden" name="id" value="big">
+				<input type="hidden" name="resourcekey" value="rk">
+				<input type="hidden" name="confirm" value="t">
+			</form>`))
+		case r.URL.Path == "/download":
+			assert.Equal(t, "t", q.Get("confirm"))
+			if len(r.Header.Get("Range")) == 0 {
+				full.Add(1)
+			}
+			w.Header().Set("Content-Disposition", `attachment; filename="big.iso"`)
+			serveRange(w, r, "big.iso", content, ranges)
+		case r.URL.Path == "/uc" && q.Get("id") == "popular":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<p>Too many users have viewed or downloaded this file recently.</p>`))
+		default:
+			// Files which aren't public redirect to the sign in page.
+			if r.URL.Path != "/signin" {
+				http.Redirect(w, r, "/signin", http.StatusFound)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<html>Sign in</html>`))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	src := NewGDriveSource("")
+	src.publicURL = ts.URL + "/uc"
+
+	return src
+}
+
+func TestGDriveSource_Public(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var ranges, full atomic.Int32
+	src := newFakePublicDrive(t, content, &ranges, &full)
+	ctx := context.Background()
+
+	file, err := src.Resolve(ctx, "https://drive.google.com/file/d/big/view?usp=sharing&resourcekey=rk", types.SourceOptions{})
+	assert.NoError(t, err)
+	assert.False(t, file.IsFolder)
+	assert.Equal(t, "big?resourcekey=rk", file.ID)
+
+	stat, err := src.Stat(ctx, file.ID, types.SourceOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "big.iso", stat.Name)
+	assert.Equal(t, int64(len(content)), stat.Size)
+	// Only the first byte is requested to get the info.
+	assert.Equal(t, int32(1), ranges.Load())
+	assert.Zero(t, full.Load())
+
+	_, err = src.Stat(ctx, "popular", types.SourceOptions{})
+	assert.ErrorIs(t, err, util.ErrGDriveQuotaExceeded)
+	assert.False(t, util.IsTransientError(err))
+
+	_, err = src.Stat(ctx, "private", types.SourceOptions{})
+	assert.ErrorIs(t, err, util.ErrGDriveNotPublic)
+
+	// Folders can't be listed without the API.
+	_, err = src.ListFolder(ctx, &types.SourceFile{ID: "folder", IsFolder: true}, types.SourceOptions{})
+	assert.Error(t, err)
+}
+
+// resumePublicDrive serves `content` as a public Drive file without an account.
+func resumePublicDrive(t *testing.T, content string) (types.Source, string, types.SourceOptions, func(t *testing.T)) {
+	var ranges, full atomic.Int32
+
+	return newFakePublicDrive(t, content, &ranges, &full), "big?resourcekey=rk", types.SourceOptions{}, func(t *testing.T) {
+		// Two stats and the rest of the file, which is never requested as a whole.
+		assert.Equal(t, int32(3), ranges.Load())
+		assert.Zero(t, full.Load())
+	}
+}
+
+func TestGDriveSource_Export(t *testing.T) {
+	content := strings.Repeat("exported ", 1000)
+	var exports atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		switch r.URL.Path {
+		case "/files/doc":
+			w.Write([]byte(`{"id": "doc", "title": "Report", "mimeType": "application/vnd.google-apps.document"}`))
+		case "/files/notes":
+			w.Write([]byte(`{"id": "notes", "title": "Notes.MD", "mimeType": "application/vnd.google-apps.document"}`))
+		case "/files/form":
+			w.Write([]byte(`{"id": "form", "title": "Survey", "mimeType": "application/vnd.google-apps.form"}`))
+		case "/files/doc/export":
+			exports.Add(1)
+			assert.Equal(t, "application/vnd.openxmlformats-officedocument.wordprocessingml.document", r.URL.Query().Get("mimeType"))
+			// Exports are generated on the fly, they're sent without a length and ignore ranges.
+			assert.Empty(t, r.Header.Get("Range"))
+			w.Write([]byte(content[:len(content)/2]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(content[len(content)/2:]))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	src := NewGDriveSource("")
+	src.apiURL = ts.URL + "/"
+	opts := types.SourceOptions{
+		TokenSource:  oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}),
+		ExportFormat: setting.ExportOffice,
+	}
+	ctx := context.Background()
+
+	file, err := src.Stat(ctx, "doc", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "Report.docx", file.Name)
+	assert.Equal(t, int64(-1), file.Size)
+	assert.Empty(t, src.Checksum(file))
+
+	// The extension isn't added twice.
+	markdown := opts
+	markdown.ExportFormat = setting.ExportMarkdown
+	notes, err := src.Stat(ctx, "notes", markdown)
+	assert.NoError(t, err)
+	assert.Equal(t, "Notes.MD", notes.Name)
+
+	_, err = src.Stat(ctx, "form", opts)
+	assert.ErrorContains(t, err, "application/vnd.google-apps.form can't be exported")
+
+	// An export always starts from the beginning.
+	body, start, err := src.Open(ctx, file, 4000, opts)
+	assert.NoError(t, err)
+	b, err := io.ReadAll(body)
+	assert.NoError(t, err)
+	body.Close()
+	assert.Equal(t, int64(0), start)
+	assert.Equal(t, content, string(b))
+
+	dir := t.TempDir()
+	progChan := make(chan types.Progress)
+	last := drainProgress(progChan)
+	err = DownloadFromSource(src, DownloaderConfig{FileID: "doc", DestinationPath: dir, Options: opts}, progChan, ctx)
+	close(progChan)
+	assert.NoError(t, err)
+
+	prog := <-last
+	assert.True(t, prog.Complete)
+	assert.Equal(t, int64(len(content)), prog.Downloaded)
+	b, err = os.ReadFile(filepath.Join(dir, "Report.docx"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(b))
+	assert.Equal(t, int32(2), exports.Load())
+}
@@ -83,23 +83,33 @@ func CreateUserAndAccount(db *sql.DB, user *types.GoogleUserResponse, token *oau
 		return "", err
 	}
 
+	accessToken, refreshToken, err := encryptTokens(token)
+	if err != nil {
+		return "", err
+	}
+
 	// Query to create an user account with the received `userID` and the OAuth Tokens.
+	// The account the user signs in with is their primary account.
 	const accQuery = `
 		INSERT INTO google_accounts (
 			user_id, 
+			email,
+			name,
+			is_primary,
 			access_token, 
 			refresh_token,
 			token_type, 
 			expires_at,
 			updated_at
 		)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		VALUES ($1, $2, $2, TRUE, $3, $4, $5, $6, $7)
 	`
 	_, err = tx.Exec(
 		accQuery,
 		userID,
-		token.AccessToken,
-		token.RefreshToken,
+		user.Email,
+		accessToken,
+		refreshToken,
 		token.TokenType,
 		token.Expiry,
 		time.Now(),
@@ -123,6 +133,8 @@ func GetUserAndAccountByEmail(db *sql.DB, email string) (*types.User, *types.Goo
 		INNER JOIN
 		    google_accounts a
 		ON
+		    a.user_id = u.id AND a.is_primary
+		WHERE
 		    u.email = $1
 	`
 
@@ -136,34 +148,244 @@ func GetUserAndAccountByEmail(db *sql.DB, email string) (*types.User, *types.Goo
 		&u.UserID, &u.Email,
 		&acc.AccessToken, &acc.RefreshToken, &acc.TokenType, &acc.ExpiresAt, &acc.CreatedAt, &acc.UpdatedAt,
 	)
-	if err != nil && err != sql.ErrNoRows {
+	if err == sql.ErrNoRows {
+		return &u, &acc, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := decryptTokens(&acc.AccessToken, &acc.RefreshToken); err != nil {
 		return nil, nil, err
 	}
 
 	return &u, &acc, nil
 }
 
-// GetAccountByUserID gets the user's google account by `userID`.
+// Columns of the `google_accounts` table in the order they're scanned.
+const googleAccountColumns = `
+	id, user_id, email, name, is_primary, access_token, refresh_token, token_type, expires_at, created_at, updated_at
+`
+
+// GetAccountByUserID gets the user's primary google account by `userID`.
 func GetAccountByUserID(db *sql.DB, userID string) (*types.GoogleAccount, error) {
-	const query = `SELECT * FROM google_accounts WHERE user_id = $1`
+	query := `SELECT ` + googleAccountColumns + ` FROM google_accounts WHERE user_id = $1 AND is_primary`
 
 	var acc types.GoogleAccount
-	row := db.QueryRow(query, userID)
+	err := scanGoogleAccount(db.QueryRow(query, userID), &acc)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return &acc, nil
+}
+
+// GetGoogleAccounts gets every google account linked to the user, the primary account first.
+func GetGoogleAccounts(db *sql.DB, userID string) ([]*types.GoogleAccount, error) {
+	query := `
+		SELECT ` + googleAccountColumns + `
+		FROM
+			google_accounts
+		WHERE
+			user_id = $1
+		ORDER BY
+			is_primary DESC, created_at
+	`
+
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := make([]*types.GoogleAccount, 0)
+	for rows.Next() {
+		var acc types.GoogleAccount
+		if err := scanGoogleAccount(rows, &acc); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, &acc)
+	}
+
+	return accounts, rows.Err()
+}
+
+// GetGoogleAccountByID gets one of the user's google accounts, the `ID` is empty if there's none.
+func GetGoogleAccountByID(db *sql.DB, userID string, accountID string) (*types.GoogleAccount, error) {
+	query := `SELECT ` + googleAccountColumns + ` FROM google_accounts WHERE id = $1 AND user_id = $2`
+
+	var acc types.GoogleAccount
+	err := scanGoogleAccount(db.QueryRow(query, accountID, userID), &acc)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return &acc, nil
+}
+
+// LinkGoogleAccount links another google account to the user, it's named after its `email`,
+// see `util.FreeGoogleAccountName`. Linking the same account again only updates its tokens.
+// It returns the ID of the account.
+func LinkGoogleAccount(db *sql.DB, userID string, email string, token *oauth2.Token) (string, error) {
+	accessToken, refreshToken, err := encryptTokens(token)
+	if err != nil {
+		return "", err
+	}
+	// Another account might have been renamed to the email.
+	taken, err := getOtherGoogleAccountNames(db, userID, email)
+	if err != nil {
+		return "", err
+	}
+
+	const query = `
+		INSERT INTO google_accounts (
+			user_id,
+			email,
+			name,
+			access_token,
+			refresh_token,
+			token_type,
+			expires_at,
+			updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (user_id, email) DO UPDATE
+		SET
+			access_token = EXCLUDED.access_token,
+			refresh_token = EXCLUDED.refresh_token,
+			token_type = EXCLUDED.token_type,
+			expires_at = EXCLUDED.expires_at,
+			updated_at = EXCLUDED.updated_at
+		RETURNING id
+	`
+
+	var id string
+	err = db.QueryRow(
+		query,
+		userID,
+		email,
+		util.FreeGoogleAccountName(email, taken),
+		accessToken,
+		refreshToken,
+		token.TokenType,
+		token.Expiry,
+		time.Now(),
+	).Scan(&id)
+
+	return id, err
+}
+
+// getOtherGoogleAccountNames gets the names of the user's google accounts, except the one of `email`.
+func getOtherGoogleAccountNames(db *sql.DB, userID string, email string) ([]string, error) {
+	const query = `SELECT name FROM google_accounts WHERE user_id = $1 AND email <> $2`
+
+	rows, err := db.Query(query, userID, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// UpdateGoogleAccountTokens updates the tokens of one of the user's google accounts.
+func UpdateGoogleAccountTokens(db *sql.DB, accountID string, token *oauth2.Token) error {
+	accessToken, refreshToken, err := encryptTokens(token)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		UPDATE google_accounts
+		SET
+			access_token = $1,
+			refresh_token = $2,
+			token_type = $3,
+			expires_at = $4,
+			updated_at = $5
+		WHERE
+			id = $6
+	`
+	_, err = db.Exec(
+		query,
+		accessToken,
+		refreshToken,
+		token.TokenType,
+		token.Expiry,
+		time.Now(),
+		accountID,
+	)
+
+	return err
+}
+
+// RenameGoogleAccount changes the name of one of the user's google accounts.
+// It reports whether the account was found.
+func RenameGoogleAccount(db *sql.DB, userID string, accountID string, name string) (bool, error) {
+	const query = `
+		UPDATE google_accounts
+		SET
+			name = $1,
+			updated_at = $2
+		WHERE
+			id = $3 AND user_id = $4
+	`
+
+	res, err := db.Exec(query, name, time.Now(), accountID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// UnlinkGoogleAccount removes one of the user's google accounts, the primary
+// account can't be removed. It reports whether the account was removed.
+func UnlinkGoogleAccount(db *sql.DB, userID string, accountID string) (bool, error) {
+	const query = `
+		DELETE FROM google_accounts
+		WHERE
+			id = $1 AND user_id = $2 AND NOT is_primary
+	`
+
+	res, err := db.Exec(query, accountID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// scanGoogleAccount scans the `googleAccountColumns` and decrypts the tokens.
+func scanGoogleAccount(row interface{ Scan(...any) error }, acc *types.GoogleAccount) error {
 	err := row.Scan(
 		&acc.ID,
 		&acc.UserID,
+		&acc.Email,
+		&acc.Name,
+		&acc.IsPrimary,
 		&acc.AccessToken,
 		&acc.RefreshToken,
+		&acc.TokenType,
 		&acc.ExpiresAt,
 		&acc.CreatedAt,
 		&acc.UpdatedAt,
-		&acc.TokenType,
 	)
-	if err != nil && err != sql.ErrNoRows {
-		return nil, err
+	if err != nil {
+		return err
 	}
 
-	return &acc, nil
+	return decryptTokens(&acc.AccessToken, &acc.RefreshToken)
 }
 
 // GetUserByEmail gets the user by `email`.
@@ -202,8 +424,13 @@ func GetUserByID(db *sql.DB, userID string) (*types.User, error) {
 	return &u, nil
 }
 
-// Updates the google account by `userID`
+// Updates the primary google account by `userID`
 func UpdateAccountByUserID(db *sql.DB, userID string, acc *types.GoogleAccount) error {
+	accessToken, refreshToken, err := encryptTokens(acc.OAuthToken())
+	if err != nil {
+		return err
+	}
+
 	const query = `
 	    UPDATE google_accounts
 		SET
@@ -213,12 +440,12 @@ func UpdateAccountByUserID(db *sql.DB, userID string, acc *types.GoogleAccount)
 			expires_at = $4,
 			updated_at = $6
 		WHERE
-            user_id = $5
+            user_id = $5 AND is_primary
 	`
-	_, err := db.Exec(
+	_, err = db.Exec(
 		query,
-		acc.AccessToken,
-		acc.RefreshToken,
+		accessToken,
+		refreshToken,
 		acc.TokenType,
 		acc.ExpiresAt,
 		userID,
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func TestLinkGoogleAccount_NameTaken(t *testing.T) {
+	keys := useTestTokenKeys(t)
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Account A was renamed to the email of the account which is linked now.
+	mock.ExpectQuery("SELECT name FROM google_accounts").
+		WithArgs("user", "b@x.com").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a@x.com").AddRow("b@x.com"))
+	expiry := time.Now().Add(time.Hour)
+	mock.ExpectQuery("INSERT INTO google_accounts").
+		WithArgs("user", "b@x.com", "b@x.com (2)", encryptedArg{keys, "access"}, encryptedArg{keys, "refresh"}, "Bearer", expiry, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("2"))
+
+	id, err := LinkGoogleAccount(db, "user", "b@x.com", &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: expiry})
+	assert.NoError(t, err)
+	assert.Equal(t, "2", id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
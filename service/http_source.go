@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+)
+
+// `HTTPSource` downloads plain HTTP(S) links, the file ID is the URL itself. Unless a file name
+// is given, it's taken from the Content-Disposition header or the URL path. An interrupted
+// download continues with a range request as long as the ETag and size didn't change.
+type HTTPSource struct {
+	client *http.Client
+}
+
+func NewHTTPSource() *HTTPSource {
+	return &HTTPSource{client: util.NewHTTPClient(setting.MaxHTTPRedirects)}
+}
+
+// No login needed, custom headers and cookies are sent instead.
+func (s *HTTPSource) AuthProvider() setting.Provider {
+	return ""
+}
+
+func (s *HTTPSource) Match(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) != 0
+}
+
+// Resolve only parses the link, the file is looked up once its download starts,
+// so an unreachable server is retried like any other download error.
+func (s *HTTPSource) Resolve(ctx context.Context, link string, opts types.SourceOptions) (*types.SourceFile, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link %s", link)
+	}
+
+	return &types.SourceFile{ID: u.String()}, nil
+}
+
+func (s *HTTPSource) ListFolder(ctx context.Context, folder *types.SourceFile, opts types.SourceOptions) ([]types.SourceFile, error) {
+	return nil, fmt.Errorf("folders aren't supported for direct links")
+}
+
+// Stat gets the info of the file at the end of the redirects. Not every server supports HEAD,
+// so a client error only leaves the info empty and the download itself tells if the link is fine.
+func (s *HTTPSource) Stat(ctx context.Context, fileID string, opts types.SourceOptions) (*types.SourceFile, error) {
+	req, err := newHTTPRequest(ctx, http.MethodHead, fileID, opts.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError {
+		return nil, &util.HTTPStatusError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		return &types.SourceFile{
+			ID:   fileID,
+			Name: util.GetHTTPFileName(make(http.Header), req.URL),
+			Size: -1,
+		}, nil
+	}
+
+	return &types.SourceFile{
+		ID:       fileID,
+		Name:     util.GetHTTPFileName(res.Header, res.Request.URL),
+		MimeType: res.Header.Get("Content-Type"),
+		Size:     res.ContentLength,
+		ETag:     res.Header.Get("ETag"),
+	}, nil
+}
+
+// Open requests the file from `offset`. The range only applies if the ETag still matches,
+// otherwise the server sends the whole file.
+func (s *HTTPSource) Open(ctx context.Context, file *types.SourceFile, offset int64, opts types.SourceOptions) (io.ReadCloser, int64, error) {
+	return util.OpenRange(s.client, offset, func(offset int64) (*http.Request, error) {
+		req, err := newHTTPRequest(ctx, http.MethodGet, file.ID, opts.Headers)
+		if err != nil {
+			return nil, err
+		}
+		util.SetRangeHeader(req, offset, file.ETag)
+
+		return req, nil
+	}, nil)
+}
+
+// Checksum isn't known for direct links.
+func (s *HTTPSource) Checksum(file *types.SourceFile) types.Checksum {
+	return types.Checksum{}
+}
+
+func newHTTPRequest(ctx context.Context, method string, link string, headers map[string]string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	return req, nil
+}
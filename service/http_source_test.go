@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// resumeHTTP serves `content` behind a redirect, it expects the headers and cookies of the download.
+func resumeHTTP(t *testing.T, content string) (types.Source, string, types.SourceOptions, func(t *testing.T)) {
+	var ranges atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/files/data.bin", http.StatusFound)
+			return
+		}
+		assert.Equal(t, "secret", r.Header.Get("X-Token"))
+		assert.Equal(t, "a=1", r.Header.Get("Cookie"))
+
+		if len(r.Header.Get("Range")) != 0 {
+			assert.Equal(t, `"v1"`, r.Header.Get("If-Range"))
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Disposition", `attachment; filename="report.bin"`)
+		serveRange(w, r, "data.bin", content, &ranges)
+	}))
+	t.Cleanup(srv.Close)
+
+	opts := types.SourceOptions{
+		Headers: util.MergeCookies(map[string]string{"x-token": "secret"}, map[string]string{"a": "1"}),
+	}
+	return NewHTTPSource(), srv.URL + "/redirect", opts, func(t *testing.T) {
+		assert.Equal(t, int32(1), ranges.Load())
+	}
+}
+
+func TestHTTPSource_ChangedFile(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var ranges atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+		serveRange(w, r, "data.bin", content, &ranges)
+	}))
+	defer srv.Close()
+
+	// The partial data belongs to the old version, the server ignores the range for the new one.
+	body, start, err := NewHTTPSource().Open(context.Background(), &types.SourceFile{ID: srv.URL + "/data.bin", ETag: `"v1"`}, 4000, types.SourceOptions{})
+	assert.NoError(t, err)
+	defer body.Close()
+	assert.Equal(t, int64(0), start)
+	b, err := io.ReadAll(body)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(b))
+	assert.Equal(t, int32(1), ranges.Load())
+}
+
+func TestDownloadFromSource_SameDestination(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var gets atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first download holds the file until the second one was rejected.
+		if r.Method == http.MethodGet && gets.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	download := func() error {
+		progChan := make(chan types.Progress)
+		drainProgress(progChan)
+		defer close(progChan)
+
+		return DownloadFromSource(NewHTTPSource(), DownloaderConfig{FileID: srv.URL + "/data.bin", DestinationPath: dir}, progChan, context.Background())
+	}
+
+	first := make(chan error, 1)
+	go func() {
+		first <- download()
+	}()
+	<-started
+
+	err := download()
+	assert.ErrorIs(t, err, util.ErrDestinationInUse)
+	assert.False(t, util.IsTransientError(err))
+
+	close(release)
+	assert.NoError(t, <-first)
+	assert.Equal(t, int32(1), gets.Load())
+
+	b, err := os.ReadFile(filepath.Join(dir, "data.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(b))
+
+	// The file can be downloaded again once the first download is done.
+	assert.NoError(t, download())
+}
+
+func TestHTTPSource_Errors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/busy":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	download := func(path string) error {
+		progChan := make(chan types.Progress)
+		drainProgress(progChan)
+		defer close(progChan)
+
+		return DownloadFromSource(NewHTTPSource(), DownloaderConfig{FileID: srv.URL + path, DestinationPath: t.TempDir()}, progChan, context.Background())
+	}
+
+	err := download("/loop")
+	assert.ErrorContains(t, err, "redirects")
+
+	err = download("/busy")
+	assert.True(t, util.IsTransientError(err), fmt.Sprint(err))
+
+	err = download("/missing")
+	assert.Error(t, err)
+	assert.False(t, util.IsTransientError(err))
+}
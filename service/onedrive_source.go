@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+)
+
+const graphAPIURL = "https://graph.microsoft.com/v1.0"
+
+// `OneDriveSource` downloads OneDrive and SharePoint sharing links through the `/shares` API of
+// Microsoft Graph, with the access token of the Microsoft provider. The ID of a file is its sharing
+// link, a file inside a shared folder has its item ID appended after a `#`.
+type OneDriveSource struct {
+	client *http.Client
+	apiURL string
+}
+
+func NewOneDriveSource() *OneDriveSource {
+	return &OneDriveSource{
+		client: util.NewHTTPClient(setting.MaxHTTPRedirects),
+		apiURL: graphAPIURL,
+	}
+}
+
+func (s *OneDriveSource) AuthProvider() setting.Provider {
+	return setting.MicrosoftProvider
+}
+
+func (s *OneDriveSource) Match(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	return host == "1drv.ms" || host == "onedrive.live.com" || strings.HasSuffix(host, ".sharepoint.com")
+}
+
+// Resolve gets the shared item of the link, which is a file or a folder.
+func (s *OneDriveSource) Resolve(ctx context.Context, link string, opts types.SourceOptions) (*types.SourceFile, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link %s", link)
+	}
+	// The fragment is used for the items inside shared folders.
+	u.Fragment = ""
+
+	item, err := s.getItem(ctx, u.String(), "", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return toOneDriveFile(u.String(), item), nil
+}
+
+// ListFolder walks the shared folder recursively, every file keeps its path relative to the shared folder.
+func (s *OneDriveSource) ListFolder(ctx context.Context, folder *types.SourceFile, opts types.SourceOptions) ([]types.SourceFile, error) {
+	link, itemID := splitSharedID(folder.ID)
+	files := make([]types.SourceFile, 0)
+
+	var walk func(dir string, itemID string) error
+	walk = func(dir string, itemID string) error {
+		children, err := s.listChildren(ctx, link, itemID, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			switch {
+			case child.Folder != nil:
+				if err := walk(path.Join(dir, util.SanitizeFileName(child.Name)), child.ID); err != nil {
+					return err
+				}
+			case child.File != nil:
+				file := toOneDriveFile(link+"#"+child.ID, &child)
+				file.Dir = dir
+				files = append(files, *file)
+			}
+		}
+
+		return nil
+	}
+
+	if err := walk(util.SanitizeFileName(folder.Name), itemID); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (s *OneDriveSource) Stat(ctx context.Context, fileID string, opts types.SourceOptions) (*types.SourceFile, error) {
+	link, itemID := splitSharedID(fileID)
+
+	item, err := s.getItem(ctx, link, itemID, opts)
+	if err != nil {
+		return nil, err
+	}
+	if item.File == nil {
+		return nil, fmt.Errorf("expected file, received a folder")
+	}
+
+	return toOneDriveFile(fileID, item), nil
+}
+
+// Open streams the file from `offset` with a range request, Graph redirects
+// to a pre-authenticated download URL which keeps the range.
+func (s *OneDriveSource) Open(ctx context.Context, file *types.SourceFile, offset int64, opts types.SourceOptions) (io.ReadCloser, int64, error) {
+	link, itemID := splitSharedID(file.ID)
+
+	return util.OpenRange(s.client, offset, func(offset int64) (*http.Request, error) {
+		req, err := s.newRequest(ctx, s.itemURL(link, itemID)+"/content", opts)
+		if err != nil {
+			return nil, err
+		}
+		util.SetRangeHeader(req, offset, "")
+
+		return req, nil
+	}, graphError)
+}
+
+// Checksum returns the SHA-256 or SHA-1 hash reported by Graph, SharePoint
+// files often only have a QuickXorHash which isn't supported.
+func (s *OneDriveSource) Checksum(file *types.SourceFile) types.Checksum {
+	switch len(file.Hash) {
+	case 64:
+		return types.Checksum{Algorithm: "sha256", Value: file.Hash}
+	case 40:
+		return types.Checksum{Algorithm: "sha1", Value: file.Hash}
+	default:
+		return types.Checksum{}
+	}
+}
+
+func (s *OneDriveSource) getItem(ctx context.Context, link string, itemID string, opts types.SourceOptions) (*types.GraphDriveItem, error) {
+	var item types.GraphDriveItem
+	if err := s.call(ctx, s.itemURL(link, itemID), &item, opts); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// listChildren returns all the items directly inside a folder, following every page.
+func (s *OneDriveSource) listChildren(ctx context.Context, link string, itemID string, opts types.SourceOptions) ([]types.GraphDriveItem, error) {
+	children := make([]types.GraphDriveItem, 0)
+
+	next := s.itemURL(link, itemID) + "/children"
+	for len(next) != 0 {
+		var page types.GraphDriveItemPage
+		if err := s.call(ctx, next, &page, opts); err != nil {
+			return nil, err
+		}
+		children = append(children, page.Value...)
+		next = page.NextLink
+	}
+
+	return children, nil
+}
+
+// itemURL returns the URL of the shared item, or of an item inside it.
+func (s *OneDriveSource) itemURL(link string, itemID string) string {
+	// Graph takes the sharing link as an unpadded base64url string prefixed with `u!`.
+	shareID := "u!" + base64.RawURLEncoding.EncodeToString([]byte(link))
+	if len(itemID) == 0 {
+		return s.apiURL + "/shares/" + shareID + "/driveItem"
+	}
+
+	return s.apiURL + "/shares/" + shareID + "/items/" + url.PathEscape(itemID)
+}
+
+func (s *OneDriveSource) call(ctx context.Context, endpoint string, target any, opts types.SourceOptions) error {
+	req, err := s.newRequest(ctx, endpoint, opts)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return graphError(res)
+	}
+
+	return util.DecodeJSON(res.Body, target)
+}
+
+func (s *OneDriveSource) newRequest(ctx context.Context, endpoint string, opts types.SourceOptions) (*http.Request, error) {
+	if opts.TokenSource == nil {
+		return nil, fmt.Errorf("invalid access token")
+	}
+	token, err := opts.TokenSource.Token()
+	if err != nil {
+		return nil, fmt.Errorf("invalid access token: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	token.SetAuthHeader(req)
+
+	return req, nil
+}
+
+// toOneDriveFile converts a drive item into a file with `fileID`.
+func toOneDriveFile(fileID string, item *types.GraphDriveItem) *types.SourceFile {
+	file := &types.SourceFile{
+		ID:       fileID,
+		Name:     item.Name,
+		Size:     item.Size,
+		IsFolder: item.Folder != nil,
+		ETag:     item.CTag,
+	}
+	if item.File != nil {
+		file.MimeType = item.File.MimeType
+		// Graph reports the hashes in upper case.
+		file.Hash = strings.ToLower(item.File.Hashes.SHA256Hash)
+		if len(file.Hash) == 0 {
+			file.Hash = strings.ToLower(item.File.Hashes.SHA1Hash)
+		}
+	}
+
+	return file
+}
+
+// graphError turns a failed response into an error. Rate limits and server errors are
+// `HTTPStatusError`s, so they're retried, the rest can't succeed by trying again.
+func graphError(res *http.Response) error {
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError {
+		return &util.HTTPStatusError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+
+	var errRes types.GraphErrorResponse
+	if err := util.DecodeJSON(res.Body, &errRes); err != nil || len(errRes.Error.Code) == 0 {
+		return fmt.Errorf("graph request failed: %s", res.Status)
+	}
+
+	return fmt.Errorf("graph request failed: %s: %s", errRes.Error.Code, errRes.Error.Message)
+}
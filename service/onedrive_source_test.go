@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+const testOneDriveLink = "https://contoso-my.sharepoint.com/:f:/g/personal/abc/EaBc?e=xyz"
+
+// newFakeGraph serves a shared folder with `a.txt` and `sub/b.bin`, the children
+// of the shared folder are split into two pages.
+func newFakeGraph(t *testing.T, content string, ranges *atomic.Int32) *OneDriveSource {
+	sum := sha1.Sum([]byte(content))
+	file := func(id string, name string, size int64, hash string) types.GraphDriveItem {
+		item := types.GraphDriveItem{ID: id, Name: name, Size: size, CTag: "c" + id, File: &types.GraphFileFacet{}}
+		item.File.Hashes.SHA1Hash = hash
+		return item
+	}
+	folder := func(id string, name string) types.GraphDriveItem {
+		return types.GraphDriveItem{ID: id, Name: name, Folder: &types.GraphFolderFacet{ChildCount: 1}}
+	}
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Pre-authenticated download URL.
+		if r.URL.Path == "/download/b.bin" {
+			assert.Empty(t, r.Header.Get("Authorization"))
+			serveRange(w, r, "", content, ranges)
+			return
+		}
+
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		shareID := "u!" + base64.RawURLEncoding.EncodeToString([]byte(testOneDriveLink))
+		prefix := "/shares/" + shareID
+
+		switch r.URL.Path {
+		case prefix + "/driveItem":
+			json.NewEncoder(w).Encode(folder("root", "Shared"))
+		case prefix + "/driveItem/children":
+			if r.URL.Query().Get("page") != "2" {
+				json.NewEncoder(w).Encode(types.GraphDriveItemPage{
+					Value:    []types.GraphDriveItem{file("a", "a.txt", 5, "")},
+					NextLink: srv.URL + prefix + "/driveItem/children?page=2",
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(types.GraphDriveItemPage{Value: []types.GraphDriveItem{folder("sub", "sub")}})
+		case prefix + "/items/sub/children":
+			json.NewEncoder(w).Encode(types.GraphDriveItemPage{
+				Value: []types.GraphDriveItem{file("b", "b.bin", int64(len(content)), strings.ToUpper(hex.EncodeToString(sum[:])))},
+			})
+		case prefix + "/items/b":
+			json.NewEncoder(w).Encode(file("b", "b.bin", int64(len(content)), strings.ToUpper(hex.EncodeToString(sum[:]))))
+		case prefix + "/items/b/content":
+			// Downloads are redirected to a different host in reality, so the token isn't sent along.
+			http.Redirect(w, r, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)+"/download/b.bin", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": "itemNotFound", "message": "The resource could not be found."}}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return &OneDriveSource{client: srv.Client(), apiURL: srv.URL}
+}
+
+func TestOneDriveSource_Match(t *testing.T) {
+	src := NewOneDriveSource()
+	assert.True(t, src.Match("https://1drv.ms/f/s!AbCdEf"))
+	assert.True(t, src.Match("https://onedrive.live.com/?cid=abc&id=def"))
+	assert.True(t, src.Match(testOneDriveLink))
+	assert.False(t, src.Match("https://sharepoint.com.example.com/file"))
+	assert.False(t, src.Match("https://example.com/file"))
+}
+
+func TestOneDriveSource_DownloadFolder(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	var ranges atomic.Int32
+	src := newFakeGraph(t, content, &ranges)
+	opts := types.SourceOptions{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})}
+
+	folder, err := src.Resolve(context.Background(), testOneDriveLink, opts)
+	assert.NoError(t, err)
+	assert.True(t, folder.IsFolder)
+
+	files, err := src.ListFolder(context.Background(), folder, opts)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.Equal(t, testOneDriveLink+"#a", files[0].ID)
+	assert.Equal(t, "Shared", files[0].Dir)
+	assert.Equal(t, testOneDriveLink+"#b", files[1].ID)
+	assert.Equal(t, "Shared/sub", files[1].Dir)
+	assert.Equal(t, "sha1", src.Checksum(&files[1]).Algorithm)
+	assert.Zero(t, ranges.Load())
+}
+
+// resumeOneDrive serves `content` as a file of a shared folder, its SHA-1 is verified.
+func resumeOneDrive(t *testing.T, content string) (types.Source, string, types.SourceOptions, func(t *testing.T)) {
+	var ranges atomic.Int32
+	opts := types.SourceOptions{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})}
+
+	return newFakeGraph(t, content, &ranges), testOneDriveLink + "#b", opts, func(t *testing.T) {
+		assert.Equal(t, int32(1), ranges.Load())
+	}
+}
+
+func TestOneDriveSource_Errors(t *testing.T) {
+	var ranges atomic.Int32
+	src := newFakeGraph(t, "", &ranges)
+
+	_, err := src.Stat(context.Background(), testOneDriveLink+"#missing", types.SourceOptions{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})})
+	assertPermanentError(t, err, "itemNotFound")
+
+	_, err = src.Stat(context.Background(), testOneDriveLink, types.SourceOptions{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})})
+	assert.ErrorContains(t, err, "expected file")
+}
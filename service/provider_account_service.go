@@ -0,0 +1,124 @@
+package service
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"golang.org/x/oauth2"
+)
+
+// CreateOrUpdateProviderAccount connects the account of `provider` to the user,
+// a user who connects again gets the new account and tokens.
+func CreateOrUpdateProviderAccount(db *sql.DB, userID string, provider setting.Provider, accountID string, token *oauth2.Token) error {
+	accessToken, refreshToken, err := encryptTokens(token)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		INSERT INTO provider_accounts (
+			user_id,
+			provider,
+			account_id,
+			access_token,
+			refresh_token,
+			token_type,
+			expires_at,
+			updated_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (user_id, provider) DO UPDATE
+		SET
+			account_id = EXCLUDED.account_id,
+			access_token = EXCLUDED.access_token,
+			refresh_token = EXCLUDED.refresh_token,
+			token_type = EXCLUDED.token_type,
+			expires_at = EXCLUDED.expires_at,
+			updated_at = EXCLUDED.updated_at
+	`
+	_, err = db.Exec(
+		query,
+		userID,
+		provider,
+		accountID,
+		accessToken,
+		refreshToken,
+		token.TokenType,
+		token.Expiry,
+		time.Now(),
+	)
+
+	return err
+}
+
+// GetProviderAccount gets the user's account of `provider`, the `ID` is empty if there's none.
+func GetProviderAccount(db *sql.DB, userID string, provider setting.Provider) (*types.ProviderAccount, error) {
+	const query = `
+		SELECT
+			id, user_id, provider, account_id, access_token, refresh_token, token_type, expires_at, created_at, updated_at
+		FROM
+			provider_accounts
+		WHERE
+			user_id = $1 AND provider = $2
+	`
+
+	var acc types.ProviderAccount
+	row := db.QueryRow(query, userID, provider)
+	err := row.Scan(
+		&acc.ID,
+		&acc.UserID,
+		&acc.Provider,
+		&acc.AccountID,
+		&acc.AccessToken,
+		&acc.RefreshToken,
+		&acc.TokenType,
+		&acc.ExpiresAt,
+		&acc.CreatedAt,
+		&acc.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return &acc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := decryptTokens(&acc.AccessToken, &acc.RefreshToken); err != nil {
+		return nil, err
+	}
+
+	return &acc, nil
+}
+
+// UpdateProviderAccountTokens updates the tokens of the user's account of `provider`.
+func UpdateProviderAccountTokens(db *sql.DB, userID string, provider setting.Provider, token *oauth2.Token) error {
+	accessToken, refreshToken, err := encryptTokens(token)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+		UPDATE provider_accounts
+		SET
+			access_token = $1,
+			refresh_token = $2,
+			token_type = $3,
+			expires_at = $4,
+			updated_at = $5
+		WHERE
+			user_id = $6 AND provider = $7
+	`
+	_, err = db.Exec(
+		query,
+		accessToken,
+		refreshToken,
+		token.TokenType,
+		token.Expiry,
+		time.Now(),
+		userID,
+		provider,
+	)
+
+	return err
+}
@@ -0,0 +1,345 @@
+package service
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+)
+
+// An ETag which is the MD5 of the object, multipart uploads have a `-` and the number of parts appended.
+var s3MD5ETag = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// `S3Source` downloads objects of S3-compatible storages, like AWS, MinIO or Backblaze B2,
+// with the credentials of the endpoints in the config. The ID of an object is its `s3://` link,
+// a link ending with `/` or not pointing to an object is a prefix, which is downloaded like a folder.
+type S3Source struct {
+	client    *http.Client
+	endpoints map[string]types.S3Endpoint
+	partSize  int64
+	now       func() time.Time
+}
+
+func NewS3Source(endpoints map[string]types.S3Endpoint) *S3Source {
+	return &S3Source{
+		client:    util.NewHTTPClient(setting.MaxHTTPRedirects),
+		endpoints: endpoints,
+		partSize:  setting.S3PartSize,
+		now:       time.Now,
+	}
+}
+
+// The credentials are taken from the config, no login needed.
+func (s *S3Source) AuthProvider() setting.Provider {
+	return ""
+}
+
+func (s *S3Source) Match(link string) bool {
+	_, err := util.ParseS3Link(link)
+	return err == nil
+}
+
+func (s *S3Source) Resolve(ctx context.Context, link string, opts types.SourceOptions) (*types.SourceFile, error) {
+	loc, err := util.ParseS3Link(link)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.endpoints[loc.Endpoint]; !ok {
+		return nil, fmt.Errorf("unknown s3 endpoint %s", loc.Endpoint)
+	}
+
+	if len(loc.Key) != 0 && !strings.HasSuffix(loc.Key, "/") {
+		file, err := s.Stat(ctx, loc.String(), opts)
+		if err == nil {
+			return file, nil
+		}
+		var statusErr *util.HTTPStatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+			return nil, err
+		}
+		// There's no such object, so it's a prefix.
+		loc.Key += "/"
+	}
+
+	return &types.SourceFile{
+		ID:       loc.String(),
+		Name:     path.Base(strings.TrimSuffix(loc.Key, "/")),
+		IsFolder: true,
+	}, nil
+}
+
+// ListFolder lists every object under the prefix, the keys after the prefix become the folders.
+func (s *S3Source) ListFolder(ctx context.Context, folder *types.SourceFile, opts types.SourceOptions) ([]types.SourceFile, error) {
+	loc, err := util.ParseS3Link(folder.ID)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, ok := s.endpoints[loc.Endpoint]
+	if !ok {
+		return nil, fmt.Errorf("unknown s3 endpoint %s", loc.Endpoint)
+	}
+
+	// The folder is named after the last part of the prefix, or the bucket.
+	base := loc.Bucket
+	if len(loc.Key) != 0 {
+		base = path.Base(strings.TrimSuffix(loc.Key, "/"))
+	}
+
+	files := make([]types.SourceFile, 0)
+	var token string
+	for {
+		u, err := util.S3ObjectURL(endpoint, loc.Bucket, "")
+		if err != nil {
+			return nil, err
+		}
+		query := url.Values{"list-type": {"2"}, "prefix": {loc.Key}}
+		if len(token) != 0 {
+			query.Set("continuation-token", token)
+		}
+		// Spaces are encoded as `%20`, like in the signature.
+		u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+		res, err := s.do(ctx, http.MethodGet, u, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page types.S3ListBucketResult
+		err = xml.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode the object list: %v", err)
+		}
+
+		for _, obj := range page.Contents {
+			name := path.Base(obj.Key)
+			// Folder markers and keys which can't be a file name are skipped.
+			if strings.HasSuffix(obj.Key, "/") || name == "." || name == ".." {
+				continue
+			}
+
+			rel := strings.TrimPrefix(obj.Key, loc.Key)
+			files = append(files, types.SourceFile{
+				ID:   util.S3Location{Endpoint: loc.Endpoint, Bucket: loc.Bucket, Key: obj.Key}.String(),
+				Dir:  util.SanitizeDir(base, path.Dir(rel)),
+				Name: name,
+				Size: obj.Size,
+				ETag: obj.ETag,
+				Hash: s3ETagMD5(obj.ETag),
+			})
+		}
+
+		if !page.IsTruncated || len(page.NextContinuationToken) == 0 {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+
+	return files, nil
+}
+
+func (s *S3Source) Stat(ctx context.Context, fileID string, opts types.SourceOptions) (*types.SourceFile, error) {
+	loc, endpoint, u, err := s.objectURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(ctx, http.MethodHead, u, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	etag := res.Header.Get("ETag")
+	return &types.SourceFile{
+		ID:       fileID,
+		Name:     path.Base(loc.Key),
+		MimeType: res.Header.Get("Content-Type"),
+		Size:     res.ContentLength,
+		ETag:     etag,
+		Hash:     s3ETagMD5(etag),
+	}, nil
+}
+
+// Open reads the object from `offset` with a ranged GET per part of `S3PartSize`,
+// so a dropped connection only loses the current part.
+func (s *S3Source) Open(ctx context.Context, file *types.SourceFile, offset int64, opts types.SourceOptions) (io.ReadCloser, int64, error) {
+	_, endpoint, u, err := s.objectURL(file.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r := &s3PartReader{
+		ctx:      ctx,
+		src:      s,
+		u:        u,
+		endpoint: endpoint,
+		etag:     file.ETag,
+		size:     file.Size,
+		offset:   offset,
+	}
+	// Requesting the first part right away, so errors like a missing permission show up here.
+	if err := r.nextPart(); err != nil {
+		return nil, 0, err
+	}
+
+	return r, offset, nil
+}
+
+// Checksum returns the MD5 of the object if its ETag is one, which isn't the case
+// for multipart uploads and objects encrypted with a customer key.
+func (s *S3Source) Checksum(file *types.SourceFile) types.Checksum {
+	if len(file.Hash) == 0 {
+		return types.Checksum{}
+	}
+
+	return types.Checksum{Algorithm: "md5", Value: file.Hash}
+}
+
+func (s *S3Source) objectURL(fileID string) (util.S3Location, types.S3Endpoint, *url.URL, error) {
+	loc, err := util.ParseS3Link(fileID)
+	if err != nil {
+		return loc, types.S3Endpoint{}, nil, err
+	}
+	endpoint, ok := s.endpoints[loc.Endpoint]
+	if !ok {
+		return loc, endpoint, nil, fmt.Errorf("unknown s3 endpoint %s", loc.Endpoint)
+	}
+
+	u, err := util.S3ObjectURL(endpoint, loc.Bucket, loc.Key)
+	return loc, endpoint, u, err
+}
+
+// do sends a signed request, a failed response is returned as an error.
+func (s *S3Source) do(ctx context.Context, method string, u *url.URL, endpoint types.S3Endpoint, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// The path is sent encoded the same way as it's signed.
+	req.URL.RawPath = util.AWSURIEncode(req.URL.Path, false)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	util.SignAWSRequestV4(req, endpoint.AccessKey, endpoint.SecretKey, endpoint.Region, "s3", s.now())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+		defer res.Body.Close()
+		return nil, s3Error(res)
+	}
+
+	return res, nil
+}
+
+// `s3PartReader` streams an object part by part, every part has to belong to the same version of the object.
+type s3PartReader struct {
+	ctx      context.Context
+	src      *S3Source
+	u        *url.URL
+	endpoint types.S3Endpoint
+	etag     string
+	size     int64
+	// Offset of the next byte to read.
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *s3PartReader) Read(p []byte) (int, error) {
+	for {
+		if r.body == nil {
+			if r.size > 0 && r.offset >= r.size {
+				return 0, io.EOF
+			}
+			if err := r.nextPart(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if err == io.EOF {
+			r.body.Close()
+			r.body = nil
+			// The size isn't known, so the whole rest was requested at once.
+			if r.size <= 0 {
+				return n, io.EOF
+			}
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+
+		return n, err
+	}
+}
+
+func (r *s3PartReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	return r.body.Close()
+}
+
+// nextPart requests the part starting at `offset`.
+func (r *s3PartReader) nextPart() error {
+	rangeHeader := fmt.Sprintf("bytes=%d-", r.offset)
+	if r.size > 0 {
+		rangeHeader = fmt.Sprintf("bytes=%d-%d", r.offset, min(r.offset+r.src.partSize, r.size)-1)
+	}
+	header := http.Header{"Range": {rangeHeader}}
+	if len(r.etag) != 0 {
+		header.Set("If-Match", r.etag)
+	}
+
+	res, err := r.src.do(r.ctx, http.MethodGet, r.u, r.endpoint, header)
+	if err != nil {
+		return err
+	}
+	// Without a range the data would be written at the wrong offset.
+	if res.StatusCode != http.StatusPartialContent && r.offset > 0 {
+		res.Body.Close()
+		return fmt.Errorf("range requests aren't supported by the s3 endpoint")
+	}
+	r.body = res.Body
+
+	return nil
+}
+
+// s3Error turns a failed response into an error. Every status is returned as an `HTTPStatusError`,
+// so rate limits and server errors are retried, the error code of the body is added if there's one.
+func s3Error(res *http.Response) error {
+	statusErr := &util.HTTPStatusError{StatusCode: res.StatusCode, Status: res.Status}
+
+	var errRes types.S3ErrorResponse
+	if err := xml.NewDecoder(res.Body).Decode(&errRes); err != nil || len(errRes.Code) == 0 {
+		return statusErr
+	}
+
+	return fmt.Errorf("s3 request failed: %s: %s: %w", errRes.Code, errRes.Message, statusErr)
+}
+
+// s3ETagMD5 returns the MD5 of the object if `etag` is one.
+func s3ETagMD5(etag string) string {
+	etag = strings.ToLower(strings.Trim(etag, `"`))
+	if !s3MD5ETag.MatchString(etag) {
+		return ""
+	}
+
+	return etag
+}
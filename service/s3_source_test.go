@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeS3 serves the bucket `datasets` path-style, the object list is split into pages of two keys.
+func newFakeS3(t *testing.T, objects map[string]string, keys []string, ranges *atomic.Int32) *S3Source {
+	etag := func(content string) string {
+		sum := md5.Sum([]byte(content))
+		return `"` + hex.EncodeToString(sum[:]) + `"`
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/"))
+
+		if r.URL.Path == "/datasets/" {
+			start, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+			end := min(start+2, len(keys))
+
+			var sb strings.Builder
+			sb.WriteString("<ListBucketResult>")
+			for _, key := range keys[start:end] {
+				fmt.Fprintf(&sb, "<Contents><Key>%s</Key><Size>%d</Size><ETag>%s</ETag></Contents>", key, len(objects[key]), etag(objects[key]))
+			}
+			if end < len(keys) {
+				fmt.Fprintf(&sb, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", end)
+			}
+			sb.WriteString("</ListBucketResult>")
+			w.Write([]byte(sb.String()))
+			return
+		}
+
+		content, ok := objects[strings.TrimPrefix(r.URL.Path, "/datasets/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method != http.MethodHead {
+				w.Write([]byte("<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>"))
+			}
+			return
+		}
+		w.Header().Set("ETag", etag(content))
+		serveRange(w, r, "", content, ranges)
+	}))
+	t.Cleanup(srv.Close)
+
+	src := NewS3Source(map[string]types.S3Endpoint{
+		"default": {URL: srv.URL, Region: "us-east-1", AccessKey: "access", SecretKey: "secret", PathStyle: true},
+	})
+	src.client = srv.Client()
+	src.partSize = 3000
+
+	return src
+}
+
+func TestS3Source_Match(t *testing.T) {
+	src := NewS3Source(nil)
+	assert.True(t, src.Match("s3://datasets/2024/"))
+	assert.True(t, src.Match("s3://minio@datasets/a.csv"))
+	assert.False(t, src.Match("s3:///a.csv"))
+	assert.False(t, src.Match("https://datasets.s3.amazonaws.com/a.csv"))
+}
+
+func TestS3Source_DownloadPrefix(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	objects := map[string]string{
+		"data/a.txt":       "hello",
+		"data/sub/":        "",
+		"data/sub/b.bin":   content,
+		"data/../evil.txt": "evil",
+	}
+	var ranges atomic.Int32
+	src := newFakeS3(t, objects, []string{"data/a.txt", "data/sub/", "data/sub/b.bin", "data/../evil.txt"}, &ranges)
+
+	folder, err := src.Resolve(context.Background(), "s3://datasets/data", types.SourceOptions{})
+	assert.NoError(t, err)
+	assert.True(t, folder.IsFolder)
+	assert.Equal(t, "s3://datasets/data/", folder.ID)
+
+	files, err := src.ListFolder(context.Background(), folder, types.SourceOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+	assert.Equal(t, "data", files[0].Dir)
+	assert.Equal(t, "s3://datasets/data/sub/b.bin", files[1].ID)
+	assert.Equal(t, "data/sub", files[1].Dir)
+	assert.Equal(t, "md5", src.Checksum(&files[1]).Algorithm)
+	assert.Equal(t, "data", files[2].Dir)
+	assert.Equal(t, "evil.txt", files[2].Name)
+	assert.Zero(t, ranges.Load())
+}
+
+// resumeS3 serves `content` as an object, its MD5 ETag is verified.
+func resumeS3(t *testing.T, content string) (types.Source, string, types.SourceOptions, func(t *testing.T)) {
+	var ranges atomic.Int32
+	src := newFakeS3(t, map[string]string{"data/b.bin": content}, []string{"data/b.bin"}, &ranges)
+
+	return src, "s3://datasets/data/b.bin", types.SourceOptions{}, func(t *testing.T) {
+		// The remaining 6000 bytes are fetched in parts of 3000.
+		assert.Equal(t, int32(2), ranges.Load())
+	}
+}
+
+func TestS3Source_SignedRequests(t *testing.T) {
+	const key = "reports/q1 2024+résumé.csv"
+	content := strings.Repeat("0123456789", 1000)
+	now := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	var ranges atomic.Int32
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The request is signed again from what the server received, the signature only
+		// matches if the path, range and x-amz headers arrived as they were signed.
+		resigned, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+		assert.NoError(t, err)
+		for name, values := range r.Header {
+			if name == "Range" || strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+				resigned.Header[name] = values
+			}
+		}
+		util.SignAWSRequestV4(resigned, "access", "secret", "eu-central-1", "s3", now)
+		assert.Equal(t, resigned.Header.Get("Authorization"), r.Header.Get("Authorization"))
+		requests = append(requests, r)
+
+		// S3 checks the signature against the path as it was sent, so it has to be encoded the same way.
+		assert.Equal(t, "/datasets/reports/q1%202024%2Br%C3%A9sum%C3%A9.csv", r.URL.EscapedPath())
+		serveRange(w, r, "", content, &ranges)
+	}))
+	defer srv.Close()
+
+	src := NewS3Source(map[string]types.S3Endpoint{
+		"default": {URL: srv.URL, Region: "eu-central-1", AccessKey: "access", SecretKey: "secret", PathStyle: true},
+	})
+	src.client = srv.Client()
+	src.now = func() time.Time { return now }
+
+	file, err := src.Stat(context.Background(), "s3://datasets/"+key, types.SourceOptions{})
+	assert.NoError(t, err)
+	body, start, err := src.Open(context.Background(), file, 4000, types.SourceOptions{})
+	assert.NoError(t, err)
+	body.Close()
+	assert.Equal(t, int64(4000), start)
+
+	if !assert.Len(t, requests, 2) {
+		return
+	}
+	for _, r := range requests {
+		assert.Equal(t, "20240801T120000Z", r.Header.Get("x-amz-date"))
+		assert.Equal(t, util.EmptyPayloadHash, r.Header.Get("x-amz-content-sha256"))
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/20240801/eu-central-1/s3/aws4_request, "))
+	}
+	assert.Equal(t, http.MethodHead, requests[0].Method)
+	assert.Contains(t, requests[0].Header.Get("Authorization"), "SignedHeaders=host;x-amz-content-sha256;x-amz-date,")
+	assert.Equal(t, "bytes=4000-9999", requests[1].Header.Get("Range"))
+	assert.Contains(t, requests[1].Header.Get("Authorization"), "SignedHeaders=host;range;x-amz-content-sha256;x-amz-date,")
+}
+
+func TestS3Source_Errors(t *testing.T) {
+	var ranges atomic.Int32
+	src := newFakeS3(t, map[string]string{}, nil, &ranges)
+
+	_, err := src.Stat(context.Background(), "s3://datasets/missing.csv", types.SourceOptions{})
+	assertPermanentError(t, err, "404")
+
+	_, _, err = src.Open(context.Background(), &types.SourceFile{ID: "s3://datasets/missing.csv"}, 0, types.SourceOptions{})
+	assert.ErrorContains(t, err, "NoSuchKey")
+
+	_, err = src.Resolve(context.Background(), "s3://minio@datasets/a.csv", types.SourceOptions{})
+	assert.ErrorContains(t, err, "unknown s3 endpoint minio")
+}
+
+func TestS3ETagMD5(t *testing.T) {
+	assert.Equal(t, "9e107d9d372bb6826bd81d3542a419d6", s3ETagMD5(`"9E107D9D372BB6826BD81D3542A419D6"`))
+	assert.Empty(t, s3ETagMD5(`"9e107d9d372bb6826bd81d3542a419d6-3"`))
+}
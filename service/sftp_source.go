@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// `SFTPSource` downloads `sftp://host/path` links with the credentials of the user's connection profile
+// for the host. The ID of a file is its link, a link to a directory is downloaded like a folder.
+type SFTPSource struct {
+	profiles profileFunc
+}
+
+func NewSFTPSource(db *sql.DB) *SFTPSource {
+	return &SFTPSource{
+		profiles: dbProfiles(db),
+	}
+}
+
+// The credentials are taken from the connection profiles, no login needed.
+func (s *SFTPSource) AuthProvider() setting.Provider {
+	return ""
+}
+
+func (s *SFTPSource) Match(link string) bool {
+	return strings.HasPrefix(strings.ToLower(link), "sftp://")
+}
+
+func (s *SFTPSource) Resolve(ctx context.Context, link string, opts types.SourceOptions) (*types.SourceFile, error) {
+	loc, err := util.ParseRemoteLink(link)
+	if err != nil {
+		return nil, util.NewAppError(http.StatusBadRequest, err.Error())
+	}
+
+	conn, err := s.connect(ctx, loc, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	fi, err := conn.client.Stat(loc.Path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &types.SourceFile{ID: loc.String(), Name: remoteBase(loc), IsFolder: true}, nil
+	}
+
+	return sftpFile(loc, fi), nil
+}
+
+// ListFolder walks the directory recursively, symlinks are skipped so a link can't loop.
+func (s *SFTPSource) ListFolder(ctx context.Context, folder *types.SourceFile, opts types.SourceOptions) ([]types.SourceFile, error) {
+	loc, err := util.ParseRemoteLink(folder.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := s.connect(ctx, loc, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	files := make([]types.SourceFile, 0)
+	base := remoteBase(loc)
+	walker := conn.client.Walk(loc.Path)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, err
+		}
+		if !walker.Stat().Mode().IsRegular() {
+			continue
+		}
+
+		fileLoc := loc
+		fileLoc.Path = walker.Path()
+		file := sftpFile(fileLoc, walker.Stat())
+		file.Dir = util.SanitizeDir(base, path.Dir(strings.TrimPrefix(walker.Path(), loc.Path)))
+		files = append(files, *file)
+	}
+
+	return files, nil
+}
+
+func (s *SFTPSource) Stat(ctx context.Context, fileID string, opts types.SourceOptions) (*types.SourceFile, error) {
+	loc, err := util.ParseRemoteLink(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := s.connect(ctx, loc, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	fi, err := conn.client.Stat(loc.Path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("expected file, got directory %s", loc.Path)
+	}
+
+	return sftpFile(loc, fi), nil
+}
+
+// Open continues from `offset` by seeking, the connection is closed along with the file.
+func (s *SFTPSource) Open(ctx context.Context, file *types.SourceFile, offset int64, opts types.SourceOptions) (io.ReadCloser, int64, error) {
+	loc, err := util.ParseRemoteLink(file.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	conn, err := s.connect(ctx, loc, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f, err := conn.client.Open(loc.Path)
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		conn.Close()
+		return nil, 0, err
+	}
+
+	return &sftpReader{file: f, conn: conn}, offset, nil
+}
+
+// SFTP has no standard way to get a checksum.
+func (s *SFTPSource) Checksum(file *types.SourceFile) types.Checksum {
+	return types.Checksum{}
+}
+
+// connect opens an SFTP session with the user's connection profile for the host of `loc`.
+func (s *SFTPSource) connect(ctx context.Context, loc util.RemoteLocation, opts types.SourceOptions) (*sftpConn, error) {
+	p, err := findProfile(s.profiles, opts.UserID, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := util.SSHHostKeyCallback(p.HostKey)
+	if err != nil {
+		return nil, err
+	}
+	auth := make([]ssh.AuthMethod, 0, 2)
+	if len(p.PrivateKey) != 0 {
+		signer, err := ssh.ParsePrivateKey([]byte(p.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if len(p.Password) != 0 {
+		auth = append(auth, ssh.Password(p.Password))
+	}
+
+	addr := net.JoinHostPort(loc.Host, strconv.Itoa(loc.Port))
+	dialer := net.Dialer{Timeout: setting.RemoteDialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The handshake doesn't take a context, so it gets the same timeout as dialing.
+	netConn.SetDeadline(time.Now().Add(setting.RemoteDialTimeout))
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, &ssh.ClientConfig{
+		User:            p.Username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	netConn.SetDeadline(time.Time{})
+
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+
+	return &sftpConn{
+		client: client,
+		ssh:    sshClient,
+		// Closing the connection unblocks a pending read once the download is cancelled or paused.
+		stop: context.AfterFunc(ctx, func() { sshClient.Close() }),
+	}, nil
+}
+
+// `sftpConn` is an SFTP session and the SSH connection it runs on.
+type sftpConn struct {
+	client *sftp.Client
+	ssh    *ssh.Client
+	stop   func() bool
+}
+
+func (c *sftpConn) Close() error {
+	c.stop()
+	c.client.Close()
+	return c.ssh.Close()
+}
+
+// `sftpReader` only exposes `Read`, so the download goes through the engine's bandwidth limit.
+type sftpReader struct {
+	file *sftp.File
+	conn *sftpConn
+}
+
+func (r *sftpReader) Read(p []byte) (int, error) {
+	return r.file.Read(p)
+}
+
+func (r *sftpReader) Close() error {
+	r.file.Close()
+	return r.conn.Close()
+}
+
+// sftpFile turns the file info into a `SourceFile`, the size and modification time tell its version.
+func sftpFile(loc util.RemoteLocation, fi os.FileInfo) *types.SourceFile {
+	return &types.SourceFile{
+		ID:   loc.String(),
+		Name: path.Base(loc.Path),
+		Size: fi.Size(),
+		ETag: fmt.Sprintf("%d-%d", fi.Size(), fi.ModTime().Unix()),
+	}
+}
+
+// remoteBase names the folder of a directory link after the directory, or the host for the root.
+func remoteBase(loc util.RemoteLocation) string {
+	name := path.Base(loc.Path)
+	if name == "/" || name == "." {
+		return loc.Host
+	}
+
+	return name
+}
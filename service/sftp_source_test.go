@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestSFTPServer serves `handlers` over SFTP for the user `me` with the password `secret`, the local
+// file system is served without them. It returns the address and the host key.
+func newTestSFTPServer(t *testing.T, handlers *sftp.Handlers) (string, ssh.PublicKey) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.NoError(t, err)
+
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "me" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("invalid credentials")
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, cfg, handlers)
+		}
+	}()
+
+	return l.Addr().String(), signer.PublicKey()
+}
+
+func serveSFTP(conn net.Conn, cfg *ssh.ServerConfig, handlers *sftp.Handlers) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		ch, reqs, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range reqs {
+				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}()
+		go func() {
+			if handlers != nil {
+				server := sftp.NewRequestServer(ch, *handlers)
+				server.Serve()
+				server.Close()
+				return
+			}
+
+			server, err := sftp.NewServer(ch)
+			if err != nil {
+				return
+			}
+			server.Serve()
+			server.Close()
+		}()
+	}
+}
+
+// newTestSFTPSource connects with the profile of the user `user`, the host key is pinned.
+// The protocol and address of the last looked up profile are stored in `profileHost`.
+func newTestSFTPSource(hostKey ssh.PublicKey, profileHost *string) *SFTPSource {
+	return &SFTPSource{profiles: func(userID string, protocol setting.ProfileProtocol, h string, p int) (*types.ConnectionProfile, error) {
+		*profileHost = fmt.Sprintf("%s %s:%d", protocol, h, p)
+		if userID != "user" {
+			return &types.ConnectionProfile{}, nil
+		}
+		return &types.ConnectionProfile{ID: "1", Username: "me", Password: "secret", HostKey: string(ssh.MarshalAuthorizedKey(hostKey))}, nil
+	}}
+}
+
+func TestSFTPSource_DownloadFolder(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "seedbox")
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "sub"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "sub", "b.bin"), []byte("world"), 0644))
+
+	addr, hostKey := newTestSFTPServer(t, nil)
+	var profileHost string
+	src := newTestSFTPSource(hostKey, &profileHost)
+	opts := types.SourceOptions{UserID: "user"}
+	link := "sftp://" + addr + filepath.ToSlash(root)
+
+	folder, err := src.Resolve(context.Background(), link, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "sftp "+addr, profileHost)
+	assert.True(t, folder.IsFolder)
+
+	files, err := src.ListFolder(context.Background(), folder, opts)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	// The server doesn't sort the directory entries.
+	byName := make(map[string]types.SourceFile)
+	for _, f := range files {
+		byName[f.Name] = f
+	}
+	assert.Equal(t, "seedbox", byName["a.txt"].Dir)
+	assert.Equal(t, link+"/sub/b.bin", byName["b.bin"].ID)
+	assert.Equal(t, "seedbox/sub", byName["b.bin"].Dir)
+
+	// Another user has no profile for the server.
+	_, err = src.Resolve(context.Background(), link, types.SourceOptions{UserID: "other"})
+	assert.ErrorContains(t, err, "no connection profile found")
+}
+
+// resumeSFTP serves `content` from the local file system over SFTP.
+func resumeSFTP(t *testing.T, content string) (types.Source, string, types.SourceOptions, func(t *testing.T)) {
+	name := filepath.Join(t.TempDir(), "b.bin")
+	assert.NoError(t, os.WriteFile(name, []byte(content), 0644))
+
+	addr, hostKey := newTestSFTPServer(t, nil)
+	var profileHost string
+
+	return newTestSFTPSource(hostKey, &profileHost), "sftp://" + addr + filepath.ToSlash(name), types.SourceOptions{UserID: "user"}, nil
+}
+
+// `offsetReader` records the offsets of the reads of the file.
+type offsetReader struct {
+	io.ReaderAt
+	mu      *sync.Mutex
+	offsets *[]int64
+}
+
+func (r offsetReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	*r.offsets = append(*r.offsets, off)
+	r.mu.Unlock()
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+// `offsetFileReader` serves the files of `sftp.FileReader` with an `offsetReader`.
+type offsetFileReader struct {
+	sftp.FileReader
+	mu      sync.Mutex
+	offsets []int64
+}
+
+func (r *offsetFileReader) Fileread(req *sftp.Request) (io.ReaderAt, error) {
+	f, err := r.FileReader.Fileread(req)
+	if err != nil {
+		return nil, err
+	}
+	return offsetReader{ReaderAt: f, mu: &r.mu, offsets: &r.offsets}, nil
+}
+
+func TestSFTPSource_Seek(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	handlers := sftp.InMemHandler()
+	reads := &offsetFileReader{FileReader: handlers.FileGet}
+	handlers.FileGet = reads
+
+	addr, hostKey := newTestSFTPServer(t, &handlers)
+	var profileHost string
+	src := newTestSFTPSource(hostKey, &profileHost)
+	opts := types.SourceOptions{UserID: "user"}
+	loc, err := util.ParseRemoteLink("sftp://" + addr + "/b.bin")
+	assert.NoError(t, err)
+
+	conn, err := src.connect(context.Background(), loc, opts)
+	if !assert.NoError(t, err) {
+		return
+	}
+	f, err := conn.client.Create("/b.bin")
+	assert.NoError(t, err)
+	_, err = f.Write([]byte(content))
+	assert.NoError(t, err)
+	f.Close()
+	conn.Close()
+
+	body, start, err := src.Open(context.Background(), &types.SourceFile{ID: "sftp://" + addr + "/b.bin"}, 4000, opts)
+	if !assert.NoError(t, err) {
+		return
+	}
+	b, err := io.ReadAll(body)
+	assert.NoError(t, err)
+	body.Close()
+	assert.Equal(t, int64(4000), start)
+	assert.Equal(t, content[4000:], string(b))
+
+	// The reads start at the offset, the data before it isn't transferred.
+	reads.mu.Lock()
+	defer reads.mu.Unlock()
+	assert.NotEmpty(t, reads.offsets)
+	assert.Equal(t, int64(4000), slices.Min(reads.offsets))
+}
+
+func TestSFTPSource_HostKeyMismatch(t *testing.T) {
+	addr, _ := newTestSFTPServer(t, nil)
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ssh.NewPublicKey(pub)
+
+	src := &SFTPSource{profiles: func(string, setting.ProfileProtocol, string, int) (*types.ConnectionProfile, error) {
+		return &types.ConnectionProfile{ID: "1", Username: "me", Password: "secret", HostKey: ssh.FingerprintSHA256(otherKey)}, nil
+	}}
+
+	_, err := src.Stat(context.Background(), "sftp://"+addr+"/etc/hostname", types.SourceOptions{UserID: "user"})
+	assert.ErrorContains(t, err, "host key mismatch")
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func drainProgress(progChan <-chan types.Progress) <-chan types.Progress {
+	last := make(chan types.Progress, 1)
+	go func() {
+		var prog types.Progress
+		for prog = range progChan {
+		}
+		last <- prog
+	}()
+	return last
+}
+
+// serveRange serves `content` like a file server, the requests for a range are counted in `ranges`.
+func serveRange(w http.ResponseWriter, r *http.Request, name string, content string, ranges *atomic.Int32) {
+	if len(r.Header.Get("Range")) != 0 {
+		ranges.Add(1)
+	}
+	http.ServeContent(w, r, name, time.Time{}, strings.NewReader(content))
+}
+
+// testResumeDownload leaves `partial` as the data of an interrupted attempt and downloads the
+// file with `src`, which has to continue after it. The file ends up as `partial` followed by the
+// rest of `content`, so a different `partial` tells the kept data apart from downloaded data.
+// It returns the last progress of the download.
+func testResumeDownload(t *testing.T, src types.Source, fileID string, opts types.SourceOptions, content string, partial string) types.Progress {
+	t.Helper()
+
+	file, err := src.Stat(context.Background(), fileID, opts)
+	if !assert.NoError(t, err) {
+		return types.Progress{}
+	}
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, util.SanitizeFileName(file.Name))
+	f, _, err := util.OpenPartFile(dest, types.PartMeta{FileID: fileID, Size: file.Size, Checksum: src.Checksum(file).Value, ETag: file.ETag})
+	assert.NoError(t, err)
+	_, err = f.WriteString(partial)
+	assert.NoError(t, err)
+	f.Close()
+
+	progChan := make(chan types.Progress)
+	last := drainProgress(progChan)
+	err = DownloadFromSource(src, DownloaderConfig{FileID: fileID, DestinationPath: dir, Options: opts}, progChan, context.Background())
+	close(progChan)
+	assert.NoError(t, err)
+
+	prog := <-last
+	assert.True(t, prog.Complete)
+	assert.Equal(t, int64(len(content)), prog.Downloaded)
+
+	b, err := os.ReadFile(dest)
+	assert.NoError(t, err)
+	assert.Equal(t, partial+content[len(partial):], string(b))
+	assert.NoFileExists(t, util.PartFilePath(dest))
+
+	return prog
+}
+
+// `resumeCase` serves a file from a fake server of a source. `setup` returns the source with the
+// ID and options of the file, `resumed` checks on the server that the download continued after
+// the partial data instead of starting over, if the server can tell.
+type resumeCase struct {
+	name string
+	// Sources with a checksum verify the file, so their partial data has to match the content.
+	// The others get different partial data, so the test can tell it was kept.
+	verified bool
+	setup    func(t *testing.T, content string) (src types.Source, fileID string, opts types.SourceOptions, resumed func(t *testing.T))
+}
+
+func TestSources_Resume(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+
+	for _, tc := range []resumeCase{
+		{name: "http", setup: resumeHTTP},
+		{name: "dropbox", setup: resumeDropbox},
+		{name: "onedrive", verified: true, setup: resumeOneDrive},
+		{name: "s3", verified: true, setup: resumeS3},
+		{name: "ftp", setup: resumeFTP},
+		{name: "sftp", setup: resumeSFTP},
+		{name: "webdav", verified: true, setup: resumeWebDAV},
+		{name: "torrent", setup: resumeTorrent},
+		{name: "gdrive public", setup: resumePublicDrive},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src, fileID, opts, resumed := tc.setup(t, content)
+
+			partial := strings.Repeat("X", 4000)
+			if tc.verified {
+				partial = content[:4000]
+			}
+			testResumeDownload(t, src, fileID, opts, content, partial)
+			if resumed != nil {
+				resumed(t)
+			}
+		})
+	}
+}
+
+// assertPermanentError checks that the download fails with `msg` and isn't retried.
+func assertPermanentError(t *testing.T, err error, msg string) {
+	t.Helper()
+
+	assert.ErrorContains(t, err, msg)
+	assert.False(t, util.IsTransientError(err))
+}
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+	"golang.org/x/oauth2"
+)
+
+// Keys which encrypt the tokens of the accounts, they're set once on startup.
+var tokenKeys *util.TokenKeyring
+
+// Tables with encrypted columns, in the order they're updated.
+var encryptedTokenTables = []string{"google_accounts", "provider_accounts", "download_jobs", "connection_profiles"}
+
+// Encrypted columns of every table in `encryptedTokenTables`.
+var encryptedTokenColumns = map[string][]string{
+	"google_accounts":   {"access_token", "refresh_token"},
+	"provider_accounts": {"access_token", "refresh_token"},
+	// The headers of direct downloads can hold cookies and authorization headers.
+	"download_jobs":       {"headers"},
+	"connection_profiles": {"password", "private_key"},
+}
+
+// SetTokenKeyring sets the keys which encrypt the stored tokens, it has to be called
+// before the database is used.
+func SetTokenKeyring(k *util.TokenKeyring) {
+	tokenKeys = k
+}
+
+// TokenKeyring returns the keys set by `SetTokenKeyring`, or an error if they weren't set.
+func TokenKeyring() (*util.TokenKeyring, error) {
+	if tokenKeys == nil {
+		return nil, fmt.Errorf("token encryption keys aren't set")
+	}
+
+	return tokenKeys, nil
+}
+
+// RewrapTokens wraps the encrypted columns of `tables` with the primary key, the ones which
+// aren't encrypted yet are encrypted. Without `tables` every table in `encryptedTokenTables`
+// is updated. It returns the number of rows which were updated.
+func RewrapTokens(ctx context.Context, tx *sql.Tx, keys *util.TokenKeyring, tables ...string) (int, error) {
+	return updateTokens(ctx, tx, tables, keys.Rewrap)
+}
+
+// DecryptTokens stores the encrypted columns of `tables` in plaintext again, see `RewrapTokens`.
+// It returns the number of rows which were updated.
+func DecryptTokens(ctx context.Context, tx *sql.Tx, keys *util.TokenKeyring, tables ...string) (int, error) {
+	return updateTokens(ctx, tx, tables, func(value string) (string, bool, error) {
+		if !util.IsEncryptedToken(value) {
+			return value, false, nil
+		}
+		plaintext, err := keys.Decrypt(value)
+		return plaintext, err == nil, err
+	})
+}
+
+// encryptTokens encrypts the access and refresh token before they're stored.
+func encryptTokens(token *oauth2.Token) (string, string, error) {
+	keys, err := TokenKeyring()
+	if err != nil {
+		return "", "", err
+	}
+
+	access, err := keys.Encrypt(token.AccessToken)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encrypt the access token: %v", err)
+	}
+	refresh, err := keys.Encrypt(token.RefreshToken)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encrypt the refresh token: %v", err)
+	}
+
+	return access, refresh, nil
+}
+
+// decryptTokens decrypts the scanned access and refresh token in place.
+func decryptTokens(access *string, refresh *string) error {
+	keys, err := TokenKeyring()
+	if err != nil {
+		return err
+	}
+
+	if *access, err = keys.Decrypt(*access); err != nil {
+		return fmt.Errorf("failed to decrypt the access token: %v", err)
+	}
+	if *refresh, err = keys.Decrypt(*refresh); err != nil {
+		return fmt.Errorf("failed to decrypt the refresh token: %v", err)
+	}
+
+	return nil
+}
+
+// encryptHeaders encrypts the JSON of the headers of a job before it's stored.
+func encryptHeaders(headers []byte) (string, error) {
+	keys, err := TokenKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := keys.Encrypt(string(headers))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt the headers: %v", err)
+	}
+
+	return encrypted, nil
+}
+
+// encryptProfileSecrets encrypts the password and private key of a connection profile before it's stored.
+func encryptProfileSecrets(p *types.ConnectionProfile) (string, string, error) {
+	keys, err := TokenKeyring()
+	if err != nil {
+		return "", "", err
+	}
+
+	password, err := keys.Encrypt(p.Password)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encrypt the password: %v", err)
+	}
+	privateKey, err := keys.Encrypt(p.PrivateKey)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encrypt the private key: %v", err)
+	}
+
+	return password, privateKey, nil
+}
+
+// decryptProfileSecrets decrypts the scanned password and private key of a connection profile in place.
+func decryptProfileSecrets(p *types.ConnectionProfile) error {
+	keys, err := TokenKeyring()
+	if err != nil {
+		return err
+	}
+
+	if p.Password, err = keys.Decrypt(p.Password); err != nil {
+		return fmt.Errorf("failed to decrypt the password: %v", err)
+	}
+	if p.PrivateKey, err = keys.Decrypt(p.PrivateKey); err != nil {
+		return fmt.Errorf("failed to decrypt the private key: %v", err)
+	}
+
+	return nil
+}
+
+// decryptHeaders decrypts the scanned headers of a job.
+func decryptHeaders(headers string) ([]byte, error) {
+	keys, err := TokenKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := keys.Decrypt(headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the headers: %v", err)
+	}
+
+	return []byte(plaintext), nil
+}
+
+// textColumns returns the encrypted columns of `table` which are stored as text. The others are
+// left alone until their own migration changes them to text, eg. the JSONB headers of the jobs
+// while the migrations before theirs run.
+func textColumns(ctx context.Context, tx *sql.Tx, table string) ([]string, error) {
+	columns, ok := encryptedTokenColumns[table]
+	if !ok {
+		return nil, fmt.Errorf("%s has no encrypted columns", table)
+	}
+
+	const query = `
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND data_type = 'text'
+	`
+	rows, err := tx.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	isText := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		isText[column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	text := make([]string, 0, len(columns))
+	for _, column := range columns {
+		if isText[column] {
+			text = append(text, column)
+		}
+	}
+
+	return text, nil
+}
+
+// updateTokens passes the encrypted columns of every row of `tables` through `update`
+// and saves the rows which changed.
+func updateTokens(ctx context.Context, tx *sql.Tx, tables []string, update func(string) (string, bool, error)) (int, error) {
+	type row struct {
+		id     string
+		values []string
+	}
+
+	if len(tables) == 0 {
+		tables = encryptedTokenTables
+	}
+
+	updated := 0
+	for _, table := range tables {
+		columns, err := textColumns(ctx, tx, table)
+		if err != nil {
+			return updated, err
+		}
+		if len(columns) == 0 {
+			continue
+		}
+
+		rows, err := tx.QueryContext(ctx, `SELECT id, `+strings.Join(columns, ", ")+` FROM `+table+` FOR UPDATE`)
+		if err != nil {
+			return updated, err
+		}
+		var toUpdate []row
+		for rows.Next() {
+			r := row{values: make([]string, len(columns))}
+			dest := []any{&r.id}
+			for i := range r.values {
+				dest = append(dest, &r.values[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return updated, err
+			}
+			toUpdate = append(toUpdate, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return updated, err
+		}
+
+		set := make([]string, len(columns))
+		for i, column := range columns {
+			set[i] = fmt.Sprintf("%s = $%d", column, i+1)
+		}
+		query := `UPDATE ` + table + ` SET ` + strings.Join(set, ", ") + fmt.Sprintf(` WHERE id = $%d`, len(columns)+1)
+
+		for _, r := range toUpdate {
+			args := make([]any, 0, len(columns)+1)
+			changed := false
+			for _, value := range r.values {
+				value, valueChanged, err := update(value)
+				if err != nil {
+					return updated, fmt.Errorf("%s %s: %v", table, r.id, err)
+				}
+				args = append(args, value)
+				changed = changed || valueChanged
+			}
+			if !changed {
+				continue
+			}
+
+			args = append(args, r.id)
+			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+				return updated, err
+			}
+			updated++
+		}
+	}
+
+	return updated, nil
+}
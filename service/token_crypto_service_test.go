@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/nilotpaul/go-downloader/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// useTestTokenKeys sets the keys of the stored secrets for a test.
+func useTestTokenKeys(t *testing.T) *util.TokenKeyring {
+	t.Helper()
+
+	keys, err := util.ParseTokenKeys("test:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	assert.NoError(t, err)
+
+	prev := tokenKeys
+	SetTokenKeyring(keys)
+	t.Cleanup(func() { SetTokenKeyring(prev) })
+
+	return keys
+}
+
+// `encryptedArg` matches a query argument which decrypts to `plaintext`.
+type encryptedArg struct {
+	keys      *util.TokenKeyring
+	plaintext string
+}
+
+func (a encryptedArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	if !ok || !util.IsEncryptedToken(s) {
+		return false
+	}
+	plaintext, err := a.keys.Decrypt(s)
+	return err == nil && plaintext == a.plaintext
+}
+
+// `capturedArg` matches any query argument and keeps it.
+type capturedArg struct {
+	value *driver.Value
+}
+
+func (a capturedArg) Match(v driver.Value) bool {
+	*a.value = v
+	return true
+}
+
+func TestRewrapTokens_ConnectionProfiles(t *testing.T) {
+	keys := useTestTokenKeys(t)
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	encrypted, err := keys.Encrypt("key")
+	assert.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM information_schema.columns").
+		WithArgs("connection_profiles").
+		WillReturnRows(sqlmock.NewRows([]string{"column_name"}).AddRow("password").AddRow("private_key"))
+	mock.ExpectQuery("SELECT id, password, private_key FROM connection_profiles FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "private_key"}).
+			AddRow("plain", "secret", "").
+			AddRow("done", encrypted, encrypted))
+	// Only the profile which wasn't encrypted yet is updated.
+	mock.ExpectExec(`UPDATE connection_profiles SET password = \$1, private_key = \$2 WHERE id = \$3`).
+		WithArgs(encryptedArg{keys, "secret"}, encryptedArg{keys, ""}, "plain").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// The JSONB headers of the jobs aren't encrypted before their migration.
+	mock.ExpectQuery("FROM information_schema.columns").
+		WithArgs("download_jobs").
+		WillReturnRows(sqlmock.NewRows([]string{"column_name"}))
+	mock.ExpectCommit()
+
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	n, err := RewrapTokens(context.Background(), tx, keys, "connection_profiles", "download_jobs")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
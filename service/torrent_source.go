@@ -0,0 +1,423 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+)
+
+// `TorrentSource` downloads torrents by their magnet link, see `util.ParseTorrentLink`. Every file
+// is a path inside the torrent, only the files which are downloaded are requested from the peers.
+// The torrent client stores the data in its data folder, the files are streamed from there into
+// their destination. Once the downloads of a torrent are done it's seeded until a limit of the
+// `TorrentConfig` is reached, then its data is removed.
+type TorrentSource struct {
+	cfg       types.TorrentConfig
+	clientCfg *torrent.ClientConfig
+	// How often the seeding limits are checked.
+	checkInterval time.Duration
+
+	mu sync.Mutex
+	// Started with the first torrent, nil until then.
+	client   *torrent.Client
+	torrents map[metainfo.Hash]*torrentEntry
+	done     chan struct{}
+}
+
+// `torrentEntry` is a torrent of the client and its downloads.
+type torrentEntry struct {
+	t *torrent.Torrent
+	// Open streams of the torrent's files.
+	readers int
+	// Files which were downloaded, the torrent is seeded once all of them are complete.
+	files      map[*torrent.File]struct{}
+	lastActive time.Time
+	// Zero while the torrent isn't seeding.
+	seedStart time.Time
+}
+
+func NewTorrentSource(cfg types.TorrentConfig) *TorrentSource {
+	clientCfg := torrent.NewDefaultClientConfig()
+	clientCfg.DataDir = cfg.DataDir
+	clientCfg.ListenPort = cfg.ListenPort
+	clientCfg.Seed = true
+	// The completion is kept in memory, as the data is removed after seeding.
+	// Data left by a restart is verified again instead.
+	clientCfg.DefaultStorage = storage.NewFileOpts(storage.NewFileClientOpts{
+		ClientBaseDir: cfg.DataDir,
+		TorrentDirMaker: func(baseDir string, _ *metainfo.Info, infoHash metainfo.Hash) string {
+			return filepath.Join(baseDir, infoHash.HexString())
+		},
+		PieceCompletion: storage.NewMapPieceCompletion(),
+	})
+
+	return &TorrentSource{
+		cfg:           cfg,
+		clientCfg:     clientCfg,
+		checkInterval: setting.TorrentCheckInterval,
+		torrents:      make(map[metainfo.Hash]*torrentEntry),
+	}
+}
+
+// Torrents are public, no login needed.
+func (s *TorrentSource) AuthProvider() setting.Provider {
+	return ""
+}
+
+func (s *TorrentSource) Match(link string) bool {
+	_, err := util.ParseTorrentLink(link)
+	return err == nil
+}
+
+// Resolve waits for the metadata of the torrent, a torrent with more than one file is a folder.
+func (s *TorrentSource) Resolve(ctx context.Context, link string, opts types.SourceOptions) (*types.SourceFile, error) {
+	loc, err := util.ParseTorrentLink(link)
+	if err != nil {
+		return nil, err
+	}
+	e, err := s.add(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(loc.Path) == 0 && !e.t.Info().IsDir() {
+		loc.Path = e.t.Files()[0].DisplayPath()
+	}
+	if f := torrentFile(e.t, loc.Path); f != nil {
+		return torrentSourceFile(loc, e.t, f), nil
+	}
+
+	// The path is a folder inside the torrent, or the torrent itself.
+	name := path.Base(loc.Path)
+	if len(loc.Path) == 0 {
+		name = e.t.Name()
+	}
+	for _, f := range e.t.Files() {
+		if _, ok := torrentRelPath(loc.Path, f.DisplayPath()); ok {
+			return &types.SourceFile{
+				ID:       loc.String(),
+				Name:     name,
+				Size:     -1,
+				IsFolder: true,
+				ETag:     e.t.InfoHash().HexString(),
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no file %s in torrent %s", loc.Path, e.t.Name())
+}
+
+func (s *TorrentSource) ListFolder(ctx context.Context, folder *types.SourceFile, opts types.SourceOptions) ([]types.SourceFile, error) {
+	loc, err := util.ParseTorrentLink(folder.ID)
+	if err != nil {
+		return nil, err
+	}
+	e, err := s.add(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]types.SourceFile, 0)
+	for _, f := range e.t.Files() {
+		rel, ok := torrentRelPath(loc.Path, f.DisplayPath())
+		if !ok || isPaddingFile(f) {
+			continue
+		}
+
+		fileLoc := loc
+		fileLoc.Path = f.DisplayPath()
+		file := torrentSourceFile(fileLoc, e.t, f)
+		file.Dir = util.SanitizeDir(folder.Name, path.Dir(rel))
+		files = append(files, *file)
+	}
+
+	return files, nil
+}
+
+func (s *TorrentSource) Stat(ctx context.Context, fileID string, opts types.SourceOptions) (*types.SourceFile, error) {
+	file, err := s.Resolve(ctx, fileID, opts)
+	if err != nil {
+		return nil, err
+	}
+	if file.IsFolder {
+		return nil, fmt.Errorf("expected file, got folder %s", fileID)
+	}
+
+	return file, nil
+}
+
+// Open streams the file from `offset`, only the pieces which are verified are read.
+// The file is requested from the peers until the stream is closed.
+func (s *TorrentSource) Open(ctx context.Context, file *types.SourceFile, offset int64, opts types.SourceOptions) (io.ReadCloser, int64, error) {
+	loc, err := util.ParseTorrentLink(file.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+	e, err := s.add(ctx, loc)
+	if err != nil {
+		return nil, 0, err
+	}
+	f := torrentFile(e.t, loc.Path)
+	if f == nil {
+		return nil, 0, fmt.Errorf("no file %s in torrent %s", loc.Path, e.t.Name())
+	}
+
+	s.mu.Lock()
+	// Dropped after seeding in the meantime, it's added again.
+	if s.torrents[loc.InfoHash] != e {
+		s.mu.Unlock()
+		return s.Open(ctx, file, offset, opts)
+	}
+	e.readers++
+	e.files[f] = struct{}{}
+	e.seedStart = time.Time{}
+	s.mu.Unlock()
+
+	f.Download()
+	r := f.NewReader()
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		r.Close()
+		s.closeReader(e, f)
+		return nil, 0, fmt.Errorf("failed to seek the torrent file: %v", err)
+	}
+
+	return &torrentReader{ctx: ctx, r: r, src: s, e: e, f: f}, offset, nil
+}
+
+// Every piece is verified by the torrent client, the whole file only if the torrent has its SHA1.
+func (s *TorrentSource) Checksum(file *types.SourceFile) types.Checksum {
+	if len(file.Hash) != 0 {
+		return types.Checksum{Algorithm: "sha1", Value: file.Hash}
+	}
+
+	return types.Checksum{}
+}
+
+// Close stops the torrent client, the data of the torrents is kept.
+func (s *TorrentSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		return
+	}
+	close(s.done)
+	s.client.Close()
+	s.client = nil
+	s.torrents = make(map[metainfo.Hash]*torrentEntry)
+}
+
+// add adds the torrent to the client if it's not there yet, and waits for its metadata.
+// The metadata of an uploaded `.torrent` file is used if there's one.
+func (s *TorrentSource) add(ctx context.Context, loc util.TorrentLocation) (*torrentEntry, error) {
+	s.mu.Lock()
+	e, ok := s.torrents[loc.InfoHash]
+	if !ok {
+		t, err := s.addTorrent(loc)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		e = &torrentEntry{
+			t:          t,
+			files:      make(map[*torrent.File]struct{}),
+			lastActive: time.Now(),
+		}
+		s.torrents[loc.InfoHash] = e
+	}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, setting.TorrentInfoTimeout)
+	defer cancel()
+
+	select {
+	case <-e.t.GotInfo():
+		return e, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no metadata received for torrent %s, it might have no peers", loc.InfoHash.HexString())
+	}
+}
+
+// addTorrent must be called with `mu` held.
+func (s *TorrentSource) addTorrent(loc util.TorrentLocation) (*torrent.Torrent, error) {
+	if s.client == nil {
+		if err := os.MkdirAll(s.cfg.DataDir, os.FileMode(setting.FolderPermission)); err != nil {
+			return nil, fmt.Errorf("failed to create the torrent data folder: %v", err)
+		}
+		client, err := torrent.NewClient(s.clientCfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start the torrent client: %v", err)
+		}
+		s.client = client
+		s.done = make(chan struct{})
+		go s.watch(s.done)
+	}
+
+	spec, err := torrent.TorrentSpecFromMagnetUri(loc.Magnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %v", err)
+	}
+	if mi, err := metainfo.LoadFromFile(util.TorrentFilePath(s.cfg.DataDir, loc.InfoHash)); err == nil {
+		spec.InfoBytes = mi.InfoBytes
+		spec.Trackers = append(spec.Trackers, mi.UpvertedAnnounceList()...)
+	}
+
+	t, _, err := s.client.AddTorrentSpec(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add the torrent: %v", err)
+	}
+
+	return t, nil
+}
+
+// watch checks the torrents every `checkInterval` until `done` is closed.
+func (s *TorrentSource) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(s.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.checkTorrents()
+		}
+	}
+}
+
+// checkTorrents drops the torrents which reached their seeding limits, and the
+// unfinished ones which haven't been downloaded for `TorrentIdleTimeout`.
+func (s *TorrentSource) checkTorrents() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for infoHash, e := range s.torrents {
+		if e.readers > 0 {
+			continue
+		}
+
+		var size int64
+		complete := len(e.files) != 0
+		for f := range e.files {
+			size += f.Length()
+			complete = complete && f.BytesCompleted() == f.Length()
+		}
+		if !complete {
+			if time.Since(e.lastActive) >= setting.TorrentIdleTimeout {
+				s.drop(infoHash, e)
+			}
+			continue
+		}
+
+		if e.seedStart.IsZero() {
+			e.seedStart = time.Now()
+		}
+		stats := e.t.Stats()
+		if util.SeedLimitReached(stats.BytesWrittenData.Int64(), size, time.Since(e.seedStart), s.cfg.SeedRatio, s.cfg.SeedTime) {
+			s.drop(infoHash, e)
+		}
+	}
+}
+
+// drop removes the torrent and its data, must be called with `mu` held.
+func (s *TorrentSource) drop(infoHash metainfo.Hash, e *torrentEntry) {
+	e.t.Drop()
+	delete(s.torrents, infoHash)
+
+	if err := os.RemoveAll(filepath.Join(s.cfg.DataDir, infoHash.HexString())); err != nil {
+		log.Warnf("failed to remove the data of torrent %s: %v", infoHash.HexString(), err)
+	}
+}
+
+// closeReader stops requesting the rest of an unfinished file, eg. when its download got paused.
+func (s *TorrentSource) closeReader(e *torrentEntry, f *torrent.File) {
+	if f.BytesCompleted() < f.Length() {
+		f.SetPriority(torrent.PiecePriorityNone)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.readers--
+	e.lastActive = time.Now()
+}
+
+// `torrentReader` streams a file of a torrent, it reports the peers of the torrent.
+type torrentReader struct {
+	ctx context.Context
+	r   torrent.Reader
+	src *TorrentSource
+	e   *torrentEntry
+	f   *torrent.File
+}
+
+func (r *torrentReader) Read(p []byte) (int, error) {
+	return r.r.ReadContext(r.ctx, p)
+}
+
+func (r *torrentReader) Close() error {
+	err := r.r.Close()
+	r.src.closeReader(r.e, r.f)
+
+	return err
+}
+
+func (r *torrentReader) PeerCount() (int, int) {
+	stats := r.e.t.Stats()
+	return stats.ActivePeers, stats.ConnectedSeeders
+}
+
+// torrentFile returns the file of the torrent by its path, nil if there's none.
+func torrentFile(t *torrent.Torrent, p string) *torrent.File {
+	for _, f := range t.Files() {
+		if f.DisplayPath() == p && !isPaddingFile(f) {
+			return f
+		}
+	}
+
+	return nil
+}
+
+// torrentRelPath returns the path of `p` inside the folder `dir`, an empty `dir` is the whole torrent.
+func torrentRelPath(dir, p string) (string, bool) {
+	if len(dir) == 0 {
+		return p, true
+	}
+
+	return strings.CutPrefix(p, dir+"/")
+}
+
+// Padding files (BEP 47) only align the other files with the pieces.
+func isPaddingFile(f *torrent.File) bool {
+	return strings.Contains(f.FileInfo().Attr, "p")
+}
+
+func torrentSourceFile(loc util.TorrentLocation, t *torrent.Torrent, f *torrent.File) *types.SourceFile {
+	file := &types.SourceFile{
+		ID:   loc.String(),
+		Name: path.Base(f.DisplayPath()),
+		Size: f.Length(),
+		ETag: t.InfoHash().HexString(),
+	}
+	// Some torrents have the SHA1 of every file (BEP 47).
+	if sha1 := f.FileInfo().Sha1; len(sha1) == 20 {
+		file.Hash = hex.EncodeToString([]byte(sha1))
+	}
+
+	return file
+}
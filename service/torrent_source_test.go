@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// localTorrentConfig keeps a client on localhost, without DHT and trackers.
+func localTorrentConfig(cfg *torrent.ClientConfig) {
+	cfg.ListenPort = 0
+	cfg.ListenHost = func(string) string { return "127.0.0.1" }
+	cfg.DisableIPv6 = true
+	cfg.NoDHT = true
+	cfg.DisableTrackers = true
+	cfg.NoDefaultPortForwarding = true
+}
+
+// newTestSeeder seeds the folder `root` from another client, it returns the magnet link of the torrent
+// with the address of the seeder, so no DHT or tracker is needed.
+func newTestSeeder(t *testing.T, root string) string {
+	info := metainfo.Info{PieceLength: 16 * 1024}
+	assert.NoError(t, info.BuildFromFilePath(root))
+	infoBytes, err := bencode.Marshal(info)
+	assert.NoError(t, err)
+	mi := &metainfo.MetaInfo{InfoBytes: infoBytes}
+
+	cfg := torrent.NewDefaultClientConfig()
+	localTorrentConfig(cfg)
+	cfg.Seed = true
+	cfg.DataDir = filepath.Dir(root)
+	cfg.DefaultStorage = storage.NewFileWithCompletion(filepath.Dir(root), storage.NewMapPieceCompletion())
+	seeder, err := torrent.NewClient(cfg)
+	assert.NoError(t, err)
+	t.Cleanup(func() { seeder.Close() })
+
+	_, err = seeder.AddTorrent(mi)
+	assert.NoError(t, err)
+
+	ih := mi.HashInfoBytes()
+	return fmt.Sprintf("%s&x.pe=%s", mi.Magnet(&ih, &info).String(), seeder.ListenAddrs()[0])
+}
+
+func TestTorrentSource_DownloadSelectedFile(t *testing.T) {
+	content := strings.Repeat("0123456789", 10000)
+	root := filepath.Join(t.TempDir(), "seedbox")
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "sub"), 0755))
+	// Two whole pieces, so no piece of `a.txt` is part of the selected file.
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte(strings.Repeat("a", 2*16*1024)), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "sub", "b.bin"), []byte(content), 0644))
+	magnet := newTestSeeder(t, root)
+
+	dataDir := t.TempDir()
+	src := NewTorrentSource(types.TorrentConfig{DataDir: dataDir})
+	localTorrentConfig(src.clientCfg)
+	t.Cleanup(src.Close)
+	opts := types.SourceOptions{UserID: "user"}
+
+	folder, err := src.Resolve(context.Background(), magnet, opts)
+	assert.NoError(t, err)
+	assert.True(t, folder.IsFolder)
+	assert.Equal(t, "seedbox", folder.Name)
+
+	list, err := src.ListFolder(context.Background(), folder, opts)
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, "seedbox", list[0].Dir)
+	assert.Equal(t, "seedbox/sub", list[1].Dir)
+
+	// Only the selected file is downloaded.
+	selected := util.FilterTorrentFiles(list, []string{"sub"})
+	assert.Len(t, selected, 1)
+	file, err := src.Stat(context.Background(), selected[0].ID, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "b.bin", file.Name)
+	assert.Equal(t, int64(len(content)), file.Size)
+
+	progChan := make(chan types.Progress)
+	last := drainProgress(progChan)
+	err = DownloadFromSource(src, DownloaderConfig{FileID: file.ID, DestinationPath: t.TempDir(), Options: opts}, progChan, context.Background())
+	close(progChan)
+	assert.NoError(t, err)
+	assert.True(t, (<-last).Complete)
+
+	// None of the pieces of the other file were requested.
+	assert.Len(t, src.torrents, 1)
+	for _, e := range src.torrents {
+		for _, f := range e.t.Files() {
+			if f.DisplayPath() == "a.txt" {
+				assert.Equal(t, torrent.PiecePriorityNone, f.Priority())
+				assert.Zero(t, f.BytesCompleted())
+			} else {
+				assert.Equal(t, f.Length(), f.BytesCompleted())
+			}
+		}
+	}
+
+	// Without seeding limits the torrent is dropped right away, along with its data.
+	infoHash := strings.TrimPrefix(strings.SplitN(magnet, "&", 2)[0], "magnet:?xt=urn:btih:")
+	_, err = os.Stat(filepath.Join(dataDir, infoHash))
+	assert.NoError(t, err)
+	src.checkTorrents()
+	assert.Empty(t, src.torrents)
+	_, err = os.Stat(filepath.Join(dataDir, infoHash))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// resumeTorrent seeds `content` as a torrent of a single file.
+func resumeTorrent(t *testing.T, content string) (types.Source, string, types.SourceOptions, func(t *testing.T)) {
+	root := filepath.Join(t.TempDir(), "b.bin")
+	assert.NoError(t, os.WriteFile(root, []byte(content), 0644))
+	magnet := newTestSeeder(t, root)
+
+	src := NewTorrentSource(types.TorrentConfig{DataDir: t.TempDir()})
+	localTorrentConfig(src.clientCfg)
+	t.Cleanup(src.Close)
+
+	file, err := src.Resolve(context.Background(), magnet, types.SourceOptions{})
+	assert.NoError(t, err)
+
+	return src, file.ID, types.SourceOptions{}, nil
+}
+
+func TestTorrentSource_Seeding(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "file.iso")
+	assert.NoError(t, os.WriteFile(root, []byte(strings.Repeat("iso", 1000)), 0644))
+	magnet := newTestSeeder(t, root)
+
+	src := NewTorrentSource(types.TorrentConfig{DataDir: t.TempDir(), SeedRatio: 1})
+	localTorrentConfig(src.clientCfg)
+	t.Cleanup(src.Close)
+
+	// A torrent of a single file isn't a folder.
+	file, err := src.Resolve(context.Background(), magnet, types.SourceOptions{})
+	assert.NoError(t, err)
+	assert.False(t, file.IsFolder)
+	assert.Equal(t, "file.iso", file.Name)
+
+	// The metadata came from the seeder, so it's connected before any data is read.
+	body, start, err := src.Open(context.Background(), file, 0, types.SourceOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), start)
+	peers, seeds := body.(types.PeerCounter).PeerCount()
+	assert.Equal(t, 1, peers)
+	assert.Equal(t, 1, seeds)
+	assert.NoError(t, body.Close())
+
+	progChan := make(chan types.Progress)
+	last := drainProgress(progChan)
+	err = DownloadFromSource(src, DownloaderConfig{FileID: file.ID, DestinationPath: t.TempDir()}, progChan, context.Background())
+	close(progChan)
+	assert.NoError(t, err)
+	assert.True(t, (<-last).Complete)
+
+	// Nothing is uploaded to the seeder, so the ratio isn't reached.
+	src.checkTorrents()
+	assert.Len(t, src.torrents, 1)
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/types"
+)
+
+// CreateUserSession creates a session for a sign in of the user, it returns the ID of the session.
+func CreateUserSession(db *sql.DB, s *types.UserSession) (string, error) {
+	const query = `
+		INSERT INTO user_sessions (
+			user_id,
+			user_agent,
+			ip_address,
+			last_seen_at,
+			expires_at
+		)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	var id string
+	err := db.QueryRow(
+		query,
+		s.UserID,
+		s.UserAgent,
+		s.IPAddress,
+		time.Now(),
+		s.ExpiresAt,
+	).Scan(&id)
+
+	return id, err
+}
+
+// GetActiveUserSession gets one of the user's sessions, the `ID` is empty if there's
+// none or it was revoked or expired.
+func GetActiveUserSession(db *sql.DB, userID string, sessionID string) (*types.UserSession, error) {
+	const query = `
+		SELECT
+			id, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at
+		FROM
+			user_sessions
+		WHERE
+			id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > $3
+	`
+
+	var s types.UserSession
+	err := scanUserSession(db.QueryRow(query, sessionID, userID, time.Now()), &s)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// GetActiveUserSessions gets the sessions of the user which weren't revoked and didn't expire,
+// the last seen first.
+func GetActiveUserSessions(db *sql.DB, userID string) ([]types.UserSession, error) {
+	const query = `
+		SELECT
+			id, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at
+		FROM
+			user_sessions
+		WHERE
+			user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY
+			last_seen_at DESC
+	`
+
+	rows, err := db.Query(query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]types.UserSession, 0)
+	for rows.Next() {
+		var s types.UserSession
+		if err := scanUserSession(rows, &s); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// TouchUserSession updates the last seen time of the session, with the user agent
+// and IP address of the latest request.
+func TouchUserSession(db *sql.DB, sessionID string, userAgent string, ip string) error {
+	const query = `
+		UPDATE user_sessions
+		SET
+			last_seen_at = $1,
+			user_agent = $2,
+			ip_address = $3
+		WHERE
+			id = $4
+	`
+	_, err := db.Exec(query, time.Now(), userAgent, ip, sessionID)
+
+	return err
+}
+
+// RevokeUserSession revokes one of the user's sessions, it reports whether an active session was revoked.
+func RevokeUserSession(db *sql.DB, userID string, sessionID string) (bool, error) {
+	const query = `
+		UPDATE user_sessions
+		SET
+			revoked_at = $1
+		WHERE
+			id = $2 AND user_id = $3 AND revoked_at IS NULL
+	`
+
+	res, err := db.Exec(query, time.Now(), sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// RevokeUserSessions revokes every session of the user, it returns the number of revoked sessions.
+func RevokeUserSessions(db *sql.DB, userID string) (int64, error) {
+	const query = `
+		UPDATE user_sessions
+		SET
+			revoked_at = $1
+		WHERE
+			user_id = $2 AND revoked_at IS NULL
+	`
+
+	res, err := db.Exec(query, time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func scanUserSession(row interface{ Scan(...any) error }, s *types.UserSession) error {
+	return row.Scan(
+		&s.ID,
+		&s.UserID,
+		&s.UserAgent,
+		&s.IPAddress,
+		&s.CreatedAt,
+		&s.LastSeenAt,
+		&s.ExpiresAt,
+	)
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+)
+
+// `WebDAVSource` downloads from WebDAV servers, see `util.ParseWebDAVLink` for the links. Public shares
+// of Nextcloud and ownCloud need no login, the other links use the user's `webdav` connection profile
+// for the host and port. The ID of a file is its link, folders are listed with `PROPFIND`.
+type WebDAVSource struct {
+	client   *http.Client
+	profiles profileFunc
+}
+
+func NewWebDAVSource(db *sql.DB) *WebDAVSource {
+	return &WebDAVSource{
+		client:   util.NewHTTPClient(setting.MaxHTTPRedirects),
+		profiles: dbProfiles(db),
+	}
+}
+
+// The credentials are the share token or a connection profile, no login needed.
+func (s *WebDAVSource) AuthProvider() setting.Provider {
+	return ""
+}
+
+func (s *WebDAVSource) Match(link string) bool {
+	_, err := util.ParseWebDAVLink(link)
+	return err == nil
+}
+
+func (s *WebDAVSource) Resolve(ctx context.Context, link string, opts types.SourceOptions) (*types.SourceFile, error) {
+	loc, err := util.ParseWebDAVLink(link)
+	if err != nil {
+		return nil, err
+	}
+
+	responses, err := s.propfind(ctx, loc, "0", opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(responses) == 0 {
+		return nil, fmt.Errorf("no properties found for %s", link)
+	}
+
+	return webDAVFile(loc, responses[0]), nil
+}
+
+// ListFolder lists the collection one level at a time, servers often don't allow `Depth: infinity`.
+func (s *WebDAVSource) ListFolder(ctx context.Context, folder *types.SourceFile, opts types.SourceOptions) ([]types.SourceFile, error) {
+	loc, err := util.ParseWebDAVLink(folder.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]types.SourceFile, 0)
+	var list func(dir string) error
+	list = func(dir string) error {
+		dirLoc := loc
+		dirLoc.Path = dir
+		responses, err := s.propfind(ctx, dirLoc, "1", opts)
+		if err != nil {
+			return err
+		}
+
+		for _, res := range responses {
+			p, err := loc.RelPath(res.Href)
+			if err != nil {
+				return err
+			}
+			// The collection itself is part of the response.
+			if p == dir {
+				continue
+			}
+
+			fileLoc := loc
+			fileLoc.Path = p
+			file := webDAVFile(fileLoc, res)
+			if file.IsFolder {
+				if err := list(p); err != nil {
+					return err
+				}
+				continue
+			}
+			file.Dir = util.SanitizeDir(folder.Name, strings.TrimPrefix(path.Dir(p), loc.Path))
+			files = append(files, *file)
+		}
+		return nil
+	}
+	if err := list(loc.Path); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (s *WebDAVSource) Stat(ctx context.Context, fileID string, opts types.SourceOptions) (*types.SourceFile, error) {
+	file, err := s.Resolve(ctx, fileID, opts)
+	if err != nil {
+		return nil, err
+	}
+	if file.IsFolder {
+		return nil, fmt.Errorf("expected file, got collection %s", fileID)
+	}
+
+	return file, nil
+}
+
+// Open requests the file from `offset`, the range only applies if the ETag still matches.
+func (s *WebDAVSource) Open(ctx context.Context, file *types.SourceFile, offset int64, opts types.SourceOptions) (io.ReadCloser, int64, error) {
+	loc, err := util.ParseWebDAVLink(file.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return util.OpenRange(s.client, offset, func(offset int64) (*http.Request, error) {
+		req, err := s.newRequest(ctx, http.MethodGet, loc, nil, opts)
+		if err != nil {
+			return nil, err
+		}
+		util.SetRangeHeader(req, offset, file.ETag)
+
+		return req, nil
+	}, webDAVError)
+}
+
+// Checksum returns the checksum of Nextcloud and ownCloud, if the file has one.
+func (s *WebDAVSource) Checksum(file *types.SourceFile) types.Checksum {
+	switch len(file.Hash) {
+	case 64:
+		return types.Checksum{Algorithm: "sha256", Value: file.Hash}
+	case 40:
+		return types.Checksum{Algorithm: "sha1", Value: file.Hash}
+	case 32:
+		return types.Checksum{Algorithm: "md5", Value: file.Hash}
+	default:
+		return types.Checksum{}
+	}
+}
+
+func (s *WebDAVSource) propfind(ctx context.Context, loc util.WebDAVLocation, depth string, opts types.SourceOptions) ([]types.WebDAVResponse, error) {
+	req, err := s.newRequest(ctx, "PROPFIND", loc, strings.NewReader(setting.WebDAVPropfindBody), opts)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	// Collections are requested with a trailing slash, some servers redirect otherwise.
+	if depth != "0" && !strings.HasSuffix(req.URL.Path, "/") {
+		req.URL.Path += "/"
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusMultiStatus {
+		return nil, webDAVError(res)
+	}
+
+	var ms types.WebDAVMultistatus
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("failed to decode the webdav response: %v", err)
+	}
+
+	return ms.Responses, nil
+}
+
+// newRequest creates a request for `loc`, authenticated with the share token or the user's connection profile.
+func (s *WebDAVSource) newRequest(ctx context.Context, method string, loc util.WebDAVLocation, body io.Reader, opts types.SourceOptions) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, loc.URL().String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(loc.ShareToken) != 0 {
+		req.SetBasicAuth(loc.ShareToken, "")
+		return req, nil
+	}
+
+	p, err := findProfile(s.profiles, opts.UserID, util.RemoteLocation{
+		Protocol: setting.ProtocolWebDAV,
+		Host:     loc.Root.Hostname(),
+		Port:     loc.Port(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(p.Username, p.Password)
+
+	return req, nil
+}
+
+// webDAVError turns a failed response into an `HTTPStatusError`,
+// so rate limits and server errors are retried.
+func webDAVError(res *http.Response) error {
+	statusErr := &util.HTTPStatusError{StatusCode: res.StatusCode, Status: res.Status}
+	if res.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("webdav request failed, check the connection profile or share link: %w", statusErr)
+	}
+
+	return fmt.Errorf("webdav request failed: %w", statusErr)
+}
+
+// webDAVFile turns the properties of a response into a `SourceFile`.
+func webDAVFile(loc util.WebDAVLocation, res types.WebDAVResponse) *types.SourceFile {
+	file := &types.SourceFile{
+		ID:   loc.String(),
+		Name: path.Base(loc.Path),
+		Size: -1,
+	}
+
+	for _, ps := range res.Propstats {
+		if !strings.Contains(ps.Status, " 200 ") {
+			continue
+		}
+		prop := ps.Prop
+
+		file.IsFolder = file.IsFolder || prop.Collection != nil
+		if len(prop.DisplayName) != 0 && loc.Path == "/" {
+			file.Name = prop.DisplayName
+		}
+		if size, err := strconv.ParseInt(prop.ContentLength, 10, 64); err == nil {
+			file.Size = size
+		}
+		if len(prop.ContentType) != 0 {
+			file.MimeType = prop.ContentType
+		}
+		if len(prop.ETag) != 0 {
+			file.ETag = prop.ETag
+		}
+		if hash := webDAVChecksum(prop.Checksums); len(hash) != 0 {
+			file.Hash = hash
+		}
+	}
+	// The root of a share or a server has no name of its own.
+	if file.Name == "/" {
+		file.Name = loc.Root.Hostname()
+	}
+
+	return file
+}
+
+// webDAVChecksum picks the strongest checksum, `checksums` are lists like `SHA1:<hex> MD5:<hex>`.
+func webDAVChecksum(checksums []string) string {
+	found := make(map[string]string)
+	for _, list := range checksums {
+		for _, c := range strings.Fields(list) {
+			algorithm, value, ok := strings.Cut(c, ":")
+			if ok {
+				found[strings.ToUpper(algorithm)] = strings.ToLower(value)
+			}
+		}
+	}
+
+	for _, algorithm := range []string{"SHA256", "SHA1", "MD5"} {
+		if value, ok := found[algorithm]; ok {
+			return value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// `webDAVRequest` is a request received by the test server, `Path` is relative to its root.
+type webDAVRequest struct {
+	Method string
+	Path   string
+	Depth  string
+	Range  string
+	// The properties asked for by a `PROPFIND`, as `namespace name`.
+	Props []string
+}
+
+// `webDAVRequests` records the requests received by the test server.
+type webDAVRequests struct {
+	mu   sync.Mutex
+	reqs []webDAVRequest
+}
+
+func (r *webDAVRequests) add(req webDAVRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reqs = append(r.reqs, req)
+}
+
+func (r *webDAVRequests) list() []webDAVRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]webDAVRequest(nil), r.reqs...)
+}
+
+// newTestWebDAVServer serves `files` by their path under `root` for the user `user` with the password `pass`,
+// the collections are implied by the paths. The received requests are recorded in `reqs`.
+func newTestWebDAVServer(t *testing.T, root, user, pass string, files map[string]string, reqs *webDAVRequests) *httptest.Server {
+	isDir := func(dir string) bool {
+		for name := range files {
+			if strings.HasPrefix(name, strings.TrimSuffix(dir, "/")+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	propResponse := func(p string) string {
+		if content, ok := files[p]; ok {
+			return fmt.Sprintf(`<d:response><d:href>%s</d:href><d:propstat><d:prop>
+<d:getcontentlength>%d</d:getcontentlength><d:getetag>"%x"</d:getetag><d:resourcetype/>
+<oc:checksums><oc:checksum>SHA1:%s MD5:%s</oc:checksum></oc:checksums>
+</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`,
+				root+p, len(content), len(content), sha1Hex(content), md5Hex(content))
+		}
+		return fmt.Sprintf(`<d:response><d:href>%s/</d:href><d:propstat><d:prop>
+<d:displayname>shared</d:displayname><d:resourcetype><d:collection/></d:resourcetype>
+</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat><d:propstat><d:prop><d:getcontentlength/></d:prop>
+<d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response>`, root+strings.TrimSuffix(p, "/"))
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != user || p != pass {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		p, ok := strings.CutPrefix(r.URL.Path, root)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		p = "/" + strings.Trim(p, "/")
+
+		req := webDAVRequest{Method: r.Method, Path: p, Depth: r.Header.Get("Depth"), Range: r.Header.Get("Range")}
+		if r.Method == "PROPFIND" {
+			var propfind struct {
+				Prop struct {
+					Props []struct {
+						XMLName xml.Name
+					} `xml:",any"`
+				} `xml:"prop"`
+			}
+			assert.NoError(t, xml.NewDecoder(r.Body).Decode(&propfind))
+			for _, prop := range propfind.Prop.Props {
+				req.Props = append(req.Props, prop.XMLName.Space+" "+prop.XMLName.Local)
+			}
+		}
+		reqs.add(req)
+
+		_, isFile := files[p]
+		if !isFile && !isDir(p) && p != "/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case "PROPFIND":
+			body := propResponse(p)
+			if !isFile && r.Header.Get("Depth") == "1" {
+				seen := make(map[string]bool)
+				for name := range files {
+					rel, ok := strings.CutPrefix(name, strings.TrimSuffix(p, "/")+"/")
+					if !ok {
+						continue
+					}
+					first, _, _ := strings.Cut(rel, "/")
+					child := strings.TrimSuffix(p, "/") + "/" + first
+					if !seen[child] {
+						seen[child] = true
+						body += propResponse(child)
+					}
+				}
+			}
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">%s</d:multistatus>`, body)
+		case http.MethodGet:
+			content := files[p]
+			w.Header().Set("ETag", fmt.Sprintf(`"%x"`, len(content)))
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte(content)))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func sha1Hex(s string) string { return fmt.Sprintf("%x", sha1.Sum([]byte(s))) }
+
+func md5Hex(s string) string { return fmt.Sprintf("%x", md5.Sum([]byte(s))) }
+
+func TestWebDAVSource_DownloadShare(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	files := map[string]string{
+		"/a.txt":       "hello",
+		"/sub/b.bin":   content,
+		"/sub/c/d.txt": "nested",
+	}
+	var reqs webDAVRequests
+	token := "aBcDeFgHiJkLmNo"
+	server := newTestWebDAVServer(t, "/public.php/webdav", token, "", files, &reqs)
+
+	src := &WebDAVSource{client: server.Client()}
+	opts := types.SourceOptions{UserID: "user"}
+	link := server.URL + "/s/" + token
+
+	folder, err := src.Resolve(context.Background(), link, opts)
+	assert.NoError(t, err)
+	assert.True(t, folder.IsFolder)
+	assert.Equal(t, "shared", folder.Name)
+
+	list, err := src.ListFolder(context.Background(), folder, opts)
+	assert.NoError(t, err)
+	assert.Len(t, list, 3)
+	// The server doesn't sort the responses.
+	byName := make(map[string]types.SourceFile)
+	for _, f := range list {
+		byName[f.Name] = f
+	}
+	assert.Equal(t, "shared", byName["a.txt"].Dir)
+	assert.Equal(t, "shared/sub/c", byName["d.txt"].Dir)
+	assert.Equal(t, link+"#/sub/b.bin", byName["b.bin"].ID)
+	assert.Equal(t, "shared/sub", byName["b.bin"].Dir)
+
+	file, err := src.Stat(context.Background(), byName["b.bin"].ID, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), file.Size)
+	assert.Equal(t, types.Checksum{Algorithm: "sha1", Value: sha1Hex(content)}, src.Checksum(file))
+}
+
+// resumeWebDAV serves `content` as a file of a public share, its SHA-1 is verified.
+func resumeWebDAV(t *testing.T, content string) (types.Source, string, types.SourceOptions, func(t *testing.T)) {
+	var reqs webDAVRequests
+	token := "aBcDeFgHiJkLmNo"
+	server := newTestWebDAVServer(t, "/public.php/webdav", token, "", map[string]string{"/b.bin": content}, &reqs)
+
+	return &WebDAVSource{client: server.Client()}, server.URL + "/s/" + token + "#/b.bin", types.SourceOptions{UserID: "user"}, func(t *testing.T) {
+		var ranges []string
+		for _, req := range reqs.list() {
+			if req.Method == http.MethodGet {
+				ranges = append(ranges, req.Range)
+			}
+		}
+		assert.Equal(t, []string{"bytes=4000-"}, ranges)
+	}
+}
+
+func TestWebDAVSource_Propfind(t *testing.T) {
+	files := map[string]string{
+		"/a.txt":       "hello",
+		"/sub/b.bin":   "world",
+		"/sub/c/d.txt": "nested",
+	}
+	var reqs webDAVRequests
+	token := "aBcDeFgHiJkLmNo"
+	server := newTestWebDAVServer(t, "/public.php/webdav", token, "", files, &reqs)
+	src := &WebDAVSource{client: server.Client()}
+	opts := types.SourceOptions{UserID: "user"}
+	link := server.URL + "/s/" + token
+
+	// propfinds runs `fn` and returns the `PROPFIND`s it sent, every one has to ask for the same properties.
+	propfinds := func(fn func()) []webDAVRequest {
+		sent := len(reqs.list())
+		fn()
+
+		found := reqs.list()[sent:]
+		for _, req := range found {
+			assert.Equal(t, "PROPFIND", req.Method)
+			assert.Equal(t, []string{
+				"DAV: displayname",
+				"DAV: getcontentlength",
+				"DAV: getcontenttype",
+				"DAV: getetag",
+				"DAV: resourcetype",
+				"http://owncloud.org/ns checksums",
+			}, req.Props)
+		}
+		return found
+	}
+
+	var folder *types.SourceFile
+	assert.Equal(t, []webDAVRequest{{Method: "PROPFIND", Path: "/", Depth: "0"}}, withoutProps(propfinds(func() {
+		var err error
+		folder, err = src.Resolve(context.Background(), link, opts)
+		assert.NoError(t, err)
+	})))
+
+	// Every collection is listed on its own, the order depends on the server.
+	assert.ElementsMatch(t, []webDAVRequest{
+		{Method: "PROPFIND", Path: "/", Depth: "1"},
+		{Method: "PROPFIND", Path: "/sub", Depth: "1"},
+		{Method: "PROPFIND", Path: "/sub/c", Depth: "1"},
+	}, withoutProps(propfinds(func() {
+		list, err := src.ListFolder(context.Background(), folder, opts)
+		assert.NoError(t, err)
+		assert.Len(t, list, 3)
+	})))
+
+	assert.Equal(t, []webDAVRequest{{Method: "PROPFIND", Path: "/sub/b.bin", Depth: "0"}}, withoutProps(propfinds(func() {
+		_, err := src.Stat(context.Background(), link+"#/sub/b.bin", opts)
+		assert.NoError(t, err)
+	})))
+}
+
+// withoutProps clears the properties of `reqs`, so they can be compared by the rest.
+func withoutProps(reqs []webDAVRequest) []webDAVRequest {
+	for i := range reqs {
+		reqs[i].Props = nil
+	}
+	return reqs
+}
+
+func TestWebDAVSource_Profile(t *testing.T) {
+	var reqs webDAVRequests
+	root := "/remote.php/dav/files/me"
+	server := newTestWebDAVServer(t, root, "me", "secret", map[string]string{"/Documents/a.txt": "hello"}, &reqs)
+
+	src := &WebDAVSource{client: server.Client(), profiles: func(userID string, protocol setting.ProfileProtocol, host string, port int) (*types.ConnectionProfile, error) {
+		if userID != "user" || protocol != setting.ProtocolWebDAV || host != "127.0.0.1" {
+			return &types.ConnectionProfile{}, nil
+		}
+		return &types.ConnectionProfile{ID: "1", Username: "me", Password: "secret"}, nil
+	}}
+	link := server.URL + root + "/Documents/a.txt"
+
+	file, err := src.Stat(context.Background(), link, types.SourceOptions{UserID: "user"})
+	assert.NoError(t, err)
+	assert.Equal(t, "a.txt", file.Name)
+	assert.Equal(t, int64(5), file.Size)
+
+	_, err = src.Stat(context.Background(), server.URL+root+"/Documents", types.SourceOptions{UserID: "user"})
+	assert.ErrorContains(t, err, "expected file")
+
+	// Another user has no profile for the server.
+	_, err = src.Resolve(context.Background(), link, types.SourceOptions{UserID: "other"})
+	assert.ErrorContains(t, err, "no connection profile found")
+}
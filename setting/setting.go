@@ -28,15 +28,151 @@ type Provider string
 // Supported Providers List.
 const (
 	GoogleProvider Provider = "google"
+	// Direct HTTP(S) links, no login needed.
+	HTTPProvider Provider = "http"
+	// Shared links, connected to a user who signed in with Google.
+	DropboxProvider Provider = "dropbox"
+	// Microsoft accounts, connected to a user who signed in with Google.
+	MicrosoftProvider Provider = "microsoft"
+	// OneDrive and SharePoint sharing links, downloaded with a Microsoft account.
+	OneDriveProvider Provider = "onedrive"
+	// `s3://` links of the S3-compatible storages in the config, no login needed.
+	S3Provider Provider = "s3"
+	// `sftp://` links, the credentials are taken from the user's connection profile.
+	SFTPProvider Provider = "sftp"
+	// `ftp://` and `ftps://` links, the credentials are taken from the user's connection profile.
+	FTPProvider Provider = "ftp"
+	// Nextcloud and ownCloud public shares, other WebDAV links use the user's connection profile.
+	WebDAVProvider Provider = "webdav"
+	// Magnet links and uploaded `.torrent` files, no login needed.
+	TorrentProvider Provider = "torrent"
 )
 
+type ProfileProtocol string
+
+// Protocols of the connection profiles.
+const (
+	ProtocolSFTP ProfileProtocol = "sftp"
+	ProtocolFTP  ProfileProtocol = "ftp"
+	// Implicit TLS, or explicit TLS (AUTH TLS) if the profile has `explicit_tls`.
+	ProtocolFTPS ProfileProtocol = "ftps"
+	// The port is 443 for HTTPS, 80 for HTTP.
+	ProtocolWebDAV ProfileProtocol = "webdav"
+)
+
+// Google Drive MIME Types.
+const (
+	GDriveFolderMimeType   string = "application/vnd.google-apps.folder"
+	GDriveShortcutMimeType string = "application/vnd.google-apps.shortcut"
+	GDriveNativeMimePrefix string = "application/vnd.google-apps."
+)
+
+// Downloads of public Drive files without an API key or a Google account.
+const GDrivePublicDownloadURL string = "https://drive.google.com/uc"
+
+type ExportFormat string
+
+// Formats for exporting Google Docs, Sheets and Slides.
+const (
+	ExportOffice   ExportFormat = "office" // docx, xlsx, pptx
+	ExportPDF      ExportFormat = "pdf"
+	ExportODF      ExportFormat = "odf"      // odt, ods, odp
+	ExportCSV      ExportFormat = "csv"      // Sheets only, exports the first sheet.
+	ExportMarkdown ExportFormat = "markdown" // Docs only.
+)
+
+type DownloadStatus string
+
+// Download Job Statuses.
+const (
+	StatusQueued    DownloadStatus = "queued"
+	StatusRunning   DownloadStatus = "running"
+	StatusPaused    DownloadStatus = "paused"
+	StatusFailed    DownloadStatus = "failed"
+	StatusCompleted DownloadStatus = "completed"
+	StatusCancelled DownloadStatus = "cancelled"
+	StatusCorrupt   DownloadStatus = "corrupt"
+)
+
+// Number of times a download is retried when its checksum doesn't match.
+const MaxChecksumRetries int = 3
+
+// Delay before retrying a download after a transient error, it doubles
+// with every attempt up to `RetryMaxDelay`.
+const (
+	RetryBaseDelay = 2 * time.Second
+	RetryMaxDelay  = time.Minute
+)
+
+// Redirects followed by direct HTTP(S) downloads.
+const MaxHTTPRedirects int = 10
+
+// Size of every ranged GET of an S3 download.
+const S3PartSize int64 = 16 * 1024 * 1024
+
+// Endpoint of the `s3://bucket/key` links without an endpoint name.
+const S3DefaultEndpoint string = "default"
+
+// Properties requested by the `PROPFIND` requests of WebDAV sources.
+const WebDAVPropfindBody string = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+	<d:prop>
+		<d:displayname/>
+		<d:getcontentlength/>
+		<d:getcontenttype/>
+		<d:getetag/>
+		<d:resourcetype/>
+		<oc:checksums/>
+	</d:prop>
+</d:propfind>`
+
+// Timeout for connecting to SFTP and FTP servers.
+const RemoteDialTimeout = 30 * time.Second
+
+// Torrents, the metadata of a magnet link comes from the peers.
+const (
+	TorrentInfoTimeout = time.Minute
+	// How often finished torrents are checked against the seeding limits.
+	TorrentCheckInterval = 10 * time.Second
+	// An unfinished torrent without downloads is dropped after this, eg. a paused one.
+	TorrentIdleTimeout = 10 * time.Minute
+)
+
+// Number of jobs shown in the download history.
+const JobHistoryLimit int = 100
+
 // Other Utilities.
 const (
 	APIPrefix       string = "/api/v1"
 	SessionKey      string = "session_token"
 	LocalSessionKey string = "session_user_id"
+	// ID of the `UserSession` of the request.
+	LocalSessionIDKey string = "session_id"
+	// Set when the request has no session but a valid API token.
+	LocalAPIPrincipalKey string = "api_principal"
+	// Downloads started with an API token aren't owned by a user, they're
+	// kept by this ID and stored without a `user_id`.
+	APIPrincipalID string = ""
 
 	FolderPermission int = 0775
 )
 
-var SessionExpiry time.Time = time.Now().AddDate(0, 6, 0) // 6 months expiration time.
+// A session expires this long after the sign in, about 6 months.
+const SessionTTL = 183 * 24 * time.Hour
+
+// The last seen time of a session is updated at most once in this interval.
+const SessionTouchInterval = time.Minute
+
+// Expired entries of the session storage are removed in this interval.
+const SessionStorageGCInterval = 10 * time.Minute
+
+// An OAuth flow has to reach its callback within this time after the consent page URL was generated.
+const OAuthFlowExpiry = 10 * time.Minute
+
+// Session keys of the OAuth flows which were started but didn't reach their callback yet.
+const (
+	OAuthFlowSignIn string = "oauth_flow_signin"
+	OAuthFlowLink   string = "oauth_flow_link"
+	// Followed by the name of the provider.
+	OAuthFlowConnect string = "oauth_flow_connect_"
+)
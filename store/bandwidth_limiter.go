@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+)
+
+// `bandwidthLimiter` holds the global rate limiter shared by all downloads,
+// its config can be changed while the downloads are running.
+type bandwidthLimiter struct {
+	mu     sync.RWMutex
+	cfg    types.BandwidthConfig
+	global *util.RateLimiter
+	// Limits of single jobs by job ID, they're used instead of `cfg.PerJobLimit`.
+	jobLimits map[string]int64
+	now       func() time.Time
+}
+
+func newBandwidthLimiter(cfg types.BandwidthConfig) *bandwidthLimiter {
+	return &bandwidthLimiter{
+		cfg:       cfg,
+		global:    util.NewRateLimiter(0),
+		jobLimits: make(map[string]int64),
+		now:       time.Now,
+	}
+}
+
+func (b *bandwidthLimiter) config() types.BandwidthConfig {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	cfg := b.cfg
+	cfg.Schedule = slices.Clone(b.cfg.Schedule)
+
+	return cfg
+}
+
+func (b *bandwidthLimiter) setConfig(cfg types.BandwidthConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.cfg = cfg
+}
+
+// setJobLimit sets the limit of a single job, less than 1 goes back to the per-job limit of the config.
+func (b *bandwidthLimiter) setJobLimit(jobID string, limit int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if limit < 1 {
+		delete(b.jobLimits, jobID)
+		return
+	}
+	b.jobLimits[jobID] = limit
+}
+
+// forJob returns the limiter of a single download, which waits for both the global
+// limit and the limit of the job, or the per-job limit of the config if it has none.
+func (b *bandwidthLimiter) forJob(jobID string) util.Limiter {
+	return &jobLimiter{bandwidth: b, jobID: jobID, own: util.NewRateLimiter(0)}
+}
+
+// `jobLimiter` picks up the current limits before every read, so config
+// changes and schedules also apply to the running downloads.
+type jobLimiter struct {
+	bandwidth *bandwidthLimiter
+	jobID     string
+	own       *util.RateLimiter
+}
+
+func (l *jobLimiter) WaitN(ctx context.Context, n int) error {
+	l.bandwidth.mu.RLock()
+	globalLimit := util.ScheduledLimit(l.bandwidth.cfg, l.bandwidth.now())
+	jobLimit, ok := l.bandwidth.jobLimits[l.jobID]
+	if !ok {
+		jobLimit = l.bandwidth.cfg.PerJobLimit
+	}
+	l.bandwidth.mu.RUnlock()
+
+	l.bandwidth.global.SetLimit(globalLimit)
+	l.own.SetLimit(jobLimit)
+
+	if err := l.own.WaitN(ctx, n); err != nil {
+		return err
+	}
+
+	return l.bandwidth.global.WaitN(ctx, n)
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBandwidthLimiter_RuntimeChanges(t *testing.T) {
+	b := newBandwidthLimiter(types.BandwidthConfig{})
+	b.now = func() time.Time {
+		return time.Date(2024, 7, 1, 12, 0, 0, 0, time.Local)
+	}
+	l := b.forJob("job").(*jobLimiter)
+
+	assert.NoError(t, l.WaitN(context.Background(), 1024))
+	assert.Equal(t, int64(0), b.global.Limit())
+
+	// The running job picks up the new limits on its next read.
+	b.setConfig(types.BandwidthConfig{
+		GlobalLimit: 100,
+		PerJobLimit: 1 << 30,
+		Schedule:    []types.BandwidthRule{{Start: "08:00", End: "23:00", Limit: 1 << 20}},
+	})
+	assert.NoError(t, l.WaitN(context.Background(), 1))
+	assert.Equal(t, int64(1<<20), b.global.Limit())
+	assert.Equal(t, int64(1<<30), l.own.Limit())
+
+	cfg := b.config()
+	cfg.Schedule[0].Limit = 0
+	assert.Equal(t, int64(1<<20), b.config().Schedule[0].Limit)
+}
+
+func TestBandwidthLimiter_JobLimit(t *testing.T) {
+	b := newBandwidthLimiter(types.BandwidthConfig{PerJobLimit: 1 << 30})
+	l := b.forJob("job").(*jobLimiter)
+	other := b.forJob("other").(*jobLimiter)
+
+	// The limit of the job is used instead of the per-job limit of the config.
+	b.setJobLimit("job", 1<<20)
+	assert.NoError(t, l.WaitN(context.Background(), 1))
+	assert.NoError(t, other.WaitN(context.Background(), 1))
+	assert.Equal(t, int64(1<<20), l.own.Limit())
+	assert.Equal(t, int64(1<<30), other.own.Limit())
+
+	b.setJobLimit("job", 0)
+	assert.NoError(t, l.WaitN(context.Background(), 1))
+	assert.Equal(t, int64(1<<30), l.own.Limit())
+	assert.Empty(t, b.jobLimits)
+}
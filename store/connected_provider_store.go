@@ -0,0 +1,243 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nilotpaul/go-downloader/service"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/util"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/microsoft"
+)
+
+// `accountIDFunc` returns the ID of the account the token belongs to on the provider's side.
+type accountIDFunc func(ctx context.Context, token *oauth2.Token) (string, error)
+
+// `ConnectedProvider` connects the accounts of a provider like Dropbox or Microsoft to the users
+// who signed in with Google, it can't be used to sign in itself. The tokens of every user are kept
+// in the `provider_accounts` table, the provider itself holds none.
+type ConnectedProvider struct {
+	Config *oauth2.Config
+	name   setting.Provider
+	db     *sql.DB
+	// Extra parameters of the consent page URL.
+	authParams []oauth2.AuthCodeOption
+	accountID  accountIDFunc
+}
+
+type connectedProviderConfig struct {
+	clientID     string
+	clientSecret string
+	redirectURL  string
+}
+
+var dropboxScopes = []string{
+	"account_info.read",
+	"files.metadata.read",
+	"files.content.read",
+	"sharing.read",
+}
+
+var dropboxEndpoint = oauth2.Endpoint{
+	AuthURL:  "https://www.dropbox.com/oauth2/authorize",
+	TokenURL: "https://api.dropboxapi.com/oauth2/token",
+}
+
+var microsoftScopes = []string{
+	"offline_access",
+	"User.Read",
+	"Files.Read.All",
+	"Sites.Read.All",
+}
+
+// Endpoint to get the ID of the signed in Microsoft account.
+const microsoftMeEndpoint = "https://graph.microsoft.com/v1.0/me"
+
+func NewDropboxProvider(cfg connectedProviderConfig, db *sql.DB) *ConnectedProvider {
+	return &ConnectedProvider{
+		Config: &oauth2.Config{
+			ClientID:     cfg.clientID,
+			ClientSecret: cfg.clientSecret,
+			RedirectURL:  cfg.redirectURL,
+			Scopes:       dropboxScopes,
+			Endpoint:     dropboxEndpoint,
+		},
+		name: setting.DropboxProvider,
+		db:   db,
+		// Dropbox's access tokens are short-lived, the offline access gets a refresh token.
+		authParams: []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("token_access_type", "offline")},
+		// Dropbox sends the ID of the account along with the tokens.
+		accountID: func(ctx context.Context, token *oauth2.Token) (string, error) {
+			accountID, _ := token.Extra("account_id").(string)
+			return accountID, nil
+		},
+	}
+}
+
+// NewMicrosoftProvider creates the provider for personal and work or school Microsoft accounts
+// of `tenant`, eg. `common` for both kinds or the ID of an organization.
+func NewMicrosoftProvider(cfg connectedProviderConfig, tenant string, db *sql.DB) *ConnectedProvider {
+	return &ConnectedProvider{
+		Config: &oauth2.Config{
+			ClientID:     cfg.clientID,
+			ClientSecret: cfg.clientSecret,
+			RedirectURL:  cfg.redirectURL,
+			Scopes:       microsoftScopes,
+			Endpoint:     microsoft.AzureADEndpoint(tenant),
+		},
+		name:      setting.MicrosoftProvider,
+		db:        db,
+		accountID: getMicrosoftAccountID,
+	}
+}
+
+// `Authenticate` exchanges the authorization code for an access token.
+func (p *ConnectedProvider) Authenticate(ctx context.Context, authCode string, verifier string) (*oauth2.Token, error) {
+	return p.exchange(ctx, authCode, verifier)
+}
+
+// `RefreshToken` generates a new access token from the refresh token of the user's
+// account. With `force` it's refreshed even if it's still valid.
+func (p *ConnectedProvider) RefreshToken(userID string, force bool) (*oauth2.Token, error) {
+	ts, err := p.tokenSource(userID, force)
+	if err != nil {
+		return nil, util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to generate refresh token",
+			"ConnectedProvider, RefreshToken() error: ",
+			err,
+		)
+	}
+
+	return ts.Token()
+}
+
+// `GetAuthURL` returns a URL to the provider's consent page.
+func (p *ConnectedProvider) GetAuthURL(state string, verifier string) string {
+	opts := append([]oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}, p.authParams...)
+	return p.Config.AuthCodeURL(state, opts...)
+}
+
+// Users can't sign in with a connected provider, see `ConnectAccount`.
+func (p *ConnectedProvider) CreateOrUpdateAccount(ctx context.Context, token *oauth2.Token) (string, error) {
+	return "", util.NewAppError(
+		http.StatusBadRequest,
+		fmt.Sprintf("%s can only be connected to a signed in user", p.name),
+	)
+}
+
+// Sessions are only created by signing in with Google.
+func (p *ConnectedProvider) CreateSession(c *fiber.Ctx, userID string) error {
+	return util.NewAppError(
+		http.StatusBadRequest,
+		fmt.Sprintf("%s can only be connected to a signed in user", p.name),
+	)
+}
+
+// `TokenSource` loads the tokens of the user's account, they're refreshed and saved whenever they expire.
+func (p *ConnectedProvider) TokenSource(userID string) (oauth2.TokenSource, error) {
+	return p.tokenSource(userID, false)
+}
+
+// `ConnectAccount` exchanges the authorization code and connects the account to the user,
+// the account replaces the one the user had connected before.
+func (p *ConnectedProvider) ConnectAccount(ctx context.Context, userID string, authCode string, verifier string) error {
+	token, err := p.exchange(ctx, authCode, verifier)
+	if err != nil {
+		return err
+	}
+
+	accountID, err := p.accountID(ctx, token)
+	if err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to get the account info",
+			"ConnectedProvider, ConnectAccount() error: ",
+			err,
+		)
+	}
+	if err := service.CreateOrUpdateProviderAccount(p.db, userID, p.name, accountID, token); err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			fmt.Sprintf("failed to connect the %s account", p.name),
+			"ConnectedProvider, ConnectAccount() error: ",
+			err,
+		)
+	}
+
+	return nil
+}
+
+func (p *ConnectedProvider) exchange(ctx context.Context, authCode string, verifier string) (*oauth2.Token, error) {
+	token, err := p.Config.Exchange(ctx, authCode, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to authenticate",
+			"ConnectedProvider, exchange() error: ",
+			err,
+		)
+	}
+	if token == nil || !token.Valid() || len(token.RefreshToken) == 0 {
+		return nil, util.NewAppError(
+			http.StatusBadRequest,
+			"invalid oauth token",
+		)
+	}
+
+	return token, nil
+}
+
+// tokenSource loads the tokens from the database, they're refreshed right away if `force` is true.
+func (p *ConnectedProvider) tokenSource(userID string, force bool) (oauth2.TokenSource, error) {
+	acc, err := service.GetProviderAccount(p.db, userID, p.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the %s account: %v", p.name, err)
+	}
+	if len(acc.ID) == 0 {
+		return nil, fmt.Errorf("no %s account connected for user %s", p.name, userID)
+	}
+
+	token := acc.OAuthToken()
+	if force {
+		token.Expiry = time.Now().AddDate(-100, 0, 0)
+	}
+
+	// Providers which don't send a new refresh token keep the old one, the token source takes care of it.
+	return newAccountTokenSource(p.Config, token, func(t *oauth2.Token) error {
+		return service.UpdateProviderAccountTokens(p.db, userID, p.name, t)
+	})
+}
+
+// getMicrosoftAccountID gets the ID of the signed in user from Microsoft Graph.
+func getMicrosoftAccountID(ctx context.Context, token *oauth2.Token) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, microsoftMeEndpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get the user info: %s", res.Status)
+	}
+
+	var me struct {
+		ID string `json:"id"`
+	}
+	if err := util.DecodeJSON(res.Body, &me); err != nil {
+		return "", err
+	}
+
+	return me.ID, nil
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/nilotpaul/go-downloader/service"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"golang.org/x/oauth2"
+)
+
+// `DownloadManager` keeps a separate `Downloader` for every user, so users can only see
+// and cancel their own downloads. All of them share the same download queue and its limits.
+type DownloadManager struct {
+	db          *sql.DB
+	registry    *ProviderRegistry
+	queue       *downloadQueue
+	retry       RetryConfig
+	bandwidth   *bandwidthLimiter
+	mu          sync.Mutex
+	downloaders map[string]*Downloader
+}
+
+// NewDownloadManager creates the manager, the files are downloaded from the sources of `r`.
+// Downloads beyond the limits of `cfg` wait in a queue. Failed downloads are retried as per
+// `retry` and the download speed is limited by `bandwidth`. With a nil `db` the jobs are only kept in memory.
+func NewDownloadManager(db *sql.DB, r *ProviderRegistry, cfg QueueConfig, retry RetryConfig, bandwidth types.BandwidthConfig) *DownloadManager {
+	return &DownloadManager{
+		db:          db,
+		registry:    r,
+		queue:       newDownloadQueue(cfg),
+		retry:       retry,
+		bandwidth:   newBandwidthLimiter(bandwidth),
+		downloaders: make(map[string]*Downloader),
+	}
+}
+
+// GetDownloader returns the downloader of `userID`, it's created on first use.
+func (m *DownloadManager) GetDownloader(userID string) *Downloader {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.downloaders[userID]
+	if !ok {
+		d = newDownloader(m.db, userID, m.registry, m.queue, m.retry, m.bandwidth)
+		m.downloaders[userID] = d
+	}
+
+	return d
+}
+
+// GetBandwidth returns the current bandwidth limits.
+func (m *DownloadManager) GetBandwidth() types.BandwidthConfig {
+	return m.bandwidth.config()
+}
+
+// SetBandwidth changes the bandwidth limits, the ongoing downloads are affected as well.
+func (m *DownloadManager) SetBandwidth(cfg types.BandwidthConfig) {
+	m.bandwidth.setConfig(cfg)
+}
+
+// SetJobSpeedLimit changes the limit of an ongoing download of any user, see `Downloader.SetSpeedLimit`.
+func (m *DownloadManager) SetJobSpeedLimit(jobID string, limit int64) error {
+	m.mu.Lock()
+	downloaders := make([]*Downloader, 0, len(m.downloaders))
+	for _, d := range m.downloaders {
+		downloaders = append(downloaders, d)
+	}
+	m.mu.Unlock()
+
+	for _, d := range downloaders {
+		if _, err := d.GetProgress(jobID); err == nil {
+			return d.SetSpeedLimit(jobID, limit)
+		}
+	}
+
+	return fmt.Errorf("no ongoing download for job %s", jobID)
+}
+
+// GetAllPendingDownloads returns the ongoing downloads of every user, keyed by `userID`.
+// Only meant for the admin view.
+func (m *DownloadManager) GetAllPendingDownloads() map[string][]*types.Progress {
+	m.mu.Lock()
+	downloaders := make(map[string]*Downloader, len(m.downloaders))
+	for userID, d := range m.downloaders {
+		downloaders[userID] = d
+	}
+	m.mu.Unlock()
+
+	all := make(map[string][]*types.Progress)
+	for userID, d := range downloaders {
+		pendings, _ := d.GetPendingDownloads()
+		if len(pendings) != 0 {
+			all[userID] = pendings
+		}
+	}
+
+	return all
+}
+
+// RecoverDownloads re-enqueues the jobs which were queued, running or paused when the server stopped.
+// Every job goes back to its user's downloader and continues from its `.part` file, paused jobs stay paused.
+func (m *DownloadManager) RecoverDownloads(ctx context.Context) error {
+	if m.db == nil {
+		return nil
+	}
+
+	jobs, err := service.GetInterruptedDownloadJobs(m.db)
+	if err != nil {
+		return fmt.Errorf("failed to get the interrupted jobs: %v", err)
+	}
+
+	for _, job := range jobs {
+		d := m.GetDownloader(job.UserID)
+
+		src, err := m.registry.GetSource(job.Provider)
+		if err != nil {
+			d.updateJobStatus(job.ID, setting.StatusFailed, "provider is not configured")
+			continue
+		}
+
+		// Sources like direct links don't need a token.
+		var ts oauth2.TokenSource
+		if authProvider := src.AuthProvider(); len(authProvider) != 0 {
+			p, err := m.registry.GetProvider(authProvider)
+			if err != nil {
+				d.updateJobStatus(job.ID, setting.StatusFailed, "provider is not configured")
+				continue
+			}
+			ts, err = jobTokenSource(p, job)
+			switch {
+			case err == nil:
+			case isAnonymousSource(src):
+				// Public files are downloaded without a token.
+			default:
+				d.updateJobStatus(job.ID, setting.StatusFailed, err.Error())
+				continue
+			}
+		}
+
+		log.Infof("recovering download job %s for file %s", job.ID, job.FileID)
+		d.startJob(ctx, job, ts)
+	}
+
+	return nil
+}
+
+// jobTokenSource returns the tokens of the account the job was started with.
+func jobTokenSource(p types.OAuthProvider, job *types.DownloadJob) (oauth2.TokenSource, error) {
+	if multi, ok := p.(types.MultiAccountProvider); ok && len(job.AccountID) != 0 {
+		return multi.AccountTokenSource(job.UserID, job.AccountID)
+	}
+
+	return p.TokenSource(job.UserID)
+}
+
+func isAnonymousSource(src types.Source) bool {
+	anon, ok := src.(types.AnonymousSource)
+	return ok && anon.AllowsAnonymous()
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/nilotpaul/go-downloader/service"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadManager_RecoverDownloads(t *testing.T) {
+	keys, err := util.ParseTokenKeys("test:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	assert.NoError(t, err)
+	prev, _ := service.TokenKeyring()
+	service.SetTokenKeyring(keys)
+	t.Cleanup(func() { service.SetTokenKeyring(prev) })
+	headers, err := keys.Encrypt("{}")
+	assert.NoError(t, err)
+
+	content := strings.Repeat("0123456789", 1000)
+	var (
+		mu     sync.Mutex
+		ranges = make(map[string][]string)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges[r.URL.Path] = append(ranges[r.URL.Path], r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	// The running job was interrupted after 4000 bytes, the queued one didn't start.
+	dir := t.TempDir()
+	f, _, err := util.OpenPartFile(filepath.Join(dir, "running.bin"), types.PartMeta{FileID: srv.URL + "/running.bin", Size: int64(len(content))})
+	assert.NoError(t, err)
+	_, err = f.WriteString(strings.Repeat("X", 4000))
+	assert.NoError(t, err)
+	f.Close()
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	rows := sqlmock.NewRows([]string{
+		"id", "user_id", "file_id", "provider", "destination_path", "file_name", "export_format", "account_id",
+		"status", "bytes_done", "total_bytes", "error", "md5_checksum", "speed_limit", "headers", "started_at", "completed_at", "created_at", "updated_at",
+	})
+	for _, job := range []struct {
+		id        string
+		name      string
+		status    setting.DownloadStatus
+		bytesDone int64
+	}{
+		{"1", "running.bin", setting.StatusRunning, 4000},
+		{"2", "queued.bin", setting.StatusQueued, 0},
+	} {
+		rows.AddRow(job.id, "user", srv.URL+"/"+job.name, setting.HTTPProvider, dir, "", "", "", job.status, job.bytesDone, len(content), "", "", 0, headers, nil, nil, time.Now(), time.Now())
+	}
+	mock.ExpectQuery("FROM download_jobs").WillReturnRows(rows)
+
+	registry := NewProviderRegistry()
+	registry.RegisterSource(setting.HTTPProvider, service.NewHTTPSource())
+	m := NewDownloadManager(db, registry, QueueConfig{}, testRetryConfig, types.BandwidthConfig{})
+	// The status updates of the jobs aren't checked here.
+	d := m.GetDownloader("user")
+	d.db = nil
+
+	assert.NoError(t, m.RecoverDownloads(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	waitForDownloads(t, d)
+	assert.Empty(t, d.TakeErrors())
+
+	// The data of the interrupted run is kept, only the rest is requested.
+	b, err := os.ReadFile(filepath.Join(dir, "running.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("X", 4000)+content[4000:], string(b))
+	b, err = os.ReadFile(filepath.Join(dir, "queued.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(b))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, ranges["/running.bin"], "bytes=4000-")
+	assert.NotContains(t, ranges["/queued.bin"], "bytes=4000-")
+}
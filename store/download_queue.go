@@ -0,0 +1,149 @@
+package store
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/setting"
+)
+
+// `QueueConfig` limits how many downloads run at the same time.
+// A limit less than 1 means unlimited.
+type QueueConfig struct {
+	MaxConcurrent  int
+	MaxPerProvider int
+}
+
+// `RetryConfig` controls how often a download is retried after a transient error,
+// like a connection reset or a rate limit. The delay between the attempts grows
+// exponentially from `BaseDelay` up to `MaxDelay`.
+type RetryConfig struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+type queuedJob struct {
+	id       string
+	provider setting.Provider
+	run      func()
+	paused   bool
+}
+
+// `downloadQueue` is a FIFO queue which only starts a job when both the global
+// and the provider's limit allow it. A job whose provider is at its limit doesn't
+// block the jobs of other providers behind it. Paused jobs keep their place but
+// are skipped until they're resumed.
+type downloadQueue struct {
+	cfg        QueueConfig
+	mu         sync.Mutex
+	pending    []*queuedJob
+	running    int
+	byProvider map[setting.Provider]int
+}
+
+func newDownloadQueue(cfg QueueConfig) *downloadQueue {
+	return &downloadQueue{
+		cfg:        cfg,
+		pending:    make([]*queuedJob, 0),
+		byProvider: make(map[setting.Provider]int),
+	}
+}
+
+// enqueue adds a job at the end of the queue, it starts right away if there's a free slot.
+func (q *downloadQueue) enqueue(id string, provider setting.Provider, paused bool, run func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending = append(q.pending, &queuedJob{id: id, provider: provider, run: run, paused: paused})
+	q.dispatch()
+}
+
+// requeue adds a job which was stopped while running at the front of the queue,
+// as it was started before all the pending ones.
+func (q *downloadQueue) requeue(id string, provider setting.Provider, paused bool, run func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending = append([]*queuedJob{{id: id, provider: provider, run: run, paused: paused}}, q.pending...)
+	q.dispatch()
+}
+
+// setPaused pauses or resumes a pending job, it returns false if the job isn't in the queue.
+func (q *downloadQueue) setPaused(id string, paused bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.pending {
+		if job.id == id {
+			job.paused = paused
+			q.dispatch()
+			return true
+		}
+	}
+
+	return false
+}
+
+// remove takes a pending job out of the queue, it returns false if the job isn't in the queue.
+func (q *downloadQueue) remove(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, job := range q.pending {
+		if job.id == id {
+			q.pending = slices.Delete(q.pending, i, i+1)
+			return true
+		}
+	}
+
+	return false
+}
+
+// dispatch starts the queued jobs in order while there are free slots.
+// Must be called with `mu` held.
+func (q *downloadQueue) dispatch() {
+	remaining := q.pending[:0]
+	for _, job := range q.pending {
+		if job.paused || !q.hasSlot(job.provider) {
+			remaining = append(remaining, job)
+			continue
+		}
+
+		q.running++
+		q.byProvider[job.provider]++
+		go q.execute(job)
+	}
+
+	// Clearing the tail, so the started jobs can be garbage collected.
+	for i := len(remaining); i < len(q.pending); i++ {
+		q.pending[i] = nil
+	}
+	q.pending = remaining
+}
+
+func (q *downloadQueue) hasSlot(provider setting.Provider) bool {
+	if q.cfg.MaxConcurrent > 0 && q.running >= q.cfg.MaxConcurrent {
+		return false
+	}
+	if q.cfg.MaxPerProvider > 0 && q.byProvider[provider] >= q.cfg.MaxPerProvider {
+		return false
+	}
+
+	return true
+}
+
+// execute runs the job and frees its slot for the next one in the queue.
+func (q *downloadQueue) execute(job *queuedJob) {
+	defer func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		q.running--
+		q.byProvider[job.provider]--
+		q.dispatch()
+	}()
+
+	job.run()
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadQueue_Limits(t *testing.T) {
+	q := newDownloadQueue(QueueConfig{MaxConcurrent: 3, MaxPerProvider: 2})
+
+	var (
+		wg          sync.WaitGroup
+		mu          sync.Mutex
+		running     int
+		maxRunning  int
+		byProvider  = make(map[setting.Provider]int)
+		maxProvider int
+		completed   atomic.Int32
+	)
+	release := make(chan struct{})
+
+	run := func(p setting.Provider) func() {
+		return func() {
+			defer wg.Done()
+
+			mu.Lock()
+			running++
+			byProvider[p]++
+			maxRunning = max(maxRunning, running)
+			maxProvider = max(maxProvider, byProvider[p])
+			mu.Unlock()
+
+			<-release
+
+			mu.Lock()
+			running--
+			byProvider[p]--
+			mu.Unlock()
+			completed.Add(1)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		q.enqueue(fmt.Sprintf("a-%d", i), "a", false, run("a"))
+		q.enqueue(fmt.Sprintf("b-%d", i), "b", false, run("b"))
+	}
+
+	// Only the allowed number of jobs should start, the rest stay queued.
+	time.Sleep(50 * time.Millisecond)
+	q.mu.Lock()
+	assert.Equal(t, 3, q.running)
+	assert.Equal(t, 17, len(q.pending))
+	q.mu.Unlock()
+
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(20), completed.Load())
+	assert.LessOrEqual(t, maxRunning, 3)
+	assert.LessOrEqual(t, maxProvider, 2)
+}
@@ -2,174 +2,598 @@ package store
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/gofiber/fiber/v2/log"
+	"github.com/google/uuid"
 	"github.com/nilotpaul/go-downloader/service"
+	"github.com/nilotpaul/go-downloader/setting"
 	"github.com/nilotpaul/go-downloader/types"
+	"github.com/nilotpaul/go-downloader/util"
+	"golang.org/x/oauth2"
 )
 
+// Downloaded bytes are persisted at most once in this interval.
+const progressSaveInterval = 3 * time.Second
+
+// `downloadFunc` downloads a single job and reports its progress on `progChan`.
+// It returns nil without completing the download if `ctx` gets cancelled.
+type downloadFunc func(ctx context.Context, job *types.DownloadJob, ts oauth2.TokenSource, progChan chan<- types.Progress) error
+
+// `activeJob` is the in memory state of a queued, running or paused download.
+type activeJob struct {
+	job      *types.DownloadJob
+	progress types.Progress
+	// Cancels the job entirely.
+	cancel context.CancelFunc
+	// Stops the current run when the job gets paused, nil while it's not running.
+	stop   context.CancelFunc
+	paused bool
+}
+
+// `Downloader` holds the downloads of a single user, see `DownloadManager`.
+// Every download is identified by its job ID, so the same file can be downloaded
+// more than once. All the methods are safe for concurrent use.
 type Downloader struct {
-	progressChans      map[string]chan *types.Progress
-	ErrChans           map[string]chan error
-	FileIDs            []string
-	DestinationPath    string
-	PendingDownloads   map[string]*types.Progress
-	cancelFuncs        map[string]context.CancelFunc
-	pendingDownloadsMu sync.RWMutex
+	db        *sql.DB
+	userID    string
+	registry  *ProviderRegistry
+	queue     *downloadQueue
+	retry     RetryConfig
+	bandwidth *bandwidthLimiter
+	download  downloadFunc
+
+	mu sync.RWMutex
+	// Queued, running and paused downloads by job ID.
+	jobs map[string]*activeJob
+	// Job IDs in the order they were started.
+	order []string
+	// Errors of the failed downloads which haven't been sent to the client yet.
+	errs []types.DownloadError
+}
+
+// newDownloader creates a downloader for `userID` which tracks every download as a job in the database.
+// With a nil `db` the jobs are only kept in memory. The files are downloaded from the sources of `r`,
+// the downloads wait in `queue` until they can start.
+func newDownloader(db *sql.DB, userID string, r *ProviderRegistry, queue *downloadQueue, retry RetryConfig, bandwidth *bandwidthLimiter) *Downloader {
+	d := &Downloader{
+		db:        db,
+		userID:    userID,
+		registry:  r,
+		queue:     queue,
+		retry:     retry,
+		bandwidth: bandwidth,
+		jobs:      make(map[string]*activeJob),
+		order:     make([]string, 0),
+		errs:      make([]types.DownloadError, 0),
+	}
+	d.download = d.downloadFile
+
+	return d
+}
+
+// UserID returns the ID of the user whose downloads these are.
+func (d *Downloader) UserID() string {
+	return d.userID
 }
 
-func NewDownloader(fileIds []string, destinationPath string) *Downloader {
-	return &Downloader{
-		progressChans:    make(map[string]chan *types.Progress),
-		ErrChans:         make(map[string]chan error),
-		FileIDs:          fileIds,
-		DestinationPath:  destinationPath,
-		PendingDownloads: make(map[string]*types.Progress),
-		cancelFuncs:      make(map[string]context.CancelFunc),
+// downloadFile is the default `downloadFunc`, it downloads the job from the source of its provider.
+func (d *Downloader) downloadFile(ctx context.Context, job *types.DownloadJob, ts oauth2.TokenSource, progChan chan<- types.Progress) error {
+	src, err := d.registry.GetSource(job.Provider)
+	if err != nil {
+		return err
 	}
+
+	return service.DownloadFromSource(src, service.DownloaderConfig{
+		FileID:          job.FileID,
+		DestinationPath: job.DestinationPath,
+		FileName:        job.FileName,
+		Options: types.SourceOptions{
+			UserID:       job.UserID,
+			TokenSource:  ts,
+			AccountID:    job.AccountID,
+			Headers:      job.Headers,
+			ExportFormat: job.ExportFormat,
+		},
+		Limiter: d.bandwidth.forJob(job.ID),
+	}, progChan, ctx)
 }
 
-func (d *Downloader) StartDownload(ctx context.Context, accToken string, fileName string) error {
+// StartDownload creates a job for every file of `provider` and puts them in the download queue.
+// The files are saved in their `Dir` inside `destinationPath`, `opts` are kept with every job.
+// Every job is limited to `speedLimit` bytes per second, or the default per-job limit if it's 0.
+// It returns the IDs of the created jobs.
+func (d *Downloader) StartDownload(ctx context.Context, provider setting.Provider, destinationPath string, speedLimit int64, opts types.SourceOptions, files []types.SourceFile) ([]string, error) {
+	jobIDs := make([]string, 0, len(files))
+
 	// For every file
-	for _, fileID := range d.FileIDs {
-		// Making progress channel and storing it in the `progressChans` map.
-		progChan := make(chan *types.Progress)
-		d.progressChans[fileID] = progChan
-
-		// Making error channel and storing it in the `ErrChans` map.
-		errChan := make(chan error)
-		d.ErrChans[fileID] = errChan
-
-		// Making context for each file and storing it in `cancelFuncs` map.
-		downloadCtx, cancel := context.WithCancel(ctx)
-		d.cancelFuncs[fileID] = cancel
-
-		// Start multiple downloads in dedicated go routines
-		go func(fileID string) {
-			err := service.GDriveDownloader(service.DownloaderConfig{
-				FileID:          fileID,
-				DestinationPath: d.DestinationPath,
-				FileName:        fileName,
-				AccessToken:     accToken,
-			}, progChan, downloadCtx)
-
-			// For any errors in between download, they'll be sent to their respective error channel.
-			if err != nil {
-				log.Errorf("error downloading file %s: %v\n", fileID, err)
-				errChan <- err
-			}
-
-			// Closing the progress and error channel, deleting them from `progressChans` and `errorChans` map
-			// with it's progress status to mark the download as complete -> can be due
-			// to an error or successful completion.
-			d.cleanUp(fileID)
-		}(fileID)
-	}
-
-	// We create dedicated go routines to handle progress updates for each file.
-	for fileID, progChan := range d.progressChans {
-		go d.handleProgressUpdates(fileID, progChan)
+	for _, file := range files {
+		job := &types.DownloadJob{
+			ID:              uuid.NewString(),
+			UserID:          d.userID,
+			FileID:          file.ID,
+			Provider:        provider,
+			DestinationPath: filepath.Join(destinationPath, file.Dir),
+			ExportFormat:    opts.ExportFormat,
+			AccountID:       opts.AccountID,
+			Headers:         opts.Headers,
+			SpeedLimit:      speedLimit,
+			Status:          setting.StatusQueued,
+		}
+		if err := d.createJob(ctx, job, opts.TokenSource); err != nil {
+			return jobIDs, err
+		}
+		jobIDs = append(jobIDs, job.ID)
 	}
 
+	return jobIDs, nil
+}
+
+// createJob persists the job, so it can be picked up again after a restart, and starts it.
+func (d *Downloader) createJob(ctx context.Context, job *types.DownloadJob, ts oauth2.TokenSource) error {
+	if d.db != nil {
+		if _, err := service.CreateDownloadJob(d.db, job); err != nil {
+			return fmt.Errorf("failed to create the download job: %v", err)
+		}
+	}
+	d.startJob(ctx, job, ts)
+
 	return nil
 }
 
-// handleProgressUpdates takes a `fileID` and `progChan`, ranges over the channel itself
-// and sets it's progress continuously.
-func (d *Downloader) handleProgressUpdates(fileID string, progChan chan *types.Progress) {
+// startJob puts a single job in the download queue, it's downloaded in a
+// dedicated go routine once the queue has a free slot. A paused job keeps
+// its place in the queue until it's resumed.
+func (d *Downloader) startJob(ctx context.Context, job *types.DownloadJob, ts oauth2.TokenSource) {
+	// Making context for each job, so it can be cancelled on its own.
+	downloadCtx, cancel := context.WithCancel(ctx)
+
+	// Until the download starts, the job is shown as queued.
+	paused := job.Status == setting.StatusPaused
+	status := setting.StatusQueued
+	if paused {
+		status = setting.StatusPaused
+	}
+
+	d.mu.Lock()
+	d.jobs[job.ID] = &activeJob{
+		job:    job,
+		cancel: cancel,
+		paused: paused,
+		progress: types.Progress{
+			JobID:      job.ID,
+			FileID:     job.FileID,
+			Status:     status,
+			Total:      job.TotalBytes,
+			Downloaded: job.BytesDone,
+		},
+	}
+	d.order = append(d.order, job.ID)
+	d.mu.Unlock()
+	d.bandwidth.setJobLimit(job.ID, job.SpeedLimit)
+
+	d.queue.enqueue(job.ID, job.Provider, paused, func() {
+		d.run(downloadCtx, job, ts)
+	})
+}
+
+// run downloads the job and removes its state once it's done -> can be due
+// to an error, a cancellation or successful completion. A paused job goes
+// back in the queue instead.
+func (d *Downloader) run(ctx context.Context, job *types.DownloadJob, ts oauth2.TokenSource) {
+	// The job was cancelled while waiting in the queue.
+	if ctx.Err() != nil {
+		d.updateJobStatus(job.ID, setting.StatusCancelled, "")
+		d.cleanUp(job.ID)
+		return
+	}
+
+	// Every run gets its own context, so pausing only stops this run.
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	// The job was paused right before it started.
+	if !d.startRun(job.ID, stop) {
+		d.requeue(ctx, job, ts)
+		return
+	}
+	d.updateJobStatus(job.ID, setting.StatusRunning, "")
+
+	// We create a dedicated go routine to handle progress updates for the job.
+	progChan := make(chan types.Progress)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.handleProgressUpdates(job.ID, progChan)
+	}()
+
+	var (
+		err             error
+		checksumRetries int
+		attempt         int
+	)
+	for {
+		err = d.download(runCtx, job, ts, progChan)
+
+		if errors.Is(err, service.ErrChecksumMismatch) && checksumRetries < setting.MaxChecksumRetries {
+			checksumRetries++
+
+			// The corrupt data is already removed, so the file is downloaded again from scratch.
+			log.Warnf("retrying job %s (checksum retry %d): %v", job.ID, checksumRetries, err)
+			d.updateJobStatus(job.ID, setting.StatusCorrupt, err.Error())
+			d.setProgressStatus(job.ID, setting.StatusCorrupt)
+			d.updateJobStatus(job.ID, setting.StatusRunning, "")
+			d.setProgressStatus(job.ID, setting.StatusRunning)
+			continue
+		}
+
+		attempt++
+		if !util.IsTransientError(err) || attempt >= d.retry.MaxAttempts {
+			break
+		}
+
+		// The partial data is kept, so the next attempt continues from where this one stopped.
+		delay := util.Backoff(attempt, d.retry.BaseDelay, d.retry.MaxDelay)
+		log.Warnf("retrying job %s in %s (attempt %d of %d): %v", job.ID, delay, attempt+1, d.retry.MaxAttempts, err)
+		d.updateJobStatus(job.ID, setting.StatusRunning, err.Error())
+
+		// Cancelled or paused while waiting, same as stopping the download itself.
+		if !sleepWithContext(runCtx, delay) {
+			err = nil
+			break
+		}
+	}
+
+	// Waiting for the last progress update before cleaning up.
+	close(progChan)
+	<-done
+
+	switch {
+	case ctx.Err() != nil:
+		d.updateJobStatus(job.ID, setting.StatusCancelled, "")
+	// Paused, the `.part` file is kept, so the next run continues from its offset.
+	case runCtx.Err() != nil:
+		d.requeue(ctx, job, ts)
+		return
+	// Errors are kept until the client receives them.
+	case err != nil:
+		log.Errorf("error downloading file %s (job %s): %v\n", job.FileID, job.ID, err)
+		d.updateJobStatus(job.ID, setting.StatusFailed, err.Error())
+		d.addError(types.DownloadError{JobID: job.ID, FileID: job.FileID, ErrMsg: err.Error()})
+	default:
+		// Keeping the verified checksum in the job history.
+		if prog, err := d.GetProgress(job.ID); err == nil && len(prog.MD5Checksum) != 0 {
+			d.updateJobChecksum(job.ID, prog.MD5Checksum)
+		}
+		d.updateJobStatus(job.ID, setting.StatusCompleted, "")
+	}
+
+	d.cleanUp(job.ID)
+}
+
+// startRun marks the job as running, `stop` is called when the job gets paused.
+// It returns false if the job is already paused.
+func (d *Downloader) startRun(jobID string, stop context.CancelFunc) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	j, ok := d.jobs[jobID]
+	if !ok || j.paused {
+		return false
+	}
+	j.stop = stop
+	j.progress.Status = setting.StatusRunning
+
+	return true
+}
+
+// requeue puts a job which was paused while running back at the front of the queue,
+// so it keeps its place. It's cleaned up instead if it got cancelled in the meantime.
+func (d *Downloader) requeue(ctx context.Context, job *types.DownloadJob, ts oauth2.TokenSource) {
+	d.mu.Lock()
+	j, ok := d.jobs[job.ID]
+	if !ok {
+		d.mu.Unlock()
+		return
+	}
+	if ctx.Err() != nil {
+		d.removeJob(job.ID)
+		d.mu.Unlock()
+		d.updateJobStatus(job.ID, setting.StatusCancelled, "")
+		return
+	}
+
+	// It might have been resumed while it was stopping.
+	j.stop = nil
+	status := setting.StatusQueued
+	if j.paused {
+		status = setting.StatusPaused
+	}
+	j.progress.Status = status
+
+	d.queue.requeue(job.ID, job.Provider, j.paused, func() {
+		d.run(ctx, job, ts)
+	})
+	d.mu.Unlock()
+
+	d.updateJobStatus(job.ID, status, "")
+}
+
+// sleepWithContext waits for `delay`, it returns false if `ctx` gets cancelled in the meantime.
+func sleepWithContext(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// handleProgressUpdates ranges over `progChan` and sets the job's progress continuously.
+// The downloaded bytes are saved in the job periodically.
+func (d *Downloader) handleProgressUpdates(jobID string, progChan <-chan types.Progress) {
+	var lastSaved time.Time
 	for prog := range progChan {
-		d.SetProgress(fileID, prog)
+		if err := d.SetProgress(jobID, &prog); err != nil {
+			continue
+		}
+
+		if time.Since(lastSaved) >= progressSaveInterval || prog.Complete {
+			d.updateJobProgress(jobID, prog.Downloaded, prog.Total)
+			lastSaved = time.Now()
+		}
 	}
 }
 
-// GetPendingDownloads a slice of `progress` which is a pointer, it ranges
-// over the `pendingsDownloads` map and retrieves all the downloads from `PendingDownloads` map
+// updateJobStatus persists the job status, errors are only logged
+// as they shouldn't interrupt the download itself.
+func (d *Downloader) updateJobStatus(jobID string, status setting.DownloadStatus, errMsg string) {
+	if d.db == nil || len(jobID) == 0 {
+		return
+	}
+	if err := service.UpdateDownloadJobStatus(d.db, jobID, status, errMsg); err != nil {
+		log.Errorf("failed to update the status of job %s: %v", jobID, err)
+	}
+}
+
+func (d *Downloader) updateJobProgress(jobID string, bytesDone int64, totalBytes int64) {
+	if d.db == nil || len(jobID) == 0 {
+		return
+	}
+	if err := service.UpdateDownloadJobProgress(d.db, jobID, bytesDone, totalBytes); err != nil {
+		log.Errorf("failed to update the progress of job %s: %v", jobID, err)
+	}
+}
+
+func (d *Downloader) updateJobChecksum(jobID string, md5Checksum string) {
+	if d.db == nil || len(jobID) == 0 {
+		return
+	}
+	if err := service.UpdateDownloadJobChecksum(d.db, jobID, md5Checksum); err != nil {
+		log.Errorf("failed to update the checksum of job %s: %v", jobID, err)
+	}
+}
+
+// GetPendingDownloads returns a copy of the progress of every queued
+// and running download, in the order they were started.
 func (d *Downloader) GetPendingDownloads() ([]*types.Progress, error) {
-	d.pendingDownloadsMu.Lock()
-	defer d.pendingDownloadsMu.Unlock()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 
-	if d.PendingDownloads == nil {
-		return nil, fmt.Errorf("no ongoing downloads")
+	pendingDownloads := make([]*types.Progress, 0, len(d.order))
+	for _, jobID := range d.order {
+		prog := d.jobs[jobID].progress
+		pendingDownloads = append(pendingDownloads, &prog)
 	}
 
-	var pendingsDownloads []*types.Progress
-	for _, prog := range d.PendingDownloads {
-		pendingsDownloads = append(pendingsDownloads, prog)
+	return pendingDownloads, nil
+}
+
+// GetProgress returns a copy of the current progress of a job.
+func (d *Downloader) GetProgress(jobID string) (*types.Progress, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	j, ok := d.jobs[jobID]
+	if !ok {
+		return nil, fmt.Errorf("no ongoing download for job %s", jobID)
 	}
+	prog := j.progress
 
-	return pendingsDownloads, nil
+	return &prog, nil
 }
 
-// GetProgress retrieves the current progress for a file by it's fileID.
-func (d *Downloader) GetProgress(fileID string) (*types.Progress, error) {
-	d.pendingDownloadsMu.Lock()
-	defer d.pendingDownloadsMu.Unlock()
+// SetProgress sets the progress of a job, the job ID and status are kept as is.
+func (d *Downloader) SetProgress(jobID string, prog *types.Progress) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	if d.PendingDownloads == nil {
-		return nil, fmt.Errorf("no ongoing downloads")
+	j, ok := d.jobs[jobID]
+	if !ok {
+		return fmt.Errorf("no ongoing download for job %s", jobID)
 	}
 
-	prog := d.PendingDownloads[fileID]
+	status := j.progress.Status
+	j.progress = *prog
+	j.progress.JobID = jobID
+	j.progress.Status = status
 
-	return prog, nil
+	return nil
 }
 
-// SetProgress sets the progress for a file by it's fileID.
-func (d *Downloader) SetProgress(fileID string, prog *types.Progress) {
-	d.pendingDownloadsMu.Lock()
-	defer d.pendingDownloadsMu.Unlock()
+// setProgressStatus changes the status shown in the progress of a job.
+func (d *Downloader) setProgressStatus(jobID string, status setting.DownloadStatus) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	// If the progress already exists in the `PendingDownloads` map then update it.
-	if existingProg, ok := d.PendingDownloads[fileID]; ok {
-		existingProg.Complete = prog.Complete
-		existingProg.Current = prog.Current
-		existingProg.EndTime = prog.EndTime
-	} else {
-		// If the progress doesn't exist in the `PendingDownloads` map then create it.
-		d.PendingDownloads[fileID] = prog
+	if j, ok := d.jobs[jobID]; ok {
+		j.progress.Status = status
 	}
 }
 
-// DeleteProgress removes the progress for a file from `PendingDownloads` map.
-func (d *Downloader) DeleteProgress(fileID string) {
-	d.pendingDownloadsMu.Lock()
-	defer d.pendingDownloadsMu.Unlock()
+// PauseDownload stops a running job and keeps its partial data, a queued job keeps its
+// place in the queue. The job doesn't start again until it's resumed.
+func (d *Downloader) PauseDownload(jobID string) error {
+	d.mu.Lock()
+	j, ok := d.jobs[jobID]
+	if !ok {
+		d.mu.Unlock()
+		return fmt.Errorf("no ongoing download for job %s", jobID)
+	}
+	if j.paused {
+		d.mu.Unlock()
+		return nil
+	}
+
+	j.paused = true
+	j.progress.Status = setting.StatusPaused
+
+	// A running job is put back in the queue by `run` once it stops.
+	if !d.queue.setPaused(jobID, true) && j.stop != nil {
+		j.stop()
+	}
+	d.mu.Unlock()
 
-	delete(d.PendingDownloads, fileID)
+	// Persisted without holding the lock, like the status changes of `run`.
+	d.updateJobStatus(jobID, setting.StatusPaused, "")
+
+	return nil
 }
 
-// CancelDownload gets the cancel function for the download context from
-// `cancelFuncs` map by `fileID` and executes it which stops the ongoing download.
-func (d *Downloader) CancelDownload(fileID string) error {
-	cancel, ok := d.cancelFuncs[fileID]
+// ResumeDownload lets a paused job start again from where it stopped,
+// as soon as the queue has a free slot for it.
+func (d *Downloader) ResumeDownload(jobID string) error {
+	d.mu.Lock()
+	j, ok := d.jobs[jobID]
 	if !ok {
+		d.mu.Unlock()
+		return fmt.Errorf("no ongoing download for job %s", jobID)
+	}
+	if !j.paused {
+		d.mu.Unlock()
+		return nil
+	}
+
+	j.paused = false
+	j.progress.Status = setting.StatusQueued
+
+	// If the job is still stopping, `run` requeues it as not paused.
+	d.queue.setPaused(jobID, false)
+	d.mu.Unlock()
+
+	d.updateJobStatus(jobID, setting.StatusQueued, "")
+
+	return nil
+}
+
+// SetSpeedLimit changes the limit of a queued, running or paused job, a running job picks
+// it up on its next read. A limit less than 1 goes back to the default per-job limit.
+func (d *Downloader) SetSpeedLimit(jobID string, limit int64) error {
+	d.mu.RLock()
+	_, ok := d.jobs[jobID]
+	d.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no ongoing download for job %s", jobID)
+	}
+	d.bandwidth.setJobLimit(jobID, limit)
+
+	if d.db == nil {
+		return nil
+	}
+	if limit < 0 {
+		limit = 0
+	}
+
+	return service.UpdateDownloadJobSpeedLimit(d.db, jobID, limit)
+}
+
+// CancelDownload cancels the context of a job which stops the ongoing download.
+// A queued or paused job is removed from the queue right away.
+func (d *Downloader) CancelDownload(jobID string) error {
+	d.mu.Lock()
+	j, ok := d.jobs[jobID]
+	if !ok {
+		d.mu.Unlock()
 		return fmt.Errorf("no downloads found to cancel")
 	}
-	cancel()
+	j.cancel()
+
+	// A running job is cleaned up by `run` once it stops.
+	removed := d.queue.remove(jobID)
+	if removed {
+		d.removeJob(jobID)
+	}
+	d.mu.Unlock()
+
+	if removed {
+		d.updateJobStatus(jobID, setting.StatusCancelled, "")
+	}
 
 	return nil
 }
 
-// CancelAllDownloads ranges over the `cancelFuncs` and executes each `cancel` functions
-// to cancel all ongoing downloads.
+// CancelAllDownloads cancels every queued, running and paused download.
 func (d *Downloader) CancelAllDownloads() {
-	for _, cancel := range d.cancelFuncs {
-		cancel()
+	d.mu.RLock()
+	jobIDs := make([]string, 0, len(d.jobs))
+	for jobID := range d.jobs {
+		jobIDs = append(jobIDs, jobID)
+	}
+	d.mu.RUnlock()
+
+	for _, jobID := range jobIDs {
+		d.CancelDownload(jobID)
 	}
 }
 
-// cleanUp removes the state for a file, progress and error channels are closed and deleted,
-// progress status is also removed.
-func (d *Downloader) cleanUp(fileID string) {
-	close(d.progressChans[fileID])
-	close(d.ErrChans[fileID])
+// TakeErrors returns the errors of the failed downloads and clears them,
+// so every error is only sent once.
+func (d *Downloader) TakeErrors() []types.DownloadError {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	delete(d.progressChans, fileID)
-	delete(d.ErrChans, fileID)
+	errs := d.errs
+	d.errs = make([]types.DownloadError, 0)
 
-	d.pendingDownloadsMu.Lock()
-	delete(d.PendingDownloads, fileID)
-	d.pendingDownloadsMu.Unlock()
+	return errs
+}
+
+func (d *Downloader) addError(err types.DownloadError) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.errs = append(d.errs, err)
+}
+
+// cleanUp removes the state of a job once it's no longer queued, running or paused.
+func (d *Downloader) cleanUp(jobID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.removeJob(jobID)
+}
+
+// removeJob must be called with `mu` held.
+func (d *Downloader) removeJob(jobID string) {
+	if j, ok := d.jobs[jobID]; ok {
+		// Releasing the resources of the job's context.
+		j.cancel()
+	}
+	delete(d.jobs, jobID)
+	d.bandwidth.setJobLimit(jobID, 0)
+
+	for i, id := range d.order {
+		if id == jobID {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
 }
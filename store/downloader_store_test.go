@@ -0,0 +1,360 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/service"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// fakeDownload reports progress in 10 steps, it fails for the file ID "fail"
+// and blocks until cancelled for the file ID "block".
+func fakeDownload(ctx context.Context, job *types.DownloadJob, _ oauth2.TokenSource, progChan chan<- types.Progress) error {
+	if job.FileID == "fail" {
+		return fmt.Errorf("download failed")
+	}
+
+	prog := types.Progress{FileID: job.FileID, Total: 10, StartTime: time.Now()}
+	for i := 1; i <= 10; i++ {
+		if job.FileID == "block" {
+			<-ctx.Done()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Millisecond):
+		}
+
+		prog.Downloaded = int64(i)
+		prog.Current = i * 10
+		progChan <- prog
+	}
+
+	prog.Complete = true
+	progChan <- prog
+
+	return nil
+}
+
+// Retries quickly, so the tests don't have to wait for the backoff.
+var testRetryConfig = RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+var testSourceOptions = types.SourceOptions{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}), ExportFormat: setting.ExportOffice}
+
+func newTestDownloader(m *DownloadManager, userID string) *Downloader {
+	d := m.GetDownloader(userID)
+	d.download = fakeDownload
+	return d
+}
+
+func waitForDownloads(t *testing.T, d *Downloader) {
+	assert.Eventually(t, func() bool {
+		pendings, _ := d.GetPendingDownloads()
+		return len(pendings) == 0
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestDownloader_ConcurrentDownloads(t *testing.T) {
+	m := NewDownloadManager(nil, NewProviderRegistry(), QueueConfig{MaxConcurrent: 3, MaxPerProvider: 2}, testRetryConfig, types.BandwidthConfig{})
+	d := newTestDownloader(m, "user")
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		jobIDs []string
+	)
+
+	// Starting downloads from multiple requests at once, the same file
+	// is downloaded to different destinations.
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ids, err := d.StartDownload(context.Background(), setting.GoogleProvider, fmt.Sprintf("dest-%d", i), 0, testSourceOptions, []types.SourceFile{
+				{ID: "same-file"},
+				{ID: fmt.Sprintf("file-%d", i)},
+			})
+			assert.NoError(t, err)
+
+			mu.Lock()
+			jobIDs = append(jobIDs, ids...)
+			mu.Unlock()
+		}(i)
+	}
+
+	// Reading the state while the downloads are running.
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			pendings, _ := d.GetPendingDownloads()
+			for _, p := range pendings {
+				d.GetProgress(p.JobID)
+			}
+			m.GetAllPendingDownloads()
+			d.TakeErrors()
+		}
+	}()
+
+	wg.Wait()
+	waitForDownloads(t, d)
+	close(stop)
+	readers.Wait()
+
+	// Every download got its own job.
+	assert.Len(t, jobIDs, 20)
+	unique := make(map[string]struct{})
+	for _, id := range jobIDs {
+		unique[id] = struct{}{}
+	}
+	assert.Len(t, unique, 20)
+	assert.Empty(t, d.TakeErrors())
+}
+
+func TestDownloader_Cancel(t *testing.T) {
+	m := NewDownloadManager(nil, NewProviderRegistry(), QueueConfig{MaxConcurrent: 1}, testRetryConfig, types.BandwidthConfig{})
+	d := newTestDownloader(m, "user")
+
+	ids, err := d.StartDownload(context.Background(), setting.GoogleProvider, "dest", 0, testSourceOptions, []types.SourceFile{
+		{ID: "block"},
+		{ID: "block"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, ids, 2)
+
+	// The first one is running while the second one waits in the queue.
+	assert.Eventually(t, func() bool {
+		prog, err := d.GetProgress(ids[0])
+		return err == nil && prog.Status == setting.StatusRunning
+	}, time.Second, time.Millisecond)
+	prog, err := d.GetProgress(ids[1])
+	assert.NoError(t, err)
+	assert.Equal(t, setting.StatusQueued, prog.Status)
+
+	// Cancelling the queued job doesn't touch the running one.
+	assert.NoError(t, d.CancelDownload(ids[1]))
+	prog, err = d.GetProgress(ids[0])
+	assert.NoError(t, err)
+	assert.Equal(t, setting.StatusRunning, prog.Status)
+
+	d.CancelAllDownloads()
+	waitForDownloads(t, d)
+
+	assert.Error(t, d.CancelDownload(ids[0]))
+	assert.Empty(t, d.TakeErrors())
+}
+
+func TestDownloader_Errors(t *testing.T) {
+	m := NewDownloadManager(nil, NewProviderRegistry(), QueueConfig{}, testRetryConfig, types.BandwidthConfig{})
+	d := newTestDownloader(m, "user")
+
+	ids, err := d.StartDownload(context.Background(), setting.GoogleProvider, "dest", 0, testSourceOptions, []types.SourceFile{
+		{ID: "fail"},
+		{ID: "ok"},
+	})
+	assert.NoError(t, err)
+	waitForDownloads(t, d)
+
+	// Errors are only returned once.
+	errs := d.TakeErrors()
+	assert.Equal(t, []types.DownloadError{{JobID: ids[0], FileID: "fail", ErrMsg: "download failed"}}, errs)
+	assert.Empty(t, d.TakeErrors())
+}
+
+func TestDownloadManager_PerUser(t *testing.T) {
+	m := NewDownloadManager(nil, NewProviderRegistry(), QueueConfig{}, testRetryConfig, types.BandwidthConfig{})
+	a := newTestDownloader(m, "a")
+	b := newTestDownloader(m, "b")
+	assert.Same(t, a, m.GetDownloader("a"))
+
+	ids, err := a.StartDownload(context.Background(), setting.GoogleProvider, "dest", 0, testSourceOptions, []types.SourceFile{{ID: "block"}})
+	assert.NoError(t, err)
+
+	// Users can't see or cancel the downloads of others.
+	_, err = b.GetProgress(ids[0])
+	assert.Error(t, err)
+	assert.Error(t, b.CancelDownload(ids[0]))
+
+	all := m.GetAllPendingDownloads()
+	assert.Len(t, all["a"], 1)
+	assert.Empty(t, all["b"])
+
+	a.CancelAllDownloads()
+	waitForDownloads(t, a)
+}
+
+func TestDownloadManager_SetJobSpeedLimit(t *testing.T) {
+	m := NewDownloadManager(nil, NewProviderRegistry(), QueueConfig{}, testRetryConfig, types.BandwidthConfig{PerJobLimit: 1 << 30})
+	d := newTestDownloader(m, "user")
+
+	ids, err := d.StartDownload(context.Background(), setting.GoogleProvider, "dest", 1<<20, testSourceOptions, []types.SourceFile{{ID: "block"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1<<20), m.bandwidth.jobLimits[ids[0]])
+
+	// The limit of a running job is changed by its ID, 0 goes back to the default.
+	assert.NoError(t, m.SetJobSpeedLimit(ids[0], 2<<20))
+	assert.Equal(t, int64(2<<20), m.bandwidth.jobLimits[ids[0]])
+	assert.NoError(t, m.SetJobSpeedLimit(ids[0], 0))
+	assert.NotContains(t, m.bandwidth.jobLimits, ids[0])
+	assert.Error(t, m.SetJobSpeedLimit("missing", 1))
+
+	assert.NoError(t, m.SetJobSpeedLimit(ids[0], 1<<20))
+	d.CancelAllDownloads()
+	waitForDownloads(t, d)
+	assert.Empty(t, m.bandwidth.jobLimits)
+}
+
+func TestDownloader_RetryOnChecksumMismatch(t *testing.T) {
+	m := NewDownloadManager(nil, NewProviderRegistry(), QueueConfig{}, testRetryConfig, types.BandwidthConfig{})
+	d := m.GetDownloader("user")
+
+	var attempts atomic.Int32
+	d.download = func(ctx context.Context, job *types.DownloadJob, _ oauth2.TokenSource, progChan chan<- types.Progress) error {
+		// Always corrupt for "bad", corrupt only on the first attempt otherwise.
+		if attempts.Add(1) == 1 || job.FileID == "bad" {
+			return fmt.Errorf("%w for %s", service.ErrChecksumMismatch, job.FileID)
+		}
+		progChan <- types.Progress{FileID: job.FileID, Complete: true, MD5Checksum: "abc"}
+		return nil
+	}
+
+	_, err := d.StartDownload(context.Background(), setting.GoogleProvider, "dest", 0, testSourceOptions, []types.SourceFile{{ID: "flaky"}})
+	assert.NoError(t, err)
+	waitForDownloads(t, d)
+	assert.Equal(t, int32(2), attempts.Load())
+	assert.Empty(t, d.TakeErrors())
+
+	// Gives up after the maximum number of retries.
+	attempts.Store(1)
+	_, err = d.StartDownload(context.Background(), setting.GoogleProvider, "dest", 0, testSourceOptions, []types.SourceFile{{ID: "bad"}})
+	assert.NoError(t, err)
+	waitForDownloads(t, d)
+	assert.Equal(t, int32(1+setting.MaxChecksumRetries+1), attempts.Load())
+	errs := d.TakeErrors()
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0].ErrMsg, "checksum mismatch")
+}
+
+func TestDownloader_RetryOnTransientError(t *testing.T) {
+	m := NewDownloadManager(nil, NewProviderRegistry(), QueueConfig{}, testRetryConfig, types.BandwidthConfig{})
+	d := m.GetDownloader("user")
+
+	var attempts atomic.Int32
+	d.download = func(ctx context.Context, job *types.DownloadJob, _ oauth2.TokenSource, progChan chan<- types.Progress) error {
+		attempt := attempts.Add(1)
+		switch {
+		case job.FileID == "missing":
+			return fmt.Errorf("failed to download the file: %w", &googleapi.Error{Code: http.StatusNotFound})
+		case job.FileID == "reset" || attempt == 1:
+			return fmt.Errorf("failed to download the file: %w", syscall.ECONNRESET)
+		}
+		progChan <- types.Progress{FileID: job.FileID, Complete: true}
+		return nil
+	}
+
+	// Succeeds on the second attempt.
+	_, err := d.StartDownload(context.Background(), setting.GoogleProvider, "dest", 0, testSourceOptions, []types.SourceFile{{ID: "flaky"}})
+	assert.NoError(t, err)
+	waitForDownloads(t, d)
+	assert.Equal(t, int32(2), attempts.Load())
+	assert.Empty(t, d.TakeErrors())
+
+	// Gives up after the maximum number of attempts.
+	attempts.Store(0)
+	_, err = d.StartDownload(context.Background(), setting.GoogleProvider, "dest", 0, testSourceOptions, []types.SourceFile{{ID: "reset"}})
+	assert.NoError(t, err)
+	waitForDownloads(t, d)
+	assert.Equal(t, int32(testRetryConfig.MaxAttempts), attempts.Load())
+	assert.Len(t, d.TakeErrors(), 1)
+
+	// Permanent errors aren't retried.
+	attempts.Store(1)
+	_, err = d.StartDownload(context.Background(), setting.GoogleProvider, "dest", 0, testSourceOptions, []types.SourceFile{{ID: "missing"}})
+	assert.NoError(t, err)
+	waitForDownloads(t, d)
+	assert.Equal(t, int32(2), attempts.Load())
+	assert.Len(t, d.TakeErrors(), 1)
+}
+
+func TestDownloader_PauseResume(t *testing.T) {
+	m := NewDownloadManager(nil, NewProviderRegistry(), QueueConfig{MaxConcurrent: 1}, testRetryConfig, types.BandwidthConfig{})
+	d := m.GetDownloader("user")
+
+	var (
+		mu   sync.Mutex
+		runs = make(map[string]int)
+	)
+	release := make(chan struct{})
+	d.download = func(ctx context.Context, job *types.DownloadJob, _ oauth2.TokenSource, progChan chan<- types.Progress) error {
+		mu.Lock()
+		runs[job.FileID]++
+		mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+		case <-release:
+			progChan <- types.Progress{FileID: job.FileID, Complete: true}
+		}
+		return nil
+	}
+	status := func(jobID string) setting.DownloadStatus {
+		prog, err := d.GetProgress(jobID)
+		if err != nil {
+			return ""
+		}
+		return prog.Status
+	}
+
+	ids, err := d.StartDownload(context.Background(), setting.GoogleProvider, "dest", 0, testSourceOptions, []types.SourceFile{{ID: "a"}, {ID: "b"}, {ID: "c"}})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return status(ids[0]) == setting.StatusRunning }, time.Second, time.Millisecond)
+
+	// Pausing the running and a queued job, the next one in the queue starts instead.
+	assert.NoError(t, d.PauseDownload(ids[1]))
+	assert.NoError(t, d.PauseDownload(ids[0]))
+	assert.Eventually(t, func() bool { return status(ids[2]) == setting.StatusRunning }, time.Second, time.Millisecond)
+	assert.Equal(t, setting.StatusPaused, status(ids[0]))
+	assert.Equal(t, setting.StatusPaused, status(ids[1]))
+
+	// Cancelling a paused job removes it right away.
+	assert.NoError(t, d.CancelDownload(ids[1]))
+	assert.Equal(t, setting.DownloadStatus(""), status(ids[1]))
+
+	// Resumed jobs keep their place, so the paused job runs before the queued ones.
+	ids2, err := d.StartDownload(context.Background(), setting.GoogleProvider, "dest", 0, testSourceOptions, []types.SourceFile{{ID: "d"}})
+	assert.NoError(t, err)
+	assert.NoError(t, d.ResumeDownload(ids[0]))
+	assert.NoError(t, d.PauseDownload(ids[2]))
+	assert.Eventually(t, func() bool { return status(ids[0]) == setting.StatusRunning }, time.Second, time.Millisecond)
+	assert.Equal(t, setting.StatusQueued, status(ids2[0]))
+
+	assert.NoError(t, d.ResumeDownload(ids[2]))
+	close(release)
+	waitForDownloads(t, d)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]int{"a": 2, "c": 2, "d": 1}, runs)
+	assert.Empty(t, d.TakeErrors())
+}
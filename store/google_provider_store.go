@@ -3,30 +3,35 @@ package store
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/nilotpaul/go-downloader/config"
 	"github.com/nilotpaul/go-downloader/service"
+	"github.com/nilotpaul/go-downloader/setting"
 	"github.com/nilotpaul/go-downloader/types"
 	"github.com/nilotpaul/go-downloader/util"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
+// `GoogleProvider` signs users in with Google and hands out the tokens of their Google accounts.
+// It's shared by every user, so the tokens are always loaded from the database.
 type GoogleProvider struct {
-	Config     *oauth2.Config
-	db         *sql.DB
-	env        config.EnvConfig
-	Token      *oauth2.Token
-	HttpClient *http.Client
+	Config *oauth2.Config
+	// Callback of the consent page for linking another account to a signed in user.
+	linkRedirectURL string
+	db              *sql.DB
+	env             config.EnvConfig
 }
 
 type googleProviderConfig struct {
 	googleClientID     string
 	googleClientSecret string
 	googleRedirectURL  string
+	linkRedirectURL    string
 }
 
 var scopes = []string{
@@ -44,18 +49,18 @@ func NewGoogleProvider(cfg googleProviderConfig, db *sql.DB, env config.EnvConfi
 	}
 
 	return &GoogleProvider{
-		Config: config,
-		db:     db,
-		env:    env,
+		Config:          config,
+		linkRedirectURL: cfg.linkRedirectURL,
+		db:              db,
+		env:             env,
 	}
 }
 
 // `Authenticate` exchanges the authorization code for an access token.
-func (g *GoogleProvider) Authenticate(authCode string) error {
-	ctx := context.Background()
-	token, err := g.Config.Exchange(ctx, authCode, oauth2.ApprovalForce)
+func (g *GoogleProvider) Authenticate(ctx context.Context, authCode string, verifier string) (*oauth2.Token, error) {
+	token, err := g.Config.Exchange(ctx, authCode, oauth2.ApprovalForce, oauth2.VerifierOption(verifier))
 	if err != nil {
-		return util.NewAppError(
+		return nil, util.NewAppError(
 			http.StatusInternalServerError,
 			"failed to authenticate",
 			"NewGoogleProvider, Authenticate() error: ",
@@ -64,7 +69,7 @@ func (g *GoogleProvider) Authenticate(authCode string) error {
 	}
 
 	if token == nil || !token.Valid() {
-		return util.NewAppError(
+		return nil, util.NewAppError(
 			http.StatusBadRequest,
 			"invalid oauth token",
 			"NewGoogleProvider, Authenticate() error: ",
@@ -72,63 +77,39 @@ func (g *GoogleProvider) Authenticate(authCode string) error {
 		)
 	}
 
-	// Updating the `GoogleProvider` struct with the token and a new HTTP client.
-	g.Token = token
-	g.HttpClient = g.Config.Client(ctx, token)
-
-	return nil
+	return token, nil
 }
 
-// A public method which returns the access token.
-func (g *GoogleProvider) GetAccessToken() string {
-	if g.Token == nil {
-		return ""
-	}
-	return g.Token.AccessToken
-}
-
-// A public method which returns the refresh token.
-func (g *GoogleProvider) GetRefreshToken() string {
-	if g.Token == nil {
-		return ""
+// `RefreshToken` takes `userID` and `force` to generate a new access token from the refresh token
+// of the user's primary account, the new tokens are saved in the database.
+func (g *GoogleProvider) RefreshToken(userID string, force bool) (*oauth2.Token, error) {
+	acc, err := service.GetAccountByUserID(g.db, userID)
+	if err != nil {
+		return nil, util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to retrieve the account",
+			"NewGoogleProvider, RefreshToken() error: ",
+			err,
+		)
 	}
-	return g.Token.RefreshToken
-}
-
-// A public method which returns the access token validity.
-func (g *GoogleProvider) IsTokenValid() bool {
-	return g.Token.Valid()
-}
-
-// `RefreshToken` takes `userID` and `force` to generate a new access token from the refresh token stored
-// in the database and updates the `GoogleProvider` struct with the new configuration.
-func (g *GoogleProvider) RefreshToken(c *fiber.Ctx, userID string, force bool) (*oauth2.Token, error) {
-	ctx := context.Background()
-
-	// If the token is already nil, it means there wasn't a session to begin with.
-	if g.Token == nil {
+	// There wasn't a session to begin with.
+	if len(acc.ID) == 0 {
 		return nil, util.NewAppError(
 			http.StatusNotFound,
 			"no oauth token found",
 			"NewGoogleProvider, RefreshToken() error",
 		)
 	}
+
+	token := acc.OAuthToken()
 	// If force is true, we refresh the token even if it's still valid.
 	// Bug: This has to be done, bcz sometimes the the Google Drive API
 	// returns `Unauthorized` even when the token is still valid.
 	// Refer to `https://github.com/nilotpaul/go-downloader/issues/1`.
 	if force {
-		g.Token.Expiry = time.Now().AddDate(-100, 0, 0)
-	}
-	tokenSrc := g.Config.TokenSource(ctx, g.Token)
-	if tokenSrc == nil {
-		return nil, util.NewAppError(
-			http.StatusInternalServerError,
-			"failed to generate refresh token",
-			"NewGoogleProvider, RefreshToken() error",
-		)
+		token.Expiry = time.Now().AddDate(-100, 0, 0)
 	}
-	newToken, err := tokenSrc.Token()
+	ts, err := g.accountTokenSource(acc.ID, token)
 	if err != nil {
 		return nil, util.NewAppError(
 			http.StatusInternalServerError,
@@ -138,39 +119,21 @@ func (g *GoogleProvider) RefreshToken(c *fiber.Ctx, userID string, force bool) (
 		)
 	}
 
-	// Updating the `GoogleProvider` struct with the token and a new HTTP client.
-	g.Token = newToken
-	g.HttpClient = g.Config.Client(ctx, newToken)
-
-	// Updating the database with new tokens.
-	err = service.UpdateAccountByUserID(g.db, userID, &types.GoogleAccount{
-		AccessToken:  g.Token.AccessToken,
-		RefreshToken: g.Token.RefreshToken,
-		TokenType:    g.Token.TokenType,
-		ExpiresAt:    g.Token.Expiry,
-	})
-	if err != nil {
-		return nil, util.NewAppError(
-			http.StatusNotFound,
-			"failed to update account with new tokens",
-			"NewGoogleProvider, RefreshToken() error: ",
-			err,
-		)
-	}
-
-	return g.Token, nil
+	return ts.Token()
 }
 
 // `GetAuthURL` returns a URL to OAuth 2.0 provider's consent page that asks for permissions
 // for the required scopes explicitly.
-func (g *GoogleProvider) GetAuthURL(state string) string {
-	return g.Config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+func (g *GoogleProvider) GetAuthURL(state string, verifier string) string {
+	return g.Config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce, oauth2.S256ChallengeOption(verifier))
 }
 
-func (g *GoogleProvider) CreateOrUpdateAccount() (string, error) {
+// `CreateOrUpdateAccount` signs the user in with the tokens from `Authenticate`,
+// a new user is created for an unknown email. It returns the ID of the user.
+func (g *GoogleProvider) CreateOrUpdateAccount(ctx context.Context, token *oauth2.Token) (string, error) {
 	// GetGoogleUserInfo uses the access token received during OAuth
 	// and gets the user info from google.
-	u, err := service.GetGoogleUserInfo(g.Token, g.HttpClient)
+	u, err := service.GetGoogleUserInfo(token, g.Config.Client(ctx, token))
 	if err != nil {
 		return "", err
 	}
@@ -190,10 +153,10 @@ func (g *GoogleProvider) CreateOrUpdateAccount() (string, error) {
 	// account with new tokens and expiry.
 	if len(dbUser.UserID) != 0 {
 		acc := types.GoogleAccount{
-			AccessToken:  g.Token.AccessToken,
-			RefreshToken: g.Token.RefreshToken,
-			TokenType:    g.Token.TokenType,
-			ExpiresAt:    g.Token.Expiry,
+			AccessToken:  token.AccessToken,
+			RefreshToken: token.RefreshToken,
+			TokenType:    token.TokenType,
+			ExpiresAt:    token.Expiry,
 		}
 		if err := service.UpdateAccountByUserID(g.db, dbUser.UserID, &acc); err != nil {
 			return "", util.NewAppError(
@@ -207,7 +170,7 @@ func (g *GoogleProvider) CreateOrUpdateAccount() (string, error) {
 		return dbUser.UserID, nil
 	}
 	// if user doesn't exists, we create new user account.
-	userID, err := service.CreateUserAndAccount(g.db, u, g.Token)
+	userID, err := service.CreateUserAndAccount(g.db, u, token)
 	if err != nil {
 		return "", util.NewAppError(
 			http.StatusInternalServerError,
@@ -220,18 +183,28 @@ func (g *GoogleProvider) CreateOrUpdateAccount() (string, error) {
 	return userID, nil
 }
 
-// `CreateSession` generates a session token and sets the cookie.
+// `CreateSession` creates a session for the device of the request and sets its token in the cookie.
 func (g *GoogleProvider) CreateSession(c *fiber.Ctx, userID string) error {
-	if g.Token == nil {
+	// Every sign in is a session of its own, it can be revoked on its own.
+	// The session, its token and the cookie all expire at the same time.
+	expiresAt := time.Now().Add(setting.SessionTTL)
+	sessionID, err := service.CreateUserSession(g.db, &types.UserSession{
+		UserID:    userID,
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IPAddress: c.IP(),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
 		return util.NewAppError(
-			http.StatusNotFound,
-			"no oauth token found",
-			"NewGoogleProvider, RefreshToken() error",
+			http.StatusInternalServerError,
+			"failed to create session",
+			"NewGoogleProvider, CreateSession() error: ",
+			err,
 		)
 	}
 
-	// Generating a JWT session token with `userID`.
-	token, err := util.GenerateSessionToken(userID, g.env.SessionSecret)
+	// Generating a JWT session token with `userID` and `sessionID`.
+	token, err := util.GenerateSessionToken(userID, sessionID, expiresAt, g.env.SessionSecret)
 	if err != nil {
 		return util.NewAppError(
 			http.StatusInternalServerError,
@@ -242,27 +215,114 @@ func (g *GoogleProvider) CreateSession(c *fiber.Ctx, userID string) error {
 	}
 
 	// Setting the session cookie with the generated session token.
-	util.SetSessionToken(c, token, g.env.Domain)
+	util.SetSessionToken(c, token, expiresAt, g.env.Domain)
 
 	return nil
 }
 
-// `UpdateTokens` takes necessary token config which can also be nil
-// We update the `GoogleProvider` struct with empty token or with token config.
-// This is mostly used in a middleware to keep all the states in sync.
-// Refer to `api/middleware/session_middleware.go`
-func (g *GoogleProvider) UpdateTokens(acc *types.GoogleAccount) error {
-	if acc == nil {
-		g.Token = nil
-		return nil
+// `TokenSource` loads the tokens of the user's primary account from the database. The returned
+// source belongs to that account only, a download keeps refreshing its tokens on its own.
+func (g *GoogleProvider) TokenSource(userID string) (oauth2.TokenSource, error) {
+	acc, err := service.GetAccountByUserID(g.db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the account: %v", err)
+	}
+	if len(acc.ID) == 0 {
+		return nil, fmt.Errorf("no account found for user %s", userID)
+	}
+
+	return g.accountTokenSource(acc.ID, acc.OAuthToken())
+}
+
+// `AccountTokenSource` loads the tokens of one of the user's linked accounts, like `TokenSource`.
+func (g *GoogleProvider) AccountTokenSource(userID string, accountID string) (oauth2.TokenSource, error) {
+	acc, err := service.GetGoogleAccountByID(g.db, userID, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the account: %v", err)
+	}
+	if len(acc.ID) == 0 {
+		return nil, fmt.Errorf("no google account %s found for user %s", accountID, userID)
+	}
+
+	return g.accountTokenSource(acc.ID, acc.OAuthToken())
+}
+
+// `AccountIDs` returns the IDs of the user's accounts, `preferred` first. See `types.MultiAccountProvider`.
+func (g *GoogleProvider) AccountIDs(userID string, preferred string) ([]string, error) {
+	accounts, err := service.GetGoogleAccounts(g.db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the accounts: %v", err)
+	}
+	accounts, err = util.OrderGoogleAccounts(accounts, preferred)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(accounts))
+	for _, acc := range accounts {
+		ids = append(ids, acc.ID)
+	}
+
+	return ids, nil
+}
+
+// `GetLinkURL` returns a URL to Google's consent page for linking another account,
+// it leads back to the link callback instead of the sign in one.
+func (g *GoogleProvider) GetLinkURL(state string, verifier string) string {
+	return g.Config.AuthCodeURL(
+		state,
+		oauth2.AccessTypeOffline,
+		oauth2.ApprovalForce,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("redirect_uri", g.linkRedirectURL),
+		// Lets the user pick an account other than the one they're signed in with.
+		oauth2.SetAuthURLParam("prompt", "select_account consent"),
+	)
+}
+
+// `LinkAccount` exchanges the authorization code of the link callback and links the account
+// to the user. Linking an account again updates its tokens.
+func (g *GoogleProvider) LinkAccount(ctx context.Context, userID string, authCode string, verifier string) error {
+	token, err := g.Config.Exchange(
+		ctx,
+		authCode,
+		oauth2.SetAuthURLParam("redirect_uri", g.linkRedirectURL),
+		oauth2.VerifierOption(verifier),
+	)
+	if err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to authenticate",
+			"GoogleProvider, LinkAccount() error: ",
+			err,
+		)
+	}
+	if token == nil || !token.Valid() || len(token.RefreshToken) == 0 {
+		return util.NewAppError(
+			http.StatusBadRequest,
+			"invalid oauth token",
+		)
 	}
 
-	g.Token = &oauth2.Token{
-		AccessToken:  acc.AccessToken,
-		RefreshToken: acc.RefreshToken,
-		TokenType:    acc.TokenType,
-		Expiry:       acc.ExpiresAt,
+	u, err := service.GetGoogleUserInfo(token, g.Config.Client(ctx, token))
+	if err != nil {
+		return err
+	}
+	if _, err := service.LinkGoogleAccount(g.db, userID, u.Email, token); err != nil {
+		return util.NewAppError(
+			http.StatusInternalServerError,
+			"failed to link the google account",
+			"GoogleProvider, LinkAccount() error: ",
+			err,
+		)
 	}
 
 	return nil
 }
+
+// accountTokenSource returns a token source which saves the refreshed tokens of the account `accountID`.
+func (g *GoogleProvider) accountTokenSource(accountID string, token *oauth2.Token) (oauth2.TokenSource, error) {
+	return newAccountTokenSource(g.Config, token, func(t *oauth2.Token) error {
+		return service.UpdateGoogleAccountTokens(g.db, accountID, t)
+	})
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/nilotpaul/go-downloader/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+// fakeAuthServer is an authorization server which requires PKCE, like Google does for the
+// codes of a consent page URL with a challenge.
+type fakeAuthServer struct {
+	*httptest.Server
+	mu sync.Mutex
+	// Challenges of the issued codes, a code can only be exchanged once.
+	challenges map[string]string
+}
+
+func newFakeAuthServer(t *testing.T) *fakeAuthServer {
+	s := &fakeAuthServer{challenges: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	// The user grants access right away.
+	mux.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" || len(q.Get("code_challenge")) == 0 {
+			http.Error(w, "pkce required", http.StatusBadRequest)
+			return
+		}
+
+		code := "code-" + q.Get("state")
+		s.mu.Lock()
+		s.challenges[code] = q.Get("code_challenge")
+		s.mu.Unlock()
+
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		code := r.FormValue("code")
+		s.mu.Lock()
+		challenge, ok := s.challenges[code]
+		delete(s.challenges, code)
+		s.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token","refresh_token":"refresh","token_type":"Bearer","expires_in":3600}`))
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// authorize opens the consent page URL and returns the code and state of the callback.
+func (s *fakeAuthServer) authorize(t *testing.T, authURL string) (string, string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(authURL)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	assert.NoError(t, err)
+
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+func newTestGoogleProvider(s *fakeAuthServer) *GoogleProvider {
+	gp := NewGoogleProvider(googleProviderConfig{
+		googleClientID:     "client",
+		googleClientSecret: "secret",
+		googleRedirectURL:  "http://localhost/api/v1/callback/google",
+		linkRedirectURL:    "http://localhost/api/v1/accounts/google/callback",
+	}, nil, config.EnvConfig{})
+	gp.Config.Endpoint = oauth2.Endpoint{
+		AuthURL:   s.URL + "/auth",
+		TokenURL:  s.URL + "/token",
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+
+	return gp
+}
+
+func TestGoogleProvider_PKCE(t *testing.T) {
+	s := newFakeAuthServer(t)
+	gp := newTestGoogleProvider(s)
+
+	verifier := oauth2.GenerateVerifier()
+	code, state := s.authorize(t, gp.GetAuthURL("state", verifier))
+	assert.Equal(t, "state", state)
+
+	token, err := gp.Authenticate(context.Background(), code, verifier)
+	assert.NoError(t, err)
+	assert.Equal(t, "token", token.AccessToken)
+	assert.Equal(t, "refresh", token.RefreshToken)
+
+	// The code was already exchanged.
+	_, err = gp.Authenticate(context.Background(), code, verifier)
+	assert.Error(t, err)
+}
+
+func TestGoogleProvider_PKCE_Mismatch(t *testing.T) {
+	s := newFakeAuthServer(t)
+	gp := newTestGoogleProvider(s)
+
+	// A code which was injected from another flow can't be exchanged without its verifier.
+	code, _ := s.authorize(t, gp.GetAuthURL("state", oauth2.GenerateVerifier()))
+	_, err := gp.Authenticate(context.Background(), code, oauth2.GenerateVerifier())
+	assert.Error(t, err)
+
+	// The link flow sends the challenge as well.
+	code, _ = s.authorize(t, gp.GetLinkURL("link", oauth2.GenerateVerifier()))
+	assert.NotEmpty(t, code)
+}
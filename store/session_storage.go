@@ -0,0 +1,113 @@
+package store
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/nilotpaul/go-downloader/setting"
+)
+
+// `SessionStorage` is a `fiber.Storage` in the `session_store` table, so the sessions
+// and OAuth flows of fiber's session store outlive a restart.
+type SessionStorage struct {
+	db   *sql.DB
+	done chan struct{}
+	once sync.Once
+}
+
+// NewSessionStorage returns the storage and starts removing its expired entries in the background.
+func NewSessionStorage(db *sql.DB) *SessionStorage {
+	s := &SessionStorage{
+		db:   db,
+		done: make(chan struct{}),
+	}
+	go s.gc(setting.SessionStorageGCInterval)
+
+	return s
+}
+
+// Get returns nil if there's no entry for the key or it expired.
+func (s *SessionStorage) Get(key string) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+
+	const query = `
+		SELECT data FROM session_store
+		WHERE key = $1 AND (expires_at IS NULL OR expires_at > $2)
+	`
+
+	var data []byte
+	err := s.db.QueryRow(query, key, time.Now()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+// Set stores the entry, without an expiry if `exp` is 0.
+func (s *SessionStorage) Set(key string, val []byte, exp time.Duration) error {
+	if len(key) == 0 || len(val) == 0 {
+		return nil
+	}
+
+	var expiresAt sql.NullTime
+	if exp > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().Add(exp), Valid: true}
+	}
+
+	const query = `
+		INSERT INTO session_store (key, data, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE
+		SET
+			data = EXCLUDED.data,
+			expires_at = EXCLUDED.expires_at
+	`
+	_, err := s.db.Exec(query, key, val, expiresAt)
+
+	return err
+}
+
+func (s *SessionStorage) Delete(key string) error {
+	if len(key) == 0 {
+		return nil
+	}
+
+	_, err := s.db.Exec(`DELETE FROM session_store WHERE key = $1`, key)
+	return err
+}
+
+func (s *SessionStorage) Reset() error {
+	_, err := s.db.Exec(`DELETE FROM session_store`)
+	return err
+}
+
+// Close stops removing the expired entries, the database is closed by its owner.
+func (s *SessionStorage) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+
+	return nil
+}
+
+func (s *SessionStorage) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			_, err := s.db.Exec(`DELETE FROM session_store WHERE expires_at <= $1`, time.Now())
+			if err != nil {
+				log.Errorf("failed to remove the expired sessions: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// `aroundTime` matches a time argument within a second of `t`.
+type aroundTime struct {
+	t time.Time
+}
+
+func (a aroundTime) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.Sub(a.t).Abs() < time.Second
+}
+
+func TestSessionStorage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	s := &SessionStorage{db: db, done: make(chan struct{})}
+
+	// Entries expire with the session, or never without an expiry.
+	mock.ExpectExec("INSERT INTO session_store").
+		WithArgs("sess", []byte("data"), aroundTime{time.Now().Add(time.Hour)}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO session_store").
+		WithArgs("flow", []byte("state"), nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.Set("sess", []byte("data"), time.Hour))
+	assert.NoError(t, s.Set("flow", []byte("state"), 0))
+	// Nothing is stored without a key or data.
+	assert.NoError(t, s.Set("", []byte("data"), time.Hour))
+	assert.NoError(t, s.Set("empty", nil, time.Hour))
+
+	// Expired entries aren't returned, even before they're removed.
+	mock.ExpectQuery("WHERE key = \\$1 AND \\(expires_at IS NULL OR expires_at > \\$2\\)").
+		WithArgs("sess", aroundTime{time.Now()}).
+		WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow([]byte("data")))
+	mock.ExpectQuery("FROM session_store").
+		WithArgs("expired", aroundTime{time.Now()}).
+		WillReturnRows(sqlmock.NewRows([]string{"data"}))
+	data, err := s.Get("sess")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	data, err = s.Get("expired")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+
+	mock.ExpectExec("DELETE FROM session_store WHERE key = \\$1").
+		WithArgs("sess").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.Delete("sess"))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSessionStorage_GC(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	s := &SessionStorage{db: db, done: make(chan struct{})}
+
+	mock.ExpectExec("DELETE FROM session_store WHERE expires_at <= \\$1").
+		WithArgs(aroundTime{time.Now()}).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	done := make(chan struct{})
+	go func() {
+		s.gc(50 * time.Millisecond)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, time.Second, time.Millisecond)
+
+	// Closing stops the removal, more than once is fine.
+	assert.NoError(t, s.Close())
+	assert.NoError(t, s.Close())
+	<-done
+}
@@ -5,18 +5,24 @@ import (
 	"fmt"
 
 	"github.com/nilotpaul/go-downloader/config"
+	"github.com/nilotpaul/go-downloader/service"
 	"github.com/nilotpaul/go-downloader/setting"
 	"github.com/nilotpaul/go-downloader/types"
 )
 
-// `ProviderRegistry` holds all the cloud storage providers.
+// `ProviderRegistry` holds all the auth providers and the download sources.
 type ProviderRegistry struct {
 	Providers map[string]types.OAuthProvider
+	Sources   map[string]types.Source
+	// Sources in the order they were registered, links are matched in this order.
+	sourceOrder []setting.Provider
 }
 
 func NewProviderRegistry() *ProviderRegistry {
 	return &ProviderRegistry{
-		Providers: make(map[string]types.OAuthProvider),
+		Providers:   make(map[string]types.OAuthProvider),
+		Sources:     make(map[string]types.Source),
+		sourceOrder: make([]setting.Provider, 0),
 	}
 }
 
@@ -37,6 +43,38 @@ func (r *ProviderRegistry) GetProvider(providerName setting.Provider) (types.OAu
 	return p, nil
 }
 
+// Adds a source in the `Sources` map, a source registered again keeps its place in the order.
+func (r *ProviderRegistry) RegisterSource(providerName setting.Provider, s types.Source) {
+	provider := string(providerName)
+	if _, exists := r.Sources[provider]; !exists {
+		r.sourceOrder = append(r.sourceOrder, providerName)
+	}
+	r.Sources[provider] = s
+}
+
+// Retrieves a source from the `Sources` map.
+func (r *ProviderRegistry) GetSource(providerName setting.Provider) (types.Source, error) {
+	provider := string(providerName)
+	s, exists := r.Sources[provider]
+	if !exists {
+		return nil, fmt.Errorf("source not found")
+	}
+
+	return s, nil
+}
+
+// Finds the first registered source which matches the link.
+func (r *ProviderRegistry) FindSource(link string) (setting.Provider, types.Source, error) {
+	for _, providerName := range r.sourceOrder {
+		s := r.Sources[string(providerName)]
+		if s.Match(link) {
+			return providerName, s, nil
+		}
+	}
+
+	return "", nil, fmt.Errorf("no source found for %s", link)
+}
+
 // `InitStore` initializes all the providers on start-up based on the provided env variables.
 func InitStore(env config.EnvConfig, db *sql.DB) *ProviderRegistry {
 	r := NewProviderRegistry()
@@ -46,10 +84,47 @@ func InitStore(env config.EnvConfig, db *sql.DB) *ProviderRegistry {
 			googleClientID:     env.GoogleClientID,
 			googleClientSecret: env.GoogleClientSecret,
 			googleRedirectURL:  env.AppURL + "/api/v1/callback/google",
+			linkRedirectURL:    env.AppURL + "/api/v1/accounts/google/callback",
 		}, db, env)
 
 		r.Register(setting.GoogleProvider, googleProvider)
 	}
 
+	if len(env.DropboxClientID) != 0 {
+		dropboxProvider := NewDropboxProvider(connectedProviderConfig{
+			clientID:     env.DropboxClientID,
+			clientSecret: env.DropboxClientSecret,
+			redirectURL:  env.AppURL + "/api/v1/callback/dropbox",
+		}, db)
+
+		r.Register(setting.DropboxProvider, dropboxProvider)
+	}
+
+	if len(env.MicrosoftClientID) != 0 {
+		microsoftProvider := NewMicrosoftProvider(connectedProviderConfig{
+			clientID:     env.MicrosoftClientID,
+			clientSecret: env.MicrosoftClientSecret,
+			redirectURL:  env.AppURL + "/api/v1/callback/microsoft",
+		}, env.MicrosoftTenant, db)
+
+		r.Register(setting.MicrosoftProvider, microsoftProvider)
+	}
+
+	// Sources are registered even if their auth provider isn't configured,
+	// so their links aren't mistaken for direct links. Direct links match
+	// every HTTP(S) URL, so they're registered last.
+	r.RegisterSource(setting.GoogleProvider, service.NewGDriveSource(env.GoogleAPIKey))
+	r.RegisterSource(setting.DropboxProvider, service.NewDropboxSource())
+	r.RegisterSource(setting.OneDriveProvider, service.NewOneDriveSource())
+	// The S3 config is validated when the env is loaded.
+	if endpoints, _ := env.S3Endpoints(); len(endpoints) != 0 {
+		r.RegisterSource(setting.S3Provider, service.NewS3Source(endpoints))
+	}
+	r.RegisterSource(setting.SFTPProvider, service.NewSFTPSource(db))
+	r.RegisterSource(setting.FTPProvider, service.NewFTPSource(db))
+	r.RegisterSource(setting.WebDAVProvider, service.NewWebDAVSource(db))
+	r.RegisterSource(setting.TorrentProvider, service.NewTorrentSource(env.TorrentConfig()))
+	r.RegisterSource(setting.HTTPProvider, service.NewHTTPSource())
+
 	return r
 }
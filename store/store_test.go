@@ -1,13 +1,13 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/nilotpaul/go-downloader/config"
 	"github.com/nilotpaul/go-downloader/setting"
-	"github.com/nilotpaul/go-downloader/types"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/oauth2"
 )
@@ -15,25 +15,21 @@ import (
 // Mock Provider
 type MockProvider struct{}
 
-func (p *MockProvider) Authenticate(string) error { return nil }
-
-func (p *MockProvider) GetAccessToken() string { return "" }
-
-func (p *MockProvider) GetRefreshToken() string { return "" }
-
-func (p *MockProvider) RefreshToken(*fiber.Ctx, string, bool) (*oauth2.Token, error) {
+func (p *MockProvider) Authenticate(context.Context, string, string) (*oauth2.Token, error) {
 	return nil, nil
 }
 
-func (p *MockProvider) IsTokenValid() bool { return true }
+func (p *MockProvider) RefreshToken(string, bool) (*oauth2.Token, error) { return nil, nil }
 
-func (p *MockProvider) GetAuthURL(string) string { return "" }
+func (p *MockProvider) GetAuthURL(string, string) string { return "" }
 
-func (p *MockProvider) CreateOrUpdateAccount() (string, error) { return "", nil }
+func (p *MockProvider) CreateOrUpdateAccount(context.Context, *oauth2.Token) (string, error) {
+	return "", nil
+}
 
 func (p *MockProvider) CreateSession(*fiber.Ctx, string) error { return nil }
 
-func (p *MockProvider) UpdateTokens(*types.GoogleAccount) error { return nil }
+func (p *MockProvider) TokenSource(string) (oauth2.TokenSource, error) { return nil, nil }
 
 func TestNewProviderRegistry(t *testing.T) {
 	r := NewProviderRegistry()
@@ -69,6 +65,53 @@ func TestNewProviderRegistry_RegisterAndGetProvider(t *testing.T) {
 	assert.Equal(t, len(r.Providers), 2)
 }
 
+func TestProviderRegistry_FindSource(t *testing.T) {
+	r := InitStore(config.EnvConfig{}, nil)
+
+	// Drive links are matched before direct links, even without the Google provider.
+	provider, src, err := r.FindSource("https://drive.google.com/file/d/abc123/view")
+	assert.NoError(t, err)
+	assert.Equal(t, setting.GoogleProvider, provider)
+	assert.Equal(t, setting.GoogleProvider, src.AuthProvider())
+
+	provider, _, err = r.FindSource("https://www.dropbox.com/scl/fo/abc/xyz?rlkey=k")
+	assert.NoError(t, err)
+	assert.Equal(t, setting.DropboxProvider, provider)
+
+	provider, src, err = r.FindSource("https://contoso.sharepoint.com/:u:/s/team/EaBc")
+	assert.NoError(t, err)
+	assert.Equal(t, setting.OneDriveProvider, provider)
+	assert.Equal(t, setting.MicrosoftProvider, src.AuthProvider())
+
+	provider, src, err = r.FindSource("https://example.com/file.iso")
+	assert.NoError(t, err)
+	assert.Equal(t, setting.HTTPProvider, provider)
+	assert.Empty(t, src.AuthProvider())
+
+	provider, _, err = r.FindSource("sftp://seedbox.example.com/downloads/")
+	assert.NoError(t, err)
+	assert.Equal(t, setting.SFTPProvider, provider)
+
+	provider, _, err = r.FindSource("ftps://archive.example.com/file.iso")
+	assert.NoError(t, err)
+	assert.Equal(t, setting.FTPProvider, provider)
+
+	provider, _, err = r.FindSource("https://cloud.example.com/s/aBcDeFgHiJkLmNo")
+	assert.NoError(t, err)
+	assert.Equal(t, setting.WebDAVProvider, provider)
+
+	provider, _, err = r.FindSource("magnet:?xt=urn:btih:c9e15763f722f23e98a29decdfae341b98d53056&dn=ubuntu.iso")
+	assert.NoError(t, err)
+	assert.Equal(t, setting.TorrentProvider, provider)
+
+	_, _, err = r.FindSource("s3://datasets/file.iso")
+	assert.Error(t, err)
+
+	s, err := r.GetSource(setting.HTTPProvider)
+	assert.NoError(t, err)
+	assert.Equal(t, src, s)
+}
+
 func TestInitStore(t *testing.T) {
 	// Mock the database connection.
 	var db *sql.DB
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/gofiber/fiber/v2/log"
+	"golang.org/x/oauth2"
+)
+
+// `accountTokenSource` hands out the tokens of a single account. Expired tokens are refreshed
+// with the refresh token and saved, so a long running download keeps working on its own,
+// no matter which user is making requests at the time.
+type accountTokenSource struct {
+	mu   sync.Mutex
+	src  oauth2.TokenSource
+	save func(*oauth2.Token) error
+	// Access token which was saved last.
+	saved string
+}
+
+// newAccountTokenSource returns a token source starting with `token`, the refreshed tokens are
+// passed to `save`. The token is checked right away, an account whose refresh token was revoked
+// fails here instead of once its download starts.
+func newAccountTokenSource(cfg *oauth2.Config, token *oauth2.Token, save func(*oauth2.Token) error) (oauth2.TokenSource, error) {
+	ts := &accountTokenSource{
+		src:   cfg.TokenSource(context.Background(), token),
+		save:  save,
+		saved: token.AccessToken,
+	}
+	if _, err := ts.Token(); err != nil {
+		return nil, err
+	}
+
+	return ts, nil
+}
+
+func (s *accountTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.src.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh the token: %v", err)
+	}
+	if token.AccessToken != s.saved {
+		// The new token still works, it's only refreshed once more next time.
+		if err := s.save(token); err != nil {
+			log.Errorf("failed to update account with new tokens: %v", err)
+		}
+		s.saved = token.AccessToken
+	}
+
+	return token, nil
+}
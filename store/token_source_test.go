@@ -0,0 +1,95 @@
+package store
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+// newTestTokenServer returns an OAuth config whose token endpoint hands out "new-token".
+func newTestTokenServer(t *testing.T, refreshes *int32) *oauth2.Config {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(refreshes, 1)
+		if r.FormValue("refresh_token") != "refresh" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"new-token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return &oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{TokenURL: srv.URL, AuthStyle: oauth2.AuthStyleInParams},
+	}
+}
+
+func TestAccountTokenSource_Refresh(t *testing.T) {
+	var refreshes int32
+	cfg := newTestTokenServer(t, &refreshes)
+
+	var saved []*oauth2.Token
+	expired := &oauth2.Token{
+		AccessToken:  "old-token",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(-time.Hour),
+	}
+	ts, err := newAccountTokenSource(cfg, expired, func(t *oauth2.Token) error {
+		saved = append(saved, t)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// The refreshed token is reused and saved only once.
+	for i := 0; i < 3; i++ {
+		token, err := ts.Token()
+		assert.NoError(t, err)
+		assert.Equal(t, "new-token", token.AccessToken)
+		// The refresh token is kept, the endpoint didn't send a new one.
+		assert.Equal(t, "refresh", token.RefreshToken)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&refreshes))
+	assert.Len(t, saved, 1)
+}
+
+func TestAccountTokenSource_Valid(t *testing.T) {
+	var refreshes int32
+	cfg := newTestTokenServer(t, &refreshes)
+
+	valid := &oauth2.Token{
+		AccessToken:  "token",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(time.Hour),
+	}
+	ts, err := newAccountTokenSource(cfg, valid, func(*oauth2.Token) error {
+		t.Fatal("valid token was saved")
+		return nil
+	})
+	assert.NoError(t, err)
+
+	token, err := ts.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "token", token.AccessToken)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&refreshes))
+}
+
+func TestAccountTokenSource_Revoked(t *testing.T) {
+	var refreshes int32
+	cfg := newTestTokenServer(t, &refreshes)
+
+	revoked := &oauth2.Token{
+		AccessToken:  "old-token",
+		RefreshToken: "revoked",
+		Expiry:       time.Now().Add(-time.Hour),
+	}
+	ts, err := newAccountTokenSource(cfg, revoked, func(*oauth2.Token) error { return nil })
+	assert.Error(t, err)
+	assert.Nil(t, ts)
+}
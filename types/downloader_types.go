@@ -1,17 +1,64 @@
 package types
 
-import "time"
+import (
+	"time"
+
+	"github.com/nilotpaul/go-downloader/setting"
+)
 
 // `Progress` represents the state of a downloading file.
 type Progress struct {
-	FileID       string    `json:"file_id"`
-	Total        int64     `json:"total"`
-	Current      int       `json:"current"`
-	Complete     bool      `json:"complete"`
-	ReadableSize string    `json:"readableSize"`
-	StartTime    time.Time `json:"startTime"`
-	EndTime      time.Time `json:"endTime"`
-	Speed        float64   `json:"speed"`
+	JobID        string                 `json:"job_id"`
+	FileID       string                 `json:"file_id"`
+	Status       setting.DownloadStatus `json:"status"`
+	Total        int64                  `json:"total"`
+	Downloaded   int64                  `json:"downloaded"`
+	Current      int                    `json:"current"`
+	Complete     bool                   `json:"complete"`
+	ReadableSize string                 `json:"readableSize"`
+	StartTime    time.Time              `json:"startTime"`
+	EndTime      time.Time              `json:"endTime"`
+	Speed        float64                `json:"speed"`
+	// Set once the download is complete and verified.
+	MD5Checksum string `json:"md5_checksum,omitempty"`
+	// Connected peers and how many of them are seeds, only for torrents.
+	Peers int `json:"peers,omitempty"`
+	Seeds int `json:"seeds,omitempty"`
+}
+
+// `DownloadJob` is the persisted state of a download, it survives restarts.
+type DownloadJob struct {
+	ID              string                 `json:"id"`
+	UserID          string                 `json:"user_id"`
+	FileID          string                 `json:"file_id"`
+	Provider        setting.Provider       `json:"provider"`
+	DestinationPath string                 `json:"destination_path"`
+	FileName        string                 `json:"file_name"`
+	ExportFormat    setting.ExportFormat   `json:"export_format"`
+	AccountID       string                 `json:"account_id"`
+	Status          setting.DownloadStatus `json:"status"`
+	BytesDone       int64                  `json:"bytes_done"`
+	TotalBytes      int64                  `json:"total_bytes"`
+	Error           string                 `json:"error"`
+	MD5Checksum     string                 `json:"md5_checksum"`
+	// Bytes per second of this download, less than 1 uses the default per-job limit.
+	SpeedLimit int64 `json:"speed_limit"`
+	// Request headers of direct downloads, they can hold credentials so they're never sent back.
+	Headers     map[string]string `json:"-"`
+	StartedAt   *time.Time        `json:"started_at"`
+	CompletedAt *time.Time        `json:"completed_at"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// `PartMeta` is the sidecar stored next to a `.part` file. It records what the
+// partial data belongs to, so a download is only resumed for the same remote file.
+type PartMeta struct {
+	FileID string `json:"file_id"`
+	Size   int64  `json:"size"`
+	// Expected checksum of the file, if the source knows it.
+	Checksum string `json:"checksum"`
+	ETag     string `json:"etag,omitempty"`
 }
 
 // `FolderNode` represents a node or folder in a hierarchical folder tree structure.
@@ -22,14 +69,64 @@ type FolderNode struct {
 }
 
 // Expected JSON Body data in download handler.
+// The body can be a multipart form as well, with the `.torrent` files to download in `torrent`.
 type DownloadHRBody struct {
-	Links           string `json:"links"`
-	DestinationPath string `json:"path"`
+	Links           string               `json:"links" form:"links"`
+	DestinationPath string               `json:"path" form:"path"`
+	ExportFormat    setting.ExportFormat `json:"export_format" form:"export_format"`
+	// Paths of the files inside the torrents to download, eg. `Season 1/E01.mkv`, a folder
+	// selects everything inside it. Every file is downloaded if it's empty.
+	TorrentFiles []string `json:"torrent_files" form:"torrent_files"`
+	// ID or name of the Google account tried first, the primary account by default.
+	// Drive links it can't access are downloaded with the other linked accounts.
+	GoogleAccount string `json:"google_account" form:"google_account"`
+	// Sent with the requests of direct download links.
+	Headers map[string]string `json:"headers"`
+	Cookies map[string]string `json:"cookies"`
+	// Bytes per second of every download of the request, instead of the default per-job limit.
+	SpeedLimit int64 `json:"speed_limit" form:"speed_limit"`
 }
 
 // Expected JSON Body data in cancel download handler.
 type CancelDownloadHRBody struct {
+	JobID string `json:"job_id"`
+}
+
+// `BandwidthConfig` limits the download speed in bytes per second, a limit less than 1 means unlimited.
+// `GlobalLimit` is shared by all downloads, unless a `Schedule` rule matches the current time.
+// `PerJobLimit` applies to every download on its own.
+type BandwidthConfig struct {
+	GlobalLimit int64           `json:"global_limit"`
+	PerJobLimit int64           `json:"per_job_limit"`
+	Schedule    []BandwidthRule `json:"schedule"`
+}
+
+// `BandwidthRule` sets the global limit between `Start` and `End` (HH:MM, local time),
+// it can go past midnight, eg. 23:00-08:00.
+type BandwidthRule struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Limit int64  `json:"limit"`
+}
+
+// Expected JSON Body data in the handler changing the limit of a single download,
+// a limit less than 1 goes back to the default per-job limit.
+type JobBandwidthHRBody struct {
+	Limit int64 `json:"limit"`
+}
+
+// Expected JSON Body data in pause and resume download handlers,
+// either a single job or a whole batch of jobs.
+type PauseResumeDownloadHRBody struct {
+	JobID  string   `json:"job_id"`
+	JobIDs []string `json:"job_ids"`
+}
+
+// `DownloadError` is the error of a failed download.
+type DownloadError struct {
+	JobID  string `json:"job_id"`
 	FileID string `json:"file_id"`
+	ErrMsg string `json:"errMsg"`
 }
 
 type Downloader interface {
@@ -0,0 +1,23 @@
+package types
+
+// `DropboxMetadata` is the metadata of a shared link or of an entry inside a shared folder.
+type DropboxMetadata struct {
+	Tag  string `json:".tag"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Revision of a file, it changes with every modification.
+	Rev string `json:"rev"`
+}
+
+// `DropboxListFolderResponse` is a page of the entries inside a shared folder.
+type DropboxListFolderResponse struct {
+	Entries []DropboxMetadata `json:"entries"`
+	Cursor  string            `json:"cursor"`
+	HasMore bool              `json:"has_more"`
+}
+
+// `DropboxErrorResponse` is the body of a failed Dropbox API call.
+type DropboxErrorResponse struct {
+	ErrorSummary string `json:"error_summary"`
+}
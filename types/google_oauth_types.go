@@ -1,6 +1,10 @@
 package types
 
-import "time"
+import (
+	"time"
+
+	"golang.org/x/oauth2"
+)
 
 type User struct {
 	UserID    string `json:"user_id"`
@@ -8,9 +12,15 @@ type User struct {
 	CreatedAt string `json:"created_at"`
 }
 
+// `GoogleAccount` is one of the Google accounts linked to a user. The primary
+// account is the one the user signs in with, the others are only used for downloads.
 type GoogleAccount struct {
-	ID           string    `json:"id"`
-	UserID       string    `json:"user_id"`
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	// Email of the Google account, it's also the default name.
+	Email        string    `json:"email"`
+	Name         string    `json:"name"`
+	IsPrimary    bool      `json:"is_primary"`
 	AccessToken  string    `json:"access_token"`
 	RefreshToken string    `json:"refresh_token"`
 	TokenType    string    `json:"token_type"`
@@ -19,6 +29,34 @@ type GoogleAccount struct {
 	UpdatedAt    time.Time `json:"updated_at"`
 }
 
+// `OAuthToken` returns the tokens of the account, nil if there's no account.
+func (acc *GoogleAccount) OAuthToken() *oauth2.Token {
+	if acc == nil {
+		return nil
+	}
+
+	return &oauth2.Token{
+		AccessToken:  acc.AccessToken,
+		RefreshToken: acc.RefreshToken,
+		TokenType:    acc.TokenType,
+		Expiry:       acc.ExpiresAt,
+	}
+}
+
+// `LinkedGoogleAccount` is a Google account as it's shown to its user, without the tokens.
+type LinkedGoogleAccount struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	IsPrimary bool      `json:"is_primary"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Expected JSON Body data in the rename Google account handler.
+type RenameGoogleAccountHRBody struct {
+	Name string `json:"name"`
+}
+
 type GoogleAccountWrapper struct {
 	GoogleAccount *GoogleAccount
 }
@@ -0,0 +1,38 @@
+package types
+
+// `GraphDriveItem` is a file or folder of OneDrive or SharePoint as returned by Microsoft Graph.
+type GraphDriveItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Changes only when the content of the file changes, unlike `eTag`.
+	CTag   string            `json:"cTag"`
+	File   *GraphFileFacet   `json:"file"`
+	Folder *GraphFolderFacet `json:"folder"`
+}
+
+type GraphFileFacet struct {
+	MimeType string `json:"mimeType"`
+	Hashes   struct {
+		SHA1Hash   string `json:"sha1Hash"`
+		SHA256Hash string `json:"sha256Hash"`
+	} `json:"hashes"`
+}
+
+type GraphFolderFacet struct {
+	ChildCount int `json:"childCount"`
+}
+
+// `GraphDriveItemPage` is a page of the children of a folder.
+type GraphDriveItemPage struct {
+	Value    []GraphDriveItem `json:"value"`
+	NextLink string           `json:"@odata.nextLink"`
+}
+
+// `GraphErrorResponse` is the body of a failed Microsoft Graph call.
+type GraphErrorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
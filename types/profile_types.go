@@ -0,0 +1,41 @@
+package types
+
+import (
+	"time"
+
+	"github.com/nilotpaul/go-downloader/setting"
+)
+
+// `ConnectionProfile` holds the credentials of a user for an SFTP or FTP server. Links never carry
+// credentials, the profile is picked by the protocol, host and port of the link instead.
+type ConnectionProfile struct {
+	ID       string                  `json:"id"`
+	UserID   string                  `json:"user_id"`
+	Name     string                  `json:"name"`
+	Protocol setting.ProfileProtocol `json:"protocol"`
+	Host     string                  `json:"host"`
+	Port     int                     `json:"port"`
+	Username string                  `json:"username"`
+	// Secrets are never sent back to the client.
+	Password   string `json:"-"`
+	PrivateKey string `json:"-"`
+	// Expected key of an SFTP server, as an `authorized_keys` line or a `SHA256:` fingerprint.
+	HostKey     string    `json:"host_key"`
+	ExplicitTLS bool      `json:"explicit_tls"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Expected JSON Body data in the create connection profile handler.
+type ConnectionProfileHRBody struct {
+	Name     string                  `json:"name"`
+	Protocol setting.ProfileProtocol `json:"protocol"`
+	Host     string                  `json:"host"`
+	// The default port of the protocol is used if it's 0.
+	Port        int    `json:"port"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	PrivateKey  string `json:"private_key"`
+	HostKey     string `json:"host_key"`
+	ExplicitTLS bool   `json:"explicit_tls"`
+}
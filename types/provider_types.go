@@ -1,23 +1,83 @@
 package types
 
 import (
+	"context"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/nilotpaul/go-downloader/setting"
 	"golang.org/x/oauth2"
 )
 
+// `OAuthProvider` is shared by every user, so it holds no tokens itself.
+// They're loaded from the database for every request or download.
 type OAuthProvider interface {
-	Authenticate(string) error
-	GetAccessToken() string
-	GetRefreshToken() string
-	RefreshToken(*fiber.Ctx, string, bool) (*oauth2.Token, error)
-	IsTokenValid() bool
-	GetAuthURL(state string) string
-	CreateOrUpdateAccount() (string, error)
+	// Authenticate exchanges the authorization code for the tokens of the account,
+	// `verifier` is the PKCE code verifier of the flow.
+	Authenticate(ctx context.Context, authCode string, verifier string) (*oauth2.Token, error)
+	// RefreshToken refreshes and saves the tokens of the user's account,
+	// with `force` even if they're still valid.
+	RefreshToken(userID string, force bool) (*oauth2.Token, error)
+	// GetAuthURL returns the consent page URL with the PKCE challenge of `verifier`.
+	GetAuthURL(state string, verifier string) string
+	CreateOrUpdateAccount(ctx context.Context, token *oauth2.Token) (string, error)
 	CreateSession(c *fiber.Ctx, userID string) error
-	UpdateTokens(*GoogleAccount) error
+	// TokenSource returns the tokens of the user's account, they're refreshed and saved
+	// whenever they expire. It fails if the account's tokens can't be used anymore.
+	TokenSource(userID string) (oauth2.TokenSource, error)
+}
+
+// `AccountConnector` is implemented by the providers which can't be used to sign in,
+// their accounts are connected to a user who's already signed in instead.
+type AccountConnector interface {
+	ConnectAccount(ctx context.Context, userID string, authCode string, verifier string) error
+}
+
+// `MultiAccountProvider` is implemented by the providers which link several accounts to a
+// single user, like Google. Every download picks the account whose tokens it uses.
+type MultiAccountProvider interface {
+	// GetLinkURL returns the consent page URL for linking another account.
+	GetLinkURL(state string, verifier string) string
+	// LinkAccount exchanges the authorization code and links the account to the user.
+	LinkAccount(ctx context.Context, userID string, authCode string, verifier string) error
+	// AccountIDs returns the user's accounts in the order they're tried, `preferred` (the ID
+	// or name of an account) first if it's set, then the primary account and the others.
+	AccountIDs(userID string, preferred string) ([]string, error)
+	// AccountTokenSource is like `OAuthProvider.TokenSource` for one of the user's accounts.
+	AccountTokenSource(userID string, accountID string) (oauth2.TokenSource, error)
 }
 
 type ProviderRegistry interface {
 	Register(string, OAuthProvider)
 	GetProvider(string) (OAuthProvider, error)
 }
+
+// `ProviderAccount` is the account of a provider connected to a user, like Dropbox or Microsoft.
+// Google accounts have their own table as they're used to sign in.
+type ProviderAccount struct {
+	ID       string           `json:"id"`
+	UserID   string           `json:"user_id"`
+	Provider setting.Provider `json:"provider"`
+	// ID of the account on the provider's side.
+	AccountID    string    `json:"account_id"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	TokenType    string    `json:"token_type"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// `OAuthToken` returns the tokens of the account, nil if there's no account.
+func (acc *ProviderAccount) OAuthToken() *oauth2.Token {
+	if acc == nil {
+		return nil
+	}
+
+	return &oauth2.Token{
+		AccessToken:  acc.AccessToken,
+		RefreshToken: acc.RefreshToken,
+		TokenType:    acc.TokenType,
+		Expiry:       acc.ExpiresAt,
+	}
+}
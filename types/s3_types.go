@@ -0,0 +1,29 @@
+package types
+
+// `S3Endpoint` is an S3-compatible storage with its credentials, eg. AWS, MinIO or Backblaze B2.
+type S3Endpoint struct {
+	// Base URL of the storage, eg. `https://s3.us-west-004.backblazeb2.com`.
+	URL       string `json:"url"`
+	Region    string `json:"region"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	// Puts the bucket in the path instead of the host, MinIO needs it by default.
+	PathStyle bool `json:"path_style"`
+}
+
+// `S3ListBucketResult` is a page of the objects of a bucket, as returned by `ListObjectsV2`.
+type S3ListBucketResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+		ETag string `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// `S3ErrorResponse` is the body of a failed S3 request.
+type S3ErrorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
@@ -12,7 +12,32 @@ type Session struct {
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
+// `OAuthFlow` is an OAuth flow which was started but didn't reach its callback yet,
+// it's kept in the session of the browser which started it.
+type OAuthFlow struct {
+	State string
+	// PKCE code verifier, only its challenge is sent to the consent page.
+	Verifier  string
+	ExpiresAt time.Time
+}
+
 type JWTSession struct {
-	UserID    string    `json:"user_id"`
+	UserID string `json:"user_id"`
+	// ID of the `UserSession` the token belongs to.
+	SessionID string    `json:"session_id"`
 	ExpiresAt time.Time `json:"expires_at"`
 }
+
+// `UserSession` is a sign in of the user on one of their devices, it's valid until
+// it expires or is revoked.
+type UserSession struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Whether it's the session of the request.
+	Current bool `json:"current"`
+}
@@ -0,0 +1,82 @@
+package types
+
+import (
+	"context"
+	"io"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"golang.org/x/oauth2"
+)
+
+// `Source` is where files are downloaded from, eg. Google Drive or a plain HTTP(S) link.
+// The download engine only talks to this interface, so every provider works the same way.
+type Source interface {
+	// AuthProvider returns the auth provider whose access token the source needs, empty if none.
+	AuthProvider() setting.Provider
+	// Match reports whether the link belongs to this source.
+	Match(link string) bool
+	// Resolve turns a link into a file or a folder. The metadata can be incomplete,
+	// `Stat` is called again before the download starts.
+	Resolve(ctx context.Context, link string, opts SourceOptions) (*SourceFile, error)
+	// ListFolder returns all the files inside a folder recursively, every file has
+	// its path relative to the download destination in `Dir`.
+	ListFolder(ctx context.Context, folder *SourceFile, opts SourceOptions) ([]SourceFile, error)
+	// Stat gets the metadata of a file by its ID.
+	Stat(ctx context.Context, fileID string, opts SourceOptions) (*SourceFile, error)
+	// Open opens the content of a file from `offset`. It returns the offset the stream
+	// actually starts from, which is 0 if the source can't continue from `offset`.
+	Open(ctx context.Context, file *SourceFile, offset int64, opts SourceOptions) (io.ReadCloser, int64, error)
+	// Checksum returns the expected checksum of a file, an empty `Value` if it's not known.
+	Checksum(file *SourceFile) Checksum
+}
+
+// `AnonymousSource` is a `Source` which can download public files when the user
+// has no account connected to its auth provider, the access token is empty then.
+type AnonymousSource interface {
+	AllowsAnonymous() bool
+}
+
+// `PeerCounter` is implemented by the streams of peer to peer sources, like torrents,
+// the counts are shown in the progress.
+type PeerCounter interface {
+	PeerCount() (peers int, seeds int)
+}
+
+// `SourceOptions` are the per download options passed to a `Source`.
+type SourceOptions struct {
+	// User who downloads, the sources which need stored credentials look them up by it.
+	UserID string
+	// Tokens of the source's auth provider, they're refreshed when they expire
+	// so a long download keeps working.
+	TokenSource oauth2.TokenSource
+	// Account the tokens belong to, for the auth providers which link
+	// several accounts to a user. It's empty for the others.
+	AccountID string
+	// Sent with the requests of direct download links.
+	Headers map[string]string
+	// Format used for files which have to be exported, like Google Docs.
+	ExportFormat setting.ExportFormat
+}
+
+// `SourceFile` is a file or folder of a `Source`.
+type SourceFile struct {
+	// Provider specific ID, eg. the Drive file ID or the URL of a direct link.
+	ID string `json:"id"`
+	// Folder path relative to the download destination.
+	Dir      string `json:"dir"`
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	// Size in bytes, less than 1 if it's not known.
+	Size     int64 `json:"size"`
+	IsFolder bool  `json:"is_folder"`
+	// Identifies the version of the file, the partial data of another version isn't reused.
+	ETag string `json:"etag"`
+	// Checksum reported by the source, `Source.Checksum` tells its algorithm.
+	Hash string `json:"hash"`
+}
+
+// `Checksum` is the expected checksum of a file and its algorithm, eg. `md5` or `sha256`.
+type Checksum struct {
+	Algorithm string
+	Value     string
+}
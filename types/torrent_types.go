@@ -0,0 +1,15 @@
+package types
+
+import "time"
+
+// `TorrentConfig` configures the BitTorrent client of the torrent source.
+type TorrentConfig struct {
+	// Data of the torrents and the uploaded `.torrent` files, by their info hash.
+	DataDir string
+	// 0 picks a random port.
+	ListenPort int
+	// Seeding stops once the uploaded data reaches `SeedRatio` times the downloaded files,
+	// or after `SeedTime`. A limit of 0 is ignored, without any limit there's no seeding.
+	SeedRatio float64
+	SeedTime  time.Duration
+}
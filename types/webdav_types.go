@@ -0,0 +1,27 @@
+package types
+
+// `WebDAVMultistatus` is the response of a `PROPFIND` request.
+type WebDAVMultistatus struct {
+	Responses []WebDAVResponse `xml:"DAV: response"`
+}
+
+// `WebDAVResponse` has the properties of a single file or collection, the properties
+// the server doesn't know are in a propstat with a 404 status.
+type WebDAVResponse struct {
+	Href      string `xml:"DAV: href"`
+	Propstats []struct {
+		Status string     `xml:"DAV: status"`
+		Prop   WebDAVProp `xml:"DAV: prop"`
+	} `xml:"DAV: propstat"`
+}
+
+type WebDAVProp struct {
+	DisplayName string `xml:"DAV: displayname"`
+	// A string, as it's empty when the property is missing.
+	ContentLength string    `xml:"DAV: getcontentlength"`
+	ContentType   string    `xml:"DAV: getcontenttype"`
+	ETag          string    `xml:"DAV: getetag"`
+	Collection    *struct{} `xml:"DAV: resourcetype>collection"`
+	// Nextcloud and ownCloud checksums, eg. `SHA1:<hex> MD5:<hex>`.
+	Checksums []string `xml:"http://owncloud.org/ns checksums>checksum"`
+}
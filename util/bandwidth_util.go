@@ -0,0 +1,232 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/types"
+)
+
+// Limiter throttles reads to a number of bytes per second.
+type Limiter interface {
+	// WaitN blocks until `n` bytes are allowed to be read or `ctx` is done.
+	WaitN(ctx context.Context, n int) error
+}
+
+// RateLimiter is a token bucket refilled with `limit` bytes every second, it holds
+// at most one second worth of tokens. A read can take more than the available tokens,
+// the following reads wait until the debt is paid off. A limit less than 1 means unlimited.
+type RateLimiter struct {
+	mu     sync.Mutex
+	limit  int64
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(limit int64) *RateLimiter {
+	return &RateLimiter{limit: limit, last: time.Now()}
+}
+
+// SetLimit changes the limit, it's applied to the reads waiting from now on.
+func (l *RateLimiter) SetLimit(limit int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit == limit {
+		return
+	}
+	l.refill(time.Now())
+	l.limit = limit
+	l.tokens = min(l.tokens, float64(limit))
+}
+
+func (l *RateLimiter) Limit() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.limit
+}
+
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.limit < 1 {
+		l.last = now
+		l.tokens = 0
+		l.mu.Unlock()
+		return nil
+	}
+	l.refill(now)
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / float64(l.limit) * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// refill must be called with `mu` held.
+func (l *RateLimiter) refill(now time.Time) {
+	if l.limit > 0 {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(l.limit), float64(l.limit))
+	}
+	l.last = now
+}
+
+type limitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []Limiter
+}
+
+// NewLimitedReader returns a reader which waits for all the `limiters` after every read.
+func NewLimitedReader(ctx context.Context, r io.Reader, limiters ...Limiter) io.Reader {
+	return &limitedReader{ctx: ctx, r: r, limiters: limiters}
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if n <= 0 {
+		return n, err
+	}
+
+	for _, l := range lr.limiters {
+		if waitErr := l.WaitN(lr.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+// ScheduledLimit returns the global limit at `t`, which is the limit of the first
+// matching schedule rule or the global limit if none of them matches.
+func ScheduledLimit(cfg types.BandwidthConfig, t time.Time) int64 {
+	minute := t.Hour()*60 + t.Minute()
+
+	for _, rule := range cfg.Schedule {
+		start, err := parseClock(rule.Start)
+		if err != nil {
+			continue
+		}
+		end, err := parseClock(rule.End)
+		if err != nil {
+			continue
+		}
+
+		// A rule can go past midnight, eg. 23:00-08:00.
+		if start <= end && minute >= start && minute < end ||
+			start > end && (minute >= start || minute < end) {
+			return rule.Limit
+		}
+	}
+
+	return cfg.GlobalLimit
+}
+
+// ParseBytes parses a size like `512KB`, `2MB` or `1.5GB`, a plain number is taken as bytes.
+func ParseBytes(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) == 0 {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"TB", TB},
+		{"GB", GB},
+		{"MB", MB},
+		{"KB", KB},
+		{"B", 1},
+	}
+
+	size := float64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			size = u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(n * size), nil
+}
+
+// ParseBandwidthSchedule parses comma separated rules like `08:00-23:00=2MB,23:00-08:00=0`.
+func ParseBandwidthSchedule(s string) ([]types.BandwidthRule, error) {
+	rules := make([]types.BandwidthRule, 0)
+	if len(strings.TrimSpace(s)) == 0 {
+		return rules, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		period, limit, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule rule %q, expected HH:MM-HH:MM=LIMIT", part)
+		}
+		start, end, ok := strings.Cut(period, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule rule %q, expected HH:MM-HH:MM=LIMIT", part)
+		}
+
+		n, err := ParseBytes(limit)
+		if err != nil {
+			return nil, err
+		}
+		rule := types.BandwidthRule{Start: strings.TrimSpace(start), End: strings.TrimSpace(end), Limit: n}
+		if err := ValidateBandwidthRule(rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// ValidateBandwidthRule checks that the start and end of `rule` are valid times of the day.
+func ValidateBandwidthRule(rule types.BandwidthRule) error {
+	if _, err := parseClock(rule.Start); err != nil {
+		return err
+	}
+	if _, err := parseClock(rule.End); err != nil {
+		return err
+	}
+	if rule.Limit < 0 {
+		return fmt.Errorf("invalid limit %d", rule.Limit)
+	}
+
+	return nil
+}
+
+// parseClock parses `HH:MM` and returns the minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(int64(MB))
+	data := bytes.Repeat([]byte{1}, int(MB)/2)
+
+	// Reading half a second worth of data twice takes about a second.
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		n, err := io.Copy(io.Discard, NewLimitedReader(context.Background(), bytes.NewReader(data), l))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(data)), n)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+
+	// Unlimited reads don't wait.
+	l.SetLimit(0)
+	start = time.Now()
+	_, err := io.Copy(io.Discard, NewLimitedReader(context.Background(), bytes.NewReader(data), l))
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// Waiting stops with the context.
+	l.SetLimit(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.WaitN(ctx, 1024), context.DeadlineExceeded)
+}
+
+func TestScheduledLimit(t *testing.T) {
+	cfg := types.BandwidthConfig{GlobalLimit: 100}
+	rules, err := ParseBandwidthSchedule("08:00-23:00=2MB, 23:30-01:00=1KB")
+	assert.NoError(t, err)
+	cfg.Schedule = rules
+
+	at := func(clock string) time.Time {
+		t, _ := time.Parse("15:04", clock)
+		return t
+	}
+	assert.Equal(t, int64(2*MB), ScheduledLimit(cfg, at("08:00")))
+	assert.Equal(t, int64(2*MB), ScheduledLimit(cfg, at("22:59")))
+	assert.Equal(t, int64(100), ScheduledLimit(cfg, at("23:00")))
+	assert.Equal(t, int64(KB), ScheduledLimit(cfg, at("23:45")))
+	assert.Equal(t, int64(KB), ScheduledLimit(cfg, at("00:30")))
+	assert.Equal(t, int64(100), ScheduledLimit(cfg, at("03:00")))
+
+	for _, invalid := range []string{"08:00=2MB", "8-23=2MB", "08:00-23:00=fast", "08:00-24:30=1MB"} {
+		_, err := ParseBandwidthSchedule(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	for s, want := range map[string]int64{
+		"":      0,
+		"512":   512,
+		"512KB": 512 * 1024,
+		"2mb":   2 * 1024 * 1024,
+		"1.5GB": 1536 * 1024 * 1024,
+	} {
+		n, err := ParseBytes(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, n, s)
+	}
+
+	_, err := ParseBytes("-1MB")
+	assert.Error(t, err)
+}
@@ -2,12 +2,23 @@ package util
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/nilotpaul/go-downloader/setting"
@@ -29,6 +40,12 @@ const (
 	YB
 )
 
+const (
+	// Suffix of the file which holds the data while a download is in progress.
+	PartSuffix     = ".part"
+	partMetaSuffix = ".part.json"
+)
+
 func SanitizeFileName(fileName string) string {
 	// Replace any invalid characters with an underscore
 	regExp := regexp.MustCompile(`[<>:"/\\|?*\x00-\x1F]`)
@@ -42,6 +59,20 @@ func SanitizeFileName(fileName string) string {
 	return newFileName
 }
 
+// SanitizeDir joins the folders of `rel` to `base`, every folder is sanitized and
+// `.` or `..` are dropped, so a remote path can't point outside of the download destination.
+func SanitizeDir(base string, rel string) string {
+	dir := SanitizeFileName(base)
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		if len(name) == 0 || name == "." || name == ".." {
+			continue
+		}
+		dir = path.Join(dir, SanitizeFileName(name))
+	}
+
+	return dir
+}
+
 func sanitizeFolderPath(folderPath string) (string, bool) {
 	// Replace any invalid characters with an underscore
 	invalidCharsRegex := regexp.MustCompile(`[<>:"\\|?*\x00-\x1F]`)
@@ -51,11 +82,7 @@ func sanitizeFolderPath(folderPath string) (string, bool) {
 	return sanitizedPath, wasValid
 }
 
-func MakeGDriveService(ctx context.Context, accToken string) (*drive.Service, error) {
-	token := &oauth2.Token{
-		AccessToken: accToken,
-	}
-	ts := oauth2.StaticTokenSource(token)
+func MakeGDriveService(ctx context.Context, ts oauth2.TokenSource) (*drive.Service, error) {
 	client := oauth2.NewClient(ctx, ts)
 
 	srv, err := drive.NewService(ctx, option.WithHTTPClient(client))
@@ -87,6 +114,230 @@ func CreateFile(path string) (*os.File, error) {
 	return f, nil
 }
 
+// ErrDestinationInUse is returned by `ClaimDestination` if another download is saving to the same file.
+var ErrDestinationInUse = errors.New("another download is saving to the same file")
+
+// Files which are being downloaded to, see `ClaimDestination`.
+var (
+	destinationsMu sync.Mutex
+	destinations   = make(map[string]bool)
+)
+
+// ClaimDestination reserves `dest` for a single download, two downloads of the same file
+// would otherwise write to the same `.part` file and sidecar. The returned func releases it.
+func ClaimDestination(dest string) (func(), error) {
+	key, err := filepath.Abs(dest)
+	if err != nil {
+		key = filepath.Clean(dest)
+	}
+
+	destinationsMu.Lock()
+	defer destinationsMu.Unlock()
+
+	if destinations[key] {
+		return nil, fmt.Errorf("%w: %s", ErrDestinationInUse, dest)
+	}
+	destinations[key] = true
+
+	return func() {
+		destinationsMu.Lock()
+		defer destinationsMu.Unlock()
+
+		delete(destinations, key)
+	}, nil
+}
+
+// PartFilePath returns the path of the `.part` file used while `dest` is downloading.
+func PartFilePath(dest string) string {
+	return dest + PartSuffix
+}
+
+func partMetaPath(dest string) string {
+	return dest + partMetaSuffix
+}
+
+// OpenPartFile opens the `.part` file for `dest` and returns it along with the
+// offset from where the download should continue. The partial data is only reused
+// if the sidecar matches `meta`, otherwise the `.part` file is truncated.
+func OpenPartFile(dest string, meta types.PartMeta) (*os.File, int64, error) {
+	partPath := PartFilePath(dest)
+
+	prev, err := readPartMeta(dest)
+	if err == nil && *prev == meta {
+		f, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, os.FileMode(0664))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to open the part file: %v", err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, fmt.Errorf("failed to stat the part file: %v", err)
+		}
+
+		offset := info.Size()
+		// A part file bigger than the remote file can't be trusted.
+		if meta.Size > 0 && offset > meta.Size {
+			if err := ResetPartFile(f); err != nil {
+				f.Close()
+				return nil, 0, err
+			}
+			offset = 0
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, 0, fmt.Errorf("failed to seek the part file: %v", err)
+		}
+
+		return f, offset, nil
+	}
+
+	// No usable sidecar, starting from scratch.
+	f, err := CreateFile(partPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := writePartMeta(dest, meta); err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+
+	return f, 0, nil
+}
+
+// ResetPartFile discards all the partial data, used when the server
+// ignores the range request and sends the whole file again.
+func ResetPartFile(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate the part file: %v", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek the part file: %v", err)
+	}
+
+	return nil
+}
+
+// FinalizePartFile atomically renames the `.part` file to `dest` and removes the sidecar.
+func FinalizePartFile(dest string) error {
+	if err := os.Rename(PartFilePath(dest), dest); err != nil {
+		return fmt.Errorf("failed to rename the part file: %v", err)
+	}
+	if err := os.Remove(partMetaPath(dest)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove the part metadata: %v", err)
+	}
+
+	return nil
+}
+
+// RemovePartFile deletes the `.part` file and its sidecar, so the next attempt starts over.
+func RemovePartFile(dest string) error {
+	for _, p := range []string{PartFilePath(dest), partMetaPath(dest)} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %v", p, err)
+		}
+	}
+
+	return nil
+}
+
+func readPartMeta(dest string) (*types.PartMeta, error) {
+	f, err := os.Open(partMetaPath(dest))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var meta types.PartMeta
+	if err := DecodeJSON(f, &meta); err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+func writePartMeta(dest string, meta types.PartMeta) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the part metadata: %v", err)
+	}
+	if err := os.WriteFile(partMetaPath(dest), b, os.FileMode(0664)); err != nil {
+		return fmt.Errorf("failed to write the part metadata: %v", err)
+	}
+
+	return nil
+}
+
+// NewChecksumHash returns the hash used to verify a checksum of `algorithm`.
+func NewChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+}
+
+type exportType struct {
+	mimeType string
+	ext      string
+}
+
+// Export types of every Google Workspace file for each supported format.
+var exportTypes = map[string]map[setting.ExportFormat]exportType{
+	"application/vnd.google-apps.document": {
+		setting.ExportOffice:   {"application/vnd.openxmlformats-officedocument.wordprocessingml.document", ".docx"},
+		setting.ExportPDF:      {"application/pdf", ".pdf"},
+		setting.ExportODF:      {"application/vnd.oasis.opendocument.text", ".odt"},
+		setting.ExportMarkdown: {"text/markdown", ".md"},
+	},
+	"application/vnd.google-apps.spreadsheet": {
+		setting.ExportOffice: {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".xlsx"},
+		setting.ExportPDF:    {"application/pdf", ".pdf"},
+		setting.ExportODF:    {"application/vnd.oasis.opendocument.spreadsheet", ".ods"},
+		setting.ExportCSV:    {"text/csv", ".csv"},
+	},
+	"application/vnd.google-apps.presentation": {
+		setting.ExportOffice: {"application/vnd.openxmlformats-officedocument.presentationml.presentation", ".pptx"},
+		setting.ExportPDF:    {"application/pdf", ".pdf"},
+		setting.ExportODF:    {"application/vnd.oasis.opendocument.presentation", ".odp"},
+	},
+	"application/vnd.google-apps.drawing": {
+		setting.ExportPDF: {"application/pdf", ".pdf"},
+	},
+}
+
+// GetExportType returns the MIME type and file extension to export a Google Workspace file with.
+// If the file doesn't support the given format, it falls back to office and then to PDF.
+// The bool is false if the file can't be exported at all (eg. Forms).
+func GetExportType(googleMimeType string, format setting.ExportFormat) (string, string, bool) {
+	formats, ok := exportTypes[googleMimeType]
+	if !ok {
+		return "", "", false
+	}
+
+	for _, f := range []setting.ExportFormat{format, setting.ExportOffice, setting.ExportPDF} {
+		if t, ok := formats[f]; ok {
+			return t.mimeType, t.ext, true
+		}
+	}
+
+	return "", "", false
+}
+
+// IsValidExportFormat reports whether `format` is one of the supported export formats.
+func IsValidExportFormat(format setting.ExportFormat) bool {
+	switch format {
+	case setting.ExportOffice, setting.ExportPDF, setting.ExportODF, setting.ExportCSV, setting.ExportMarkdown:
+		return true
+	}
+
+	return false
+}
+
 // GetGDriveFileID will extract the fileIDs from the url or link.
 // The bool will return true for a file and vise-versa for a folder.
 func GetGDriveFileID(url string) (string, bool) {
@@ -130,43 +381,84 @@ func GetGDriveFileID(url string) (string, bool) {
 	return fileID, isFile
 }
 
-func ParseGDriveIDs(linksStr string) map[string][]string {
-	IDs := make(map[string][]string)
-
-	links := strings.Split(linksStr, ",")
-	for _, link := range links {
-		fileID, isFile := GetGDriveFileID(link)
-		if len(fileID) != 0 {
-			if isFile {
-				IDs["file"] = append(IDs["file"], fileID)
-			} else {
-				IDs["folder"] = append(IDs["folder"], fileID)
-			}
-		}
+// GetFilesFromFolder walks the folder recursively and returns all the files inside it.
+// Every file has the path of its parent folder relative to the download destination,
+// starting with the name of the top-level folder, so the Drive hierarchy can be recreated.
+func GetFilesFromFolder(srv *drive.Service, folder GDriveFileRef) ([]types.SourceFile, error) {
+	call := srv.Files.Get(folder.ID).Fields("id, title, mimeType")
+	SetGDriveResourceKey(call.Header(), folder)
+	root, err := call.Do()
+	if err != nil {
+		return nil, err
+	}
+	if root.MimeType != setting.GDriveFolderMimeType {
+		return nil, fmt.Errorf("expected folder, received a file")
 	}
 
-	return IDs
-}
+	files := make([]types.SourceFile, 0)
+	// Every folder is only walked and every file only added once, shortcuts pointing to
+	// a parent folder would otherwise create an endless loop, and shortcuts pointing to
+	// a file of the folder would download it twice.
+	visited := map[string]bool{root.Id: true}
+
+	var walk func(folder GDriveFileRef, dir string) error
+	walk = func(folder GDriveFileRef, dir string) error {
+		query := fmt.Sprintf("'%s' in parents and trashed = false", folder.ID)
+		pageToken := ""
+		for {
+			call := srv.Files.List().
+				Q(query).
+				PageToken(pageToken).
+				MaxResults(100).
+				Fields("nextPageToken, items(id, title, mimeType, resourceKey, shortcutDetails)")
+			SetGDriveResourceKey(call.Header(), folder)
+			r, err := call.Do()
+			if err != nil {
+				return err
+			}
 
-func GetFileIDsFromFolder(srv *drive.Service, folderID string) ([]string, error) {
-	var folderIDs []string
-	query := fmt.Sprintf("'%s' in parents and trashed = false", folderID)
-	pageToken := ""
-	for {
-		r, err := srv.Files.List().Q(query).PageToken(pageToken).MaxResults(100).Fields("nextPageToken, items(id, title)").Do()
-		if err != nil {
-			return folderIDs, err
-		}
-		for _, file := range r.Items {
-			folderIDs = append(folderIDs, file.Id)
-		}
-		pageToken = r.NextPageToken
-		if len(pageToken) == 0 {
-			break
+			for _, item := range r.Items {
+				ref, mimeType := GDriveFileRef{ID: item.Id, ResourceKey: item.ResourceKey}, item.MimeType
+				// Shortcuts are resolved to the file or folder they're pointing to.
+				if mimeType == setting.GDriveShortcutMimeType && item.ShortcutDetails != nil {
+					ref = GDriveFileRef{ID: item.ShortcutDetails.TargetId, ResourceKey: item.ShortcutDetails.TargetResourceKey}
+					mimeType = item.ShortcutDetails.TargetMimeType
+				}
+
+				if mimeType != setting.GDriveFolderMimeType {
+					if visited[ref.ID] {
+						slog.Warn("skipping already added file, possible shortcut", "fileID", ref.ID, "dir", dir)
+						continue
+					}
+					visited[ref.ID] = true
+					files = append(files, types.SourceFile{ID: ref.String(), Dir: dir, Name: item.Title, MimeType: mimeType})
+					continue
+				}
+				if visited[ref.ID] {
+					slog.Warn("skipping already visited folder, possible shortcut cycle", "folderID", ref.ID, "dir", dir)
+					continue
+				}
+				visited[ref.ID] = true
+
+				if err := walk(ref, filepath.Join(dir, SanitizeFileName(item.Title))); err != nil {
+					return err
+				}
+			}
+
+			pageToken = r.NextPageToken
+			if len(pageToken) == 0 {
+				break
+			}
 		}
+
+		return nil
+	}
+
+	if err := walk(folder, SanitizeFileName(root.Title)); err != nil {
+		return nil, err
 	}
 
-	return folderIDs, nil
+	return files, nil
 }
 
 func GetFolderTree(rootPath string) (*types.FolderNode, error) {
@@ -269,14 +561,96 @@ func ValidateCancelDownloadHRBody(c *fiber.Ctx) (string, error) {
 		)
 	}
 
-	if len(body.FileID) == 0 {
+	if len(body.JobID) == 0 {
 		return "", NewAppError(
 			http.StatusBadRequest,
-			"invalid fileID",
+			"invalid jobID",
 		)
 	}
 
-	return body.FileID, nil
+	return body.JobID, nil
+}
+
+func ValidatePauseResumeDownloadHRBody(c *fiber.Ctx) ([]string, error) {
+	var body types.PauseResumeDownloadHRBody
+	if err := c.BodyParser(&body); err != nil {
+		return nil, NewAppError(
+			http.StatusUnprocessableEntity,
+			"failed to parse the response body",
+			err,
+		)
+	}
+
+	jobIDs := body.JobIDs
+	if len(body.JobID) != 0 {
+		jobIDs = append(jobIDs, body.JobID)
+	}
+	if len(jobIDs) == 0 || slices.Contains(jobIDs, "") {
+		return nil, NewAppError(
+			http.StatusBadRequest,
+			"invalid jobID",
+		)
+	}
+
+	return jobIDs, nil
+}
+
+func ValidateBandwidthHRBody(c *fiber.Ctx) (*types.BandwidthConfig, error) {
+	var body types.BandwidthConfig
+	if err := c.BodyParser(&body); err != nil {
+		return nil, NewAppError(
+			http.StatusUnprocessableEntity,
+			"failed to parse the response body",
+			err,
+		)
+	}
+
+	if body.GlobalLimit < 0 || body.PerJobLimit < 0 {
+		return nil, NewAppError(
+			http.StatusBadRequest,
+			"invalid limit",
+		)
+	}
+	for _, rule := range body.Schedule {
+		if err := ValidateBandwidthRule(rule); err != nil {
+			return nil, NewAppError(
+				http.StatusBadRequest,
+				fmt.Sprintf("invalid schedule: %s", err.Error()),
+			)
+		}
+	}
+	if body.Schedule == nil {
+		body.Schedule = make([]types.BandwidthRule, 0)
+	}
+
+	return &body, nil
+}
+
+func ValidateJobBandwidthHRBody(c *fiber.Ctx) (string, int64, error) {
+	var body types.JobBandwidthHRBody
+	if err := c.BodyParser(&body); err != nil {
+		return "", 0, NewAppError(
+			http.StatusUnprocessableEntity,
+			"failed to parse the response body",
+			err,
+		)
+	}
+
+	jobID := c.Params("id")
+	if len(jobID) == 0 {
+		return "", 0, NewAppError(
+			http.StatusBadRequest,
+			"invalid jobID",
+		)
+	}
+	if body.Limit < 0 {
+		return "", 0, NewAppError(
+			http.StatusBadRequest,
+			"invalid limit",
+		)
+	}
+
+	return jobID, body.Limit, nil
 }
 
 func ValidateDownloadHRBody(c *fiber.Ctx) (*types.DownloadHRBody, error) {
@@ -296,12 +670,39 @@ func ValidateDownloadHRBody(c *fiber.Ctx) (*types.DownloadHRBody, error) {
 			"invalid folder path",
 		)
 	}
-	if len(body.Links) == 0 {
+	// Uploaded `.torrent` files are downloaded like links.
+	if len(body.Links) == 0 && len(TorrentUploads(c)) == 0 {
 		return nil, NewAppError(
 			http.StatusBadRequest,
 			"invalid link(s)",
 		)
 	}
+	if body.SpeedLimit < 0 {
+		return nil, NewAppError(
+			http.StatusBadRequest,
+			"invalid speed limit",
+		)
+	}
+	if len(body.ExportFormat) != 0 && !IsValidExportFormat(body.ExportFormat) {
+		return nil, NewAppError(
+			http.StatusBadRequest,
+			"invalid export format",
+		)
+	}
+	if err := ValidateHTTPHeaders(body.Headers); err != nil {
+		return nil, NewAppError(
+			http.StatusBadRequest,
+			err.Error(),
+		)
+	}
+	for name, value := range body.Cookies {
+		if len(name) == 0 || strings.ContainsAny(name+value, "; \t\r\n") {
+			return nil, NewAppError(
+				http.StatusBadRequest,
+				fmt.Sprintf("invalid cookie %q", name),
+			)
+		}
+	}
 
 	return &body, nil
 }
@@ -0,0 +1,160 @@
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/option"
+)
+
+func TestOpenPartFile_Resume(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "nested", "file.bin")
+	meta := types.PartMeta{FileID: "id", Size: 10, Checksum: "abc"}
+
+	// First attempt starts from scratch.
+	f, offset, err := OpenPartFile(dest, meta)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), offset)
+	_, err = f.Write([]byte("hello"))
+	assert.NoError(t, err)
+	f.Close()
+
+	// Same remote file, the download continues from the existing offset.
+	f, offset, err = OpenPartFile(dest, meta)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), offset)
+	f.Close()
+
+	// A different remote file discards the partial data.
+	f, offset, err = OpenPartFile(dest, types.PartMeta{FileID: "id", Size: 10, Checksum: "def"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), offset)
+	_, err = f.Write([]byte("0123456789"))
+	assert.NoError(t, err)
+	f.Close()
+
+	// Finalizing renames the `.part` file and removes the sidecar.
+	assert.NoError(t, FinalizePartFile(dest))
+	b, err := os.ReadFile(dest)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(b))
+	_, err = os.Stat(PartFilePath(dest))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(partMetaPath(dest))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestGetFilesFromFolder(t *testing.T) {
+	// root
+	// ├── a.txt
+	// └── sub
+	//     ├── b.txt
+	//     ├── b shortcut -> b (duplicate)
+	//     └── shortcut -> root (cycle)
+	children := map[string]string{
+		"root": `{"items":[
+			{"id":"a","title":"a.txt","mimeType":"text/plain"},
+			{"id":"sub","title":"sub","mimeType":"application/vnd.google-apps.folder","resourceKey":"rk-sub"}
+		]}`,
+		"sub": `{"items":[
+			{"id":"b","title":"b.txt","mimeType":"text/plain","resourceKey":"rk-b"},
+			{"id":"bsc","title":"b shortcut","mimeType":"application/vnd.google-apps.shortcut",
+			 "shortcutDetails":{"targetId":"b","targetResourceKey":"rk-b","targetMimeType":"text/plain"}},
+			{"id":"sc","title":"shortcut","mimeType":"application/vnd.google-apps.shortcut",
+			 "shortcutDetails":{"targetId":"root","targetMimeType":"application/vnd.google-apps.folder"}}
+		]}`,
+	}
+
+	// The resource keys sent with every request, by the folder.
+	keys := make(map[string]string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		key := r.Header.Get("X-Goog-Drive-Resource-Keys")
+		if r.URL.Path == "/files/root" {
+			keys["get"] = key
+			w.Write([]byte(`{"id":"root","title":"Root: Folder","mimeType":"application/vnd.google-apps.folder"}`))
+			return
+		}
+		q := r.URL.Query().Get("q")
+		for id, body := range children {
+			if strings.HasPrefix(q, "'"+id+"'") {
+				keys[id] = key
+				w.Write([]byte(body))
+				return
+			}
+		}
+		w.Write([]byte(`{"items":[]}`))
+	}))
+	defer ts.Close()
+
+	srv, err := drive.NewService(context.Background(), option.WithHTTPClient(ts.Client()), option.WithEndpoint(ts.URL+"/"))
+	assert.NoError(t, err)
+
+	files, err := GetFilesFromFolder(srv, GDriveFileRef{ID: "root", ResourceKey: "rk-root"})
+	assert.NoError(t, err)
+	assert.Equal(t, []types.SourceFile{
+		{ID: "a", Dir: "Root_ Folder", Name: "a.txt", MimeType: "text/plain"},
+		{ID: "b?resourcekey=rk-b", Dir: filepath.Join("Root_ Folder", "sub"), Name: "b.txt", MimeType: "text/plain"},
+	}, files)
+	assert.Equal(t, map[string]string{"get": "root/rk-root", "root": "root/rk-root", "sub": "sub/rk-sub"}, keys)
+}
+
+func TestGetExportType(t *testing.T) {
+	tests := []struct {
+		name     string
+		mimeType string
+		format   setting.ExportFormat
+		wantMime string
+		wantExt  string
+		wantOK   bool
+	}{
+		{"docs as markdown", "application/vnd.google-apps.document", setting.ExportMarkdown, "text/markdown", ".md", true},
+		{"sheets as csv", "application/vnd.google-apps.spreadsheet", setting.ExportCSV, "text/csv", ".csv", true},
+		{"slides as odf", "application/vnd.google-apps.presentation", setting.ExportODF, "application/vnd.oasis.opendocument.presentation", ".odp", true},
+		{"slides as csv falls back to office", "application/vnd.google-apps.presentation", setting.ExportCSV, "application/vnd.openxmlformats-officedocument.presentationml.presentation", ".pptx", true},
+		{"docs as csv falls back to office", "application/vnd.google-apps.document", setting.ExportCSV, "application/vnd.openxmlformats-officedocument.wordprocessingml.document", ".docx", true},
+		{"drawing as office falls back to pdf", "application/vnd.google-apps.drawing", setting.ExportOffice, "application/pdf", ".pdf", true},
+		{"drawing as markdown falls back to pdf", "application/vnd.google-apps.drawing", setting.ExportMarkdown, "application/pdf", ".pdf", true},
+		{"forms can't be exported", "application/vnd.google-apps.form", setting.ExportPDF, "", "", false},
+		{"shortcuts can't be exported", "application/vnd.google-apps.shortcut", setting.ExportOffice, "", "", false},
+		{"regular files aren't exported", "application/pdf", setting.ExportPDF, "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mimeType, ext, ok := GetExportType(tt.mimeType, tt.format)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantMime, mimeType)
+			assert.Equal(t, tt.wantExt, ext)
+		})
+	}
+}
+
+func TestIsValidExportFormat(t *testing.T) {
+	tests := []struct {
+		format setting.ExportFormat
+		want   bool
+	}{
+		{setting.ExportOffice, true},
+		{setting.ExportPDF, true},
+		{setting.ExportODF, true},
+		{setting.ExportCSV, true},
+		{setting.ExportMarkdown, true},
+		{"", false},
+		{"docx", false},
+		{"PDF", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, IsValidExportFormat(tt.format), tt.format)
+	}
+}
@@ -0,0 +1,120 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrGDriveQuotaExceeded = errors.New("the download quota of this Drive file has been exceeded, try again later or connect a Google account")
+	ErrGDriveNotPublic     = errors.New("the Drive file isn't shared publicly, connect a Google account with access to it")
+)
+
+var (
+	// The form of the page shown instead of large files, which can't be scanned for viruses.
+	gdriveConfirmFormRegex  = regexp.MustCompile(`(?s)<form[^>]*id="download-form"[^>]*action="([^"]+)"[^>]*>(.*?)</form>`)
+	gdriveHiddenInputRegex  = regexp.MustCompile(`<input[^>]*type="hidden"[^>]*name="([^"]+)"[^>]*value="([^"]*)"`)
+	gdriveConfirmLinkRegex  = regexp.MustCompile(`href="(/uc\?export=download[^"]*confirm=[^"]*)"`)
+	gdriveQuotaPageMessages = [][]byte{
+		[]byte("Too many users have viewed or downloaded this file recently"),
+		[]byte("download quota for this file has been exceeded"),
+	}
+)
+
+// GDriveFileRef is a Drive file or folder. Links shared before 2021 need the
+// resource key of the file as well, unless the user has access to it.
+type GDriveFileRef struct {
+	ID          string
+	ResourceKey string
+}
+
+// String formats the reference as it's stored in the download jobs, the resource key follows the ID.
+func (r GDriveFileRef) String() string {
+	if len(r.ResourceKey) == 0 {
+		return r.ID
+	}
+
+	return r.ID + "?resourcekey=" + url.QueryEscape(r.ResourceKey)
+}
+
+// ResourceKeyHeader returns the value of the `X-Goog-Drive-Resource-Keys` header, empty if there's no key.
+func (r GDriveFileRef) ResourceKeyHeader() string {
+	if len(r.ResourceKey) == 0 {
+		return ""
+	}
+
+	return r.ID + "/" + r.ResourceKey
+}
+
+// SetGDriveResourceKey sends the resource key of `ref` with a Drive API request, if it has one.
+func SetGDriveResourceKey(header http.Header, ref GDriveFileRef) {
+	if key := ref.ResourceKeyHeader(); len(key) != 0 {
+		header.Set("X-Goog-Drive-Resource-Keys", key)
+	}
+}
+
+// ParseGDriveFileRef parses the ID of a Drive file as formatted by `GDriveFileRef.String`.
+func ParseGDriveFileRef(fileID string) GDriveFileRef {
+	id, query, ok := strings.Cut(fileID, "?")
+	if !ok {
+		return GDriveFileRef{ID: fileID}
+	}
+	values, _ := url.ParseQuery(query)
+
+	return GDriveFileRef{ID: id, ResourceKey: values.Get("resourcekey")}
+}
+
+// GetGDriveResourceKey returns the `resourcekey` of a Drive link, empty if it has none.
+func GetGDriveResourceKey(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	return u.Query().Get("resourcekey")
+}
+
+// ParseGDriveConfirmPage returns the URL which confirms the download of a large file, from the
+// warning page Drive shows instead of it. `base` is the URL of the page.
+func ParseGDriveConfirmPage(page []byte, base *url.URL) (string, bool) {
+	if m := gdriveConfirmFormRegex.FindSubmatch(page); m != nil {
+		action, err := base.Parse(html.UnescapeString(string(m[1])))
+		if err != nil {
+			return "", false
+		}
+		query := action.Query()
+		for _, input := range gdriveHiddenInputRegex.FindAllSubmatch(m[2], -1) {
+			query.Set(html.UnescapeString(string(input[1])), html.UnescapeString(string(input[2])))
+		}
+		action.RawQuery = query.Encode()
+		return action.String(), true
+	}
+
+	// Older pages link to the file with a confirmation token.
+	if m := gdriveConfirmLinkRegex.FindSubmatch(page); m != nil {
+		u, err := base.Parse(html.UnescapeString(string(m[1])))
+		if err != nil {
+			return "", false
+		}
+		return u.String(), true
+	}
+
+	return "", false
+}
+
+// IsGDriveQuotaPage reports whether Drive refused the download, because the file
+// was downloaded too often. The quota is reset after up to a day.
+func IsGDriveQuotaPage(page []byte) bool {
+	for _, msg := range gdriveQuotaPageMessages {
+		if bytes.Contains(page, msg) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGDriveFileRef(t *testing.T) {
+	ref := GDriveFileRef{ID: "abc", ResourceKey: "0-a/b"}
+	assert.Equal(t, "abc?resourcekey=0-a%2Fb", ref.String())
+	assert.Equal(t, "abc/0-a/b", ref.ResourceKeyHeader())
+	assert.Equal(t, ref, ParseGDriveFileRef(ref.String()))
+
+	plain := GDriveFileRef{ID: "abc"}
+	assert.Equal(t, "abc", plain.String())
+	assert.Empty(t, plain.ResourceKeyHeader())
+	assert.Equal(t, plain, ParseGDriveFileRef("abc"))
+
+	assert.Equal(t, "0-xyz", GetGDriveResourceKey("https://drive.google.com/file/d/abc/view?usp=sharing&resourcekey=0-xyz"))
+	assert.Empty(t, GetGDriveResourceKey("https://drive.google.com/file/d/abc/view"))
+}
+
+func TestParseGDriveConfirmPage(t *testing.T) {
+	base, _ := url.Parse("https://drive.google.com/uc?export=download&id=abc")
+
+	page := []byte(`<p>Google Drive can't scan this file for viruses.</p>
+<form id="download-form" action="https://drive.usercontent.google.com/download" method="get">
+<input type="submit" id="uc-download-link" value="Download anyway"/>
+<input type="hidden" name="id" value="abc">
+<input type="hidden" name="export" value="download">
+<input type="hidden" name="confirm" value="t">
+<input type="hidden" name="uuid" value="1&amp;2">
+</form>`)
+	link, ok := ParseGDriveConfirmPage(page, base)
+	assert.True(t, ok)
+	assert.Equal(t, "https://drive.usercontent.google.com/download?confirm=t&export=download&id=abc&uuid=1%262", link)
+
+	// Older pages link to the file instead.
+	page = []byte(`<a id="uc-download-link" href="/uc?export=download&amp;confirm=Xy_1&amp;id=abc">Download anyway</a>`)
+	link, ok = ParseGDriveConfirmPage(page, base)
+	assert.True(t, ok)
+	assert.Equal(t, "https://drive.google.com/uc?export=download&confirm=Xy_1&id=abc", link)
+
+	_, ok = ParseGDriveConfirmPage([]byte(`<html>Sign in</html>`), base)
+	assert.False(t, ok)
+}
+
+func TestIsGDriveQuotaPage(t *testing.T) {
+	assert.True(t, IsGDriveQuotaPage([]byte(`<p>Too many users have viewed or downloaded this file recently.</p>`)))
+	assert.False(t, IsGDriveQuotaPage([]byte(`<p>Google Drive can't scan this file for viruses.</p>`)))
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nilotpaul/go-downloader/types"
+	"google.golang.org/api/googleapi"
+)
+
+// OrderGoogleAccounts puts the `preferred` account first, it's matched by its ID or name.
+// The other accounts keep their order, the primary account first.
+func OrderGoogleAccounts(accounts []*types.GoogleAccount, preferred string) ([]*types.GoogleAccount, error) {
+	if len(preferred) == 0 {
+		return accounts, nil
+	}
+
+	for i, acc := range accounts {
+		if acc.ID != preferred && !strings.EqualFold(acc.Name, preferred) {
+			continue
+		}
+
+		ordered := make([]*types.GoogleAccount, 0, len(accounts))
+		ordered = append(ordered, acc)
+		ordered = append(ordered, accounts[:i]...)
+		return append(ordered, accounts[i+1:]...), nil
+	}
+
+	return nil, fmt.Errorf("no google account named %s", preferred)
+}
+
+// ValidateGoogleAccountName trims the name of a linked account and checks it.
+func ValidateGoogleAccountName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return "", fmt.Errorf("name is required")
+	}
+	if len(name) > 255 {
+		return "", fmt.Errorf("name is too long")
+	}
+
+	return name, nil
+}
+
+// FreeGoogleAccountName returns the name of a newly linked account, its `email` unless another account
+// of the user was renamed to it. A number is added then, eg. `b@x.com (2)`. Names are compared without
+// their case, like downloads pick the account by its name.
+func FreeGoogleAccountName(email string, taken []string) string {
+	used := make(map[string]bool, len(taken))
+	for _, name := range taken {
+		used[strings.ToLower(name)] = true
+	}
+
+	name := email
+	for i := 2; used[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s (%d)", email, i)
+	}
+
+	return name
+}
+
+// IsAccessDenied reports whether a request failed because the account has no access to the file,
+// another account of the user might have it.
+func IsAccessDenied(err error) bool {
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		return isAccessDeniedStatus(gErr.Code)
+	}
+
+	var httpErr *HTTPStatusError
+	if errors.As(err, &httpErr) {
+		return isAccessDeniedStatus(httpErr.StatusCode)
+	}
+
+	return false
+}
+
+func isAccessDeniedStatus(status int) bool {
+	return status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusNotFound
+}
@@ -0,0 +1,73 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+func TestOrderGoogleAccounts(t *testing.T) {
+	accounts := []*types.GoogleAccount{
+		{ID: "1", Name: "personal", IsPrimary: true},
+		{ID: "2", Name: "Lab"},
+		{ID: "3", Name: "institute"},
+	}
+
+	ordered, err := OrderGoogleAccounts(accounts, "")
+	assert.NoError(t, err)
+	assert.Equal(t, accounts, ordered)
+
+	ordered, err = OrderGoogleAccounts(accounts, "lab")
+	assert.NoError(t, err)
+	assert.Equal(t, []*types.GoogleAccount{accounts[1], accounts[0], accounts[2]}, ordered)
+
+	ordered, err = OrderGoogleAccounts(accounts, "3")
+	assert.NoError(t, err)
+	assert.Equal(t, []*types.GoogleAccount{accounts[2], accounts[0], accounts[1]}, ordered)
+	// The accounts aren't reordered in place.
+	assert.Equal(t, "1", accounts[0].ID)
+
+	_, err = OrderGoogleAccounts(accounts, "work")
+	assert.Error(t, err)
+}
+
+func TestValidateGoogleAccountName(t *testing.T) {
+	name, err := ValidateGoogleAccountName("  Lab  ")
+	assert.NoError(t, err)
+	assert.Equal(t, "Lab", name)
+
+	_, err = ValidateGoogleAccountName(" ")
+	assert.Error(t, err)
+}
+
+func TestIsAccessDenied(t *testing.T) {
+	assert.True(t, IsAccessDenied(&googleapi.Error{Code: http.StatusNotFound}))
+	assert.True(t, IsAccessDenied(fmt.Errorf("stat: %w", &googleapi.Error{Code: http.StatusForbidden})))
+	assert.True(t, IsAccessDenied(&HTTPStatusError{StatusCode: http.StatusUnauthorized}))
+	assert.False(t, IsAccessDenied(&googleapi.Error{Code: http.StatusInternalServerError}))
+	assert.False(t, IsAccessDenied(fmt.Errorf("connection reset")))
+}
+
+func TestFreeGoogleAccountName(t *testing.T) {
+	tests := []struct {
+		name  string
+		taken []string
+		want  string
+	}{
+		{"no other accounts", nil, "b@x.com"},
+		{"email is free", []string{"a@x.com", "lab"}, "b@x.com"},
+		{"another account was renamed to the email", []string{"b@x.com"}, "b@x.com (2)"},
+		{"names are compared without their case", []string{"B@X.com"}, "b@x.com (2)"},
+		{"numbered names are taken too", []string{"b@x.com", "b@x.com (2)", "b@x.com (4)"}, "b@x.com (3)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, FreeGoogleAccountName("b@x.com", tt.taken))
+		})
+	}
+}
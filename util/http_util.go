@@ -0,0 +1,164 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPStatusError is returned for an unexpected response status of a direct download,
+// so `IsTransientError` can tell a rate limit or a server error apart from a missing file.
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected response status: %s", e.Status)
+}
+
+// NewHTTPClient returns a client for direct downloads which follows at most `maxRedirects` redirects.
+// There's no overall timeout as downloads can take hours, only waiting for the response headers is limited.
+func NewHTTPClient(maxRedirects int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 30 * time.Second
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// SetRangeHeader asks for the data from `offset`. With a strong `etag` the range only applies
+// if the file didn't change, otherwise the server sends the whole file.
+func SetRangeHeader(req *http.Request, offset int64, etag string) {
+	if offset <= 0 {
+		return
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	// Weak ETags can't be used for range requests.
+	if len(etag) != 0 && !strings.HasPrefix(etag, "W/") {
+		req.Header.Set("If-Range", etag)
+	}
+}
+
+// OpenRange sends the request of `newRequest` for the data from `offset`, it returns the body
+// and the offset it starts at, which is 0 if the server sent the whole file. A status other than
+// 200 or 206 is turned into an error by `statusErr`, or an `HTTPStatusError` if it's nil.
+func OpenRange(client *http.Client, offset int64, newRequest func(offset int64) (*http.Request, error), statusErr func(*http.Response) error) (io.ReadCloser, int64, error) {
+	req, err := newRequest(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		return res.Body, offset, nil
+	case http.StatusOK:
+		return res.Body, 0, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial data is already bigger than the file, it has to start over.
+		if offset > 0 {
+			res.Body.Close()
+			return OpenRange(client, 0, newRequest, statusErr)
+		}
+	}
+	defer res.Body.Close()
+
+	if statusErr == nil {
+		return nil, 0, &HTTPStatusError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+
+	return nil, 0, statusErr(res)
+}
+
+// ContentRangeSize returns the complete size from a Content-Range header like `bytes 0-0/1234`,
+// or -1 if it's not known.
+func ContentRangeSize(header string) int64 {
+	_, size, ok := strings.Cut(header, "/")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+
+	return n
+}
+
+// GetHTTPFileName takes the file name from the Content-Disposition header,
+// falling back to the last segment of the URL path.
+func GetHTTPFileName(header http.Header, u *url.URL) string {
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		// `filename*` is already decoded into `filename` by the parser.
+		if name := path.Base(strings.ReplaceAll(params["filename"], "\\", "/")); name != "." && name != "/" {
+			return name
+		}
+	}
+
+	if name := path.Base(u.Path); name != "." && name != "/" {
+		return name
+	}
+
+	return u.Hostname()
+}
+
+// MergeCookies adds `cookies` to the `Cookie` header, the result is a new map.
+func MergeCookies(headers map[string]string, cookies map[string]string) map[string]string {
+	merged := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		merged[http.CanonicalHeaderKey(k)] = v
+	}
+	if len(cookies) == 0 {
+		return merged
+	}
+
+	names := make([]string, 0, len(cookies))
+	for name := range cookies {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	pairs := make([]string, 0, len(cookies))
+	if cookie, ok := merged["Cookie"]; ok && len(cookie) != 0 {
+		pairs = append(pairs, cookie)
+	}
+	for _, name := range names {
+		pairs = append(pairs, (&http.Cookie{Name: name, Value: cookies[name]}).String())
+	}
+	merged["Cookie"] = strings.Join(pairs, "; ")
+
+	return merged
+}
+
+// ValidateHTTPHeaders checks the custom headers of a direct download,
+// so they can't break the request.
+func ValidateHTTPHeaders(headers map[string]string) error {
+	for k, v := range headers {
+		if len(k) == 0 || strings.ContainsAny(k, ": \t\r\n") {
+			return fmt.Errorf("invalid header name %q", k)
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("invalid value for header %q", k)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package util
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetHTTPFileName(t *testing.T) {
+	u, _ := url.Parse("https://example.com/releases/ubuntu%2024.iso?token=1")
+
+	header := make(http.Header)
+	assert.Equal(t, "ubuntu 24.iso", GetHTTPFileName(header, u))
+
+	header.Set("Content-Disposition", `attachment; filename="../report.pdf"`)
+	assert.Equal(t, "report.pdf", GetHTTPFileName(header, u))
+
+	header.Set("Content-Disposition", `attachment; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`)
+	assert.Equal(t, "résumé.pdf", GetHTTPFileName(header, u))
+
+	root, _ := url.Parse("https://example.com/")
+	assert.Equal(t, "example.com", GetHTTPFileName(make(http.Header), root))
+}
+
+func TestOpenRange(t *testing.T) {
+	content := "0123456789"
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Range"))
+		if r.URL.Path == "/missing" {
+			http.Error(w, "no such file", http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	open := func(path string, offset int64, statusErr func(*http.Response) error) (string, int64, error) {
+		body, start, err := OpenRange(srv.Client(), offset, func(offset int64) (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+			if err != nil {
+				return nil, err
+			}
+			SetRangeHeader(req, offset, `W/"weak"`)
+			assert.Empty(t, req.Header.Get("If-Range"))
+
+			return req, nil
+		}, statusErr)
+		if err != nil {
+			return "", 0, err
+		}
+		defer body.Close()
+		b, err := io.ReadAll(body)
+
+		return string(b), start, err
+	}
+
+	data, start, err := open("/data.bin", 4, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), start)
+	assert.Equal(t, content[4:], data)
+
+	// Past the end of the file, it starts over without a range.
+	requests = nil
+	data, start, err = open("/data.bin", 20, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), start)
+	assert.Equal(t, content, data)
+	assert.Equal(t, []string{"bytes=20-", ""}, requests)
+
+	_, _, err = open("/missing", 0, nil)
+	var statusErr *HTTPStatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+
+	// The body can still be read for the error.
+	_, _, err = open("/missing", 0, func(res *http.Response) error {
+		b, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return errors.New(strings.TrimSpace(string(b)))
+	})
+	assert.EqualError(t, err, "no such file")
+}
+
+func TestContentRangeSize(t *testing.T) {
+	assert.Equal(t, int64(1234), ContentRangeSize("bytes 0-0/1234"))
+	assert.Equal(t, int64(0), ContentRangeSize("bytes */0"))
+	assert.Equal(t, int64(-1), ContentRangeSize("bytes 0-0/*"))
+	assert.Equal(t, int64(-1), ContentRangeSize(""))
+}
+
+func TestMergeCookies(t *testing.T) {
+	headers := MergeCookies(map[string]string{"cookie": "a=1", "authorization": "Bearer x"}, map[string]string{"c": "3", "b": "2"})
+	assert.Equal(t, map[string]string{"Cookie": "a=1; b=2; c=3", "Authorization": "Bearer x"}, headers)
+}
@@ -0,0 +1,193 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// RemoteLocation is a file or a folder of an `sftp://`, `ftp://` or `ftps://` link.
+type RemoteLocation struct {
+	Protocol setting.ProfileProtocol
+	Host     string
+	Port     int
+	Path     string
+}
+
+// String formats the location as a link, the default port is left out.
+func (l RemoteLocation) String() string {
+	host := l.Host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if l.Port != DefaultRemotePort(l.Protocol) {
+		host = net.JoinHostPort(l.Host, strconv.Itoa(l.Port))
+	}
+	u := url.URL{Scheme: string(l.Protocol), Host: host, Path: l.Path}
+
+	return u.String()
+}
+
+// ParseRemoteLink parses an `sftp://`, `ftp://` or `ftps://` link. The credentials come
+// from the user's connection profile, so links with credentials are rejected.
+func ParseRemoteLink(link string) (RemoteLocation, error) {
+	u, err := url.Parse(link)
+	if err != nil || len(u.Hostname()) == 0 {
+		return RemoteLocation{}, fmt.Errorf("invalid link %s", link)
+	}
+
+	protocol := setting.ProfileProtocol(strings.ToLower(u.Scheme))
+	// WebDAV links have their own format, see `ParseWebDAVLink`.
+	if DefaultRemotePort(protocol) == 0 || protocol == setting.ProtocolWebDAV {
+		return RemoteLocation{}, fmt.Errorf("invalid link %s", link)
+	}
+	if u.User != nil {
+		return RemoteLocation{}, fmt.Errorf("links can't contain credentials, use a connection profile instead")
+	}
+
+	loc := RemoteLocation{
+		Protocol: protocol,
+		Host:     strings.ToLower(u.Hostname()),
+		Port:     DefaultRemotePort(protocol),
+		Path:     u.Path,
+	}
+	if len(u.Port()) != 0 {
+		if loc.Port, err = strconv.Atoi(u.Port()); err != nil || loc.Port < 1 || loc.Port > 65535 {
+			return RemoteLocation{}, fmt.Errorf("invalid port in link %s", link)
+		}
+	}
+	if len(loc.Path) == 0 {
+		loc.Path = "/"
+	}
+
+	return loc, nil
+}
+
+// DefaultRemotePort returns the default port of the protocol, 0 if it's not supported.
+func DefaultRemotePort(protocol setting.ProfileProtocol) int {
+	switch protocol {
+	case setting.ProtocolSFTP:
+		return 22
+	case setting.ProtocolFTP:
+		return 21
+	case setting.ProtocolFTPS:
+		return 990
+	case setting.ProtocolWebDAV:
+		return 443
+	default:
+		return 0
+	}
+}
+
+// SSHHostKeyCallback only accepts `hostKey`, which is either an `authorized_keys` or `known_hosts`
+// line, or a `SHA256:` fingerprint as printed by `ssh-keygen -l`.
+func SSHHostKeyCallback(hostKey string) (ssh.HostKeyCallback, error) {
+	hostKey = strings.TrimSpace(hostKey)
+	if strings.HasPrefix(hostKey, "SHA256:") {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if ssh.FingerprintSHA256(key) != hostKey {
+				return fmt.Errorf("host key mismatch for %s, got %s", hostname, ssh.FingerprintSHA256(key))
+			}
+			return nil
+		}, nil
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		// A line of `known_hosts` or `ssh-keyscan`, which starts with the host.
+		if _, _, key, _, _, err = ssh.ParseKnownHosts([]byte(hostKey)); err != nil {
+			return nil, fmt.Errorf("invalid host key: %v", err)
+		}
+	}
+
+	return func(hostname string, remote net.Addr, remoteKey ssh.PublicKey) error {
+		if !bytes.Equal(key.Marshal(), remoteKey.Marshal()) {
+			return fmt.Errorf("host key mismatch for %s, got %s", hostname, ssh.FingerprintSHA256(remoteKey))
+		}
+		return nil
+	}, nil
+}
+
+func ValidateConnectionProfileHRBody(c *fiber.Ctx) (*types.ConnectionProfileHRBody, error) {
+	var body types.ConnectionProfileHRBody
+	if err := c.BodyParser(&body); err != nil {
+		return nil, NewAppError(
+			http.StatusUnprocessableEntity,
+			"failed to parse the response body",
+			err,
+		)
+	}
+
+	if err := ValidateConnectionProfile(&body); err != nil {
+		return nil, NewAppError(
+			http.StatusBadRequest,
+			err.Error(),
+		)
+	}
+
+	return &body, nil
+}
+
+// ValidateConnectionProfile checks the profile and sets the default port if it has none.
+func ValidateConnectionProfile(body *types.ConnectionProfileHRBody) error {
+	body.Protocol = setting.ProfileProtocol(strings.ToLower(string(body.Protocol)))
+	body.Host = strings.ToLower(strings.TrimSpace(body.Host))
+
+	if DefaultRemotePort(body.Protocol) == 0 {
+		return fmt.Errorf("invalid protocol, expected sftp, ftp, ftps or webdav")
+	}
+	if len(body.Host) == 0 || strings.ContainsAny(body.Host, "/@ \t") {
+		return fmt.Errorf("invalid host")
+	}
+	if body.Port == 0 {
+		body.Port = DefaultRemotePort(body.Protocol)
+	}
+	if body.Port < 1 || body.Port > 65535 {
+		return fmt.Errorf("invalid port")
+	}
+	if len(body.Name) == 0 {
+		body.Name = body.Host
+	}
+	if len(body.Username) == 0 {
+		return fmt.Errorf("username is required")
+	}
+
+	if body.ExplicitTLS && body.Protocol != setting.ProtocolFTPS {
+		return fmt.Errorf("explicit_tls is only supported by ftps")
+	}
+	if body.Protocol != setting.ProtocolSFTP {
+		if len(body.PrivateKey) != 0 || len(body.HostKey) != 0 {
+			return fmt.Errorf("private_key and host_key are only supported by sftp")
+		}
+		if body.Protocol == setting.ProtocolWebDAV && len(body.Password) == 0 {
+			return fmt.Errorf("password is required, eg. an app password")
+		}
+		return nil
+	}
+
+	if len(body.Password) == 0 && len(body.PrivateKey) == 0 {
+		return fmt.Errorf("password or private_key is required")
+	}
+	if len(body.PrivateKey) != 0 {
+		if _, err := ssh.ParsePrivateKey([]byte(body.PrivateKey)); err != nil {
+			return fmt.Errorf("invalid private key: %v", err)
+		}
+	}
+	if len(body.HostKey) == 0 {
+		return fmt.Errorf("host_key is required, eg. the output of `ssh-keyscan %s`", body.Host)
+	}
+	if _, err := SSHHostKeyCallback(body.HostKey); err != nil {
+		return err
+	}
+
+	return nil
+}
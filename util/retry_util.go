@@ -0,0 +1,93 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Drive error reasons which go away on their own after some time.
+var transientReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"backendError":          true,
+	"internalError":         true,
+}
+
+// Drive error reasons which won't go away by retrying.
+var permanentReasons = map[string]bool{
+	"cannotDownloadFile":          true,
+	"downloadQuotaExceeded":       true,
+	"quotaExceeded":               true,
+	"dailyLimitExceeded":          true,
+	"notFound":                    true,
+	"insufficientFilePermissions": true,
+}
+
+// IsTransientError reports whether a failed download is worth retrying. Connection
+// resets, timeouts, 5xx, 429 and rate limit errors are transient, everything else
+// like 404, `cannotDownloadFile` or an exceeded quota is permanent.
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		for _, e := range gErr.Errors {
+			if permanentReasons[e.Reason] {
+				return false
+			}
+			if transientReasons[e.Reason] {
+				return true
+			}
+		}
+		return gErr.Code == http.StatusTooManyRequests || gErr.Code >= http.StatusInternalServerError
+	}
+
+	var httpErr *HTTPStatusError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Backoff returns the delay before the retry `attempt` (starting from 1). The delay doubles
+// with every attempt up to `max`, and a random jitter of up to half of it is subtracted,
+// so many failed downloads don't retry at the same time.
+func Backoff(attempt int, base time.Duration, max time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if half := int64(delay / 2); half > 0 {
+		delay -= time.Duration(rand.Int64N(half))
+	}
+
+	return delay
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsTransientError(t *testing.T) {
+	driveErr := func(code int, reason string) error {
+		return fmt.Errorf("failed to download the file: %w", &googleapi.Error{
+			Code:   code,
+			Errors: []googleapi.ErrorItem{{Reason: reason}},
+		})
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"cancelled", context.Canceled, false},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"unexpected eof", fmt.Errorf("%w: size mismatch", io.ErrUnexpectedEOF), true},
+		{"server error", driveErr(http.StatusServiceUnavailable, ""), true},
+		{"too many requests", driveErr(http.StatusTooManyRequests, ""), true},
+		{"rate limit", driveErr(http.StatusForbidden, "rateLimitExceeded"), true},
+		{"not found", driveErr(http.StatusNotFound, "notFound"), false},
+		{"cannot download", driveErr(http.StatusForbidden, "cannotDownloadFile"), false},
+		{"quota exceeded", driveErr(http.StatusForbidden, "downloadQuotaExceeded"), false},
+		{"other", fmt.Errorf("invalid file id"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsTransientError(tt.err))
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := time.Second, 10*time.Second
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		want := min(base<<(attempt-1), max)
+		got := Backoff(attempt, base, max)
+
+		// The jitter takes off up to half of the delay.
+		assert.LessOrEqual(t, got, want)
+		assert.Greater(t, got, want/2-1)
+	}
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/nilotpaul/go-downloader/setting"
+	"github.com/nilotpaul/go-downloader/types"
+)
+
+// S3Location is an object or a prefix of an `s3://` link.
+type S3Location struct {
+	// Name of the endpoint in the config.
+	Endpoint string
+	Bucket   string
+	Key      string
+}
+
+// String formats the location as an `s3://` link, the default endpoint is left out.
+func (l S3Location) String() string {
+	u := url.URL{Scheme: "s3", Host: l.Bucket, Path: "/" + l.Key}
+	if l.Endpoint != setting.S3DefaultEndpoint {
+		u.User = url.User(l.Endpoint)
+	}
+
+	return u.String()
+}
+
+// ParseS3Link parses links like `s3://bucket/prefix`, another endpoint than the default one
+// is picked by its name, eg. `s3://minio@bucket/prefix`.
+func ParseS3Link(link string) (S3Location, error) {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "s3" || len(u.Host) == 0 {
+		return S3Location{}, fmt.Errorf("invalid s3 link %s", link)
+	}
+
+	loc := S3Location{
+		Endpoint: setting.S3DefaultEndpoint,
+		Bucket:   u.Host,
+		Key:      strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.User != nil && len(u.User.Username()) != 0 {
+		loc.Endpoint = u.User.Username()
+	}
+
+	return loc, nil
+}
+
+// S3ObjectURL returns the URL of an object, or of the bucket if `key` is empty.
+func S3ObjectURL(endpoint types.S3Endpoint, bucket string, key string) (*url.URL, error) {
+	u, err := url.Parse(endpoint.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	} else {
+		u.Host = bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+
+	return u, nil
+}
+
+func ValidateS3Endpoint(endpoint types.S3Endpoint) error {
+	u, err := url.Parse(endpoint.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("invalid url %q", endpoint.URL)
+	}
+	if len(endpoint.Region) == 0 {
+		return fmt.Errorf("region is required")
+	}
+	if len(endpoint.AccessKey) == 0 || len(endpoint.SecretKey) == 0 {
+		return fmt.Errorf("access_key and secret_key are required")
+	}
+
+	return nil
+}
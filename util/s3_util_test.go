@@ -0,0 +1,33 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseS3Link(t *testing.T) {
+	loc, err := ParseS3Link("s3://datasets/2024/july/")
+	assert.NoError(t, err)
+	assert.Equal(t, S3Location{Endpoint: "default", Bucket: "datasets", Key: "2024/july/"}, loc)
+	assert.Equal(t, "s3://datasets/2024/july/", loc.String())
+
+	loc, err = ParseS3Link("s3://minio@datasets/a b.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, S3Location{Endpoint: "minio", Bucket: "datasets", Key: "a b.csv"}, loc)
+	assert.Equal(t, "s3://minio@datasets/a%20b.csv", loc.String())
+
+	_, err = ParseS3Link("https://datasets.s3.amazonaws.com/a.csv")
+	assert.Error(t, err)
+}
+
+func TestS3ObjectURL(t *testing.T) {
+	u, err := S3ObjectURL(types.S3Endpoint{URL: "http://localhost:9000", PathStyle: true}, "datasets", "a b.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:9000/datasets/a%20b.csv", u.String())
+
+	u, err = S3ObjectURL(types.S3Endpoint{URL: "https://s3.us-west-004.backblazeb2.com"}, "datasets", "a.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://datasets.s3.us-west-004.backblazeb2.com/a.csv", u.String())
+}
@@ -1,7 +1,10 @@
 package util
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -9,12 +12,21 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/session"
 	"github.com/nilotpaul/go-downloader/setting"
 	"github.com/nilotpaul/go-downloader/types"
+	"golang.org/x/oauth2"
 )
 
-func GenerateSessionToken(userID string, secret string) (string, error) {
+var (
+	ErrOAuthFlowNotFound  = errors.New("no sign in was started from this browser, please try again")
+	ErrOAuthFlowExpired   = errors.New("the sign in took too long, please try again")
+	ErrOAuthStateMismatch = errors.New("invalid oauth state, please try again")
+)
+
+// GenerateSessionToken signs the token of the session `sessionID`, it can be revoked until it expires.
+func GenerateSessionToken(userID string, sessionID string, expiresAt time.Time, secret string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"exp":     setting.SessionExpiry.Unix(),
+		"user_id":    userID,
+		"session_id": sessionID,
+		"exp":        expiresAt.Unix(),
 	})
 
 	ts, err := token.SignedString([]byte(secret))
@@ -25,11 +37,11 @@ func GenerateSessionToken(userID string, secret string) (string, error) {
 	return ts, nil
 }
 
-func SetSessionToken(c *fiber.Ctx, token string, domain string) {
+func SetSessionToken(c *fiber.Ctx, token string, expiresAt time.Time, domain string) {
 	c.Cookie(&fiber.Cookie{
 		Name:     setting.SessionKey,
 		Value:    token,
-		Expires:  setting.SessionExpiry,
+		Expires:  expiresAt,
 		HTTPOnly: true,
 		Path:     "/",
 		Secure:   false,
@@ -41,6 +53,32 @@ func GetSessionToken(c *fiber.Ctx) string {
 	return c.Cookies(setting.SessionKey, "")
 }
 
+// GetAPIToken gets the bearer token of the `Authorization` header, empty if there's none.
+func GetAPIToken(c *fiber.Ctx) string {
+	scheme, token, ok := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+// IsValidAPIToken reports whether `token` is one of `tokens`, in constant time.
+func IsValidAPIToken(token string, tokens []string) bool {
+	if len(token) == 0 {
+		return false
+	}
+
+	valid := false
+	for _, t := range tokens {
+		if len(t) != 0 && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			valid = true
+		}
+	}
+
+	return valid
+}
+
 func VerifyAndDecodeSessionToken(tokenStr string, secret string) (*types.JWTSession, error) {
 	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -65,6 +103,11 @@ func VerifyAndDecodeSessionToken(tokenStr string, secret string) (*types.JWTSess
 	if !ok {
 		return nil, fmt.Errorf("invalid userID")
 	}
+	// Tokens without a session can't be revoked, they're signed in again.
+	sessionID, ok := claims["session_id"].(string)
+	if !ok || len(sessionID) == 0 {
+		return nil, fmt.Errorf("invalid session_id")
+	}
 	expiry, ok := claims["exp"].(float64)
 	if !ok {
 		return nil, fmt.Errorf("invalid expires_at")
@@ -72,6 +115,7 @@ func VerifyAndDecodeSessionToken(tokenStr string, secret string) (*types.JWTSess
 
 	session := types.JWTSession{
 		UserID:    userID,
+		SessionID: sessionID,
 		ExpiresAt: time.Unix(int64(expiry), 0),
 	}
 
@@ -96,11 +140,25 @@ func GetSessionFromStore(c *fiber.Ctx, store *session.Store) (*types.GoogleAccou
 	return v.GoogleAccount, nil
 }
 
+// SetSessionInStore keeps the account in the session, without its tokens as the session
+// store is persisted in the database as is. They're only stored encrypted.
+// Saving writes the whole session to the storage, so it's skipped if the account didn't change.
 func SetSessionInStore(c *fiber.Ctx, store *session.Store, acc *types.GoogleAccount) error {
 	sess, err := store.Get(c)
 	if err != nil {
 		return err
 	}
+	if acc != nil {
+		withoutTokens := *acc
+		withoutTokens.AccessToken = ""
+		withoutTokens.RefreshToken = ""
+		acc = &withoutTokens
+	}
+
+	prev, _ := sess.Get(setting.SessionKey).(types.GoogleAccountWrapper)
+	if sameSessionAccount(prev.GoogleAccount, acc) {
+		return nil
+	}
 
 	sess.Set(setting.SessionKey, types.GoogleAccountWrapper{
 		GoogleAccount: acc,
@@ -112,7 +170,22 @@ func SetSessionInStore(c *fiber.Ctx, store *session.Store, acc *types.GoogleAcco
 	return nil
 }
 
-func ResetSession(c *fiber.Ctx, r types.OAuthProvider, domain string) error {
+// sameSessionAccount reports whether both are the same account, or both are nil.
+// Only the fields the handlers use are compared, the token expiry changes with every refresh.
+func sameSessionAccount(a *types.GoogleAccount, b *types.GoogleAccount) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.ID == b.ID &&
+		a.UserID == b.UserID &&
+		a.Email == b.Email &&
+		a.Name == b.Name &&
+		a.IsPrimary == b.IsPrimary
+}
+
+// ResetSession clears the session cookies, the tokens stay in the database.
+func ResetSession(c *fiber.Ctx, domain string) {
 	c.Cookie(&fiber.Cookie{
 		Name:     setting.SessionKey,
 		Path:     "/",
@@ -127,6 +200,65 @@ func ResetSession(c *fiber.Ctx, r types.OAuthProvider, domain string) error {
 		Expires:  time.Now().AddDate(-100, 0, 0),
 		Domain:   domain,
 	})
+}
+
+// StartOAuthFlow generates the state and PKCE verifier of a new OAuth flow and keeps them in the
+// session under `name` until the callback, a flow which was started before is replaced.
+func StartOAuthFlow(c *fiber.Ctx, store *session.Store, name string) (*types.OAuthFlow, error) {
+	state, err := GenerateRandomState(32)
+	if err != nil {
+		return nil, err
+	}
+	flow := types.OAuthFlow{
+		State:     state,
+		Verifier:  oauth2.GenerateVerifier(),
+		ExpiresAt: time.Now().Add(setting.OAuthFlowExpiry),
+	}
+
+	sess, err := store.Get(c)
+	if err != nil {
+		return nil, err
+	}
+	sess.Set(name, flow)
+	if err := sess.Save(); err != nil {
+		return nil, err
+	}
+
+	return &flow, nil
+}
+
+// FinishOAuthFlow removes the OAuth flow `name` from the session and checks the `state` of the
+// callback against it, so a flow can only be finished once by the browser which started it.
+// The errors of a callback which doesn't belong to the flow are `ErrOAuth...`.
+func FinishOAuthFlow(c *fiber.Ctx, store *session.Store, name string, state string) (*types.OAuthFlow, error) {
+	sess, err := store.Get(c)
+	if err != nil {
+		return nil, err
+	}
+	v := sess.Get(name)
+	if v == nil {
+		return nil, ErrOAuthFlowNotFound
+	}
+	sess.Delete(name)
+	if err := sess.Save(); err != nil {
+		return nil, err
+	}
+
+	flow, ok := v.(types.OAuthFlow)
+	if !ok {
+		return nil, ErrOAuthFlowNotFound
+	}
+	if time.Now().After(flow.ExpiresAt) {
+		return nil, ErrOAuthFlowExpired
+	}
+	if len(state) == 0 || subtle.ConstantTimeCompare([]byte(state), []byte(flow.State)) != 1 {
+		return nil, ErrOAuthStateMismatch
+	}
+
+	return &flow, nil
+}
 
-	return r.UpdateTokens(nil)
+// IsOAuthFlowError reports whether `err` is caused by a callback which doesn't belong to the flow.
+func IsOAuthFlowError(err error) bool {
+	return errors.Is(err, ErrOAuthFlowNotFound) || errors.Is(err, ErrOAuthFlowExpired) || errors.Is(err, ErrOAuthStateMismatch)
 }
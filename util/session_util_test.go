@@ -0,0 +1,244 @@
+package util
+
+import (
+	"encoding/gob"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	gob.Register(types.OAuthFlow{})
+	gob.Register(types.GoogleAccountWrapper{})
+}
+
+// `countingStorage` is an in memory `fiber.Storage` which counts the writes.
+type countingStorage struct {
+	data map[string][]byte
+	sets int
+}
+
+func (s *countingStorage) Get(key string) ([]byte, error) { return s.data[key], nil }
+
+func (s *countingStorage) Set(key string, val []byte, exp time.Duration) error {
+	s.sets++
+	s.data[key] = val
+	return nil
+}
+
+func (s *countingStorage) Delete(key string) error { delete(s.data, key); return nil }
+func (s *countingStorage) Reset() error            { clear(s.data); return nil }
+func (s *countingStorage) Close() error            { return nil }
+
+// newOAuthFlowApp starts the flow on `/start` and finishes it on `/callback`, like the OAuth handlers.
+func newOAuthFlowApp() *fiber.App {
+	store := session.New()
+	app := fiber.New()
+
+	app.Get("/start", func(c *fiber.Ctx) error {
+		flow, err := StartOAuthFlow(c, store, "flow")
+		if err != nil {
+			return err
+		}
+		return c.JSON(flow)
+	})
+	// Starts a flow which already expired.
+	app.Get("/start-expired", func(c *fiber.Ctx) error {
+		sess, err := store.Get(c)
+		if err != nil {
+			return err
+		}
+		sess.Set("flow", types.OAuthFlow{State: "expired", Verifier: "v", ExpiresAt: time.Now().Add(-time.Second)})
+		return sess.Save()
+	})
+	app.Get("/callback", func(c *fiber.Ctx) error {
+		flow, err := FinishOAuthFlow(c, store, "flow", c.Query("state"))
+		if err != nil {
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+		return c.SendString(flow.Verifier)
+	})
+
+	return app
+}
+
+// startOAuthFlow returns the flow and the session cookie of the browser which started it.
+func startOAuthFlow(t *testing.T, app *fiber.App) (types.OAuthFlow, *http.Cookie) {
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/start", nil))
+	assert.NoError(t, err)
+
+	var flow types.OAuthFlow
+	assert.NoError(t, DecodeJSON(res.Body, &flow))
+	cookies := res.Cookies()
+	assert.Len(t, cookies, 1)
+
+	return flow, cookies[0]
+}
+
+func finishOAuthFlow(t *testing.T, app *fiber.App, state string, cookie *http.Cookie) (int, string) {
+	req := httptest.NewRequest(http.MethodGet, "/callback?state="+url.QueryEscape(state), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	res, err := app.Test(req)
+	assert.NoError(t, err)
+
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+
+	return res.StatusCode, string(body)
+}
+
+func TestOAuthFlow(t *testing.T) {
+	app := newOAuthFlowApp()
+
+	flow, cookie := startOAuthFlow(t, app)
+	assert.NotEmpty(t, flow.State)
+	assert.GreaterOrEqual(t, len(flow.Verifier), 43)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), flow.ExpiresAt, time.Minute)
+
+	status, body := finishOAuthFlow(t, app, flow.State, cookie)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, flow.Verifier, body)
+
+	// A flow can only be finished once.
+	status, body = finishOAuthFlow(t, app, flow.State, cookie)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, ErrOAuthFlowNotFound.Error(), body)
+}
+
+func TestOAuthFlow_Invalid(t *testing.T) {
+	app := newOAuthFlowApp()
+
+	// Another browser can't finish the flow.
+	flow, cookie := startOAuthFlow(t, app)
+	status, body := finishOAuthFlow(t, app, flow.State, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, ErrOAuthFlowNotFound.Error(), body)
+
+	// Neither can a callback with another state, the flow is dropped.
+	status, body = finishOAuthFlow(t, app, "forged", cookie)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, ErrOAuthStateMismatch.Error(), body)
+	status, _ = finishOAuthFlow(t, app, flow.State, cookie)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// Or without one.
+	_, cookie = startOAuthFlow(t, app)
+	status, body = finishOAuthFlow(t, app, "", cookie)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, ErrOAuthStateMismatch.Error(), body)
+
+	// Or once it expired.
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/start-expired", nil))
+	assert.NoError(t, err)
+	status, body = finishOAuthFlow(t, app, "expired", res.Cookies()[0])
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, ErrOAuthFlowExpired.Error(), body)
+}
+
+func TestSetSessionInStore(t *testing.T) {
+	storage := &countingStorage{data: make(map[string][]byte)}
+	store := session.New(session.Config{Storage: storage})
+	acc := &types.GoogleAccount{ID: "acc", UserID: "user", Email: "me@example.com", AccessToken: "a", ExpiresAt: time.Now()}
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		a := acc
+		if c.Query("signed_out") == "1" {
+			a = nil
+		}
+		return SetSessionInStore(c, store, a)
+	})
+
+	set := func(cookie *http.Cookie, query string) *http.Cookie {
+		req := httptest.NewRequest(http.MethodGet, "/"+query, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		res, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		if cookies := res.Cookies(); len(cookies) != 0 {
+			return cookies[0]
+		}
+		return cookie
+	}
+
+	cookie := set(nil, "")
+	assert.Equal(t, 1, storage.sets)
+
+	// A refreshed token isn't kept in the session, so there's nothing to save.
+	acc.AccessToken = "b"
+	acc.ExpiresAt = time.Now().Add(time.Hour)
+	set(cookie, "")
+	assert.Equal(t, 1, storage.sets)
+
+	set(cookie, "?signed_out=1")
+	assert.Equal(t, 2, storage.sets)
+
+	// A browser without a session isn't saved either.
+	set(nil, "?signed_out=1")
+	assert.Equal(t, 2, storage.sets)
+}
+
+func TestAPIToken(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(GetAPIToken(c))
+	})
+
+	for header, expected := range map[string]string{
+		"Bearer secret": "secret",
+		"bearer secret": "secret",
+		"Basic secret":  "",
+		"secret":        "",
+		"":              "",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(fiber.HeaderAuthorization, header)
+		res, err := app.Test(req)
+		assert.NoError(t, err)
+		b, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(b), header)
+	}
+
+	assert.True(t, IsValidAPIToken("secret", []string{"other", "secret"}))
+	assert.False(t, IsValidAPIToken("secrets", []string{"secret"}))
+	assert.False(t, IsValidAPIToken("", []string{""}))
+	assert.False(t, IsValidAPIToken("secret", nil))
+}
+
+func TestSessionToken(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	token, err := GenerateSessionToken("user", "session", expiresAt, "secret")
+	assert.NoError(t, err)
+
+	decoded, err := VerifyAndDecodeSessionToken(token, "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", decoded.UserID)
+	assert.Equal(t, "session", decoded.SessionID)
+	assert.True(t, expiresAt.Equal(decoded.ExpiresAt))
+
+	_, err = VerifyAndDecodeSessionToken(token, "other-secret")
+	assert.Error(t, err)
+
+	// Tokens from before sessions could be revoked aren't accepted.
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": "user",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	_, err = VerifyAndDecodeSessionToken(legacy, "secret")
+	assert.Error(t, err)
+}
@@ -0,0 +1,209 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Prefix of the values encrypted by `TokenKeyring`, followed by the ID of the key, the wrapped
+// data key and the ciphertext, separated by colons.
+const encryptedTokenPrefix = "enc:v1:"
+
+// `TokenKeyring` encrypts the OAuth tokens stored in the database with envelope encryption.
+// Every value gets its own random data key, which is encrypted (wrapped) by a key of the keyring.
+// Rotating the keyring only wraps the data keys again, the tokens themselves aren't touched.
+type TokenKeyring struct {
+	// ID of the key new values are encrypted with, the other keys only decrypt.
+	primary string
+	keys    map[string][]byte
+}
+
+// ParseTokenKeys parses keys like `2024-07:<base64 of 32 bytes>`, separated by commas or newlines.
+// The first key encrypts, the others are kept to decrypt the values encrypted before a rotation.
+// Empty lines and lines starting with `#` are skipped, so a key file can have comments.
+func ParseTokenKeys(s string) (*TokenKeyring, error) {
+	k := &TokenKeyring{keys: make(map[string][]byte)}
+
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 || strings.HasPrefix(field, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(field, ":")
+		if !ok || len(id) == 0 {
+			return nil, fmt.Errorf("invalid key %q, expected <id>:<base64 key>", field)
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("duplicate key %s", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %v", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid key %s: expected 32 bytes, got %d", id, len(key))
+		}
+
+		if len(k.primary) == 0 {
+			k.primary = id
+		}
+		k.keys[id] = key
+	}
+	if len(k.primary) == 0 {
+		return nil, fmt.Errorf("no key found")
+	}
+
+	return k, nil
+}
+
+// PrimaryKeyID returns the ID of the key new values are encrypted with.
+func (k *TokenKeyring) PrimaryKeyID() string {
+	return k.primary
+}
+
+// Encrypt encrypts `plaintext` with a new data key wrapped by the primary key.
+func (k *TokenKeyring) Encrypt(plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	ciphertext, err := sealGCM(dataKey, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return k.format(dataKey, ciphertext)
+}
+
+// Decrypt decrypts a value of `Encrypt`, with whichever key of the keyring it was encrypted.
+func (k *TokenKeyring) Decrypt(value string) (string, error) {
+	dataKey, ciphertext, err := k.unwrap(value)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := openGCM(dataKey, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt the token: %v", err)
+	}
+
+	return string(plaintext), nil
+}
+
+// Rewrap wraps the data key of `value` with the primary key, values which aren't encrypted yet
+// are encrypted. It reports whether the value changed, it doesn't if it's already up to date.
+func (k *TokenKeyring) Rewrap(value string) (string, bool, error) {
+	if !IsEncryptedToken(value) {
+		encrypted, err := k.Encrypt(value)
+		return encrypted, err == nil, err
+	}
+	if keyID, _, _ := parseEncryptedToken(value); keyID == k.primary {
+		return value, false, nil
+	}
+
+	dataKey, ciphertext, err := k.unwrap(value)
+	if err != nil {
+		return "", false, err
+	}
+	rewrapped, err := k.format(dataKey, ciphertext)
+
+	return rewrapped, err == nil, err
+}
+
+// IsEncryptedToken reports whether `value` was encrypted by a `TokenKeyring`.
+func IsEncryptedToken(value string) bool {
+	return strings.HasPrefix(value, encryptedTokenPrefix)
+}
+
+// format wraps the data key with the primary key, the key ID is authenticated with it.
+func (k *TokenKeyring) format(dataKey []byte, ciphertext []byte) (string, error) {
+	wrapped, err := sealGCM(k.keys[k.primary], dataKey, []byte(k.primary))
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedTokenPrefix + k.primary +
+		":" + base64.RawStdEncoding.EncodeToString(wrapped) +
+		":" + base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// unwrap returns the data key and ciphertext of an encrypted value.
+func (k *TokenKeyring) unwrap(value string) ([]byte, []byte, error) {
+	if !IsEncryptedToken(value) {
+		return nil, nil, fmt.Errorf("token isn't encrypted")
+	}
+	keyID, wrapped, ciphertext := parseEncryptedToken(value)
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("token is encrypted with unknown key %s", keyID)
+	}
+
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid encrypted token: %v", err)
+	}
+	data, err := base64.RawStdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid encrypted token: %v", err)
+	}
+	dataKey, err := openGCM(key, wrappedKey, []byte(keyID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unwrap the data key: %v", err)
+	}
+
+	return dataKey, data, nil
+}
+
+func parseEncryptedToken(value string) (keyID string, wrapped string, ciphertext string) {
+	parts := strings.SplitN(strings.TrimPrefix(value, encryptedTokenPrefix), ":", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+
+	return parts[0], parts[1], parts[2]
+}
+
+// sealGCM encrypts with AES-256-GCM, the random nonce is prepended to the ciphertext.
+func sealGCM(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openGCM(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, data := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+	return gcm.Open(nil, nonce, data, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
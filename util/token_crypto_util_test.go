@@ -0,0 +1,122 @@
+package util
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), 32)))
+}
+
+func TestParseTokenKeys(t *testing.T) {
+	k, err := ParseTokenKeys("new:" + testKey('a') + ", old:" + testKey('b'))
+	assert.NoError(t, err)
+	assert.Equal(t, "new", k.PrimaryKeyID())
+	assert.Len(t, k.keys, 2)
+
+	// Key files have a key per line and comments.
+	k, err = ParseTokenKeys("# rotated on 2024-07-30\nnew:" + testKey('a') + "\r\n\nold:" + testKey('b') + "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", k.PrimaryKeyID())
+	assert.Len(t, k.keys, 2)
+
+	for _, s := range []string{
+		"",
+		"# only a comment",
+		testKey('a'),
+		":" + testKey('a'),
+		"a:not base64",
+		"a:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"a:" + testKey('a') + ",a:" + testKey('b'),
+	} {
+		_, err := ParseTokenKeys(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestTokenKeyring_EncryptDecrypt(t *testing.T) {
+	k, err := ParseTokenKeys("a:" + testKey('a'))
+	assert.NoError(t, err)
+
+	for _, token := range []string{"ya29.access-token", ""} {
+		encrypted, err := k.Encrypt(token)
+		assert.NoError(t, err)
+		assert.True(t, IsEncryptedToken(encrypted))
+		assert.NotContains(t, encrypted, "access-token")
+
+		decrypted, err := k.Decrypt(encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, token, decrypted)
+	}
+
+	// Every value has its own data key.
+	first, _ := k.Encrypt("token")
+	second, _ := k.Encrypt("token")
+	assert.NotEqual(t, first, second)
+
+	// Plaintext values aren't accepted.
+	_, err = k.Decrypt("token")
+	assert.Error(t, err)
+
+	// Tampered values don't decrypt.
+	tampered := first[:len(first)-2] + "AA"
+	if tampered == first {
+		tampered = first[:len(first)-2] + "BB"
+	}
+	_, err = k.Decrypt(tampered)
+	assert.Error(t, err)
+
+	// A keyring without the key can't decrypt.
+	other, _ := ParseTokenKeys("b:" + testKey('b'))
+	_, err = other.Decrypt(first)
+	assert.Error(t, err)
+
+	// The key ID is authenticated with the wrapped data key.
+	same, _ := ParseTokenKeys("b:" + testKey('a'))
+	_, err = same.Decrypt(first)
+	assert.Error(t, err)
+}
+
+func TestTokenKeyring_Rewrap(t *testing.T) {
+	old, _ := ParseTokenKeys("old:" + testKey('a'))
+	encrypted, err := old.Encrypt("token")
+	assert.NoError(t, err)
+
+	rotated, _ := ParseTokenKeys("new:" + testKey('b') + ",old:" + testKey('a'))
+
+	// Values of the old key are wrapped with the new one.
+	rewrapped, changed, err := rotated.Rewrap(encrypted)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, strings.HasPrefix(rewrapped, encryptedTokenPrefix+"new:"))
+	// Only the data key is wrapped again, the ciphertext stays the same.
+	assert.Equal(t, encrypted[strings.LastIndex(encrypted, ":"):], rewrapped[strings.LastIndex(rewrapped, ":"):])
+
+	// The old key isn't needed anymore.
+	newOnly, _ := ParseTokenKeys("new:" + testKey('b'))
+	decrypted, err := newOnly.Decrypt(rewrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, "token", decrypted)
+
+	// Values of the primary key are left as is.
+	same, changed, err := rotated.Rewrap(rewrapped)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, rewrapped, same)
+
+	// Plaintext values are encrypted.
+	encrypted, changed, err = rotated.Rewrap("plain")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	decrypted, err = rotated.Decrypt(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", decrypted)
+
+	// Values of a key which was dropped too early fail.
+	_, _, err = newOnly.Rewrap(encrypted[:len(encryptedTokenPrefix)] + "gone" + encrypted[len(encryptedTokenPrefix)+3:])
+	assert.Error(t, err)
+}
@@ -0,0 +1,156 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/gofiber/fiber/v2"
+	"github.com/nilotpaul/go-downloader/types"
+)
+
+// TorrentLocation is a torrent or a file inside of it.
+type TorrentLocation struct {
+	// The magnet link without the path.
+	Magnet   string
+	InfoHash metainfo.Hash
+	// Path of the file inside the torrent without the torrent's name, empty for the torrent itself.
+	Path string
+}
+
+// String formats the location as a magnet link, the path of a file follows a `#`.
+func (l TorrentLocation) String() string {
+	if len(l.Path) == 0 {
+		return l.Magnet
+	}
+
+	return l.Magnet + "#" + (&url.URL{Fragment: l.Path}).EscapedFragment()
+}
+
+// ParseTorrentLink parses a magnet link, the path of a file inside the torrent can follow a `#`.
+func ParseTorrentLink(link string) (TorrentLocation, error) {
+	if !strings.HasPrefix(strings.ToLower(link), "magnet:?") {
+		return TorrentLocation{}, fmt.Errorf("invalid magnet link %s", link)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return TorrentLocation{}, fmt.Errorf("invalid magnet link %s", link)
+	}
+	p := u.Fragment
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	m, err := metainfo.ParseMagnetV2Uri(u.String())
+	if err != nil {
+		return TorrentLocation{}, fmt.Errorf("invalid magnet link %s: %v", link, err)
+	}
+	if !m.InfoHash.Ok {
+		return TorrentLocation{}, fmt.Errorf("magnet links without a v1 info hash aren't supported")
+	}
+
+	return TorrentLocation{
+		Magnet:   u.String(),
+		InfoHash: m.InfoHash.Value,
+		Path:     cleanTorrentPath(p),
+	}, nil
+}
+
+// TorrentFilePath returns the path of an uploaded `.torrent` file in `dataDir`.
+func TorrentFilePath(dataDir string, infoHash metainfo.Hash) string {
+	return filepath.Join(dataDir, infoHash.HexString()+".torrent")
+}
+
+// SaveTorrentFile saves a `.torrent` file in `dataDir`, so the torrent doesn't
+// need its metadata from the peers. It returns the magnet link of the torrent.
+func SaveTorrentFile(dataDir string, r io.Reader) (string, error) {
+	mi, err := metainfo.Load(r)
+	if err != nil {
+		return "", fmt.Errorf("invalid torrent file: %v", err)
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return "", fmt.Errorf("invalid torrent file: %v", err)
+	}
+	if !info.HasV1() {
+		return "", fmt.Errorf("torrent files without a v1 info hash aren't supported")
+	}
+
+	ih := mi.HashInfoBytes()
+	if err := os.MkdirAll(dataDir, os.FileMode(0775)); err != nil {
+		return "", fmt.Errorf("failed to create the torrent data folder: %v", err)
+	}
+	f, err := os.Create(TorrentFilePath(dataDir, ih))
+	if err != nil {
+		return "", fmt.Errorf("failed to save the torrent file: %v", err)
+	}
+	defer f.Close()
+	if err := mi.Write(f); err != nil {
+		return "", fmt.Errorf("failed to save the torrent file: %v", err)
+	}
+
+	return mi.Magnet(&ih, &info).String(), nil
+}
+
+// TorrentUploads returns the `.torrent` files uploaded with a multipart download request.
+func TorrentUploads(c *fiber.Ctx) []*multipart.FileHeader {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil
+	}
+
+	return form.File["torrent"]
+}
+
+// FilterTorrentFiles keeps the files of torrents which are in `selected`, or inside a selected folder.
+// Files of other sources are always kept.
+func FilterTorrentFiles(files []types.SourceFile, selected []string) []types.SourceFile {
+	if len(selected) == 0 {
+		return files
+	}
+
+	filtered := make([]types.SourceFile, 0, len(files))
+	for _, f := range files {
+		loc, err := ParseTorrentLink(f.ID)
+		if err != nil {
+			filtered = append(filtered, f)
+			continue
+		}
+		for _, s := range selected {
+			s = cleanTorrentPath(s)
+			if loc.Path == s || strings.HasPrefix(loc.Path, s+"/") {
+				filtered = append(filtered, f)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
+// SeedLimitReached reports whether a torrent has been seeded long enough, `ratio` and `limit`
+// of 0 are ignored. `uploaded` is compared with the size of the files which were downloaded.
+func SeedLimitReached(uploaded, downloaded int64, seeding time.Duration, ratio float64, limit time.Duration) bool {
+	if ratio <= 0 && limit <= 0 {
+		return true
+	}
+	if ratio > 0 && float64(uploaded) >= ratio*float64(downloaded) {
+		return true
+	}
+
+	return limit > 0 && seeding >= limit
+}
+
+func cleanTorrentPath(p string) string {
+	if len(p) == 0 {
+		return ""
+	}
+
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/nilotpaul/go-downloader/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const testInfoHash = "c9e15763f722f23e98a29decdfae341b98d53056"
+
+func TestParseTorrentLink(t *testing.T) {
+	magnet := "magnet:?xt=urn:btih:" + testInfoHash + "&dn=Season+1"
+	loc, err := ParseTorrentLink(magnet + "#Season%201/E01.mkv")
+	assert.NoError(t, err)
+	assert.Equal(t, magnet, loc.Magnet)
+	assert.Equal(t, testInfoHash, loc.InfoHash.HexString())
+	assert.Equal(t, "Season 1/E01.mkv", loc.Path)
+
+	// The ID of a file parses back to the same location.
+	again, err := ParseTorrentLink(loc.String())
+	assert.NoError(t, err)
+	assert.Equal(t, loc, again)
+
+	loc.Path = ""
+	assert.Equal(t, magnet, loc.String())
+
+	_, err = ParseTorrentLink("magnet:?dn=no-hash")
+	assert.Error(t, err)
+	_, err = ParseTorrentLink("https://example.com/file.torrent")
+	assert.Error(t, err)
+}
+
+func TestSaveTorrentFile(t *testing.T) {
+	info := metainfo.Info{Name: "file.iso", PieceLength: 16 * 1024, Length: 5, Pieces: make([]byte, 20)}
+	infoBytes, err := bencode.Marshal(info)
+	assert.NoError(t, err)
+	mi := metainfo.MetaInfo{InfoBytes: infoBytes, Announce: "udp://tracker.example.com:1337"}
+	var buf bytes.Buffer
+	assert.NoError(t, mi.Write(&buf))
+
+	dir := t.TempDir()
+	magnet, err := SaveTorrentFile(dir, &buf)
+	assert.NoError(t, err)
+
+	loc, err := ParseTorrentLink(magnet)
+	assert.NoError(t, err)
+	assert.Equal(t, mi.HashInfoBytes(), loc.InfoHash)
+	assert.Contains(t, magnet, "tr=udp")
+	_, err = os.Stat(TorrentFilePath(dir, loc.InfoHash))
+	assert.NoError(t, err)
+
+	_, err = SaveTorrentFile(dir, bytes.NewReader([]byte("not a torrent")))
+	assert.Error(t, err)
+}
+
+func TestFilterTorrentFiles(t *testing.T) {
+	magnet := "magnet:?xt=urn:btih:" + testInfoHash
+	files := []types.SourceFile{
+		{ID: magnet + "#Season%201/E01.mkv"},
+		{ID: magnet + "#Season%201/E02.mkv"},
+		{ID: magnet + "#Extras/Trailer.mkv"},
+		{ID: "https://example.com/file.iso"},
+	}
+
+	assert.Equal(t, files, FilterTorrentFiles(files, nil))
+	assert.Equal(t, []types.SourceFile{files[0], files[2], files[3]}, FilterTorrentFiles(files, []string{"Season 1/E01.mkv", "/Extras/"}))
+	assert.Equal(t, []types.SourceFile{files[0], files[1], files[3]}, FilterTorrentFiles(files, []string{"Season 1"}))
+	// A prefix of a name isn't a folder.
+	assert.Equal(t, []types.SourceFile{files[3]}, FilterTorrentFiles(files, []string{"Season"}))
+}
+
+func TestSeedLimitReached(t *testing.T) {
+	// No limits, no seeding.
+	assert.True(t, SeedLimitReached(0, 100, 0, 0, 0))
+
+	assert.False(t, SeedLimitReached(50, 100, time.Minute, 1, 0))
+	assert.True(t, SeedLimitReached(100, 100, time.Minute, 1, 0))
+
+	assert.False(t, SeedLimitReached(0, 100, time.Minute, 0, time.Hour))
+	assert.True(t, SeedLimitReached(0, 100, time.Hour, 0, time.Hour))
+
+	// Whichever comes first.
+	assert.True(t, SeedLimitReached(0, 100, time.Hour, 2, time.Hour))
+	assert.True(t, SeedLimitReached(200, 100, time.Minute, 2, time.Hour))
+}
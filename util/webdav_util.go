@@ -0,0 +1,134 @@
+package util
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Public share links of Nextcloud and ownCloud, eg. `https://cloud.example.com/s/<token>`.
+	webDAVShareRegex = regexp.MustCompile(`^(.*?)/(?:index\.php/)?s/([A-Za-z0-9]{15})/?$`)
+	// WebDAV URLs of a user's files, eg. `https://cloud.example.com/remote.php/dav/files/<user>/<path>`.
+	webDAVFilesRegex = regexp.MustCompile(`^(.*?/remote\.php/(?:dav/files/[^/]+|webdav))(/.*)?$`)
+)
+
+// WebDAVLocation is a file or a folder of a WebDAV server.
+type WebDAVLocation struct {
+	// The link without the path inside of it, eg. the share link.
+	Link string
+	// Collection all the paths are relative to.
+	Root url.URL
+	// Token of a public share, it's used as the user name.
+	ShareToken string
+	// Path inside `Root`, always starts with `/`.
+	Path string
+}
+
+// String formats the location as a link, the path of a public share follows a `#`.
+func (l WebDAVLocation) String() string {
+	if len(l.ShareToken) != 0 {
+		if l.Path == "/" {
+			return l.Link
+		}
+		return l.Link + "#" + l.Path
+	}
+
+	return l.Link + (&url.URL{Path: l.Path}).EscapedPath()
+}
+
+// URL returns the URL of the path on the server.
+func (l WebDAVLocation) URL() *url.URL {
+	u := l.Root
+	u.Path = strings.TrimSuffix(u.Path, "/") + l.Path
+	u.RawPath = ""
+
+	return &u
+}
+
+// Port returns the port of the server, which picks the connection profile.
+func (l WebDAVLocation) Port() int {
+	if port, err := strconv.Atoi(l.Root.Port()); err == nil {
+		return port
+	}
+	if l.Root.Scheme == "http" {
+		return 80
+	}
+
+	return 443
+}
+
+// RelPath returns the path of `href` inside `Root`, `href` is an URL or a path as sent in a `PROPFIND` response.
+func (l WebDAVLocation) RelPath(href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+	rel, ok := strings.CutPrefix(u.Path, strings.TrimSuffix(l.Root.Path, "/"))
+	if !ok {
+		return "", fmt.Errorf("unexpected href %s", href)
+	}
+
+	return path.Clean("/" + rel), nil
+}
+
+// ParseWebDAVLink parses a Nextcloud or ownCloud public share link, a WebDAV URL of a user's files,
+// or a `webdav://` (`webdavs://` for HTTPS) link to any WebDAV server.
+func ParseWebDAVLink(link string) (WebDAVLocation, error) {
+	u, err := url.Parse(link)
+	if err != nil || len(u.Host) == 0 {
+		return WebDAVLocation{}, fmt.Errorf("invalid webdav link %s", link)
+	}
+	if u.User != nil {
+		return WebDAVLocation{}, fmt.Errorf("links can't contain credentials, use a connection profile instead")
+	}
+
+	switch u.Scheme {
+	case "webdav", "webdavs":
+		root := url.URL{Scheme: "https", Host: u.Host}
+		if u.Scheme == "webdav" {
+			root.Scheme = "http"
+		}
+		return WebDAVLocation{
+			Link: u.Scheme + "://" + u.Host,
+			Root: root,
+			Path: cleanWebDAVPath(u.Path),
+		}, nil
+	case "http", "https":
+	default:
+		return WebDAVLocation{}, fmt.Errorf("invalid webdav link %s", link)
+	}
+
+	if m := webDAVShareRegex.FindStringSubmatch(u.Path); m != nil {
+		// A folder opened in the web UI has its path in the query.
+		p := u.Fragment
+		if len(p) == 0 {
+			p = u.Query().Get("path")
+		}
+		share := url.URL{Scheme: u.Scheme, Host: u.Host, Path: m[1] + "/s/" + m[2]}
+		return WebDAVLocation{
+			Link:       share.String(),
+			Root:       url.URL{Scheme: u.Scheme, Host: u.Host, Path: m[1] + "/public.php/webdav"},
+			ShareToken: m[2],
+			Path:       cleanWebDAVPath(p),
+		}, nil
+	}
+
+	if m := webDAVFilesRegex.FindStringSubmatch(u.Path); m != nil {
+		root := url.URL{Scheme: u.Scheme, Host: u.Host, Path: m[1]}
+		return WebDAVLocation{
+			Link: root.String(),
+			Root: root,
+			Path: cleanWebDAVPath(m[2]),
+		}, nil
+	}
+
+	return WebDAVLocation{}, fmt.Errorf("invalid webdav link %s", link)
+}
+
+func cleanWebDAVPath(p string) string {
+	return path.Clean("/" + p)
+}